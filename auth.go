@@ -29,6 +29,7 @@ package mgo
 import (
 	"crypto/md5"
 	"crypto/sha1"
+	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -36,6 +37,7 @@ import (
 
 	"github.com/globalsign/mgo/bson"
 	"github.com/globalsign/mgo/internal/scram"
+	"github.com/xdg-go/stringprep"
 )
 
 type authCmd struct {
@@ -94,6 +96,14 @@ type saslStepper interface {
 
 func (socket *mongoSocket) getNonce() (nonce string, err error) {
 	socket.Lock()
+	if socket.cachedNonce == "" && !socket.nonceRequested && socket.dead == nil {
+		// Nonces are only requested on demand, as servers that no
+		// longer support getnonce would otherwise kill every socket.
+		socket.nonceRequested = true
+		socket.Unlock()
+		socket.resetNonce()
+		socket.Lock()
+	}
 	for socket.cachedNonce == "" && socket.dead == nil {
 		debugf("Socket %p to %s: waiting for nonce", socket, socket.addr)
 		socket.gotNonce.Wait()
@@ -105,6 +115,7 @@ func (socket *mongoSocket) getNonce() (nonce string, err error) {
 	debugf("Socket %p to %s: got nonce", socket, socket.addr)
 	nonce, err = socket.cachedNonce, socket.dead
 	socket.cachedNonce = ""
+	socket.nonceRequested = false
 	socket.Unlock()
 	if err != nil {
 		nonce = ""
@@ -114,6 +125,9 @@ func (socket *mongoSocket) getNonce() (nonce string, err error) {
 
 func (socket *mongoSocket) resetNonce() {
 	debugf("Socket %p to %s: requesting a new nonce", socket, socket.addr)
+	socket.Lock()
+	socket.nonceRequested = true
+	socket.Unlock()
 	op := &queryOp{}
 	op.query = &getNonceCmd{GetNonce: 1}
 	op.collection = "admin.$cmd"
@@ -161,7 +175,7 @@ func (socket *mongoSocket) resetNonce() {
 func (socket *mongoSocket) Login(cred Credential) error {
 	socket.Lock()
 	if cred.Mechanism == "" && socket.serverInfo.MaxWireVersion >= 3 {
-		cred.Mechanism = "SCRAM-SHA-1"
+		cred.Mechanism = socket.serverInfo.scramMechanism(cred)
 	}
 	for _, sockCred := range socket.creds {
 		if sockCred == cred {
@@ -237,12 +251,27 @@ func (socket *mongoSocket) loginClassic(cred Credential) error {
 
 type authX509Cmd struct {
 	Authenticate int
-	User         string
+	User         string `bson:",omitempty"`
 	Mechanism    string
 }
 
 func (socket *mongoSocket) loginX509(cred Credential) error {
-	cmd := authX509Cmd{Authenticate: 1, User: cred.Username, Mechanism: "MONGODB-X509"}
+	user := cred.Username
+	if user == "" {
+		// Servers older than 3.4 require the subject of the client
+		// certificate, so always provide it when it's at hand.
+		cert, err := clientCertificate(socket.dialInfo.TLSConfig)
+		if err != nil {
+			return err
+		}
+		if cert != nil {
+			user, err = getRFC2253NameStringFromCert(cert)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	cmd := authX509Cmd{Authenticate: 1, User: user, Mechanism: "MONGODB-X509"}
 	res := authResult{}
 	return socket.loginRun(cred.Source, &cmd, &res, func() error {
 		if !res.Ok {
@@ -274,9 +303,9 @@ func (socket *mongoSocket) loginPlain(cred Credential) error {
 func (socket *mongoSocket) loginSASL(cred Credential) error {
 	var sasl saslStepper
 	var err error
-	if cred.Mechanism == "SCRAM-SHA-1" {
+	if cred.Mechanism == "SCRAM-SHA-1" || cred.Mechanism == "SCRAM-SHA-256" {
 		// SCRAM is handled without external libraries.
-		sasl = saslNewScram(cred)
+		sasl, err = saslNewScram(cred)
 	} else if len(cred.ServiceHost) > 0 {
 		sasl, err = saslNew(cred, cred.ServiceHost)
 	} else {
@@ -353,11 +382,22 @@ func (socket *mongoSocket) loginSASL(cred Credential) error {
 	return nil
 }
 
-func saslNewScram(cred Credential) *saslScram {
-	credsum := md5.New()
-	credsum.Write([]byte(cred.Username + ":mongo:" + cred.Password))
-	client := scram.NewClient(sha1.New, cred.Username, hex.EncodeToString(credsum.Sum(nil)))
-	return &saslScram{cred: cred, client: client}
+func saslNewScram(cred Credential) (*saslScram, error) {
+	var client *scram.Client
+	if cred.Mechanism == "SCRAM-SHA-256" {
+		// Unlike SCRAM-SHA-1, the password is not digested beforehand,
+		// but normalized with SASLprep as described in RFC 7677.
+		pass, err := stringprep.SASLprep.Prepare(cred.Password)
+		if err != nil {
+			return nil, fmt.Errorf("cannot prepare SCRAM-SHA-256 password: %v", err)
+		}
+		client = scram.NewClient(sha256.New, cred.Username, pass)
+	} else {
+		credsum := md5.New()
+		credsum.Write([]byte(cred.Username + ":mongo:" + cred.Password))
+		client = scram.NewClient(sha1.New, cred.Username, hex.EncodeToString(credsum.Sum(nil)))
+	}
+	return &saslScram{cred: cred, client: client}, nil
 }
 
 type saslScram struct {
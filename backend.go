@@ -0,0 +1,380 @@
+package mgo
+
+import (
+	"errors"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+// Backend names the implementation behind the sessions obtained with
+// DialAPI and DialAPIWithInfo.
+type Backend string
+
+const (
+	// AutoBackend picks the legacy backend for servers up to MongoDB 3.6,
+	// and the modern one for newer releases, going by the maxWireVersion
+	// the servers report in the handshake. It's the default.
+	AutoBackend Backend = "auto"
+
+	// LegacyBackend talks to the servers with the socket stack of this
+	// package, as Dial does.
+	LegacyBackend Backend = "legacy"
+
+	// ModernBackend runs on the official MongoDB driver, as DialModernMGO
+	// does, which is needed for recent server releases.
+	ModernBackend Backend = "modern"
+)
+
+// SessionAPI is the part of the Session API implemented by both backends,
+// so that code may switch between them, or be handed a fake in tests.
+// See DialAPI.
+type SessionAPI interface {
+	DB(name string) DatabaseAPI
+	Copy() SessionAPI
+	Clone() SessionAPI
+	Close()
+	Ping() error
+	Refresh()
+	SetMode(consistency Mode, refresh bool)
+	Mode() Mode
+	SelectServers(tags ...bson.D)
+	SetSafe(safe *Safe)
+	Safe() *Safe
+	EnsureSafe(safe *Safe)
+	SetStrictReplace(strict bool)
+	BuildInfo() (BuildInfo, error)
+	DatabaseNames() ([]string, error)
+	Run(cmd interface{}, result interface{}) error
+}
+
+// DatabaseAPI is the part of the Database API implemented by both backends.
+type DatabaseAPI interface {
+	C(name string) CollectionAPI
+	Run(cmd interface{}, result interface{}) error
+	CollectionNames() ([]string, error)
+	DropDatabase() error
+	CreateView(view string, source string, pipeline interface{}, collation *Collation) error
+	UpsertUser(user *User) error
+	AddUser(username, password string, readOnly bool) error
+	RemoveUser(user string) error
+}
+
+// CollectionAPI is the part of the Collection API implemented by both
+// backends.
+type CollectionAPI interface {
+	Find(query interface{}) QueryAPI
+	FindId(id interface{}) QueryAPI
+	Count() (int, error)
+	Insert(docs ...interface{}) error
+	Update(selector interface{}, update interface{}) error
+	UpdateId(id interface{}, update interface{}) error
+	UpdateAll(selector interface{}, update interface{}) (*ChangeInfo, error)
+	Upsert(selector interface{}, update interface{}) (*ChangeInfo, error)
+	UpsertId(id interface{}, update interface{}) (*ChangeInfo, error)
+	Replace(selector interface{}, replacement interface{}) error
+	ReplaceId(id interface{}, replacement interface{}) error
+	ReplaceOne(selector interface{}, replacement interface{}) (*ChangeInfo, error)
+	Remove(selector interface{}) error
+	RemoveId(id interface{}) error
+	RemoveAll(selector interface{}) (*ChangeInfo, error)
+	Create(info *CollectionInfo) error
+	DropCollection() error
+	EnsureIndex(index Index) error
+	EnsureIndexKey(key ...string) error
+	Indexes() ([]Index, error)
+	DropIndex(key ...string) error
+	DropIndexName(name string) error
+	DropAllIndexes() error
+	Pipe(pipeline interface{}) PipeAPI
+	Bulk() BulkAPI
+	Repair() IterAPI
+	Watch(pipeline interface{}, opts ChangeStreamOptions) (ChangeStreamAPI, error)
+}
+
+// QueryAPI is the part of the Query API implemented by both backends.
+type QueryAPI interface {
+	One(result interface{}) error
+	All(result interface{}) error
+	Count() (int, error)
+	Iter() IterAPI
+	Tail(timeout time.Duration) IterAPI
+	For(result interface{}, f func() error) error
+	Distinct(key string, result interface{}) error
+	Explain(result interface{}) error
+	MapReduce(job *MapReduce, result interface{}) (*MapReduceInfo, error)
+	Apply(change Change, result interface{}) (*ChangeInfo, error)
+	Sort(fields ...string) QueryAPI
+	Limit(n int) QueryAPI
+	Skip(n int) QueryAPI
+	Select(selector interface{}) QueryAPI
+	Batch(n int) QueryAPI
+	Prefetch(p float64) QueryAPI
+	Hint(indexKey ...string) QueryAPI
+	Collation(collation *Collation) QueryAPI
+	SetMaxTime(d time.Duration) QueryAPI
+	Comment(comment string) QueryAPI
+}
+
+// PipeAPI is the part of the Pipe API implemented by both backends.
+type PipeAPI interface {
+	Iter() IterAPI
+	All(result interface{}) error
+	One(result interface{}) error
+	Explain(result interface{}) error
+	AllowDiskUse() PipeAPI
+	Batch(n int) PipeAPI
+	SetMaxTime(d time.Duration) PipeAPI
+	Collation(collation *Collation) PipeAPI
+}
+
+// IterAPI is the part of the Iter API implemented by both backends.
+type IterAPI interface {
+	Next(result interface{}) bool
+	All(result interface{}) error
+	For(result interface{}, f func() error) error
+	Err() error
+	Timeout() bool
+	Close() error
+}
+
+// BulkAPI is the Bulk API, implemented by both backends.
+type BulkAPI interface {
+	Unordered()
+	Insert(docs ...interface{})
+	Update(pairs ...interface{})
+	UpdateAll(pairs ...interface{})
+	Upsert(pairs ...interface{})
+	Remove(selectors ...interface{})
+	RemoveAll(selectors ...interface{})
+	Run() (*BulkResult, error)
+}
+
+// ChangeStreamAPI is the ChangeStream API, implemented by both backends.
+type ChangeStreamAPI interface {
+	Next(result interface{}) bool
+	Err() error
+	Timeout() bool
+	ResumeToken() *bson.Raw
+	Close() error
+}
+
+// legacyMaxWireVersion is the wire version of MongoDB 3.6, the newest
+// release the legacy backend is tested against.
+const legacyMaxWireVersion = 6
+
+// DialAPI works like Dial, but returns a session of the backend picked
+// with the backend=<auto|legacy|modern> URL option, or for the server
+// version by default.
+func DialAPI(url string) (SessionAPI, error) {
+	info, err := ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+	info.Timeout = 10 * time.Second
+	session, err := DialAPIWithInfo(info)
+	if s, ok := session.(legacySession); ok {
+		s.SetSyncTimeout(1 * time.Minute)
+		s.SetSocketTimeout(1 * time.Minute)
+	}
+	return session, err
+}
+
+// DialAPIWithInfo works like DialWithInfo, but returns a session of the
+// backend picked with info.Backend, or for the server version by default.
+// The backend picked is logged. See SetLogger.
+func DialAPIWithInfo(info *DialInfo) (SessionAPI, error) {
+	switch info.Backend {
+	case "", AutoBackend:
+		backend, err := autoBackend(info)
+		if err != nil {
+			return nil, err
+		}
+		info = info.Copy()
+		info.Backend = backend
+		return DialAPIWithInfo(info)
+	case LegacyBackend:
+		session, err := DialWithInfo(info)
+		if err != nil {
+			return nil, err
+		}
+		return legacySession{session}, nil
+	case ModernBackend:
+		session, err := DialModernWithInfo(info)
+		if err != nil {
+			return nil, err
+		}
+		return modernSession{session}, nil
+	}
+	return nil, errors.New("unsupported backend: " + string(info.Backend))
+}
+
+// autoBackend performs the handshake with the servers of info, and returns
+// the backend suiting the maxWireVersion reported by the first one to
+// answer.
+func autoBackend(info *DialInfo) (Backend, error) {
+	info = info.Copy()
+	if info.SRVName != "" {
+		if err := info.resolveSRV(); err != nil {
+			return "", err
+		}
+	}
+	cluster := newCluster(seedAddrs(info.Addrs), info)
+	session := newSession(Monotonic, cluster, info)
+	cluster.Release()
+	defer session.Close()
+
+	// The socket comes out of the cluster synchronization, which only
+	// runs isMaster, and is not logged in as there are no credentials.
+	socket, err := session.acquireSocket(true)
+	if err != nil {
+		return "", err
+	}
+	defer socket.Release()
+
+	backend := LegacyBackend
+	wireVersion := socket.ServerInfo().MaxWireVersion
+	if wireVersion > legacyMaxWireVersion {
+		backend = ModernBackend
+	}
+	logf("Picked the %s backend for server %s with maxWireVersion %d", backend, socket.Server().Addr, wireVersion)
+	return backend, nil
+}
+
+// NewSessionAPI returns the SessionAPI of session.
+func NewSessionAPI(session *Session) SessionAPI {
+	return legacySession{session}
+}
+
+// NewModernSessionAPI returns the SessionAPI of session.
+func NewModernSessionAPI(session *ModernMGO) SessionAPI {
+	return modernSession{session}
+}
+
+// The legacy* and modern* types adapt the backends to the API interfaces,
+// wrapping up the values of the methods returning the backend types.
+
+type legacySession struct{ *Session }
+
+func (s legacySession) DB(name string) DatabaseAPI { return legacyDatabase{s.Session.DB(name)} }
+func (s legacySession) Copy() SessionAPI           { return legacySession{s.Session.Copy()} }
+func (s legacySession) Clone() SessionAPI          { return legacySession{s.Session.Clone()} }
+
+type legacyDatabase struct{ *Database }
+
+func (db legacyDatabase) C(name string) CollectionAPI { return legacyCollection{db.Database.C(name)} }
+
+type legacyCollection struct{ *Collection }
+
+func (c legacyCollection) Find(query interface{}) QueryAPI {
+	return legacyQuery{c.Collection.Find(query)}
+}
+func (c legacyCollection) FindId(id interface{}) QueryAPI {
+	return legacyQuery{c.Collection.FindId(id)}
+}
+func (c legacyCollection) Pipe(pipeline interface{}) PipeAPI {
+	return legacyPipe{c.Collection.Pipe(pipeline)}
+}
+func (c legacyCollection) Bulk() BulkAPI   { return c.Collection.Bulk() }
+func (c legacyCollection) Repair() IterAPI { return c.Collection.Repair() }
+func (c legacyCollection) Watch(pipeline interface{}, opts ChangeStreamOptions) (ChangeStreamAPI, error) {
+	stream, err := c.Collection.Watch(pipeline, opts)
+	if err != nil {
+		return nil, err
+	}
+	return stream, nil
+}
+
+type legacyQuery struct{ *Query }
+
+func (q legacyQuery) Iter() IterAPI                        { return q.Query.Iter() }
+func (q legacyQuery) Tail(timeout time.Duration) IterAPI   { return q.Query.Tail(timeout) }
+func (q legacyQuery) Sort(fields ...string) QueryAPI       { q.Query.Sort(fields...); return q }
+func (q legacyQuery) Limit(n int) QueryAPI                 { q.Query.Limit(n); return q }
+func (q legacyQuery) Skip(n int) QueryAPI                  { q.Query.Skip(n); return q }
+func (q legacyQuery) Select(selector interface{}) QueryAPI { q.Query.Select(selector); return q }
+func (q legacyQuery) Batch(n int) QueryAPI                 { q.Query.Batch(n); return q }
+func (q legacyQuery) Prefetch(p float64) QueryAPI          { q.Query.Prefetch(p); return q }
+func (q legacyQuery) Hint(indexKey ...string) QueryAPI     { q.Query.Hint(indexKey...); return q }
+func (q legacyQuery) Collation(collation *Collation) QueryAPI {
+	q.Query.Collation(collation)
+	return q
+}
+func (q legacyQuery) SetMaxTime(d time.Duration) QueryAPI { q.Query.SetMaxTime(d); return q }
+func (q legacyQuery) Comment(comment string) QueryAPI     { q.Query.Comment(comment); return q }
+
+type legacyPipe struct{ *Pipe }
+
+func (p legacyPipe) Iter() IterAPI                      { return p.Pipe.Iter() }
+func (p legacyPipe) AllowDiskUse() PipeAPI              { p.Pipe.AllowDiskUse(); return p }
+func (p legacyPipe) Batch(n int) PipeAPI                { p.Pipe.Batch(n); return p }
+func (p legacyPipe) SetMaxTime(d time.Duration) PipeAPI { p.Pipe.SetMaxTime(d); return p }
+func (p legacyPipe) Collation(collation *Collation) PipeAPI {
+	p.Pipe.Collation(collation)
+	return p
+}
+
+type modernSession struct{ *ModernMGO }
+
+func (s modernSession) DB(name string) DatabaseAPI { return modernDatabase{s.ModernMGO.DB(name)} }
+func (s modernSession) Copy() SessionAPI           { return modernSession{s.ModernMGO.Copy()} }
+func (s modernSession) Clone() SessionAPI          { return modernSession{s.ModernMGO.Clone()} }
+
+// Run runs cmd on the admin database, as Session.Run does.
+func (s modernSession) Run(cmd interface{}, result interface{}) error {
+	return s.ModernMGO.Run(true, cmd, result)
+}
+
+type modernDatabase struct{ *ModernDB }
+
+func (db modernDatabase) C(name string) CollectionAPI { return modernCollection{db.ModernDB.C(name)} }
+
+type modernCollection struct{ *ModernColl }
+
+func (c modernCollection) Find(query interface{}) QueryAPI {
+	return modernQuery{c.ModernColl.Find(query)}
+}
+func (c modernCollection) FindId(id interface{}) QueryAPI {
+	return modernQuery{c.ModernColl.FindId(id)}
+}
+func (c modernCollection) Pipe(pipeline interface{}) PipeAPI {
+	return modernPipe{c.ModernColl.Pipe(pipeline)}
+}
+func (c modernCollection) Bulk() BulkAPI   { return c.ModernColl.Bulk() }
+func (c modernCollection) Repair() IterAPI { return c.ModernColl.Repair() }
+func (c modernCollection) Watch(pipeline interface{}, opts ChangeStreamOptions) (ChangeStreamAPI, error) {
+	stream, err := c.ModernColl.Watch(pipeline, opts)
+	if err != nil {
+		return nil, err
+	}
+	return stream, nil
+}
+
+type modernQuery struct{ *ModernQ }
+
+func (q modernQuery) Iter() IterAPI                        { return q.ModernQ.Iter() }
+func (q modernQuery) Tail(timeout time.Duration) IterAPI   { return q.ModernQ.Tail(timeout) }
+func (q modernQuery) Sort(fields ...string) QueryAPI       { q.ModernQ.Sort(fields...); return q }
+func (q modernQuery) Limit(n int) QueryAPI                 { q.ModernQ.Limit(n); return q }
+func (q modernQuery) Skip(n int) QueryAPI                  { q.ModernQ.Skip(n); return q }
+func (q modernQuery) Select(selector interface{}) QueryAPI { q.ModernQ.Select(selector); return q }
+func (q modernQuery) Batch(n int) QueryAPI                 { q.ModernQ.Batch(n); return q }
+func (q modernQuery) Prefetch(p float64) QueryAPI          { q.ModernQ.Prefetch(p); return q }
+func (q modernQuery) Hint(indexKey ...string) QueryAPI     { q.ModernQ.Hint(indexKey...); return q }
+func (q modernQuery) Collation(collation *Collation) QueryAPI {
+	q.ModernQ.Collation(collation)
+	return q
+}
+func (q modernQuery) SetMaxTime(d time.Duration) QueryAPI { q.ModernQ.SetMaxTime(d); return q }
+func (q modernQuery) Comment(comment string) QueryAPI     { q.ModernQ.Comment(comment); return q }
+
+type modernPipe struct{ *ModernPipe }
+
+func (p modernPipe) Iter() IterAPI                      { return p.ModernPipe.Iter() }
+func (p modernPipe) AllowDiskUse() PipeAPI              { p.ModernPipe.AllowDiskUse(); return p }
+func (p modernPipe) Batch(n int) PipeAPI                { p.ModernPipe.Batch(n); return p }
+func (p modernPipe) SetMaxTime(d time.Duration) PipeAPI { p.ModernPipe.SetMaxTime(d); return p }
+func (p modernPipe) Collation(collation *Collation) PipeAPI {
+	p.ModernPipe.Collation(collation)
+	return p
+}
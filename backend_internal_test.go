@@ -0,0 +1,135 @@
+package mgo
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	officialBson "go.mongodb.org/mongo-driver/bson"
+	mongodrv "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	. "gopkg.in/check.v1"
+)
+
+func (s *S) TestURLBackend(c *C) {
+	tests := []struct {
+		url     string
+		backend Backend
+	}{
+		{"localhost:40001", ""},
+		{"localhost:40001?backend=auto", AutoBackend},
+		{"localhost:40001?backend=legacy", LegacyBackend},
+		{"localhost:40001?backend=modern", ModernBackend},
+	}
+	for _, test := range tests {
+		info, err := ParseURL(test.url)
+		c.Assert(err, IsNil)
+		c.Assert(info.Backend, Equals, test.backend)
+		c.Assert(info.Copy().Backend, Equals, test.backend)
+	}
+
+	_, err := ParseURL("localhost:40001?backend=other")
+	c.Assert(err, ErrorMatches, "bad value for backend: other")
+}
+
+func (s *S) TestDialAPIUnsupportedBackend(c *C) {
+	_, err := DialAPIWithInfo(&DialInfo{Backend: "other"})
+	c.Assert(err, ErrorMatches, "unsupported backend: other")
+}
+
+// fakeHandshakeServer answers every isMaster sent through conn, over
+// OP_QUERY or OP_MSG, with a primary of the given wire version, until conn
+// is closed.
+func fakeHandshakeServer(conn net.Conn, wireVersion int) {
+	defer conn.Close()
+	reply := bson.M{"ok": 1, "ismaster": true, "maxWireVersion": wireVersion}
+	for {
+		h := make([]byte, 16)
+		if err := fill(conn, h); err != nil {
+			return
+		}
+		b := make([]byte, getInt32(h, 0)-16)
+		if err := fill(conn, b); err != nil {
+			return
+		}
+		if getInt32(h, 12) == 2013 {
+			if fakeMsgReply(conn, h, reply) != nil {
+				return
+			}
+			continue
+		}
+		r := addHeader(nil, 1)
+		r = addInt32(r, 0) // Response flags
+		r = addInt64(r, 0) // Cursor id
+		r = addInt32(r, 0) // Starting from
+		r = addInt32(r, 1) // Number returned
+		r, _ = addBSON(r, reply)
+		setInt32(r, 0, int32(len(r)))
+		setInt32(r, 8, getInt32(h, 4))
+		if _, err := conn.Write(r); err != nil {
+			return
+		}
+	}
+}
+
+func (s *S) TestAutoBackend(c *C) {
+	tests := []struct {
+		wireVersion int
+		backend     Backend
+	}{
+		{5, LegacyBackend},  // MongoDB 3.4
+		{6, LegacyBackend},  // MongoDB 3.6
+		{7, ModernBackend},  // MongoDB 4.0
+		{17, ModernBackend}, // MongoDB 6.0
+	}
+	for _, test := range tests {
+		info := &DialInfo{
+			Addrs:   []string{"127.0.0.1:27017"},
+			Direct:  true,
+			Timeout: 5 * time.Second,
+			DialServer: func(addr *ServerAddr) (net.Conn, error) {
+				client, server := net.Pipe()
+				go fakeHandshakeServer(server, test.wireVersion)
+				return client, nil
+			},
+		}
+		backend, err := autoBackend(info)
+		c.Assert(err, IsNil)
+		c.Assert(backend, Equals, test.backend, Commentf("maxWireVersion %d", test.wireVersion))
+	}
+}
+
+func (s *S) TestLegacyQueryAPI(c *C) {
+	coll := NewSessionAPI(&Session{defaultdb: "db"}).DB("").C("coll")
+	q := coll.Find(bson.M{"a": 1}).Sort("-a").Skip(2).Limit(3).Batch(10).Comment("c").SetMaxTime(time.Second)
+	query := q.(legacyQuery).Query
+	c.Assert(query.op.collection, Equals, "db.coll")
+	c.Assert(query.op.skip, Equals, int32(2))
+	c.Assert(query.limit, Equals, int32(3))
+	c.Assert(query.op.options.OrderBy, DeepEquals, bson.D{{Name: "a", Value: -1}})
+	c.Assert(query.op.options.Comment, Equals, "c")
+	c.Assert(query.op.options.MaxTimeMS, Equals, 1000)
+}
+
+func (s *S) TestModernQueryAPI(c *C) {
+	client, err := mongodrv.Connect(context.Background(), options.Client().ApplyURI("mongodb://localhost:1"))
+	c.Assert(err, IsNil)
+	defer client.Disconnect(context.Background())
+
+	session := NewModernSessionAPI(newModernMGO(client, "db", newModernSettings(Strong, &Safe{}, "", nil)))
+	coll := session.DB("").C("coll")
+	q := coll.Find(bson.M{"a": 1}).Sort("-a").Skip(2).Limit(3).Batch(10).Comment("c").SetMaxTime(time.Second)
+	query := q.(modernQuery).ModernQ
+	c.Assert(query.coll.name, Equals, "coll")
+	c.Assert(query.skip, Equals, int64(2))
+	c.Assert(query.limit, Equals, int64(3))
+	c.Assert(query.sort, DeepEquals, officialBson.D{{Key: "a", Value: -1}})
+	c.Assert(query.batchSize, Equals, int32(10))
+	c.Assert(query.comment, Equals, "c")
+	c.Assert(query.maxTime, Equals, time.Second)
+
+	pipe := coll.Pipe([]bson.M{}).AllowDiskUse().Batch(5)
+	c.Assert(pipe.(modernPipe).allowDisk, Equals, true)
+	c.Assert(pipe.(modernPipe).batchSize, Equals, int32(5))
+}
@@ -268,7 +268,7 @@ func (b *Bulk) Upsert(pairs ...interface{}) {
 		}
 
 		// Wrap plain documents in $set operator for MongoDB compatibility
-		wrappedUpdate := wrapInSetOperator(pairs[i+1])
+		wrappedUpdate := b.c.Database.Session.updateDocument(pairs[i+1])
 
 		action.docs = append(action.docs, &updateOp{
 			Collection: b.c.FullName,
@@ -15,6 +15,13 @@ type FullDocument string
 const (
 	Default      = "default"
 	UpdateLookup = "updateLookup"
+
+	// WhenAvailable and Required are the values of FullDocumentBeforeChange
+	// asking for the pre-image of changed documents, if recorded or
+	// erroring out otherwise respectively. They apply to FullDocument as
+	// well, for post-images. Both require MongoDB 6.0+.
+	WhenAvailable = "whenAvailable"
+	Required      = "required"
 )
 
 type ChangeStream struct {
@@ -39,6 +46,19 @@ type ChangeStreamOptions struct {
 	// ResumeAfter specifies the logical starting point for the new change stream.
 	ResumeAfter *bson.Raw
 
+	// StartAfter is like ResumeAfter, but the change stream may start after
+	// an invalidate event. It requires MongoDB 4.2+.
+	StartAfter *bson.Raw
+
+	// StartAtOperationTime starts the change stream at the given operation
+	// time rather than at the current one. It requires MongoDB 4.0+.
+	StartAtOperationTime bson.MongoTimestamp
+
+	// FullDocumentBeforeChange controls whether the server returns the
+	// documents as they were before the change, in the fullDocumentBeforeChange
+	// field of change documents. It requires MongoDB 6.0+.
+	FullDocumentBeforeChange FullDocument
+
 	// MaxAwaitTimeMS specifies the maximum amount of time for the server to wait
 	// on new documents to satisfy a change stream query.
 	MaxAwaitTimeMS time.Duration
@@ -231,6 +251,15 @@ func constructChangeStreamPipeline(pipeline interface{},
 	if options.ResumeAfter != nil {
 		changeStreamStageOptions["resumeAfter"] = options.ResumeAfter
 	}
+	if options.StartAfter != nil {
+		changeStreamStageOptions["startAfter"] = options.StartAfter
+	}
+	if options.StartAtOperationTime != 0 {
+		changeStreamStageOptions["startAtOperationTime"] = options.StartAtOperationTime
+	}
+	if options.FullDocumentBeforeChange != "" {
+		changeStreamStageOptions["fullDocumentBeforeChange"] = options.FullDocumentBeforeChange
+	}
 
 	changeStreamStage := bson.M{"$changeStream": changeStreamStageOptions}
 
@@ -257,7 +286,7 @@ func (changeStream *ChangeStream) resume() error {
 	// fetch the cursor from the iterator and use it to run a killCursors
 	// on the connection.
 	cursorId := changeStream.iter.op.cursorId
-	err := runKillCursorsOnSession(newSession, cursorId)
+	err := runKillCursorsOnSession(newSession, changeStream.iter.op.collection, cursorId)
 	if err != nil {
 		return err
 	}
@@ -271,7 +300,10 @@ func (changeStream *ChangeStream) resume() error {
 
 	opts := changeStream.options
 	if changeStream.resumeToken != nil {
+		// The server accepts a single starting point.
 		opts.ResumeAfter = changeStream.resumeToken
+		opts.StartAfter = nil
+		opts.StartAtOperationTime = 0
 	}
 	// make a new pipeline containing the resume token.
 	changeStreamPipeline := constructChangeStreamPipeline(changeStream.pipeline, opts)
@@ -342,12 +374,12 @@ func isResumableError(err error) bool {
 	return (!isQueryError || isNotMasterError(err)) && (err != errMissingResumeToken)
 }
 
-func runKillCursorsOnSession(session *Session, cursorId int64) error {
+func runKillCursorsOnSession(session *Session, collection string, cursorId int64) error {
 	socket, err := session.acquireSocket(true)
 	if err != nil {
 		return err
 	}
-	err = socket.Query(&killCursorsOp{[]int64{cursorId}})
+	err = socket.Query(&killCursorsOp{collection, []int64{cursorId}})
 	if err != nil {
 		return err
 	}
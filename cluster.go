@@ -60,6 +60,8 @@ type mongoCluster struct {
 	sync         chan bool
 	dial         dialer
 	dialInfo     *DialInfo
+	srvPolled    time.Time
+	sessionPool  serverSessionPool
 }
 
 func newCluster(userSeeds []string, info *DialInfo) *mongoCluster {
@@ -68,6 +70,7 @@ func newCluster(userSeeds []string, info *DialInfo) *mongoCluster {
 		references: 1,
 		dial:       dialer{info.Dial, info.DialServer},
 		dialInfo:   info,
+		srvPolled:  time.Now(),
 	}
 	cluster.serverSynced.L = cluster.RWMutex.RLocker()
 	cluster.sync = make(chan bool, 1)
@@ -125,6 +128,20 @@ func (cluster *mongoCluster) removeServer(server *mongoServer) {
 	server.CloseIdle()
 }
 
+// demoteServer stops considering server a master until the next sync says
+// otherwise, after it refused a write with a "not master" error.
+func (cluster *mongoCluster) demoteServer(server *mongoServer) {
+	cluster.Lock()
+	if cluster.masters.Remove(server) != nil {
+		info := *server.Info()
+		info.Master = false
+		server.SetInfo(&info)
+		log("Server ", server.Addr, " is no longer a master; resyncing.")
+	}
+	cluster.Unlock()
+	cluster.syncServers()
+}
+
 type isMasterResult struct {
 	IsMaster       bool
 	Secondary      bool
@@ -135,6 +152,28 @@ type isMasterResult struct {
 	Msg            string
 	SetName        string `bson:"setName"`
 	MaxWireVersion int    `bson:"maxWireVersion"`
+	Compression    []string
+	SaslMechs      []string `bson:"saslSupportedMechs"`
+	MaxMessageSize int      `bson:"maxMessageSizeBytes"`
+
+	LogicalSessionTimeoutMinutes int `bson:"logicalSessionTimeoutMinutes"`
+}
+
+// saslUser returns the "<source>.<username>" name of the user authenticated
+// with on dial, if its mechanism is to be negotiated with the servers.
+func (cluster *mongoCluster) saslUser() string {
+	info := cluster.dialInfo
+	if info.Username == "" || info.Mechanism != "" {
+		return ""
+	}
+	source := info.Source
+	if source == "" {
+		source = info.Database
+		if source == "" {
+			source = "admin"
+		}
+	}
+	return source + "." + info.Username
 }
 
 func (cluster *mongoCluster) isMaster(socket *mongoSocket, result *isMasterResult) error {
@@ -173,6 +212,18 @@ func (cluster *mongoCluster) isMaster(socket *mongoSocket, result *isMasterResul
 		})
 	})
 
+	// The server replies with the compressors it supports out of these,
+	// which are then used for every socket to it.
+	if len(cluster.dialInfo.Compressors) > 0 {
+		cmd = append(cmd, bson.DocElem{Name: "compression", Value: cluster.dialInfo.Compressors})
+	}
+
+	// Ask which SCRAM mechanisms may be used for the dial credential, so
+	// that Login can pick the strongest one when none was requested.
+	if user := cluster.saslUser(); user != "" {
+		cmd = append(cmd, bson.DocElem{Name: "saslSupportedMechs", Value: user})
+	}
+
 	err := session.runOnSocket(socket, cmd, result)
 	session.Close()
 	return err
@@ -255,6 +306,14 @@ func (cluster *mongoCluster) syncServer(server *mongoServer) (info *mongoServerI
 		Tags:           result.Tags,
 		SetName:        result.SetName,
 		MaxWireVersion: result.MaxWireVersion,
+		Compressors:    result.Compression,
+		MaxMessageSize: result.MaxMessageSize,
+
+		LogicalSessionTimeout: time.Duration(result.LogicalSessionTimeoutMinutes) * time.Minute,
+	}
+	if len(result.SaslMechs) > 0 {
+		info.SaslUser = cluster.saslUser()
+		info.SaslSupportedMechs = result.SaslMechs
 	}
 
 	hosts = make([]string, 0, 1+len(result.Hosts)+len(result.Passives))
@@ -375,6 +434,7 @@ func (cluster *mongoCluster) syncServersLoop() {
 		direct := cluster.dialInfo.Direct
 		cluster.Unlock()
 
+		cluster.pollSRV()
 		cluster.syncServersIteration(direct)
 
 		// We just synchronized, so consume any outstanding requests.
@@ -0,0 +1,216 @@
+package mgo
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compressor ids as defined by the wire protocol. See:
+//
+//	https://github.com/mongodb/specifications/blob/master/source/compression/OP_COMPRESSED.rst
+const (
+	compressorNoop   uint8 = 0
+	compressorSnappy uint8 = 1
+	compressorZlib   uint8 = 2
+	compressorZstd   uint8 = 3
+)
+
+// defaultMaxMessageSize is the maximum size of messages of servers that
+// don't report their maxMessageSizeBytes.
+const defaultMaxMessageSize = 48000000
+
+var compressorIds = map[string]uint8{
+	"noop":   compressorNoop,
+	"snappy": compressorSnappy,
+	"zlib":   compressorZlib,
+	"zstd":   compressorZstd,
+}
+
+// uncompressedCommands holds the lowercased names of the commands that must
+// never be compressed, as they're part of the handshake or carry credentials.
+var uncompressedCommands = map[string]bool{
+	"ismaster":        true,
+	"hello":           true,
+	"saslstart":       true,
+	"saslcontinue":    true,
+	"getnonce":        true,
+	"authenticate":    true,
+	"createuser":      true,
+	"updateuser":      true,
+	"copydbsaslstart": true,
+	"copydbgetnonce":  true,
+	"copydb":          true,
+}
+
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+	zstdErr     error
+)
+
+func zstdCodecs() (*zstd.Encoder, *zstd.Decoder, error) {
+	zstdOnce.Do(func() {
+		zstdEncoder, zstdErr = zstd.NewWriter(nil)
+		if zstdErr == nil {
+			zstdDecoder, zstdErr = zstd.NewReader(nil)
+		}
+	})
+	return zstdEncoder, zstdDecoder, zstdErr
+}
+
+// pickCompressor returns the id of the first of the negotiated compressors
+// that is actually able to compress, and whether there is one at all.
+func pickCompressor(negotiated []string) (id uint8, ok bool) {
+	for _, name := range negotiated {
+		if id, ok := compressorIds[name]; ok && id != compressorNoop {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+// compressible returns whether the serialized message msg may be sent as an
+// OP_COMPRESSED, which is not the case for handshake and authentication
+// commands, nor for checksummed OP_MSGs.
+func compressible(msg []byte) bool {
+	var doc []byte
+	switch getInt32(msg, 12) {
+	case 2004:
+		collection := msg[20:]
+		end := bytes.IndexByte(collection, 0)
+		if end < 0 || !strings.HasSuffix(string(collection[:end]), ".$cmd") {
+			return true
+		}
+		if len(collection) < end+1+8 {
+			return false
+		}
+		doc = collection[end+1+8:]
+	case 2013:
+		if msgFlags(getInt32(msg, 16))&msgFlagChecksumPresent != 0 {
+			return false
+		}
+		doc = msg[21:]
+	default:
+		return true
+	}
+	name := firstKey(doc)
+	if (name == "$query" || name == "query") && len(doc) > 4+1+len(name)+1 {
+		// Wrapped with query options; the command is the nested document.
+		name = firstKey(doc[4+1+len(name)+1:])
+	}
+	return !uncompressedCommands[strings.ToLower(name)]
+}
+
+// firstKey returns the name of the first element in the BSON document doc.
+func firstKey(doc []byte) string {
+	if len(doc) < 6 || doc[4] == 0 {
+		return ""
+	}
+	name := doc[5:]
+	end := bytes.IndexByte(name, 0)
+	if end < 0 {
+		return ""
+	}
+	return string(name[:end])
+}
+
+// compressMsg replaces the serialized message starting at b[start:] with an
+// equivalent OP_COMPRESSED, leaving its length to be set by the caller.
+func compressMsg(b []byte, start int, id uint8, level int) ([]byte, error) {
+	requestId := getInt32(b, start+4)
+	responseTo := getInt32(b, start+8)
+	opcode := getInt32(b, start+12)
+	body := make([]byte, len(b)-start-16)
+	copy(body, b[start+16:])
+
+	b = addHeader(b[:start], 2012)
+	setInt32(b, start+4, requestId)
+	setInt32(b, start+8, responseTo)
+	b = addInt32(b, opcode)
+	b = addInt32(b, int32(len(body)))
+	b = append(b, id)
+
+	switch id {
+	case compressorNoop:
+		return append(b, body...), nil
+	case compressorSnappy:
+		return append(b, snappy.Encode(nil, body)...), nil
+	case compressorZlib:
+		buf := bytes.NewBuffer(b)
+		w, err := zlib.NewWriterLevel(buf, level)
+		if err != nil {
+			return b, err
+		}
+		if _, err := w.Write(body); err != nil {
+			return b, err
+		}
+		if err := w.Close(); err != nil {
+			return b, err
+		}
+		return buf.Bytes(), nil
+	case compressorZstd:
+		enc, _, err := zstdCodecs()
+		if err != nil {
+			return b, err
+		}
+		return enc.EncodeAll(body, b), nil
+	}
+	return b, fmt.Errorf("unknown compressor id %d", id)
+}
+
+// decompressMsg parses the body b of an OP_COMPRESSED message, returning the
+// original opcode and the uncompressed body of the original message, which
+// may not make a message of more than maxSize bytes.
+func decompressMsg(b []byte, maxSize int) (opcode int32, body []byte, err error) {
+	if len(b) < 9 {
+		return 0, nil, fmt.Errorf("OP_COMPRESSED of %d bytes is too short, corrupted data?", len(b)+16)
+	}
+	opcode = getInt32(b, 0)
+	size := int(getInt32(b, 4))
+	id := b[8]
+	data := b[9:]
+	if size < 0 {
+		return 0, nil, fmt.Errorf("OP_COMPRESSED has a negative size, corrupted data?")
+	}
+	if 16+size > maxSize {
+		return 0, nil, fmt.Errorf("OP_COMPRESSED of %d bytes once decompressed exceeds the maximum message size of %d bytes, corrupted data?", 16+size, maxSize)
+	}
+
+	switch id {
+	case compressorNoop:
+		body = data
+	case compressorSnappy:
+		body, err = snappy.Decode(make([]byte, size), data)
+	case compressorZlib:
+		var r io.ReadCloser
+		r, err = zlib.NewReader(bytes.NewReader(data))
+		if err == nil {
+			body = make([]byte, size)
+			_, err = io.ReadFull(r, body)
+			r.Close()
+		}
+	case compressorZstd:
+		var dec *zstd.Decoder
+		_, dec, err = zstdCodecs()
+		if err == nil {
+			body, err = dec.DecodeAll(data, make([]byte, 0, size))
+		}
+	default:
+		return 0, nil, fmt.Errorf("OP_COMPRESSED has unknown compressor id %d, corrupted data?", id)
+	}
+	if err != nil {
+		return 0, nil, fmt.Errorf("OP_COMPRESSED decompression failed: %v", err)
+	}
+	if len(body) != size {
+		return 0, nil, fmt.Errorf("OP_COMPRESSED has %d bytes once decompressed, expected %d, corrupted data?", len(body), size)
+	}
+	return opcode, body, nil
+}
@@ -0,0 +1,205 @@
+package mgo
+
+import (
+	"compress/zlib"
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/globalsign/mgo/bson"
+	. "gopkg.in/check.v1"
+)
+
+func (s *S) TestCompressMsgRoundTrip(c *C) {
+	for _, id := range []uint8{compressorNoop, compressorSnappy, compressorZlib, compressorZstd} {
+		b, err := addMsg([]byte("prefix"), &msgOp{
+			body:     bson.D{{Name: "insert", Value: "coll"}, {Name: "pad", Value: strings.Repeat("x", 4096)}},
+			database: "db",
+		})
+		c.Assert(err, IsNil)
+		start := len("prefix")
+		setInt32(b, start, int32(len(b)-start))
+		setInt32(b, start+4, 42)
+		orig := append([]byte(nil), b[start:]...)
+
+		b, err = compressMsg(b, start, id, zlib.DefaultCompression)
+		c.Assert(err, IsNil)
+		setInt32(b, start, int32(len(b)-start))
+		c.Assert(string(b[:start]), Equals, "prefix")
+		c.Assert(getInt32(b, start+4), Equals, int32(42))
+		c.Assert(getInt32(b, start+12), Equals, int32(2012))
+		if id != compressorNoop {
+			c.Assert(len(b)-start < len(orig), Equals, true)
+		}
+
+		opcode, body, err := decompressMsg(b[start+16:], defaultMaxMessageSize)
+		c.Assert(err, IsNil)
+		c.Assert(opcode, Equals, int32(2013))
+		c.Assert(body, DeepEquals, orig[16:])
+	}
+}
+
+func (s *S) TestDecompressMsgErrors(c *C) {
+	_, _, err := decompressMsg([]byte{1, 2, 3}, defaultMaxMessageSize)
+	c.Assert(err, ErrorMatches, "OP_COMPRESSED of 19 bytes is too short.*")
+
+	b := []byte{221, 7, 0, 0, 10, 0, 0, 0, 9, 0}
+	_, _, err = decompressMsg(b, defaultMaxMessageSize)
+	c.Assert(err, ErrorMatches, "OP_COMPRESSED has unknown compressor id 9.*")
+
+	b[8] = compressorNoop
+	_, _, err = decompressMsg(b, defaultMaxMessageSize)
+	c.Assert(err, ErrorMatches, "OP_COMPRESSED has 1 bytes once decompressed, expected 10.*")
+
+	// Sizes are checked before anything is allocated for them.
+	for _, id := range []uint8{compressorNoop, compressorSnappy, compressorZlib, compressorZstd} {
+		b = []byte{221, 7, 0, 0, 0xff, 0xff, 0xff, 0x7f, id, 0}
+		_, _, err = decompressMsg(b, (&mongoServerInfo{}).maxMessageSize())
+		c.Assert(err, ErrorMatches, "OP_COMPRESSED of 2147483663 bytes once decompressed exceeds the maximum message size of 48000000 bytes.*")
+	}
+	setInt32(b, 4, 100)
+	_, _, err = decompressMsg(b, (&mongoServerInfo{MaxMessageSize: 64}).maxMessageSize())
+	c.Assert(err, ErrorMatches, "OP_COMPRESSED of 116 bytes once decompressed exceeds the maximum message size of 64 bytes.*")
+}
+
+func (s *S) TestCompressible(c *C) {
+	msg := func(body interface{}) []byte {
+		b, err := addMsg(nil, &msgOp{body: body, database: "admin"})
+		c.Assert(err, IsNil)
+		return b
+	}
+	c.Assert(compressible(msg(bson.D{{Name: "find", Value: "coll"}})), Equals, true)
+	c.Assert(compressible(msg(bson.D{{Name: "isMaster", Value: 1}})), Equals, false)
+	c.Assert(compressible(msg(bson.D{{Name: "saslStart", Value: 1}})), Equals, false)
+	c.Assert(compressible(msg(&getNonceCmd{GetNonce: 1})), Equals, false)
+
+	query := func(collection string, query interface{}) []byte {
+		b := addHeader(nil, 2004)
+		b = addInt32(b, 0)
+		b = addCString(b, collection)
+		b = addInt32(b, 0)
+		b = addInt32(b, -1)
+		b, err := addBSON(b, query)
+		c.Assert(err, IsNil)
+		return b
+	}
+	c.Assert(compressible(query("admin.$cmd", bson.D{{Name: "ismaster", Value: 1}})), Equals, false)
+	c.Assert(compressible(query("admin.$cmd", bson.D{{Name: "$query", Value: bson.D{{Name: "isMaster", Value: 1}}}})), Equals, false)
+	c.Assert(compressible(query("admin.$cmd", bson.D{{Name: "ping", Value: 1}})), Equals, true)
+	c.Assert(compressible(query("db.coll", bson.D{{Name: "isMaster", Value: 1}})), Equals, true)
+}
+
+func (s *S) TestPickCompressor(c *C) {
+	_, ok := pickCompressor(nil)
+	c.Assert(ok, Equals, false)
+	_, ok = pickCompressor([]string{"noop"})
+	c.Assert(ok, Equals, false)
+	id, ok := pickCompressor([]string{"noop", "zlib", "snappy"})
+	c.Assert(ok, Equals, true)
+	c.Assert(id, Equals, compressorZlib)
+}
+
+func (s *S) TestSocketQueryCompressed(c *C) {
+	client, server := net.Pipe()
+	defer server.Close()
+
+	mserver := &mongoServer{Addr: "fake", info: &mongoServerInfo{
+		MaxWireVersion: opMsgMinWireVersion,
+		Compressors:    []string{"snappy"},
+	}}
+	socket := newSocket(mserver, client, &DialInfo{})
+	defer socket.kill(errors.New("test done"), false)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- func() error {
+			h := make([]byte, 16)
+			if err := fill(server, h); err != nil {
+				return err
+			}
+			if getInt32(h, 12) != 2012 {
+				return errors.New("request is not an OP_COMPRESSED")
+			}
+			b := make([]byte, getInt32(h, 0)-16)
+			if err := fill(server, b); err != nil {
+				return err
+			}
+			opcode, body, err := decompressMsg(b, defaultMaxMessageSize)
+			if err != nil {
+				return err
+			}
+			if opcode != 2013 {
+				return errors.New("compressed request is not an OP_MSG")
+			}
+
+			// Reply with a compressed OP_MSG as well, as a server would.
+			r, err := addMsg(nil, &msgOp{})
+			if err != nil {
+				return err
+			}
+			r, _ = addBSON(r[:21], bson.M{"ok": 1, "echo": body[5:]})
+			setInt32(r, 0, int32(len(r)))
+			setInt32(r, 8, getInt32(h, 4))
+			r, err = compressMsg(r, 0, compressorZlib, zlib.DefaultCompression)
+			if err != nil {
+				return err
+			}
+			setInt32(r, 0, int32(len(r)))
+			_, err = server.Write(r)
+			return err
+		}()
+	}()
+
+	op := &queryOp{
+		collection: "db.$cmd",
+		query:      bson.D{{Name: "count", Value: "coll"}},
+		limit:      -1,
+	}
+	data, err := socket.SimpleQuery(op)
+	c.Assert(err, IsNil)
+	c.Assert(<-done, IsNil)
+
+	var result struct{ Echo []byte }
+	c.Assert(bson.Unmarshal(data, &result), IsNil)
+	var echo bson.D
+	c.Assert(bson.Unmarshal(result.Echo, &echo), IsNil)
+	c.Assert(echo, DeepEquals, bson.D{{Name: "count", Value: "coll"}, {Name: "$db", Value: "db"}})
+}
+
+func (s *S) TestSocketCompressedTooLarge(c *C) {
+	client, server := net.Pipe()
+	defer server.Close()
+
+	mserver := &mongoServer{Addr: "fake", info: &mongoServerInfo{
+		MaxWireVersion: opMsgMinWireVersion,
+		MaxMessageSize: 1024,
+	}}
+	socket := newSocket(mserver, client, &DialInfo{})
+	defer socket.kill(errors.New("test done"), false)
+
+	done := make(chan error, 1)
+	go func() {
+		h, _, err := fakeMsgRequest(server)
+		if err != nil {
+			done <- err
+			return
+		}
+		// Only the header is sent, as the length must be rejected before
+		// the rest of the message is read.
+		r := make([]byte, 16)
+		setInt32(r, 0, 1025)
+		setInt32(r, 8, getInt32(h, 4))
+		setInt32(r, 12, 2012)
+		_, err = server.Write(r)
+		done <- err
+	}()
+
+	op := &queryOp{
+		collection: "db.$cmd",
+		query:      bson.D{{Name: "count", Value: "coll"}},
+		limit:      -1,
+	}
+	_, err := socket.SimpleQuery(op)
+	c.Assert(err, ErrorMatches, "OP_COMPRESSED of 1025 bytes is too large.*")
+	c.Assert(<-done, IsNil)
+}
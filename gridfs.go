@@ -28,6 +28,7 @@ package mgo
 
 import (
 	"crypto/md5"
+	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"hash"
@@ -61,6 +62,60 @@ import (
 type GridFS struct {
 	Files  *Collection
 	Chunks *Collection
+
+	chunkSize int            // Chunk size of new files; see GridFSOptions
+	checksum  GridFSChecksum // Checksum of new files; see GridFSOptions
+	verify    bool           // Whether reads verify checksums; see GridFSOptions
+}
+
+// GridFSChecksum is the algorithm of the checksum GridFS computes for the
+// data of the files it stores. See GridFSOptions.
+type GridFSChecksum int
+
+const (
+	// ChecksumMD5 stores the MD5 checksum of files in their md5 field, as
+	// GridFS always did.
+	ChecksumMD5 GridFSChecksum = iota
+	// ChecksumNone doesn't compute any checksum.
+	ChecksumNone
+	// ChecksumSHA256 stores the SHA-256 checksum of files in their sha256
+	// field, for environments where MD5 is not allowed.
+	ChecksumSHA256
+)
+
+// ErrChecksumMismatch is returned when reading a GridFS file verified
+// against its checksum reaches the end of data not matching it.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// GridFSOptions holds the settings of a GridFS bucket, as defined by the
+// GridFS specification. See Database.GridFSWithOptions.
+type GridFSOptions struct {
+	// ChunkSize is the size in bytes of the chunks of the files created in
+	// the bucket, unless changed with SetChunkSize. It's 255kB if zero.
+	ChunkSize int
+
+	// Safe is the safety mode of the writes made on the bucket, in place of
+	// the one of the session when not nil. See Session.SetSafe.
+	Safe *Safe
+
+	// Checksum is the algorithm of the checksum computed for the files
+	// created in the bucket. It's ChecksumMD5 by default.
+	Checksum GridFSChecksum
+
+	// VerifyChecksum makes files read from the bucket verify their data
+	// against their stored checksum when reaching its end, returning
+	// ErrChecksumMismatch rather than io.EOF if it doesn't match. Only
+	// data read sequentially from the start of the file is verified.
+	VerifyChecksum bool
+}
+
+// GridFSUploadOptions holds the settings of a file uploaded with
+// UploadFromStream.
+type GridFSUploadOptions struct {
+	Id          interface{} // Id of the file; a new ObjectId if nil
+	ChunkSize   int         // Chunk size of the file; the bucket's one if zero
+	ContentType string      // Content type of the file
+	Metadata    interface{} // Metadata of the file, as given to SetMeta
 }
 
 type gfsFileMode int
@@ -85,9 +140,12 @@ type GridFile struct {
 	wpending int
 	wbuf     []byte
 	wsum     hash.Hash
+	checksum GridFSChecksum
 
-	rbuf   []byte
-	rcache *gfsCachedChunk
+	rbuf    []byte
+	rcache  *gfsCachedChunk
+	rsum    hash.Hash // Checksum of the data read from the start, if verifying
+	rsummed int64     // Length of the data in rsum
 
 	doc gfsFile
 }
@@ -97,10 +155,11 @@ type gfsFile struct {
 	ChunkSize   int         `bson:"chunkSize"`
 	UploadDate  time.Time   `bson:"uploadDate"`
 	Length      int64       `bson:",minsize"`
-	MD5         string
-	Filename    string    `bson:",omitempty"`
-	ContentType string    `bson:"contentType,omitempty"`
-	Metadata    *bson.Raw `bson:",omitempty"`
+	MD5         string      `bson:",omitempty"`
+	SHA256      string      `bson:"sha256,omitempty"`
+	Filename    string      `bson:",omitempty"`
+	ContentType string      `bson:"contentType,omitempty"`
+	Metadata    *bson.Raw   `bson:",omitempty"`
 }
 
 type gfsChunk struct {
@@ -117,8 +176,56 @@ type gfsCachedChunk struct {
 	err  error
 }
 
-func newGridFS(db *Database, prefix string) *GridFS {
-	return &GridFS{db.C(prefix + ".files"), db.C(prefix + ".chunks")}
+func newGridFS(db *Database, prefix string, opts GridFSOptions) *GridFS {
+	return &GridFS{
+		Files:     db.C(prefix + ".files").withSafe(opts.Safe),
+		Chunks:    db.C(prefix + ".chunks").withSafe(opts.Safe),
+		chunkSize: gfsChunkSize(opts.ChunkSize),
+		checksum:  opts.Checksum,
+		verify:    opts.VerifyChecksum,
+	}
+}
+
+// newHash returns the hash computing the checksum, or nil if there's none.
+func (checksum GridFSChecksum) newHash() hash.Hash {
+	switch checksum {
+	case ChecksumMD5:
+		return md5.New()
+	case ChecksumSHA256:
+		return sha256.New()
+	}
+	return nil
+}
+
+// storedChecksum returns the algorithm and the hex-encoded value of the
+// checksum stored for the file, preferring SHA-256 to MD5.
+func (doc *gfsFile) storedChecksum() (GridFSChecksum, string) {
+	switch {
+	case doc.SHA256 != "":
+		return ChecksumSHA256, doc.SHA256
+	case doc.MD5 != "":
+		return ChecksumMD5, doc.MD5
+	}
+	return ChecksumNone, ""
+}
+
+// setChecksum stores sum as the hex-encoded checksum of the file.
+func (doc *gfsFile) setChecksum(checksum GridFSChecksum, sum hash.Hash) {
+	switch checksum {
+	case ChecksumMD5:
+		doc.MD5 = hex.EncodeToString(sum.Sum(nil))
+	case ChecksumSHA256:
+		doc.SHA256 = hex.EncodeToString(sum.Sum(nil))
+	}
+}
+
+// gfsChunkSize returns the chunk size of new files given the size
+// requested, which is the default one if zero.
+func gfsChunkSize(size int) int {
+	if size > 0 {
+		return size
+	}
+	return 255 * 1024
 }
 
 func (gfs *GridFS) newFile() *GridFile {
@@ -128,6 +235,18 @@ func (gfs *GridFS) newFile() *GridFile {
 	return file
 }
 
+// newReader returns the file described by doc, open for reading.
+func (gfs *GridFS) newReader(doc gfsFile) *GridFile {
+	file := gfs.newFile()
+	file.mode = gfsReading
+	file.doc = doc
+	if gfs.verify {
+		checksum, _ := doc.storedChecksum()
+		file.rsum = checksum.newHash()
+	}
+	return file
+}
+
 func finalizeFile(file *GridFile) {
 	file.Close()
 }
@@ -172,8 +291,9 @@ func finalizeFile(file *GridFile) {
 func (gfs *GridFS) Create(name string) (file *GridFile, err error) {
 	file = gfs.newFile()
 	file.mode = gfsWriting
-	file.wsum = md5.New()
-	file.doc = gfsFile{Id: bson.NewObjectId(), ChunkSize: 255 * 1024, Filename: name}
+	file.checksum = gfs.checksum
+	file.wsum = gfs.checksum.newHash()
+	file.doc = gfsFile{Id: bson.NewObjectId(), ChunkSize: gfsChunkSize(gfs.chunkSize), Filename: name}
 	return
 }
 
@@ -218,9 +338,7 @@ func (gfs *GridFS) OpenId(id interface{}) (file *GridFile, err error) {
 	if err != nil {
 		return
 	}
-	file = gfs.newFile()
-	file.mode = gfsReading
-	file.doc = doc
+	file = gfs.newReader(doc)
 	return
 }
 
@@ -256,15 +374,45 @@ func (gfs *GridFS) OpenId(id interface{}) (file *GridFile, err error) {
 //	err = file.Close()
 //	check(err)
 func (gfs *GridFS) Open(name string) (file *GridFile, err error) {
-	var doc gfsFile
-	err = gfs.Files.Find(bson.M{"filename": name}).Sort("-uploadDate").One(&doc)
+	return gfs.OpenRevision(name, -1)
+}
+
+// OpenRevision returns the given revision of the file with the provided
+// name, for reading, as ordered by upload date. Revision 0 is the first
+// file uploaded with that name, 1 the second one and so on, while -1 is
+// the most recent one, -2 the one before it and so on. If there's no
+// such revision, err will be set to mgo.ErrNotFound.
+//
+// It's important to Close files whether they are being written to
+// or read from, and to check the err result to ensure the operation
+// completed successfully.
+//
+// Relevant documentation:
+//
+//	https://github.com/mongodb/specifications/blob/master/source/gridfs/gridfs-spec.md
+func (gfs *GridFS) OpenRevision(name string, revision int) (file *GridFile, err error) {
+	doc, err := gfsFindRevision(legacyCollection{gfs.Files}, name, revision)
 	if err != nil {
-		return
+		return nil, err
 	}
-	file = gfs.newFile()
-	file.mode = gfsReading
-	file.doc = doc
-	return
+	return gfs.newReader(doc), nil
+}
+
+// gfsFindRevision finds the document of the given revision of the file
+// with the provided name in the files collection, as OpenRevision does.
+func gfsFindRevision(files CollectionAPI, name string, revision int) (doc gfsFile, err error) {
+	sort, skip := gfsRevisionOrder(revision)
+	err = files.Find(bson.M{"filename": name}).Sort(sort).Skip(skip).One(&doc)
+	return doc, err
+}
+
+// gfsRevisionOrder returns the upload date sort order of the files and
+// the number of them to skip to find the given revision.
+func gfsRevisionOrder(revision int) (sort string, skip int) {
+	if revision < 0 {
+		return "-uploadDate", -revision - 1
+	}
+	return "uploadDate", revision
 }
 
 // OpenNext opens the next file from iter for reading, sets *file to it,
@@ -305,10 +453,7 @@ func (gfs *GridFS) OpenNext(iter *Iter, file **GridFile) bool {
 		*file = nil
 		return false
 	}
-	f := gfs.newFile()
-	f.mode = gfsReading
-	f.doc = doc
-	*file = f
+	*file = gfs.newReader(doc)
 	return true
 }
 
@@ -357,6 +502,307 @@ func (gfs *GridFS) Remove(name string) (err error) {
 	return err
 }
 
+// Rename changes the name of the file with the provided id. If the
+// file isn't found, ErrNotFound is returned.
+func (gfs *GridFS) Rename(id interface{}, name string) error {
+	return gfsRename(legacyCollection{gfs.Files}, id, name)
+}
+
+// gfsRename changes the name of the file with the provided id in the
+// files collection, as Rename does.
+func gfsRename(files CollectionAPI, id interface{}, name string) error {
+	return files.UpdateId(id, bson.M{"$set": bson.M{"filename": name}})
+}
+
+// Drop removes the files and chunks collections of the GridFS, along
+// with every file stored in them.
+func (gfs *GridFS) Drop() error {
+	return gfsDrop(legacyCollection{gfs.Files}, legacyCollection{gfs.Chunks})
+}
+
+// gfsDrop drops the files and chunks collections, as Drop does.
+func gfsDrop(files, chunks CollectionAPI) error {
+	err := files.DropCollection()
+	if err != nil && !isNsNotFound(err) {
+		return err
+	}
+	err = chunks.DropCollection()
+	if err != nil && !isNsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// UploadFromStream creates a file with the provided name holding the
+// data read from source until EOF, and returns its id. The file isn't
+// created if reading or writing fails.
+func (gfs *GridFS) UploadFromStream(name string, source io.Reader, opts GridFSUploadOptions) (id interface{}, err error) {
+	file, err := gfs.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	return gfsUploadFromStream(file, source, opts)
+}
+
+// gfsWriteFile is the part of the GridFile API implemented by both
+// backends used to write files.
+type gfsWriteFile interface {
+	io.Writer
+	Id() interface{}
+	SetId(id interface{})
+	SetChunkSize(bytes int)
+	SetContentType(ctype string)
+	SetMeta(metadata interface{})
+	Abort()
+	Close() error
+}
+
+// gfsUploadFromStream writes the data read from source until EOF to file,
+// just created, as UploadFromStream does, and returns its id.
+func gfsUploadFromStream(file gfsWriteFile, source io.Reader, opts GridFSUploadOptions) (id interface{}, err error) {
+	if opts.Id != nil {
+		file.SetId(opts.Id)
+	}
+	if opts.ChunkSize > 0 {
+		file.SetChunkSize(opts.ChunkSize)
+	}
+	if opts.ContentType != "" {
+		file.SetContentType(opts.ContentType)
+	}
+	if opts.Metadata != nil {
+		file.SetMeta(opts.Metadata)
+	}
+	if _, err := io.Copy(file, source); err != nil {
+		file.Abort()
+		file.Close()
+		return nil, err
+	}
+	if err := file.Close(); err != nil {
+		return nil, err
+	}
+	return file.Id(), nil
+}
+
+// DownloadToStream writes the data of the file with the provided id to
+// destination, and returns the number of bytes written. If the file isn't
+// found, ErrNotFound is returned.
+func (gfs *GridFS) DownloadToStream(id interface{}, destination io.Writer) (n int64, err error) {
+	file, err := gfs.OpenId(id)
+	if err != nil {
+		return 0, err
+	}
+	return gfsDownloadToStream(file, destination)
+}
+
+// gfsDownloadToStream writes the data of file, open for reading, to
+// destination and closes it, as DownloadToStream does.
+func gfsDownloadToStream(file io.ReadCloser, destination io.Writer) (n int64, err error) {
+	n, err = io.Copy(destination, file)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	return n, err
+}
+
+// GridFSFsckOptions holds the settings of GridFS.Fsck.
+type GridFSFsckOptions struct {
+	// Repair fixes the damage that loses no data: orphaned chunks and the
+	// duplicates of chunks are removed, and files with all of their chunks
+	// get the length of the data in them.
+	Repair bool
+
+	// Delete removes the damaged files along with their chunks, as well as
+	// orphaned chunks. With Repair, only files that can't be repaired
+	// because chunks are missing are removed.
+	Delete bool
+
+	// MinAge is the age chunks must have, going by the time in their
+	// ObjectId, to be checked for being orphaned. Newer ones may belong to
+	// files still being written, whose document is only inserted once
+	// they're closed, so it should be longer than any upload. It's an hour
+	// if zero, and chunks of any age are checked if it's negative. Chunks
+	// with ids that aren't ObjectIds are always checked.
+	MinAge time.Duration
+}
+
+// gfsFsckMinAge is the default of GridFSFsckOptions.MinAge.
+const gfsFsckMinAge = time.Hour
+
+// orphanFilter returns the query for the chunks checked for being orphaned
+// at time now, which are old enough as set by MinAge.
+func (opts *GridFSFsckOptions) orphanFilter(now time.Time) bson.M {
+	minAge := opts.MinAge
+	if minAge == 0 {
+		minAge = gfsFsckMinAge
+	}
+	if minAge < 0 {
+		return bson.M{}
+	}
+	// $gte only matches ObjectIds, so $not matches the ids of other types.
+	return bson.M{"_id": bson.M{"$not": bson.M{"$gte": bson.NewObjectIdWithTime(now.Add(-minAge))}}}
+}
+
+// gfsOrphanPipeline returns the pipeline grouping the chunks matching
+// filter by the id of their file.
+func gfsOrphanPipeline(filter bson.M) []bson.M {
+	return []bson.M{{"$match": filter}, {"$group": bson.M{"_id": "$files_id"}}}
+}
+
+// gfsOrphanGroup is a result of gfsOrphanPipeline.
+type gfsOrphanGroup struct {
+	FilesId interface{} `bson:"_id"`
+}
+
+// GridFSFsckResult reports the damage found by GridFS.Fsck.
+type GridFSFsckResult struct {
+	Files   int              // Number of files checked
+	Damaged []GridFSFsckFile // Files with missing or duplicate chunks, or a wrong length
+	Orphans []interface{}    // Ids of the missing files orphaned chunks belong to
+}
+
+// GridFSFsckFile reports the damage to a file found by GridFS.Fsck.
+type GridFSFsckFile struct {
+	Id              interface{} // Id of the file
+	Name            string      // Name of the file
+	MissingChunks   []int       // Numbers of the chunks missing
+	DuplicateChunks []int       // Numbers held by more than one chunk
+	Length          int64       // Length of the file in the files collection
+	ChunksLength    int64       // Length of the data in the chunks
+	Repaired        bool        // Whether the file was repaired
+	Deleted         bool        // Whether the file was deleted
+}
+
+// damaged returns whether any damage was found.
+func (file *GridFSFsckFile) damaged() bool {
+	return len(file.MissingChunks) > 0 || len(file.DuplicateChunks) > 0 || file.Length != file.ChunksLength
+}
+
+// gfsCheckFile checks the chunks of the file described by doc, which next
+// stores one at a time ordered by number. It returns the damage found and
+// the ids of the duplicate chunks, which repairing the file removes.
+func gfsCheckFile(doc *gfsFile, next func(chunk *gfsChunk) bool) (file GridFSFsckFile, duplicates []interface{}) {
+	file = GridFSFsckFile{Id: doc.Id, Name: doc.Filename, Length: doc.Length}
+	n := 0
+	for {
+		var chunk gfsChunk
+		if !next(&chunk) {
+			break
+		}
+		if chunk.N < n {
+			if k := len(file.DuplicateChunks); k == 0 || file.DuplicateChunks[k-1] != chunk.N {
+				file.DuplicateChunks = append(file.DuplicateChunks, chunk.N)
+			}
+			duplicates = append(duplicates, chunk.Id)
+			continue
+		}
+		for ; n < chunk.N; n++ {
+			file.MissingChunks = append(file.MissingChunks, n)
+		}
+		file.ChunksLength += int64(len(chunk.Data))
+		n++
+	}
+	if doc.ChunkSize > 0 {
+		chunks := int((doc.Length + int64(doc.ChunkSize) - 1) / int64(doc.ChunkSize))
+		for ; n < chunks; n++ {
+			file.MissingChunks = append(file.MissingChunks, n)
+		}
+	}
+	return file, duplicates
+}
+
+// Fsck checks the consistency of the files and chunks collections of the
+// GridFS, finding the chunks whose file doesn't exist, the files with
+// missing chunks or several chunks with the same number, and the files
+// whose length doesn't match the data in their chunks. The damage found
+// is repaired or deleted as requested in opts.
+//
+// Every chunk of the GridFS is read, so Fsck may take a long while on
+// large ones.
+//
+// Chunks written in the last MinAge, an hour by default, aren't checked
+// for being orphaned, as they may belong to files still being written.
+func (gfs *GridFS) Fsck(opts GridFSFsckOptions) (*GridFSFsckResult, error) {
+	return gfsFsck(legacyCollection{gfs.Files}, legacyCollection{gfs.Chunks}, gfs.RemoveId, opts)
+}
+
+// gfsFsck checks the GridFS with the given files and chunks collections as
+// Fsck does. removeFile removes a file along with its chunks.
+func gfsFsck(files, chunks CollectionAPI, removeFile func(id interface{}) error, opts GridFSFsckOptions) (*GridFSFsckResult, error) {
+	result := &GridFSFsckResult{}
+	iter := files.Find(nil).Iter()
+	for {
+		var doc gfsFile
+		if !iter.Next(&doc) {
+			break
+		}
+		result.Files++
+		fileChunks := chunks.Find(bson.M{"files_id": doc.Id}).Sort("n", "_id").Iter()
+		file, duplicates := gfsCheckFile(&doc, func(chunk *gfsChunk) bool { return fileChunks.Next(chunk) })
+		if err := fileChunks.Close(); err != nil {
+			iter.Close()
+			return result, err
+		}
+		if !file.damaged() {
+			continue
+		}
+		if err := gfsFsckFile(files, chunks, removeFile, &file, duplicates, opts); err != nil {
+			iter.Close()
+			return result, err
+		}
+		result.Damaged = append(result.Damaged, file)
+	}
+	if err := iter.Close(); err != nil {
+		return result, err
+	}
+
+	filter := opts.orphanFilter(time.Now())
+	groups := chunks.Pipe(gfsOrphanPipeline(filter)).AllowDiskUse().Iter()
+	var group gfsOrphanGroup
+	for groups.Next(&group) {
+		id := group.FilesId
+		n, err := files.FindId(id).Count()
+		if err != nil {
+			groups.Close()
+			return result, err
+		}
+		if n > 0 {
+			continue
+		}
+		result.Orphans = append(result.Orphans, id)
+		if opts.Repair || opts.Delete {
+			if _, err := chunks.RemoveAll(bson.M{"$and": []bson.M{filter, {"files_id": id}}}); err != nil {
+				groups.Close()
+				return result, err
+			}
+		}
+	}
+	return result, groups.Close()
+}
+
+// gfsFsckFile repairs or deletes the damaged file as requested in opts.
+func gfsFsckFile(files, chunks CollectionAPI, removeFile func(id interface{}) error, file *GridFSFsckFile, duplicates []interface{}, opts GridFSFsckOptions) error {
+	switch {
+	case opts.Repair && len(file.MissingChunks) == 0:
+		if len(duplicates) > 0 {
+			if _, err := chunks.RemoveAll(bson.M{"_id": bson.M{"$in": duplicates}}); err != nil {
+				return err
+			}
+		}
+		if file.Length != file.ChunksLength {
+			if err := files.UpdateId(file.Id, bson.M{"$set": bson.M{"length": file.ChunksLength}}); err != nil {
+				return err
+			}
+		}
+		file.Repaired = true
+	case opts.Delete:
+		if err := removeFile(file.Id); err != nil {
+			return err
+		}
+		file.Deleted = true
+	}
+	return nil
+}
+
 func (file *GridFile) assertMode(mode gfsFileMode) {
 	switch file.mode {
 	case mode:
@@ -485,11 +931,18 @@ func (file *GridFile) Size() (bytes int64) {
 	return
 }
 
-// MD5 returns the file MD5 as a hex-encoded string.
+// MD5 returns the file MD5 as a hex-encoded string. It's empty if the
+// file was stored with another checksum algorithm.
 func (file *GridFile) MD5() (md5 string) {
 	return file.doc.MD5
 }
 
+// SHA256 returns the file SHA-256 as a hex-encoded string. It's empty if
+// the file was stored with another checksum algorithm.
+func (file *GridFile) SHA256() string {
+	return file.doc.SHA256
+}
+
 // UploadDate returns the file upload time.
 func (file *GridFile) UploadDate() time.Time {
 	return file.doc.UploadDate
@@ -536,11 +989,10 @@ func (file *GridFile) completeWrite() {
 		file.c.Wait()
 	}
 	if file.err == nil {
-		hexsum := hex.EncodeToString(file.wsum.Sum(nil))
 		if file.doc.UploadDate.IsZero() {
 			file.doc.UploadDate = bson.Now()
 		}
-		file.doc.MD5 = hexsum
+		file.doc.setChecksum(file.checksum, file.wsum)
 		file.err = file.gfs.Files.Insert(file.doc)
 	}
 	if file.err != nil {
@@ -629,8 +1081,10 @@ func (file *GridFile) Write(data []byte) (n int, err error) {
 func (file *GridFile) insertChunk(data []byte) {
 	n := file.chunk
 	file.chunk++
-	debugf("GridFile %p: adding to checksum: %q", file, string(data))
-	file.wsum.Write(data)
+	if file.wsum != nil {
+		debugf("GridFile %p: adding to checksum: %q", file, string(data))
+		file.wsum.Write(data)
+	}
 
 	for file.doc.ChunkSize*file.wpending >= 1024*1024 {
 		// Hold on.. we got a MB pending.
@@ -685,6 +1139,11 @@ func (file *GridFile) Seek(offset int64, whence int) (pos int64, err error) {
 	if offset > file.doc.Length {
 		return file.offset, errors.New("seek past end of file")
 	}
+	if offset == 0 && file.rsum != nil {
+		// Verify the data read again from the start.
+		file.rsum.Reset()
+		file.rsummed = 0
+	}
 	if offset == file.doc.Length {
 		// If we're seeking to the end of the file,
 		// no need to read anything. This enables
@@ -722,10 +1181,14 @@ func (file *GridFile) Read(b []byte) (n int, err error) {
 	debugf("GridFile %p: reading at offset %d into buffer of length %d", file, file.offset, len(b))
 	defer file.m.Unlock()
 	if file.offset == file.doc.Length {
-		return 0, io.EOF
+		return 0, file.eof()
 	}
 	for err == nil {
 		i := copy(b, file.rbuf)
+		if file.rsum != nil && file.rsummed == file.offset {
+			file.rsum.Write(b[:i])
+			file.rsummed += int64(i)
+		}
 		n += i
 		file.offset += int64(i)
 		file.rbuf = file.rbuf[i:]
@@ -738,6 +1201,17 @@ func (file *GridFile) Read(b []byte) (n int, err error) {
 	return n, err
 }
 
+// eof returns io.EOF, or ErrChecksumMismatch if the file is verified and
+// the data read from its start doesn't match its stored checksum.
+func (file *GridFile) eof() error {
+	if file.rsum != nil && file.rsummed == file.doc.Length {
+		if _, sum := file.doc.storedChecksum(); hex.EncodeToString(file.rsum.Sum(nil)) != sum {
+			return ErrChecksumMismatch
+		}
+	}
+	return io.EOF
+}
+
 func (file *GridFile) getChunk() (data []byte, err error) {
 	cache := file.rcache
 	file.rcache = nil
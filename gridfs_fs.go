@@ -0,0 +1,291 @@
+package mgo
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+// GridFSFS exposes the files of a GridFS as an fs.FS, so that they may be
+// used with http.FileServer, template.ParseFS, fs.WalkDir and the like.
+//
+// File names are taken as slash-separated paths forming a tree of virtual
+// directories: the file "img/logo.png" is the file "logo.png" of the
+// directory "img". Opening a name gives the most recent file with it, as
+// GridFS.Open does. Names that aren't valid paths, as defined by
+// fs.ValidPath, such as those starting with a slash, are left out.
+//
+// GridFSFS implements fs.ReadDirFS, fs.StatFS and fs.ReadFileFS too. The
+// fs.FileInfo of files report their Size and UploadDate, and their Sys
+// method returns their metadata as a *bson.Raw, or nil if they have none.
+type GridFSFS struct {
+	gfs gfsFSBackend
+}
+
+var (
+	_ fs.ReadDirFS  = (*GridFSFS)(nil)
+	_ fs.StatFS     = (*GridFSFS)(nil)
+	_ fs.ReadFileFS = (*GridFSFS)(nil)
+)
+
+// gfsFSBackend is the GridFS of either backend behind a GridFSFS.
+type gfsFSBackend interface {
+	// openFS opens the file described by doc, as given by listFS, for
+	// reading.
+	openFS(doc *gfsFile) gfsReadFile
+
+	// listFS calls fn with the files whose name starts with prefix and
+	// isn't less than from, sorted by name, the most recent first for each
+	// name, until it returns false.
+	listFS(prefix, from string, fn func(doc *gfsFile) bool) error
+}
+
+// FS returns the files of the GridFS as an fs.FS. See GridFSFS.
+func (gfs *GridFS) FS() *GridFSFS {
+	return &GridFSFS{gfs}
+}
+
+func (gfs *GridFS) openFS(doc *gfsFile) gfsReadFile {
+	return gfs.newReader(*doc)
+}
+
+func (gfs *GridFS) listFS(prefix, from string, fn func(doc *gfsFile) bool) error {
+	return gfsListFS(legacyCollection{gfs.Files}, prefix, from, fn)
+}
+
+// gfsFileFields selects the fields of gfsFile from the files collection.
+var gfsFileFields = bson.M{
+	"_id": 1, "chunkSize": 1, "uploadDate": 1, "length": 1, "md5": 1,
+	"sha256": 1, "filename": 1, "contentType": 1, "metadata": 1,
+}
+
+// gfsListFS lists the files of the files collection as listFS does. Only
+// the documents are read, without opening the files.
+func gfsListFS(files CollectionAPI, prefix, from string, fn func(doc *gfsFile) bool) error {
+	query := bson.M{"$regex": "^" + regexp.QuoteMeta(prefix)}
+	if from != "" {
+		query["$gte"] = from
+	}
+	iter := files.Find(bson.M{"filename": query}).Select(gfsFileFields).Sort("filename", "-uploadDate").Iter()
+	for {
+		var doc gfsFile
+		if !iter.Next(&doc) || !fn(&doc) {
+			break
+		}
+	}
+	return iter.Close()
+}
+
+// Open opens the named file or directory, implementing fs.FS.
+func (fsys *GridFSFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	doc, entries, err := fsys.list(name, false)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	if doc != nil {
+		return &gfsFSFile{fsys.gfs.openFS(doc), newGfsFileInfo(doc)}, nil
+	}
+	return &gfsFSDir{name: name, entries: entries}, nil
+}
+
+// ReadDir returns the entries of the named directory sorted by name,
+// implementing fs.ReadDirFS.
+func (fsys *GridFSFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	_, entries, err := fsys.list(name, true)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	return entries, nil
+}
+
+// list returns the most recent file with the given name, unless dirOnly
+// is set, or else the entries of the named directory sorted by name. Only
+// the root directory exists without any file in it.
+//
+// The files of subdirectories aren't read through: the listing skips past
+// them once their first file is seen, so that only the files in the
+// directory and one per subdirectory are read.
+func (fsys *GridFSFS) list(name string, dirOnly bool) (*gfsFile, []fs.DirEntry, error) {
+	// The listing starts at the file with the name itself, if any, which
+	// sorts before the files of the directory.
+	match, prefix := "", ""
+	if name != "." {
+		match, prefix = name, name+"/"
+	}
+	var file *gfsFile
+	var entries []fs.DirEntry
+	seen := make(map[string]bool)
+	for from := match; ; {
+		next := ""
+		err := fsys.gfs.listFS(match, from, func(doc *gfsFile) bool {
+			if name != "." && doc.Filename == name {
+				if dirOnly {
+					return true
+				}
+				file = doc
+				return false
+			}
+			if !strings.HasPrefix(doc.Filename, prefix) {
+				// Names such as "name-x" sort between name and its files.
+				if doc.Filename < prefix {
+					next = prefix
+				}
+				return false
+			}
+			rel := doc.Filename[len(prefix):]
+			if !fs.ValidPath(rel) {
+				return true
+			}
+			i := strings.IndexByte(rel, '/')
+			if i < 0 {
+				if !seen[rel] {
+					seen[rel] = true
+					entries = append(entries, fs.FileInfoToDirEntry(newGfsFileInfo(doc)))
+				}
+				return true
+			}
+			base := rel[:i]
+			if !seen[base] {
+				seen[base] = true
+				entries = append(entries, fs.FileInfoToDirEntry(&gfsFileInfo{name: base, dir: true}))
+			}
+			// '0' follows '/', so the files past the subdirectory start there.
+			next = prefix + base + "0"
+			return false
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		if file != nil {
+			return file, nil, nil
+		}
+		if next == "" {
+			break
+		}
+		from = next
+	}
+	if len(entries) == 0 && name != "." {
+		return nil, nil, fs.ErrNotExist
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return nil, entries, nil
+}
+
+// Stat returns the fs.FileInfo of the named file or directory,
+// implementing fs.StatFS.
+func (fsys *GridFSFS) Stat(name string) (fs.FileInfo, error) {
+	file, err := fsys.Open(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: errors.Unwrap(err)}
+	}
+	defer file.Close()
+	return file.Stat()
+}
+
+// ReadFile returns the data of the named file, implementing fs.ReadFileFS.
+func (fsys *GridFSFS) ReadFile(name string) ([]byte, error) {
+	file, err := fsys.Open(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: errors.Unwrap(err)}
+	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
+
+// gfsFSFile is a file of a GridFSFS.
+type gfsFSFile struct {
+	gfsReadFile
+	info *gfsFileInfo
+}
+
+func (f *gfsFSFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+// gfsFSDir is a directory of a GridFSFS, holding its entries.
+type gfsFSDir struct {
+	name    string
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (d *gfsFSDir) Stat() (fs.FileInfo, error) {
+	return &gfsFileInfo{name: path.Base(d.name), dir: true}, nil
+}
+
+func (d *gfsFSDir) Read(b []byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: errors.New("is a directory")}
+}
+
+func (d *gfsFSDir) Close() error {
+	return nil
+}
+
+// ReadDir returns the next n entries of the directory, implementing
+// fs.ReadDirFile.
+func (d *gfsFSDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	entries := d.entries[d.offset:]
+	if n > 0 {
+		if len(entries) == 0 {
+			return nil, io.EOF
+		}
+		if len(entries) > n {
+			entries = entries[:n]
+		}
+	}
+	d.offset += len(entries)
+	return entries, nil
+}
+
+// gfsFileInfo is the fs.FileInfo of a file or directory of a GridFSFS.
+type gfsFileInfo struct {
+	name       string
+	size       int64
+	uploadDate time.Time
+	metadata   *bson.Raw
+	dir        bool
+}
+
+// newGfsFileInfo returns the fs.FileInfo of the file described by doc.
+func newGfsFileInfo(doc *gfsFile) *gfsFileInfo {
+	return &gfsFileInfo{
+		name:       path.Base(doc.Filename),
+		size:       doc.Length,
+		uploadDate: doc.UploadDate,
+		metadata:   doc.Metadata,
+	}
+}
+
+func (info *gfsFileInfo) Name() string       { return info.name }
+func (info *gfsFileInfo) Size() int64        { return info.size }
+func (info *gfsFileInfo) ModTime() time.Time { return info.uploadDate }
+func (info *gfsFileInfo) IsDir() bool        { return info.dir }
+
+func (info *gfsFileInfo) Mode() fs.FileMode {
+	if info.dir {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+// Sys returns the metadata of the file as a *bson.Raw, or nil if it has
+// none or is a directory.
+func (info *gfsFileInfo) Sys() interface{} {
+	if info.metadata == nil {
+		return nil
+	}
+	return info.metadata
+}
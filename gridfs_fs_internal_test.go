@@ -0,0 +1,147 @@
+package mgo
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+	"testing/fstest"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	. "gopkg.in/check.v1"
+)
+
+// memFS is a gfsFSBackend holding its files in memory.
+type memFS struct {
+	docs   []gfsFile
+	data   map[interface{}]string
+	listed int // Number of files given by listFS
+}
+
+func (m *memFS) add(id interface{}, name, data string, uploadDate time.Time) {
+	m.docs = append(m.docs, gfsFile{Id: id, Filename: name, Length: int64(len(data)), UploadDate: uploadDate})
+	m.data[id] = data
+}
+
+func (m *memFS) sorted() []gfsFile {
+	docs := append([]gfsFile(nil), m.docs...)
+	sort.SliceStable(docs, func(i, j int) bool {
+		if docs[i].Filename != docs[j].Filename {
+			return docs[i].Filename < docs[j].Filename
+		}
+		return docs[i].UploadDate.After(docs[j].UploadDate)
+	})
+	return docs
+}
+
+func (m *memFS) openFS(doc *gfsFile) gfsReadFile {
+	return &memFile{Reader: bytes.NewReader([]byte(m.data[doc.Id])), name: doc.Filename, uploadDate: doc.UploadDate}
+}
+
+func (m *memFS) listFS(prefix, from string, fn func(doc *gfsFile) bool) error {
+	for _, doc := range m.sorted() {
+		if !strings.HasPrefix(doc.Filename, prefix) || doc.Filename < from {
+			continue
+		}
+		m.listed++
+		if !fn(&doc) {
+			break
+		}
+	}
+	return nil
+}
+
+func (s *S) TestGridFSFS(c *C) {
+	uploaded := time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+	backend := &memFS{data: make(map[interface{}]string)}
+	backend.add(1, "hello.txt", "hello", uploaded)
+	backend.add(2, "img/logo.png", "old logo", uploaded)
+	backend.add(3, "img/logo.png", "new logo", uploaded.Add(time.Hour))
+	backend.add(4, "img/icons/a.png", "a", uploaded)
+	backend.add(5, "img-other.txt", "other", uploaded)
+	backend.add(6, "/absolute.txt", "invalid", uploaded)
+	backend.add(7, "a//b.txt", "invalid", uploaded)
+	fsys := &GridFSFS{backend}
+
+	err := fstest.TestFS(fsys, "hello.txt", "img/logo.png", "img/icons/a.png", "img-other.txt")
+	c.Assert(err, IsNil)
+
+	data, err := fs.ReadFile(fsys, "img/logo.png")
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "new logo")
+
+	entries, err := fs.ReadDir(fsys, ".")
+	c.Assert(err, IsNil)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	c.Assert(names, DeepEquals, []string{"hello.txt", "img", "img-other.txt"})
+
+	info, err := fs.Stat(fsys, "img/logo.png")
+	c.Assert(err, IsNil)
+	c.Assert(info.Name(), Equals, "logo.png")
+	c.Assert(info.Size(), Equals, int64(8))
+	c.Assert(info.ModTime().Equal(uploaded.Add(time.Hour)), Equals, true)
+	c.Assert(info.Mode(), Equals, fs.FileMode(0444))
+	c.Assert(info.Sys(), IsNil)
+
+	info, err = fs.Stat(fsys, "img/icons")
+	c.Assert(err, IsNil)
+	c.Assert(info.IsDir(), Equals, true)
+	c.Assert(info.Name(), Equals, "icons")
+
+	_, err = fs.Stat(fsys, "img/missing")
+	c.Assert(errors.Is(err, fs.ErrNotExist), Equals, true)
+	_, err = fsys.Open("/absolute.txt")
+	c.Assert(errors.Is(err, fs.ErrInvalid), Equals, true)
+	_, err = fsys.ReadFile("img")
+	c.Assert(err, ErrorMatches, "read img: is a directory")
+}
+
+func (s *S) TestGridFSFSSkipsSubdirectories(c *C) {
+	uploaded := time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+	backend := &memFS{data: make(map[interface{}]string)}
+	backend.add(1, "dir", "file", uploaded)
+	backend.add(2, "dir-other.txt", "other", uploaded)
+	backend.add(3, "dir/a.txt", "a", uploaded)
+	for i := 0; i < 100; i++ {
+		backend.add(10+i, fmt.Sprintf("dir/sub/%03d.txt", i), "data", uploaded)
+	}
+	backend.add(200, "dir/z.txt", "z", uploaded)
+	fsys := &GridFSFS{backend}
+
+	entries, err := fsys.ReadDir("dir")
+	c.Assert(err, IsNil)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	c.Assert(names, DeepEquals, []string{"a.txt", "sub", "z.txt"})
+	// dir, dir-other.txt, a.txt, sub/000.txt and z.txt.
+	c.Assert(backend.listed, Equals, 5)
+
+	// The file takes precedence over the directory with the same name.
+	backend.listed = 0
+	data, err := fsys.ReadFile("dir")
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "file")
+	c.Assert(backend.listed, Equals, 1)
+
+	backend.listed = 0
+	entries, err = fsys.ReadDir(".")
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 2)
+	// dir, dir-other.txt and dir/a.txt.
+	c.Assert(backend.listed, Equals, 3)
+}
+
+func (s *S) TestGridFSFileInfoSys(c *C) {
+	meta := &bson.Raw{Kind: 3, Data: []byte{5, 0, 0, 0, 0}}
+	info := newGfsFileInfo(&gfsFile{Filename: "dir/name", Metadata: meta})
+	c.Assert(info.Name(), Equals, "name")
+	c.Assert(info.Sys(), Equals, meta)
+}
@@ -0,0 +1,158 @@
+package mgo
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+// GridFSHTTPOptions holds the settings of the handler GridFS.HTTPHandler
+// returns.
+type GridFSHTTPOptions struct {
+	// Prefix is removed from the path of requests to get the name of the
+	// file served. Requests whose path doesn't start with it, followed by
+	// a slash unless it ends in one, are not found: "/assets" serves
+	// "/assets/logo.png" but not "/assetslogo.png".
+	Prefix string
+
+	// ById makes the handler find files by their id rather than by name.
+	// Ids are the path of requests without the prefix, taken as an
+	// ObjectId when they're one in hex and as a string otherwise.
+	ById bool
+
+	// CacheControl is the Cache-Control header of the responses serving
+	// files, if not empty.
+	CacheControl string
+}
+
+// gfsReadFile is a GridFS file open for reading, from either backend.
+type gfsReadFile interface {
+	io.ReadSeeker
+	io.Closer
+	Name() string
+	ContentType() string
+	UploadDate() time.Time
+	MD5() string
+	SHA256() string
+}
+
+// gfsHandler is the http.Handler serving GridFS files.
+type gfsHandler struct {
+	opts     GridFSHTTPOptions
+	openName func(name string) (gfsReadFile, error)
+	openId   func(id interface{}) (gfsReadFile, error)
+}
+
+// HTTPHandler returns an http.Handler serving the files of the GridFS, the
+// most recent one with the name in the path of the request, or the one
+// with that id, as set in opts.
+//
+// Files are served through http.ServeContent, which handles HEAD requests,
+// byte ranges by seeking the file, and conditional requests. Responses hold
+// an ETag with the checksum of the file, a Last-Modified header with its
+// upload date and a Content-Type header with its content type, if it has
+// one, or the type guessed from its name or data otherwise.
+//
+// For example, the following serves the file named "img/logo.png" at
+// "/assets/img/logo.png":
+//
+//	opts := mgo.GridFSHTTPOptions{Prefix: "/assets/"}
+//	http.Handle("/assets/", db.GridFS("fs").HTTPHandler(opts))
+//
+// The handler uses the session of the GridFS for all requests, so it should
+// be given a GridFS from a session that stays open while it's serving.
+func (gfs *GridFS) HTTPHandler(opts GridFSHTTPOptions) http.Handler {
+	return &gfsHandler{
+		opts: opts,
+		openName: func(name string) (gfsReadFile, error) {
+			file, err := gfs.Open(name)
+			if err != nil {
+				return nil, err
+			}
+			return file, nil
+		},
+		openId: func(id interface{}) (gfsReadFile, error) {
+			file, err := gfs.OpenId(id)
+			if err != nil {
+				return nil, err
+			}
+			return file, nil
+		},
+	}
+}
+
+func (h *gfsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	prefix := h.opts.Prefix
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		http.NotFound(w, r)
+		return
+	}
+	rest := r.URL.Path[len(prefix):]
+	if !strings.HasSuffix(prefix, "/") && !strings.HasPrefix(rest, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	name := strings.TrimPrefix(rest, "/")
+	if name == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	var file gfsReadFile
+	var err error
+	if h.opts.ById {
+		file, err = h.openId(gfsHTTPId(name))
+	} else {
+		file, err = h.openName(name)
+	}
+	if err == ErrNotFound {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+
+	header := w.Header()
+	if ctype := file.ContentType(); ctype != "" {
+		header.Set("Content-Type", ctype)
+	}
+	if etag := gfsETag(file); etag != "" {
+		header.Set("ETag", etag)
+	}
+	if h.opts.CacheControl != "" {
+		header.Set("Cache-Control", h.opts.CacheControl)
+	}
+	http.ServeContent(w, r, file.Name(), file.UploadDate(), file)
+}
+
+// gfsHTTPId returns the id of the file in the path of a request.
+func gfsHTTPId(s string) interface{} {
+	if bson.IsObjectIdHex(s) {
+		return bson.ObjectIdHex(s)
+	}
+	return s
+}
+
+// gfsETag returns the entity tag of the file, made of its checksum, or an
+// empty string if it has none.
+func gfsETag(file gfsReadFile) string {
+	sum := file.SHA256()
+	if sum == "" {
+		sum = file.MD5()
+	}
+	if sum == "" {
+		return ""
+	}
+	return `"` + sum + `"`
+}
@@ -0,0 +1,127 @@
+package mgo
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	. "gopkg.in/check.v1"
+)
+
+// memFile is a gfsReadFile holding its data in memory.
+type memFile struct {
+	*bytes.Reader
+	name, ctype, md5 string
+	uploadDate       time.Time
+	closed           bool
+}
+
+func (f *memFile) Close() error          { f.closed = true; return nil }
+func (f *memFile) Name() string          { return f.name }
+func (f *memFile) ContentType() string   { return f.ctype }
+func (f *memFile) UploadDate() time.Time { return f.uploadDate }
+func (f *memFile) MD5() string           { return f.md5 }
+func (f *memFile) SHA256() string        { return "" }
+
+func (s *S) TestGridFSHTTPHandler(c *C) {
+	uploaded := time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+	var opened []*memFile
+	open := func(name string) (gfsReadFile, error) {
+		if name != "dir/file.txt" && name != "myid" {
+			return nil, ErrNotFound
+		}
+		file := &memFile{
+			Reader:     bytes.NewReader([]byte("0123456789")),
+			name:       "dir/file.txt",
+			md5:        "781e5e245d69b566979b86e28d23f2c7",
+			uploadDate: uploaded,
+		}
+		opened = append(opened, file)
+		return file, nil
+	}
+	handler := &gfsHandler{
+		opts:     GridFSHTTPOptions{Prefix: "/files/", CacheControl: "max-age=60"},
+		openName: open,
+		openId:   func(id interface{}) (gfsReadFile, error) { return open(id.(string)) },
+	}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	do := func(method, path string, header http.Header) (*http.Response, string) {
+		req, err := http.NewRequest(method, server.URL+path, nil)
+		c.Assert(err, IsNil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		resp, err := http.DefaultClient.Do(req)
+		c.Assert(err, IsNil)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		c.Assert(err, IsNil)
+		return resp, string(body)
+	}
+
+	resp, body := do("GET", "/files/dir/file.txt", nil)
+	c.Assert(resp.StatusCode, Equals, http.StatusOK)
+	c.Assert(body, Equals, "0123456789")
+	c.Assert(resp.Header.Get("Content-Type"), Equals, "text/plain; charset=utf-8")
+	c.Assert(resp.Header.Get("ETag"), Equals, `"781e5e245d69b566979b86e28d23f2c7"`)
+	c.Assert(resp.Header.Get("Last-Modified"), Equals, "Tue, 02 Jan 2018 03:04:05 GMT")
+	c.Assert(resp.Header.Get("Cache-Control"), Equals, "max-age=60")
+	c.Assert(resp.Header.Get("Accept-Ranges"), Equals, "bytes")
+	c.Assert(opened[len(opened)-1].closed, Equals, true)
+
+	resp, body = do("HEAD", "/files/dir/file.txt", nil)
+	c.Assert(resp.StatusCode, Equals, http.StatusOK)
+	c.Assert(resp.ContentLength, Equals, int64(10))
+	c.Assert(body, Equals, "")
+
+	resp, body = do("GET", "/files/dir/file.txt", http.Header{"Range": {"bytes=2-5"}})
+	c.Assert(resp.StatusCode, Equals, http.StatusPartialContent)
+	c.Assert(resp.Header.Get("Content-Range"), Equals, "bytes 2-5/10")
+	c.Assert(body, Equals, "2345")
+
+	resp, _ = do("GET", "/files/dir/file.txt", http.Header{"If-None-Match": {`"781e5e245d69b566979b86e28d23f2c7"`}})
+	c.Assert(resp.StatusCode, Equals, http.StatusNotModified)
+	resp, _ = do("GET", "/files/dir/file.txt", http.Header{"If-Modified-Since": {"Tue, 02 Jan 2018 03:04:05 GMT"}})
+	c.Assert(resp.StatusCode, Equals, http.StatusNotModified)
+	resp, body = do("GET", "/files/dir/file.txt", http.Header{"Range": {"bytes=0-1"}, "If-Range": {`"other"`}})
+	c.Assert(resp.StatusCode, Equals, http.StatusOK)
+	c.Assert(body, Equals, "0123456789")
+
+	resp, _ = do("GET", "/files/missing.txt", nil)
+	c.Assert(resp.StatusCode, Equals, http.StatusNotFound)
+	resp, _ = do("GET", "/other/dir/file.txt", nil)
+	c.Assert(resp.StatusCode, Equals, http.StatusNotFound)
+	resp, _ = do("GET", "/files/", nil)
+	c.Assert(resp.StatusCode, Equals, http.StatusNotFound)
+	resp, _ = do("POST", "/files/dir/file.txt", nil)
+	c.Assert(resp.StatusCode, Equals, http.StatusMethodNotAllowed)
+	c.Assert(resp.Header.Get("Allow"), Equals, "GET, HEAD")
+
+	// The prefix only matches whole path segments.
+	handler.opts.Prefix = "/files"
+	resp, body = do("GET", "/files/dir/file.txt", nil)
+	c.Assert(resp.StatusCode, Equals, http.StatusOK)
+	c.Assert(body, Equals, "0123456789")
+	resp, _ = do("GET", "/filesmyid", nil)
+	c.Assert(resp.StatusCode, Equals, http.StatusNotFound)
+	resp, _ = do("GET", "/files-private/myid", nil)
+	c.Assert(resp.StatusCode, Equals, http.StatusNotFound)
+	resp, _ = do("GET", "/files", nil)
+	c.Assert(resp.StatusCode, Equals, http.StatusNotFound)
+
+	handler.opts.ById = true
+	resp, body = do("GET", "/files/myid", nil)
+	c.Assert(resp.StatusCode, Equals, http.StatusOK)
+	c.Assert(body, Equals, "0123456789")
+}
+
+func (s *S) TestGridFSHTTPId(c *C) {
+	id := bson.NewObjectId()
+	c.Assert(gfsHTTPId(id.Hex()), Equals, id)
+	c.Assert(gfsHTTPId("myid"), Equals, "myid")
+}
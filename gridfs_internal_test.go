@@ -0,0 +1,184 @@
+package mgo
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"strings"
+	"testing/iotest"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	. "gopkg.in/check.v1"
+)
+
+func (s *S) TestGridFSChecksum(c *C) {
+	c.Assert(ChecksumNone.newHash(), IsNil)
+
+	var doc gfsFile
+	sum := ChecksumSHA256.newHash()
+	sum.Write([]byte("data"))
+	doc.setChecksum(ChecksumSHA256, sum)
+	want := sha256.Sum256([]byte("data"))
+	c.Assert(doc.SHA256, Equals, hex.EncodeToString(want[:]))
+	c.Assert(doc.MD5, Equals, "")
+	checksum, stored := doc.storedChecksum()
+	c.Assert(checksum, Equals, ChecksumSHA256)
+	c.Assert(stored, Equals, doc.SHA256)
+
+	doc = gfsFile{}
+	sum = ChecksumMD5.newHash()
+	sum.Write([]byte("data"))
+	doc.setChecksum(ChecksumMD5, sum)
+	c.Assert(doc.MD5, Equals, "8d777f385d3dfec8815d20f7496026dc")
+	checksum, _ = doc.storedChecksum()
+	c.Assert(checksum, Equals, ChecksumMD5)
+
+	checksum, stored = (&gfsFile{}).storedChecksum()
+	c.Assert(checksum, Equals, ChecksumNone)
+	c.Assert(stored, Equals, "")
+}
+
+func (s *S) TestGridFSVerifyChecksum(c *C) {
+	gfs := &GridFS{verify: true}
+	sum := md5.Sum([]byte("some data"))
+
+	for _, t := range []struct {
+		data string
+		err  error
+	}{
+		{"some data", io.EOF},
+		{"some dat!", ErrChecksumMismatch},
+	} {
+		// The data is all in the read buffer, so no chunk is fetched.
+		file := gfs.newReader(gfsFile{Length: 9, MD5: hex.EncodeToString(sum[:])})
+		file.rbuf = []byte(t.data)
+		b := make([]byte, 4)
+		var data []byte
+		for {
+			n, err := file.Read(b)
+			data = append(data, b[:n]...)
+			if err != nil {
+				c.Assert(err, Equals, t.err)
+				break
+			}
+		}
+		c.Assert(string(data), Equals, t.data)
+	}
+
+	// Data not read from the start isn't verified.
+	file := gfs.newReader(gfsFile{Length: 9, ChunkSize: 9, MD5: hex.EncodeToString(sum[:])})
+	file.rbuf = []byte("some dat!")
+	file.chunk = 1
+	_, err := file.Seek(5, io.SeekStart)
+	c.Assert(err, IsNil)
+	data, err := io.ReadAll(file)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "dat!")
+
+	// Nor is it without a stored checksum.
+	file = gfs.newReader(gfsFile{Length: 9})
+	c.Assert(file.rsum, IsNil)
+}
+
+func (s *S) TestGridFSCheckFile(c *C) {
+	check := func(doc gfsFile, chunks ...gfsChunk) (GridFSFsckFile, []interface{}) {
+		return gfsCheckFile(&doc, func(chunk *gfsChunk) bool {
+			if len(chunks) == 0 {
+				return false
+			}
+			*chunk = chunks[0]
+			chunks = chunks[1:]
+			return true
+		})
+	}
+	chunk := func(id interface{}, n int, data string) gfsChunk {
+		return gfsChunk{Id: id, FilesId: "file", N: n, Data: []byte(data)}
+	}
+
+	file, duplicates := check(gfsFile{Id: "file", Filename: "name", Length: 6, ChunkSize: 4}, chunk(1, 0, "1234"), chunk(2, 1, "56"))
+	c.Assert(file.damaged(), Equals, false)
+	c.Assert(file.Name, Equals, "name")
+	c.Assert(duplicates, IsNil)
+
+	// Missing chunks, in between and at the end.
+	file, _ = check(gfsFile{Length: 14, ChunkSize: 4}, chunk(1, 0, "1234"), chunk(3, 2, "9012"))
+	c.Assert(file.damaged(), Equals, true)
+	c.Assert(file.MissingChunks, DeepEquals, []int{1, 3})
+	c.Assert(file.ChunksLength, Equals, int64(8))
+
+	// Duplicate chunks.
+	file, duplicates = check(gfsFile{Length: 6, ChunkSize: 4}, chunk(1, 0, "1234"), chunk(2, 0, "1234"), chunk(3, 0, "1234"), chunk(4, 1, "56"))
+	c.Assert(file.damaged(), Equals, true)
+	c.Assert(file.MissingChunks, IsNil)
+	c.Assert(file.DuplicateChunks, DeepEquals, []int{0})
+	c.Assert(duplicates, DeepEquals, []interface{}{2, 3})
+	c.Assert(file.ChunksLength, Equals, int64(6))
+
+	// A length not matching the chunks.
+	file, _ = check(gfsFile{Length: 5, ChunkSize: 4}, chunk(1, 0, "1234"), chunk(2, 1, "56"))
+	c.Assert(file.damaged(), Equals, true)
+	c.Assert(file.MissingChunks, IsNil)
+	c.Assert(file.Length, Equals, int64(5))
+	c.Assert(file.ChunksLength, Equals, int64(6))
+}
+
+func (s *S) TestGridFSOrphanFilter(c *C) {
+	now := time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+	cutoff := func(filter bson.M) time.Time {
+		return filter["_id"].(bson.M)["$not"].(bson.M)["$gte"].(bson.ObjectId).Time().UTC()
+	}
+	opts := GridFSFsckOptions{}
+	c.Assert(cutoff(opts.orphanFilter(now)), Equals, now.Add(-time.Hour))
+	opts.MinAge = time.Minute
+	c.Assert(cutoff(opts.orphanFilter(now)), Equals, now.Add(-time.Minute))
+	opts.MinAge = -1
+	c.Assert(opts.orphanFilter(now), DeepEquals, bson.M{})
+
+	c.Assert(gfsOrphanPipeline(bson.M{}), DeepEquals, []bson.M{
+		{"$match": bson.M{}},
+		{"$group": bson.M{"_id": "$files_id"}},
+	})
+}
+
+// fakeWriteFile is a gfsWriteFile recording what's done to it.
+type fakeWriteFile struct {
+	bytes.Buffer
+	id        interface{}
+	chunkSize int
+	ctype     string
+	meta      interface{}
+	aborted   bool
+	closed    bool
+}
+
+func (f *fakeWriteFile) Id() interface{}              { return f.id }
+func (f *fakeWriteFile) SetId(id interface{})         { f.id = id }
+func (f *fakeWriteFile) SetChunkSize(bytes int)       { f.chunkSize = bytes }
+func (f *fakeWriteFile) SetContentType(ctype string)  { f.ctype = ctype }
+func (f *fakeWriteFile) SetMeta(metadata interface{}) { f.meta = metadata }
+func (f *fakeWriteFile) Abort()                       { f.aborted = true }
+func (f *fakeWriteFile) Close() error                 { f.closed = true; return nil }
+
+func (s *S) TestGridFSUploadFromStream(c *C) {
+	file := &fakeWriteFile{id: "default"}
+	opts := GridFSUploadOptions{Id: "id", ChunkSize: 10, ContentType: "text/plain", Metadata: bson.M{"a": 1}}
+	id, err := gfsUploadFromStream(file, strings.NewReader("data"), opts)
+	c.Assert(err, IsNil)
+	c.Assert(id, Equals, "id")
+	c.Assert(file.String(), Equals, "data")
+	c.Assert(file.chunkSize, Equals, 10)
+	c.Assert(file.ctype, Equals, "text/plain")
+	c.Assert(file.meta, DeepEquals, bson.M{"a": 1})
+	c.Assert(file.aborted, Equals, false)
+	c.Assert(file.closed, Equals, true)
+
+	file = &fakeWriteFile{id: "default"}
+	id, err = gfsUploadFromStream(file, iotest.ErrReader(io.ErrUnexpectedEOF), GridFSUploadOptions{})
+	c.Assert(err, Equals, io.ErrUnexpectedEOF)
+	c.Assert(id, IsNil)
+	c.Assert(file.aborted, Equals, true)
+	c.Assert(file.closed, Equals, true)
+}
@@ -27,8 +27,15 @@
 package mgo_test
 
 import (
+	"bytes"
+	"errors"
 	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
+	"testing/fstest"
 	"time"
 
 	mgo "github.com/globalsign/mgo"
@@ -706,3 +713,437 @@ func (s *S) TestGridFSOpenNext(c *C) {
 	c.Assert(iter.Close(), IsNil)
 	c.Assert(f, IsNil)
 }
+
+func (s *S) TestGridFSOpenRevision(c *C) {
+	session, err := mgo.Dial("localhost:40011")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	db := session.DB("mydb")
+
+	gfs := db.GridFS("fs")
+
+	uploaded := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		file, err := gfs.Create("myfile.txt")
+		c.Assert(err, IsNil)
+		file.SetUploadDate(uploaded.Add(time.Duration(i) * time.Hour))
+		file.Write([]byte{byte('0' + i)})
+		c.Assert(file.Close(), IsNil)
+	}
+
+	var b [1]byte
+	for revision, want := range map[int]string{0: "0", 1: "1", 2: "2", -1: "2", -2: "1", -3: "0"} {
+		file, err := gfs.OpenRevision("myfile.txt", revision)
+		c.Assert(err, IsNil)
+		_, err = file.Read(b[:])
+		c.Assert(err, IsNil)
+		c.Assert(string(b[:]), Equals, want, Commentf("revision %d", revision))
+		c.Assert(file.Close(), IsNil)
+	}
+
+	_, err = gfs.OpenRevision("myfile.txt", 3)
+	c.Assert(err == mgo.ErrNotFound, Equals, true)
+	_, err = gfs.OpenRevision("myfile.txt", -4)
+	c.Assert(err == mgo.ErrNotFound, Equals, true)
+}
+
+func (s *S) TestGridFSRename(c *C) {
+	session, err := mgo.Dial("localhost:40011")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	db := session.DB("mydb")
+
+	gfs := db.GridFS("fs")
+
+	file, err := gfs.Create("myfile.txt")
+	c.Assert(err, IsNil)
+	file.Write([]byte{'1'})
+	id := file.Id()
+	c.Assert(file.Close(), IsNil)
+
+	err = gfs.Rename(id, "renamed.txt")
+	c.Assert(err, IsNil)
+
+	file, err = gfs.Open("renamed.txt")
+	c.Assert(err, IsNil)
+	c.Assert(file.Id(), Equals, id)
+	c.Assert(file.Close(), IsNil)
+
+	_, err = gfs.Open("myfile.txt")
+	c.Assert(err == mgo.ErrNotFound, Equals, true)
+
+	err = gfs.Rename(bson.NewObjectId(), "other.txt")
+	c.Assert(err == mgo.ErrNotFound, Equals, true)
+}
+
+func (s *S) TestGridFSDrop(c *C) {
+	session, err := mgo.Dial("localhost:40011")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	db := session.DB("mydb")
+
+	gfs := db.GridFS("fs")
+
+	// Dropping an empty bucket isn't an error.
+	c.Assert(gfs.Drop(), IsNil)
+
+	file, err := gfs.Create("myfile.txt")
+	c.Assert(err, IsNil)
+	file.Write([]byte{'1'})
+	c.Assert(file.Close(), IsNil)
+
+	c.Assert(gfs.Drop(), IsNil)
+
+	names, err := db.CollectionNames()
+	c.Assert(err, IsNil)
+	for _, name := range names {
+		c.Assert(name, Not(Matches), `fs\..*`)
+	}
+}
+
+func (s *S) TestGridFSUploadDownloadStream(c *C) {
+	session, err := mgo.Dial("localhost:40011")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	db := session.DB("mydb")
+
+	gfs := db.GridFS("fs")
+
+	data := strings.Repeat("abcdefghij", 10)
+	id, err := gfs.UploadFromStream("myfile.txt", strings.NewReader(data), mgo.GridFSUploadOptions{
+		Id:          "myid",
+		ChunkSize:   16,
+		ContentType: "text/plain",
+		Metadata:    M{"a": 1},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(id, Equals, "myid")
+
+	file, err := gfs.OpenId("myid")
+	c.Assert(err, IsNil)
+	c.Assert(file.Name(), Equals, "myfile.txt")
+	c.Assert(file.ContentType(), Equals, "text/plain")
+	c.Assert(file.Size(), Equals, int64(len(data)))
+	var meta M
+	c.Assert(file.GetMeta(&meta), IsNil)
+	c.Assert(meta, DeepEquals, M{"a": 1})
+	c.Assert(file.Close(), IsNil)
+
+	n, err := db.C("fs.chunks").Find(M{"files_id": "myid"}).Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 7)
+
+	var buf bytes.Buffer
+	written, err := gfs.DownloadToStream("myid", &buf)
+	c.Assert(err, IsNil)
+	c.Assert(written, Equals, int64(len(data)))
+	c.Assert(buf.String(), Equals, data)
+
+	_, err = gfs.DownloadToStream("missing", &buf)
+	c.Assert(err == mgo.ErrNotFound, Equals, true)
+
+	// Failed uploads leave nothing behind.
+	_, err = gfs.UploadFromStream("broken.txt", io.MultiReader(strings.NewReader(data), &errorReader{}), mgo.GridFSUploadOptions{ChunkSize: 16})
+	c.Assert(err, ErrorMatches, "read failed")
+	_, err = gfs.Open("broken.txt")
+	c.Assert(err == mgo.ErrNotFound, Equals, true)
+	n, err = db.C("fs.chunks").Find(nil).Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 7)
+}
+
+type errorReader struct{}
+
+func (r *errorReader) Read(b []byte) (int, error) {
+	return 0, errors.New("read failed")
+}
+
+func (s *S) TestGridFSWithOptions(c *C) {
+	session, err := mgo.Dial("localhost:40011")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	// Writes of the bucket are acknowledged even if the session's aren't.
+	session.SetSafe(nil)
+
+	db := session.DB("mydb")
+
+	gfs := db.GridFSWithOptions("fs", mgo.GridFSOptions{ChunkSize: 4, Safe: &mgo.Safe{}})
+
+	file, err := gfs.Create("myfile.txt")
+	c.Assert(err, IsNil)
+	file.SetId("myid")
+	file.Write([]byte("0123456789"))
+	c.Assert(file.Close(), IsNil)
+
+	n, err := db.C("fs.chunks").Find(M{"files_id": "myid"}).Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 3)
+
+	file, err = gfs.Create("myfile.txt")
+	c.Assert(err, IsNil)
+	file.SetId("myid")
+	file.Write([]byte("0123456789"))
+	c.Assert(mgo.IsDup(file.Close()), Equals, true)
+}
+
+func (s *S) TestGridFSCreateSHA256(c *C) {
+	session, err := mgo.Dial("localhost:40011")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	db := session.DB("mydb")
+
+	gfs := db.GridFSWithOptions("fs", mgo.GridFSOptions{Checksum: mgo.ChecksumSHA256})
+	file, err := gfs.Create("sha.txt")
+	c.Assert(err, IsNil)
+	file.Write([]byte("some data"))
+	c.Assert(file.Close(), IsNil)
+	c.Assert(file.MD5(), Equals, "")
+	c.Assert(file.SHA256(), Equals, "1307990e6ba5ca145eb35e99182a9bec46531bc54ddf656a602c780fa0240dee")
+
+	result := M{}
+	err = db.C("fs.files").Find(M{"filename": "sha.txt"}).One(result)
+	c.Assert(err, IsNil)
+	c.Assert(result["sha256"], Equals, file.SHA256())
+	_, ok := result["md5"]
+	c.Assert(ok, Equals, false)
+
+	gfs = db.GridFSWithOptions("fs", mgo.GridFSOptions{Checksum: mgo.ChecksumNone})
+	file, err = gfs.Create("none.txt")
+	c.Assert(err, IsNil)
+	file.Write([]byte("some data"))
+	c.Assert(file.Close(), IsNil)
+
+	result = M{}
+	err = db.C("fs.files").Find(M{"filename": "none.txt"}).One(result)
+	c.Assert(err, IsNil)
+	_, ok = result["md5"]
+	c.Assert(ok, Equals, false)
+	_, ok = result["sha256"]
+	c.Assert(ok, Equals, false)
+}
+
+func (s *S) TestGridFSReadChecksumMismatch(c *C) {
+	session, err := mgo.Dial("localhost:40011")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	db := session.DB("mydb")
+
+	gfs := db.GridFSWithOptions("fs", mgo.GridFSOptions{Checksum: mgo.ChecksumSHA256, VerifyChecksum: true})
+	file, err := gfs.Create("myfile.txt")
+	c.Assert(err, IsNil)
+	file.SetChunkSize(4)
+	file.Write([]byte("some data"))
+	id := file.Id()
+	c.Assert(file.Close(), IsNil)
+
+	file, err = gfs.OpenId(id)
+	c.Assert(err, IsNil)
+	data, err := io.ReadAll(file)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "some data")
+	c.Assert(file.Close(), IsNil)
+
+	err = db.C("fs.chunks").Update(M{"files_id": id, "n": 1}, M{"$set": M{"data": []byte(" da!")}})
+	c.Assert(err, IsNil)
+
+	file, err = gfs.OpenId(id)
+	c.Assert(err, IsNil)
+	data, err = io.ReadAll(file)
+	c.Assert(err, Equals, mgo.ErrChecksumMismatch)
+	c.Assert(string(data), Equals, "some da!a")
+
+	// Reading again from the start verifies again.
+	_, err = file.Seek(0, io.SeekStart)
+	c.Assert(err, IsNil)
+	_, err = io.ReadAll(file)
+	c.Assert(err, Equals, mgo.ErrChecksumMismatch)
+	c.Assert(file.Close(), IsNil)
+
+	// Files aren't verified unless requested.
+	file, err = db.GridFS("fs").OpenId(id)
+	c.Assert(err, IsNil)
+	_, err = io.ReadAll(file)
+	c.Assert(err, IsNil)
+	c.Assert(file.Close(), IsNil)
+}
+
+func (s *S) TestGridFSFsck(c *C) {
+	session, err := mgo.Dial("localhost:40011")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	db := session.DB("mydb")
+
+	gfs := db.GridFSWithOptions("fs", mgo.GridFSOptions{ChunkSize: 4})
+	create := func(id string) {
+		file, err := gfs.Create(id + ".txt")
+		c.Assert(err, IsNil)
+		file.SetId(id)
+		file.Write([]byte("0123456789"))
+		c.Assert(file.Close(), IsNil)
+	}
+	create("healthy")
+	create("missing")
+	create("duplicate")
+	create("length")
+	create("orphan")
+
+	chunks := db.C("fs.chunks")
+	c.Assert(chunks.Remove(M{"files_id": "missing", "n": 1}), IsNil)
+	c.Assert(db.C("fs.files").UpdateId("length", M{"$set": M{"length": 9}}), IsNil)
+	c.Assert(db.C("fs.files").RemoveId("orphan"), IsNil)
+
+	// The unique index would prevent duplicate chunks.
+	c.Assert(chunks.DropIndex("files_id", "n"), IsNil)
+	c.Assert(chunks.Insert(M{"files_id": "duplicate", "n": 2, "data": []byte("89")}), IsNil)
+
+	// The chunks of files just written may belong to uploads in progress.
+	result, err := gfs.Fsck(mgo.GridFSFsckOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(result.Orphans, HasLen, 0)
+
+	result, err = gfs.Fsck(mgo.GridFSFsckOptions{MinAge: -1})
+	c.Assert(err, IsNil)
+	c.Assert(result.Files, Equals, 4)
+	c.Assert(result.Orphans, DeepEquals, []interface{}{"orphan"})
+	damaged := map[interface{}]mgo.GridFSFsckFile{}
+	for _, file := range result.Damaged {
+		damaged[file.Id] = file
+	}
+	c.Assert(damaged, HasLen, 3)
+	c.Assert(damaged["missing"].MissingChunks, DeepEquals, []int{1})
+	c.Assert(damaged["missing"].ChunksLength, Equals, int64(6))
+	c.Assert(damaged["duplicate"].DuplicateChunks, DeepEquals, []int{2})
+	c.Assert(damaged["duplicate"].ChunksLength, Equals, int64(10))
+	c.Assert(damaged["length"].Length, Equals, int64(9))
+	c.Assert(damaged["length"].ChunksLength, Equals, int64(10))
+
+	// Repairing deletes the files that can't be repaired only with Delete.
+	result, err = gfs.Fsck(mgo.GridFSFsckOptions{Repair: true, MinAge: -1})
+	c.Assert(err, IsNil)
+	c.Assert(result.Damaged, HasLen, 3)
+	for _, file := range result.Damaged {
+		c.Assert(file.Repaired, Equals, file.Id != "missing")
+		c.Assert(file.Deleted, Equals, false)
+	}
+
+	result, err = gfs.Fsck(mgo.GridFSFsckOptions{Repair: true, Delete: true, MinAge: -1})
+	c.Assert(err, IsNil)
+	c.Assert(result.Orphans, HasLen, 0)
+	c.Assert(result.Damaged, HasLen, 1)
+	c.Assert(result.Damaged[0].Id, Equals, "missing")
+	c.Assert(result.Damaged[0].Deleted, Equals, true)
+
+	result, err = gfs.Fsck(mgo.GridFSFsckOptions{MinAge: -1})
+	c.Assert(err, IsNil)
+	c.Assert(result.Files, Equals, 3)
+	c.Assert(result.Damaged, HasLen, 0)
+	c.Assert(result.Orphans, HasLen, 0)
+
+	file, err := gfs.OpenId("length")
+	c.Assert(err, IsNil)
+	c.Assert(file.Size(), Equals, int64(10))
+	c.Assert(file.Close(), IsNil)
+}
+
+func (s *S) TestGridFSServeHTTP(c *C) {
+	session, err := mgo.Dial("localhost:40011")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	db := session.DB("mydb")
+
+	gfs := db.GridFSWithOptions("fs", mgo.GridFSOptions{ChunkSize: 4})
+	file, err := gfs.Create("img/logo.txt")
+	c.Assert(err, IsNil)
+	file.SetContentType("text/x-logo")
+	file.SetUploadDate(time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC))
+	file.Write([]byte("0123456789"))
+	id := file.Id().(bson.ObjectId)
+	c.Assert(file.Close(), IsNil)
+
+	server := httptest.NewServer(gfs.HTTPHandler(mgo.GridFSHTTPOptions{Prefix: "/assets"}))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/assets/img/logo.txt")
+	c.Assert(err, IsNil)
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	c.Assert(err, IsNil)
+	c.Assert(resp.StatusCode, Equals, http.StatusOK)
+	c.Assert(string(body), Equals, "0123456789")
+	c.Assert(resp.Header.Get("Content-Type"), Equals, "text/x-logo")
+	c.Assert(resp.Header.Get("ETag"), Equals, `"781e5e245d69b566979b86e28d23f2c7"`)
+	c.Assert(resp.Header.Get("Last-Modified"), Equals, "Tue, 02 Jan 2018 03:04:05 GMT")
+
+	// Ranges spanning chunks are read by seeking the file.
+	req, err := http.NewRequest("GET", server.URL+"/assets/img/logo.txt", nil)
+	c.Assert(err, IsNil)
+	req.Header.Set("Range", "bytes=3-8")
+	resp, err = http.DefaultClient.Do(req)
+	c.Assert(err, IsNil)
+	body, err = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	c.Assert(err, IsNil)
+	c.Assert(resp.StatusCode, Equals, http.StatusPartialContent)
+	c.Assert(string(body), Equals, "345678")
+
+	resp, err = http.Get(server.URL + "/assets/img/missing.txt")
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, http.StatusNotFound)
+
+	server = httptest.NewServer(gfs.HTTPHandler(mgo.GridFSHTTPOptions{ById: true}))
+	defer server.Close()
+
+	resp, err = http.Head(server.URL + "/" + id.Hex())
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, http.StatusOK)
+	c.Assert(resp.ContentLength, Equals, int64(10))
+}
+
+func (s *S) TestGridFSFS(c *C) {
+	session, err := mgo.Dial("localhost:40011")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	db := session.DB("mydb")
+
+	gfs := db.GridFS("fs")
+	for _, name := range []string{"a.txt", "dir/b.txt", "dir/b.txt", "dir/sub/c.txt", "dir.txt"} {
+		file, err := gfs.Create(name)
+		c.Assert(err, IsNil)
+		file.SetMeta(bson.M{"name": name})
+		file.Write([]byte("data of " + name))
+		c.Assert(file.Close(), IsNil)
+	}
+
+	fsys := gfs.FS()
+	c.Assert(fstest.TestFS(fsys, "a.txt", "dir/b.txt", "dir/sub/c.txt", "dir.txt"), IsNil)
+
+	var walked []string
+	err = fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		walked = append(walked, path)
+		return err
+	})
+	c.Assert(err, IsNil)
+	c.Assert(walked, DeepEquals, []string{".", "a.txt", "dir", "dir/b.txt", "dir/sub", "dir/sub/c.txt", "dir.txt"})
+
+	info, err := fs.Stat(fsys, "dir/b.txt")
+	c.Assert(err, IsNil)
+	c.Assert(info.Size(), Equals, int64(len("data of dir/b.txt")))
+	var meta bson.M
+	c.Assert(info.Sys().(*bson.Raw).Unmarshal(&meta), IsNil)
+	c.Assert(meta["name"], Equals, "dir/b.txt")
+
+	_, err = fs.Stat(fsys, "dir/missing.txt")
+	c.Assert(errors.Is(err, fs.ErrNotExist), Equals, true)
+}
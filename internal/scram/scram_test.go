@@ -2,6 +2,8 @@ package scram_test
 
 import (
 	"crypto/sha1"
+	"crypto/sha256"
+	"hash"
 	"testing"
 
 	"strings"
@@ -32,13 +34,31 @@ var tests = [][]string{{
 	"S: v=LBnd9dUJRxdqZiEq91NKP3z/bHA=",
 }}
 
+// sha256Tests holds the SCRAM-SHA-256 example from RFC 7677.
+var sha256Tests = [][]string{{
+	"U: user pencil",
+	"N: rOprNGfwEbeRWgbNEkqO",
+	"C: n,,n=user,r=rOprNGfwEbeRWgbNEkqO",
+	"S: r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096",
+	"C: c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ=",
+	"S: v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4=",
+}}
+
 func (s *S) TestExamples(c *C) {
+	runExamples(c, sha1.New, tests)
+}
+
+func (s *S) TestExamplesSha256(c *C) {
+	runExamples(c, sha256.New, sha256Tests)
+}
+
+func runExamples(c *C, newHash func() hash.Hash, tests [][]string) {
 	for _, steps := range tests {
 		if len(steps) < 2 || len(steps[0]) < 3 || !strings.HasPrefix(steps[0], "U: ") {
 			c.Fatalf("Invalid test: %#v", steps)
 		}
 		auth := strings.Fields(steps[0][3:])
-		client := scram.NewClient(sha1.New, auth[0], auth[1])
+		client := scram.NewClient(newHash, auth[0], auth[1])
 		first, done := true, false
 		c.Logf("-----")
 		c.Logf("%s", steps[0])
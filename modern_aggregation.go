@@ -3,7 +3,6 @@
 package mgo
 
 import (
-	"context"
 	"time"
 
 	"github.com/globalsign/mgo/bson"
@@ -13,7 +12,7 @@ import (
 
 // Iter executes the aggregation pipeline and returns an iterator
 func (p *ModernPipe) Iter() *ModernIt {
-	ctx := context.Background()
+	ctx, sess := p.collection.cursorContext()
 
 	// Convert pipeline to the correct format for the official driver
 	var pipeline interface{}
@@ -54,12 +53,13 @@ func (p *ModernPipe) Iter() *ModernIt {
 		opts.Collation = p.collation
 	}
 
-	cursor, err := p.collection.mgoColl.Aggregate(ctx, pipeline, opts)
+	cursor, err := p.collection.collection().Aggregate(ctx, pipeline, opts)
 
 	return &ModernIt{
 		cursor: cursor,
 		ctx:    ctx,
-		err:    err,
+		sess:   sess,
+		err:    modernError(err),
 	}
 }
 
@@ -86,7 +86,7 @@ func (p *ModernPipe) One(result interface{}) error {
 
 // Explain returns aggregation execution statistics
 func (p *ModernPipe) Explain(result interface{}) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := p.collection.opContext(10 * time.Second)
 	defer cancel()
 
 	// Convert pipeline to the correct format
@@ -116,17 +116,14 @@ func (p *ModernPipe) Explain(result interface{}) error {
 		"explain":   true,
 	}
 
-	db := p.collection.mgoColl.Database()
+	db := p.collection.ackCollection().Database()
 	singleResult := db.RunCommand(ctx, explainCmd)
 
-	var doc officialBson.M
-	err := singleResult.Decode(&doc)
+	raw, err := singleResult.Raw()
 	if err != nil {
-		return err
+		return modernError(err)
 	}
-
-	converted := convertOfficialToMGO(doc)
-	return mapStructToInterface(converted, result)
+	return decodeModern(raw, result)
 }
 
 // AllowDiskUse enables writing to temporary files during aggregation
@@ -149,19 +146,6 @@ func (p *ModernPipe) SetMaxTime(d time.Duration) *ModernPipe {
 
 // Collation sets the collation for the aggregation
 func (p *ModernPipe) Collation(collation *Collation) *ModernPipe {
-	if collation != nil {
-		// Convert mgo Collation to official driver Collation
-		p.collation = &options.Collation{
-			Locale:          collation.Locale,
-			CaseFirst:       collation.CaseFirst,
-			Strength:        collation.Strength,
-			Alternate:       collation.Alternate,
-			MaxVariable:     collation.MaxVariable,
-			Normalization:   collation.Normalization,
-			CaseLevel:       collation.CaseLevel,
-			NumericOrdering: collation.NumericOrdering,
-			Backwards:       collation.Backwards,
-		}
-	}
+	p.collation = modernCollation(collation)
 	return p
 }
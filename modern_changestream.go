@@ -0,0 +1,155 @@
+// modern_changestream.go - Change streams for modern MongoDB driver compatibility wrapper
+
+package mgo
+
+import (
+	"fmt"
+
+	"github.com/globalsign/mgo/bson"
+	officialBson "go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Watch opens a change stream reporting the changes made to the
+// collection and passing through pipeline, as Collection.Watch does (mgo
+// API compatible). The change stream resumes by itself once after errors
+// that allow for it, as with the original driver.
+func (c *ModernColl) Watch(pipeline interface{}, opts ChangeStreamOptions) (*ModernChangeStream, error) {
+	ctx, sess := c.cursorContext()
+	stream, err := c.collection().Watch(ctx, modernPipeline(pipeline), modernChangeStreamOptions(opts))
+	if err != nil {
+		sess.end()
+		return nil, modernError(err)
+	}
+	return &ModernChangeStream{stream: stream, ctx: ctx, sess: sess}, nil
+}
+
+// Watch opens a change stream reporting the changes made to all the
+// collections of the database. It requires MongoDB 4.0+.
+func (db *ModernDB) Watch(pipeline interface{}, opts ChangeStreamOptions) (*ModernChangeStream, error) {
+	ctx, sess := db.session.sessionContext(db.ctx)
+	stream, err := db.database().Watch(ctx, modernPipeline(pipeline), modernChangeStreamOptions(opts))
+	if err != nil {
+		sess.end()
+		return nil, modernError(err)
+	}
+	return &ModernChangeStream{stream: stream, ctx: ctx, sess: sess}, nil
+}
+
+// Watch opens a change stream reporting the changes made to all the
+// databases of the deployment, except for admin, local and config. It
+// requires MongoDB 4.0+.
+func (m *ModernMGO) Watch(pipeline interface{}, opts ChangeStreamOptions) (*ModernChangeStream, error) {
+	ctx, sess := m.sessionContext(m.ctx)
+	stream, err := m.client.Watch(ctx, modernPipeline(pipeline), modernChangeStreamOptions(opts))
+	if err != nil {
+		sess.end()
+		return nil, modernError(err)
+	}
+	return &ModernChangeStream{stream: stream, ctx: ctx, sess: sess}, nil
+}
+
+// modernChangeStreamOptions maps opts onto the official driver options.
+func modernChangeStreamOptions(opts ChangeStreamOptions) *options.ChangeStreamOptions {
+	csOpts := options.ChangeStream()
+	if opts.FullDocument != "" {
+		csOpts.SetFullDocument(options.FullDocument(opts.FullDocument))
+	}
+	if opts.FullDocumentBeforeChange != "" {
+		csOpts.SetFullDocumentBeforeChange(options.FullDocument(opts.FullDocumentBeforeChange))
+	}
+	if opts.ResumeAfter != nil {
+		csOpts.SetResumeAfter(officialBson.Raw(opts.ResumeAfter.Data))
+	}
+	if opts.StartAfter != nil {
+		csOpts.SetStartAfter(officialBson.Raw(opts.StartAfter.Data))
+	}
+	if ts := opts.StartAtOperationTime; ts != 0 {
+		csOpts.SetStartAtOperationTime(&primitive.Timestamp{T: uint32(ts >> 32), I: uint32(ts)})
+	}
+	if opts.MaxAwaitTimeMS > 0 {
+		csOpts.SetMaxAwaitTime(opts.MaxAwaitTimeMS)
+	}
+	if opts.BatchSize > 0 {
+		csOpts.SetBatchSize(int32(opts.BatchSize))
+	}
+	return csOpts
+}
+
+// Next retrieves the next change document from the change stream, as
+// ChangeStream.Next does (mgo API compatible). It waits for a change for
+// up to the MaxAwaitTimeMS option, or a server default of one second, and
+// returns false if there was none, with Err returning nil and Timeout
+// returning true. Next may be called again then.
+func (cs *ModernChangeStream) Next(result interface{}) bool {
+	cs.m.Lock()
+	defer cs.m.Unlock()
+
+	if cs.err != nil {
+		return false
+	}
+	if cs.isClosed {
+		cs.err = fmt.Errorf("illegal use of a closed ChangeStream")
+		return false
+	}
+
+	cs.timedout = false
+	if !cs.stream.TryNext(cs.ctx) {
+		cs.err = modernError(cs.stream.Err())
+		// Streams whose cursor is gone, as after an invalidate event, have
+		// nothing more to wait for.
+		cs.timedout = cs.err == nil && cs.stream.ID() != 0
+		return false
+	}
+	cs.sess.observe()
+	if err := decodeModern(cs.stream.Current, result); err != nil {
+		cs.err = err
+		return false
+	}
+	return true
+}
+
+// Err returns nil if no errors happened during iteration, or the actual
+// error otherwise (mgo API compatible).
+func (cs *ModernChangeStream) Err() error {
+	cs.m.Lock()
+	defer cs.m.Unlock()
+	return cs.err
+}
+
+// Close kills the server cursor used by the change stream, and returns nil
+// if no errors happened during iteration, or the actual error otherwise
+// (mgo API compatible).
+func (cs *ModernChangeStream) Close() error {
+	cs.m.Lock()
+	defer cs.m.Unlock()
+	cs.isClosed = true
+	if err := cs.stream.Close(cs.ctx); err != nil {
+		cs.err = modernError(err)
+	}
+	cs.sess.end()
+	cs.sess = nil
+	return cs.err
+}
+
+// ResumeToken returns a copy of the current resume token held by the change
+// stream, to be given as ResumeAfter or StartAfter to a new change stream
+// (mgo API compatible).
+func (cs *ModernChangeStream) ResumeToken() *bson.Raw {
+	cs.m.Lock()
+	defer cs.m.Unlock()
+	token := cs.stream.ResumeToken()
+	if token == nil {
+		return nil
+	}
+	return &bson.Raw{Kind: 0x03, Data: append([]byte(nil), token...)}
+}
+
+// Timeout returns true if the last call of Next returned false because no
+// change happened in time (mgo API compatible).
+func (cs *ModernChangeStream) Timeout() bool {
+	cs.m.Lock()
+	defer cs.m.Unlock()
+	return cs.timedout
+}
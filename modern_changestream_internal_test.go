@@ -0,0 +1,58 @@
+package mgo
+
+import (
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	officialBson "go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	. "gopkg.in/check.v1"
+)
+
+func (s *S) TestModernChangeStreamOptions(c *C) {
+	token := &bson.Raw{Kind: 0x03, Data: []byte("\x05\x00\x00\x00\x00")}
+	opts := modernChangeStreamOptions(ChangeStreamOptions{
+		FullDocument:             UpdateLookup,
+		FullDocumentBeforeChange: WhenAvailable,
+		ResumeAfter:              token,
+		StartAfter:               token,
+		StartAtOperationTime:     bson.MongoTimestamp(7<<32 | 3),
+		MaxAwaitTimeMS:           2 * time.Second,
+		BatchSize:                10,
+	})
+	c.Assert(*opts.FullDocument, Equals, options.UpdateLookup)
+	c.Assert(*opts.FullDocumentBeforeChange, Equals, options.WhenAvailable)
+	c.Assert(opts.ResumeAfter, DeepEquals, officialBson.Raw(token.Data))
+	c.Assert(opts.StartAfter, DeepEquals, officialBson.Raw(token.Data))
+	c.Assert(*opts.StartAtOperationTime, Equals, primitive.Timestamp{T: 7, I: 3})
+	c.Assert(*opts.MaxAwaitTime, Equals, 2*time.Second)
+	c.Assert(*opts.BatchSize, Equals, int32(10))
+
+	opts = modernChangeStreamOptions(ChangeStreamOptions{})
+	c.Assert(opts.FullDocument, IsNil)
+	c.Assert(opts.ResumeAfter, IsNil)
+	c.Assert(opts.StartAtOperationTime, IsNil)
+}
+
+func (s *S) TestModernPipeline(c *C) {
+	c.Assert(modernPipeline(nil), HasLen, 0)
+	stages := modernPipeline([]bson.D{{{Name: "$match", Value: bson.M{"n": 1}}}})
+	c.Assert(stages, DeepEquals, []interface{}{officialBson.D{{Key: "$match", Value: officialBson.M{"n": 1}}}})
+	c.Assert(func() { modernPipeline(bson.M{}) }, PanicMatches, "pipeline argument must be a slice")
+}
+
+func (s *S) TestChangeStreamStageOptions(c *C) {
+	token := &bson.Raw{Kind: 0x03, Data: []byte("\x05\x00\x00\x00\x00")}
+	pipeline := constructChangeStreamPipeline([]bson.M{{"$match": bson.M{}}}, ChangeStreamOptions{
+		StartAfter:               token,
+		StartAtOperationTime:     bson.MongoTimestamp(1),
+		FullDocumentBeforeChange: Required,
+	}).([]interface{})
+	c.Assert(pipeline, HasLen, 2)
+	c.Assert(pipeline[0], DeepEquals, bson.M{"$changeStream": bson.M{
+		"startAfter":               token,
+		"startAtOperationTime":     bson.MongoTimestamp(1),
+		"fullDocumentBeforeChange": FullDocument(Required),
+	}})
+}
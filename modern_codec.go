@@ -0,0 +1,146 @@
+// modern_codec.go - mgo BSON codecs for the official MongoDB driver
+
+package mgo
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+
+	"github.com/globalsign/mgo/bson"
+	officialBson "go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsoncodec"
+	"go.mongodb.org/mongo-driver/bson/bsonrw"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+)
+
+// ModernRegistry is a codec registry for the official driver that encodes
+// and decodes documents with the mgo bson package. Structs, maps and the
+// mgo BSON types thus follow the mgo rules: struct tags such as ",inline",
+// ",omitempty" and ",minsize" are honoured, bson.Getter and bson.Setter are
+// called, and bson.Raw, bson.RawD, bson.ObjectId, bson.MongoTimestamp and
+// bson.Decimal128 map to their BSON counterparts.
+//
+// Types defined by the official driver, such as primitive.M, keep the
+// codecs of the driver.
+//
+// The modern wrapper decodes query results with ModernRegistry. It may be
+// used with the official driver directly too:
+//
+//	options.Client().SetRegistry(mgo.ModernRegistry)
+var ModernRegistry = newModernRegistry()
+
+func newModernRegistry() *bsoncodec.Registry {
+	reg := officialBson.NewRegistry()
+	codec := &mgoCodec{fallback: officialBson.NewRegistry()}
+	for _, t := range []reflect.Type{
+		reflect.TypeOf(bson.M{}),
+		reflect.TypeOf(bson.D{}),
+		reflect.TypeOf(bson.RawD{}),
+		reflect.TypeOf(bson.Raw{}),
+		reflect.TypeOf(bson.ObjectId("")),
+		reflect.TypeOf(bson.MongoTimestamp(0)),
+		reflect.TypeOf(bson.Decimal128{}),
+		reflect.TypeOf(bson.Binary{}),
+		reflect.TypeOf(bson.RegEx{}),
+		reflect.TypeOf(bson.JavaScript{}),
+		reflect.TypeOf(bson.Symbol("")),
+		reflect.TypeOf(bson.DBPointer{}),
+		reflect.TypeOf(bson.MinKey),
+	} {
+		reg.RegisterTypeEncoder(t, codec)
+		reg.RegisterTypeDecoder(t, codec)
+	}
+	reg.RegisterInterfaceEncoder(reflect.TypeOf((*bson.Getter)(nil)).Elem(), codec)
+	reg.RegisterInterfaceDecoder(reflect.TypeOf((*bson.Setter)(nil)).Elem(), codec)
+	for _, kind := range []reflect.Kind{reflect.Struct, reflect.Map} {
+		reg.RegisterKindEncoder(kind, codec)
+		reg.RegisterKindDecoder(kind, codec)
+	}
+	return reg
+}
+
+// mgoCodec encodes and decodes values with the mgo bson package, handing
+// the raw bytes over to and from the official driver.
+type mgoCodec struct {
+	fallback *bsoncodec.Registry // Codecs for the types of the official driver.
+}
+
+// isDriverType returns whether t is defined by the official driver.
+func isDriverType(t reflect.Type) bool {
+	return strings.HasPrefix(t.PkgPath(), "go.mongodb.org/")
+}
+
+func (c *mgoCodec) EncodeValue(ec bsoncodec.EncodeContext, vw bsonrw.ValueWriter, val reflect.Value) error {
+	if !val.IsValid() || val.Kind() == reflect.Ptr && val.IsNil() {
+		return vw.WriteNull()
+	}
+	if isDriverType(val.Type()) {
+		enc, err := c.fallback.LookupEncoder(val.Type())
+		if err != nil {
+			return err
+		}
+		return enc.EncodeValue(ec, vw, val)
+	}
+
+	// The mgo bson package only marshals documents, so the value is
+	// marshalled as the single element of one and taken out of it.
+	data, err := bson.Marshal(bson.D{{Name: "v", Value: val.Interface()}})
+	if err != nil {
+		return err
+	}
+	// int32 length, kind, "v\x00", value, trailing '\x00'.
+	kind, value := bsontype.Type(data[4]), data[7:len(data)-1]
+	if kind == bsontype.EmbeddedDocument {
+		return bsonrw.Copier{}.CopyDocumentFromBytes(vw, value)
+	}
+	return bsonrw.Copier{}.CopyValueFromBytes(vw, kind, value)
+}
+
+func (c *mgoCodec) DecodeValue(dc bsoncodec.DecodeContext, vr bsonrw.ValueReader, val reflect.Value) error {
+	if isDriverType(val.Type()) {
+		dec, err := c.fallback.LookupDecoder(val.Type())
+		if err != nil {
+			return err
+		}
+		return dec.DecodeValue(dc, vr, val)
+	}
+
+	kind, data, err := bsonrw.Copier{}.CopyValueToBytes(vr)
+	if err != nil {
+		return err
+	}
+	if kind == 0 {
+		// The value read is the top-level document.
+		kind = bsontype.EmbeddedDocument
+	}
+	raw := bson.Raw{Kind: byte(kind), Data: data}
+	switch {
+	case val.CanAddr():
+		return raw.Unmarshal(val.Addr().Interface())
+	case val.Kind() == reflect.Map && !val.IsNil():
+		return raw.Unmarshal(val.Interface())
+	case val.CanSet():
+		out := reflect.New(val.Type())
+		if err := raw.Unmarshal(out.Interface()); err != nil {
+			return err
+		}
+		val.Set(out.Elem())
+		return nil
+	}
+	return errors.New("cannot decode into unsettable " + val.Type().String())
+}
+
+// decodeModern decodes the document in data into result as bson.Unmarshal
+// does. Results may be nil, in which case the document is dropped.
+func decodeModern(data []byte, result interface{}) error {
+	switch out := result.(type) {
+	case nil:
+		return nil
+	case *interface{}:
+		// Documents decode into empty interfaces as bson.M, as with mgo,
+		// rather than as the primitive.D of the official driver.
+		return bson.Unmarshal(data, out)
+	}
+	return officialBson.UnmarshalWithRegistry(ModernRegistry, data, result)
+}
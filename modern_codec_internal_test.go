@@ -0,0 +1,190 @@
+package mgo
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	officialBson "go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	. "gopkg.in/check.v1"
+)
+
+type codecInner struct {
+	City string `bson:"city"`
+	Zip  string `bson:"zip,omitempty"`
+}
+
+type codecDoc struct {
+	Id       bson.ObjectId       `bson:"_id"`
+	Name     string              `bson:"name"`
+	Skipped  string              `bson:"skipped,omitempty"`
+	Small    int64               `bson:"small,minsize"`
+	Ts       bson.MongoTimestamp `bson:"ts"`
+	Price    bson.Decimal128     `bson:"price"`
+	When     time.Time           `bson:"when"`
+	Inner    codecInner          `bson:",inline"`
+	Tags     []string            `bson:"tags"`
+	Extra    bson.Raw            `bson:"extra"`
+	Ordered  bson.D              `bson:"ordered"`
+	Upper    codecUpper          `bson:"upper"`
+	Untagged int
+}
+
+// codecUpper is stored with an "UP:" prefix through bson.Getter and bson.Setter.
+type codecUpper string
+
+func (u codecUpper) GetBSON() (interface{}, error) {
+	return "UP:" + string(u), nil
+}
+
+func (u *codecUpper) SetBSON(raw bson.Raw) error {
+	var s string
+	if err := raw.Unmarshal(&s); err != nil {
+		return err
+	}
+	if len(s) < 3 || s[:3] != "UP:" {
+		return errors.New("bad upper value: " + s)
+	}
+	*u = codecUpper(s[3:])
+	return nil
+}
+
+func newCodecDoc() codecDoc {
+	price, _ := bson.ParseDecimal128("12.50")
+	return codecDoc{
+		Id:       bson.ObjectIdHex("5a934e000102030405000000"),
+		Name:     "doc",
+		Small:    42,
+		Ts:       bson.MongoTimestamp(7 << 32),
+		Price:    price,
+		When:     time.Date(2018, 2, 26, 0, 0, 0, 0, time.UTC),
+		Inner:    codecInner{City: "Lisbon"},
+		Tags:     []string{"a", "b"},
+		Extra:    bson.Raw{Kind: 0x02, Data: []byte("\x02\x00\x00\x00x\x00")},
+		Ordered:  bson.D{{Name: "z", Value: 1}, {Name: "a", Value: 2}},
+		Upper:    "value",
+		Untagged: 3,
+	}
+}
+
+func (s *S) TestModernRegistryEncode(c *C) {
+	doc := newCodecDoc()
+	data, err := officialBson.MarshalWithRegistry(ModernRegistry, doc)
+	c.Assert(err, IsNil)
+
+	// The driver produces exactly what the mgo bson package does.
+	want, err := bson.Marshal(doc)
+	c.Assert(err, IsNil)
+	c.Assert([]byte(data), DeepEquals, want)
+
+	var raw officialBson.D
+	c.Assert(officialBson.Unmarshal(data, &raw), IsNil)
+	keys := make([]string, len(raw))
+	for i, e := range raw {
+		keys[i] = e.Key
+	}
+	c.Assert(keys, DeepEquals, []string{"_id", "name", "small", "ts", "price", "when", "city", "tags", "extra", "ordered", "upper", "untagged"})
+	m := raw.Map()
+	c.Assert(m["_id"], Equals, primitive.ObjectID{0x5a, 0x93, 0x4e, 0, 1, 2, 3, 4, 5, 0, 0, 0})
+	c.Assert(m["small"], Equals, int32(42))
+	c.Assert(m["ts"], Equals, primitive.Timestamp{T: 7, I: 0})
+	c.Assert(m["upper"], Equals, "UP:value")
+}
+
+func (s *S) TestModernRegistryDecode(c *C) {
+	doc := newCodecDoc()
+	data, err := bson.Marshal(doc)
+	c.Assert(err, IsNil)
+
+	var result codecDoc
+	c.Assert(decodeModern(data, &result), IsNil)
+	c.Assert(result, DeepEquals, doc)
+
+	var d bson.D
+	c.Assert(decodeModern(data, &d), IsNil)
+	c.Assert(d[0].Name, Equals, "_id")
+	c.Assert(d[len(d)-1].Name, Equals, "untagged")
+	ordered, ok := d[9].Value.(bson.D)
+	c.Assert(ok, Equals, true)
+	c.Assert(ordered, DeepEquals, bson.D{{Name: "z", Value: 1}, {Name: "a", Value: 2}})
+
+	var rawD bson.RawD
+	c.Assert(decodeModern(data, &rawD), IsNil)
+	c.Assert(rawD, HasLen, 12)
+
+	var rawDoc bson.Raw
+	c.Assert(decodeModern(data, &rawDoc), IsNil)
+	c.Assert(rawDoc.Kind, Equals, byte(0x03))
+	c.Assert(rawDoc.Data, DeepEquals, data)
+
+	var m bson.M
+	c.Assert(decodeModern(data, &m), IsNil)
+	c.Assert(m["_id"], Equals, doc.Id)
+	c.Assert(m["when"].(time.Time).Equal(doc.When), Equals, true)
+	c.Assert(m["ordered"], DeepEquals, bson.M{"z": 1, "a": 2})
+
+	mv := map[string]interface{}{}
+	c.Assert(decodeModern(data, mv), IsNil)
+	c.Assert(mv["name"], Equals, "doc")
+
+	var iface interface{}
+	c.Assert(decodeModern(data, &iface), IsNil)
+	c.Assert(iface.(bson.M)["name"], Equals, "doc")
+
+	c.Assert(decodeModern(data, nil), IsNil)
+
+	var bad struct {
+		Upper codecUpper `bson:"name"`
+	}
+	c.Assert(decodeModern(data, &bad), ErrorMatches, "bad upper value: doc")
+}
+
+func (s *S) TestModernRegistryDriverTypes(c *C) {
+	// Types of the official driver keep the codecs of the driver.
+	data, err := bson.Marshal(bson.M{"_id": bson.ObjectIdHex("5a934e000102030405000000"), "n": 1})
+	c.Assert(err, IsNil)
+
+	var m officialBson.M
+	c.Assert(officialBson.UnmarshalWithRegistry(ModernRegistry, data, &m), IsNil)
+	c.Assert(m["_id"], Equals, primitive.ObjectID{0x5a, 0x93, 0x4e, 0, 1, 2, 3, 4, 5, 0, 0, 0})
+
+	out, err := officialBson.MarshalWithRegistry(ModernRegistry, officialBson.D{{Key: "b", Value: 1}, {Key: "a", Value: bson.ObjectIdHex("5a934e000102030405000000")}})
+	c.Assert(err, IsNil)
+	var d bson.D
+	c.Assert(bson.Unmarshal(out, &d), IsNil)
+	c.Assert(d, DeepEquals, bson.D{{Name: "b", Value: 1}, {Name: "a", Value: bson.ObjectIdHex("5a934e000102030405000000")}})
+}
+
+// decodeModernRoundTrip is how results were decoded before ModernRegistry.
+func decodeModernRoundTrip(data []byte, result interface{}) error {
+	var doc officialBson.M
+	if err := officialBson.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+	return mapStructToInterface(convertOfficialToMGO(doc), result)
+}
+
+func benchmarkModernDecode(b *testing.B, decode func([]byte, interface{}) error) {
+	data, err := bson.Marshal(newCodecDoc())
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var result codecDoc
+		if err := decode(data, &result); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkModernDecodeRegistry(b *testing.B) {
+	benchmarkModernDecode(b, decodeModern)
+}
+
+func BenchmarkModernDecodeRoundTrip(b *testing.B) {
+	benchmarkModernDecode(b, decodeModernRoundTrip)
+}
@@ -16,7 +16,7 @@ import (
 
 // Insert inserts documents (mgo API compatible)
 func (c *ModernColl) Insert(docs ...interface{}) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := c.opContext(10 * time.Second)
 	defer cancel()
 
 	convertedDocs := make([]interface{}, len(docs))
@@ -27,11 +27,11 @@ func (c *ModernColl) Insert(docs ...interface{}) error {
 	}
 
 	if len(convertedDocs) == 1 {
-		_, err := c.mgoColl.InsertOne(ctx, convertedDocs[0])
-		return err
+		_, err := c.collection().InsertOne(ctx, convertedDocs[0])
+		return modernWriteError(err)
 	}
-	_, err := c.mgoColl.InsertMany(ctx, convertedDocs)
-	return err
+	_, err := c.collection().InsertMany(ctx, convertedDocs)
+	return modernWriteError(err)
 }
 
 // Find creates a query (mgo API compatible)
@@ -53,40 +53,112 @@ func (c *ModernColl) Find(query interface{}) *ModernQ {
 
 // Count counts documents
 func (c *ModernColl) Count() (int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := c.opContext(10 * time.Second)
 	defer cancel()
 
-	count, err := c.mgoColl.CountDocuments(ctx, officialBson.M{})
-	return int(count), err
+	count, err := c.collection().CountDocuments(ctx, officialBson.M{})
+	return int(count), modernError(err)
 }
 
-// Remove removes a document
+// Remove removes a document, returning ErrNotFound if none matches the
+// selector in safe mode (mgo API compatible)
 func (c *ModernColl) Remove(selector interface{}) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := c.opContext(10 * time.Second)
 	defer cancel()
 
 	filter := convertMGOToOfficial(selector)
-	_, err := c.mgoColl.DeleteOne(ctx, filter)
-	return err
+	result, err := c.collection().DeleteOne(ctx, filter)
+	if err == nil && result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return modernDeleteError(result, err)
 }
 
-// Update updates a document
+// Update updates a document, returning ErrNotFound if none matches the
+// selector in safe mode (mgo API compatible)
 func (c *ModernColl) Update(selector, update interface{}) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := c.opContext(10 * time.Second)
+	defer cancel()
+
+	filter := convertMGOToOfficial(selector)
+	result, err := c.updateOne(ctx, filter, update, false)
+	if err == nil && result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return modernUpdateError(result, err)
+}
+
+// Replace replaces a document with the replacement document, dropping the
+// fields the replacement doesn't hold, and returns ErrNotFound if none
+// matches the selector in safe mode (mgo API compatible)
+func (c *ModernColl) Replace(selector, replacement interface{}) error {
+	if err := checkReplacement(replacement); err != nil {
+		return err
+	}
+	ctx, cancel := c.opContext(10 * time.Second)
 	defer cancel()
 
 	filter := convertMGOToOfficial(selector)
-	// Wrap plain documents in $set operator for MongoDB compatibility
-	wrappedUpdate := wrapInSetOperator(update)
-	updateDoc := convertMGOToOfficial(wrappedUpdate)
+	result, err := c.collection().ReplaceOne(ctx, filter, convertMGOToOfficial(replacement))
+	if err == nil && result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return modernUpdateError(result, err)
+}
+
+// ReplaceId replaces a document by its ID (mgo API compatible)
+func (c *ModernColl) ReplaceId(id, replacement interface{}) error {
+	return c.Replace(bson.M{"_id": id}, replacement)
+}
+
+// ReplaceOne replaces a document with the replacement document, as Replace
+// does, but reports what was done rather than an error when no document
+// matches the selector (mgo API compatible)
+func (c *ModernColl) ReplaceOne(selector, replacement interface{}) (*ChangeInfo, error) {
+	if err := checkReplacement(replacement); err != nil {
+		return nil, err
+	}
+	ctx, cancel := c.opContext(10 * time.Second)
+	defer cancel()
+
+	filter := convertMGOToOfficial(selector)
+	result, err := c.collection().ReplaceOne(ctx, filter, convertMGOToOfficial(replacement))
+	err = modernUpdateError(result, err)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ChangeInfo{
+		Updated: int(result.ModifiedCount),
+		Matched: int(result.MatchedCount),
+	}, nil
+}
+
+// updateDocument returns update as sent to the server, wrapped in a $set
+// operator unless the session is in strict replace mode. See
+// ModernMGO.SetStrictReplace.
+func (c *ModernColl) updateDocument(update interface{}) interface{} {
+	if c.session != nil && c.session.strictReplace() {
+		return update
+	}
+	return wrapInSetOperator(update)
+}
 
-	_, err := c.mgoColl.UpdateOne(ctx, filter, updateDoc)
-	return err
+// updateOne updates the first document matching filter, or replaces it if
+// update is a replacement document in strict replace mode.
+func (c *ModernColl) updateOne(ctx context.Context, filter, update interface{}, upsert bool) (*mongodrv.UpdateResult, error) {
+	doc := c.updateDocument(update)
+	if !hasUpdateOperators(doc) {
+		opts := options.Replace().SetUpsert(upsert)
+		return c.collection().ReplaceOne(ctx, filter, convertMGOToOfficial(doc), opts)
+	}
+	opts := options.Update().SetUpsert(upsert)
+	return c.collection().UpdateOne(ctx, filter, convertMGOToOfficial(doc), opts)
 }
 
 // EnsureIndex creates an index (mgo API compatible)
 func (c *ModernColl) EnsureIndex(index Index) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := c.opContext(30 * time.Second)
 	defer cancel()
 
 	// Use officialBson.D to maintain key order for index creation
@@ -122,8 +194,8 @@ func (c *ModernColl) EnsureIndex(index Index) error {
 		indexModel.Options.ExpireAfterSeconds = &expireAfterSeconds
 	}
 
-	_, err := c.mgoColl.Indexes().CreateOne(ctx, indexModel)
-	return err
+	_, err := c.ackCollection().Indexes().CreateOne(ctx, indexModel)
+	return modernError(err)
 }
 
 // EnsureIndexKey ensures an index with the given key exists, creating it if necessary (mgo API compatible)
@@ -133,12 +205,12 @@ func (c *ModernColl) EnsureIndexKey(key ...string) error {
 
 // Indexes returns a list of all indexes for the collection.
 func (c *ModernColl) Indexes() ([]Index, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := c.opContext(10 * time.Second)
 	defer cancel()
 
-	cursor, err := c.mgoColl.Indexes().List(ctx)
+	cursor, err := c.ackCollection().Indexes().List(ctx)
 	if err != nil {
-		return nil, err
+		return nil, modernError(err)
 	}
 	defer cursor.Close(ctx)
 
@@ -178,15 +250,70 @@ func (c *ModernColl) Indexes() ([]Index, error) {
 		indexes = append(indexes, index)
 	}
 
-	return indexes, cursor.Err()
+	return indexes, modernError(cursor.Err())
 }
 
 // DropCollection drops the collection
 func (c *ModernColl) DropCollection() error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := c.opContext(10 * time.Second)
 	defer cancel()
 
-	return c.mgoColl.Drop(ctx)
+	return modernError(c.ackCollection().Drop(ctx))
+}
+
+// Create explicitly creates the collection with the details of info, such as
+// a capped size, a validator or a default collation (mgo API compatible).
+func (c *ModernColl) Create(info *CollectionInfo) error {
+	cmd, err := info.createCmd(c.name)
+	if err != nil {
+		return err
+	}
+	return c.Run(cmd, nil)
+}
+
+// DropIndex drops the index with the provided key, or with the provided
+// index name, from the collection (mgo API compatible).
+func (c *ModernColl) DropIndex(key ...string) error {
+	keyInfo, err := parseIndexKey(key)
+	if err != nil {
+		return err
+	}
+	return c.DropIndexName(keyInfo.name)
+}
+
+// DropIndexName removes the index with the provided index name (mgo API
+// compatible).
+func (c *ModernColl) DropIndexName(name string) error {
+	ctx, cancel := c.opContext(10 * time.Second)
+	defer cancel()
+
+	_, err := c.ackCollection().Indexes().DropOne(ctx, name)
+	return modernError(err)
+}
+
+// DropAllIndexes drops all the indexes from the collection, except for the
+// one on _id (mgo API compatible).
+func (c *ModernColl) DropAllIndexes() error {
+	ctx, cancel := c.opContext(10 * time.Second)
+	defer cancel()
+
+	_, err := c.ackCollection().Indexes().DropAll(ctx)
+	return modernError(err)
+}
+
+// Repair returns an iterator over all recovered documents in the collection,
+// as Collection.Repair does (mgo API compatible). The repairCursor command
+// is gone in MongoDB 4.2+, where the iterator reports the server error.
+func (c *ModernColl) Repair() *ModernIt {
+	ctx, sess := c.cursorContext()
+	cmd := officialBson.D{{Key: "repairCursor", Value: c.name}}
+	cursor, err := c.ackCollection().Database().RunCommandCursor(ctx, cmd)
+	return &ModernIt{
+		cursor: cursor,
+		ctx:    ctx,
+		sess:   sess,
+		err:    modernError(err),
+	}
 }
 
 // Pipe creates an aggregation pipeline (mgo API compatible)
@@ -203,20 +330,17 @@ func (c *ModernColl) Pipe(pipeline interface{}) *ModernPipe {
 
 // Run executes a database command on the collection's database (mgo API compatible)
 func (c *ModernColl) Run(cmd, result interface{}) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := c.opContext(10 * time.Second)
 	defer cancel()
 
 	command := convertMGOToOfficial(cmd)
-	singleResult := c.mgoColl.Database().RunCommand(ctx, command)
+	singleResult := c.ackCollection().Database().RunCommand(ctx, command)
 
-	var doc officialBson.M
-	err := singleResult.Decode(&doc)
+	raw, err := singleResult.Raw()
 	if err != nil {
-		return err
+		return modernError(err)
 	}
-
-	converted := convertOfficialToMGO(doc)
-	return mapStructToInterface(converted, result)
+	return decodeModern(raw, result)
 }
 
 // Bulk returns a bulk operation builder (mgo API compatible)
@@ -245,6 +369,11 @@ func (c *ModernColl) UpdateId(id, update interface{}) error {
 	return c.Update(bson.M{"_id": id}, update)
 }
 
+// UpsertId upserts a document by its ID (mgo API compatible)
+func (c *ModernColl) UpsertId(id, update interface{}) (*ChangeInfo, error) {
+	return c.Upsert(bson.M{"_id": id}, update)
+}
+
 // RemoveId removes a document by its ID (mgo API compatible)
 func (c *ModernColl) RemoveId(id interface{}) error {
 	return c.Remove(bson.M{"_id": id})
@@ -252,11 +381,12 @@ func (c *ModernColl) RemoveId(id interface{}) error {
 
 // RemoveAll removes all documents matching the selector (mgo API compatible)
 func (c *ModernColl) RemoveAll(selector interface{}) (*ChangeInfo, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := c.opContext(10 * time.Second)
 	defer cancel()
 
 	filter := convertMGOToOfficial(selector)
-	result, err := c.mgoColl.DeleteMany(ctx, filter)
+	result, err := c.collection().DeleteMany(ctx, filter)
+	err = modernDeleteError(result, err)
 	if err != nil {
 		return nil, err
 	}
@@ -269,16 +399,20 @@ func (c *ModernColl) RemoveAll(selector interface{}) (*ChangeInfo, error) {
 
 // Upsert updates a document or inserts it if it doesn't exist (mgo API compatible)
 func (c *ModernColl) Upsert(selector, update interface{}) (*ChangeInfo, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := c.opContext(10 * time.Second)
 	defer cancel()
 
 	filter := convertMGOToOfficial(selector)
-	// Wrap plain documents in $set operator for MongoDB compatibility
-	wrappedUpdate := wrapInSetOperator(update)
-	updateDoc := convertMGOToOfficial(wrappedUpdate)
-
-	opts := options.Update().SetUpsert(true)
-	result, err := c.mgoColl.UpdateOne(ctx, filter, updateDoc, opts)
+	var result *mongodrv.UpdateResult
+	var err error
+	for i := 0; i < maxUpsertRetries; i++ {
+		result, err = c.updateOne(ctx, filter, update, true)
+		err = modernUpdateError(result, err)
+		// Retry duplicate key errors on upserts, as Collection.Upsert does.
+		if !IsDup(err) {
+			break
+		}
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -297,15 +431,14 @@ func (c *ModernColl) Upsert(selector, update interface{}) (*ChangeInfo, error) {
 
 // UpdateAll updates all documents matching the selector (mgo API compatible)
 func (c *ModernColl) UpdateAll(selector, update interface{}) (*ChangeInfo, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := c.opContext(10 * time.Second)
 	defer cancel()
 
 	filter := convertMGOToOfficial(selector)
-	// Wrap plain documents in $set operator for MongoDB compatibility
-	wrappedUpdate := wrapInSetOperator(update)
-	updateDoc := convertMGOToOfficial(wrappedUpdate)
+	updateDoc := convertMGOToOfficial(c.updateDocument(update))
 
-	result, err := c.mgoColl.UpdateMany(ctx, filter, updateDoc)
+	result, err := c.collection().UpdateMany(ctx, filter, updateDoc)
+	err = modernUpdateError(result, err)
 	if err != nil {
 		return nil, err
 	}
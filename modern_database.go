@@ -0,0 +1,150 @@
+// modern_database.go - Database administration for modern MongoDB driver compatibility wrapper
+
+package mgo
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	officialBson "go.mongodb.org/mongo-driver/bson"
+	mongodrv "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// database returns the driver database behind db, with the read preference
+// and read concern of the session applied.
+func (db *ModernDB) database() *mongodrv.Database {
+	if db.session == nil {
+		return db.mgoDB
+	}
+	settings := db.session.currentSettings()
+	opts := options.Database().SetReadPreference(settings.coll.ReadPreference)
+	if settings.coll.ReadConcern != nil {
+		opts.SetReadConcern(settings.coll.ReadConcern)
+	}
+	return db.mgoDB.Client().Database(db.name, opts)
+}
+
+// CollectionNames returns the collection names present in the db database
+// (mgo API compatible).
+func (db *ModernDB) CollectionNames() ([]string, error) {
+	ctx, cancel := db.opContext(30 * time.Second)
+	defer cancel()
+
+	names, err := db.database().ListCollectionNames(ctx, officialBson.D{})
+	if err != nil {
+		return nil, modernError(err)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// DropDatabase removes the entire database including all of its collections
+// (mgo API compatible).
+func (db *ModernDB) DropDatabase() error {
+	return db.Run(bson.D{{Name: "dropDatabase", Value: 1}}, nil)
+}
+
+// CreateView creates a view named view over the source collection, as
+// Database.CreateView does (mgo API compatible). The pipeline must be a
+// slice of stages and collation may be nil.
+func (db *ModernDB) CreateView(view string, source string, pipeline interface{}, collation *Collation) error {
+	ctx, cancel := db.opContext(30 * time.Second)
+	defer cancel()
+
+	opts := options.CreateView()
+	if collation != nil {
+		opts.SetCollation(modernCollation(collation))
+	}
+	return modernError(db.mgoDB.CreateView(ctx, view, source, modernPipeline(pipeline), opts))
+}
+
+// UpsertUser updates the authentication credentials and the roles for a
+// MongoDB user within the db database, creating the user if it doesn't
+// exist yet, as Database.UpsertUser does (mgo API compatible). The UserSource
+// setting is only supported for users of the $external database.
+func (db *ModernDB) UpsertUser(user *User) error {
+	if user.Username == "" {
+		return fmt.Errorf("user has no Username")
+	}
+	if (user.Password != "" || user.PasswordHash != "") && user.UserSource != "" {
+		return fmt.Errorf("user has both Password/PasswordHash and UserSource set")
+	}
+	if len(user.OtherDBRoles) > 0 && db.name != "admin" && db.name != "$external" {
+		return fmt.Errorf("user with OtherDBRoles is only supported in the admin or $external databases")
+	}
+
+	rundb := db
+	if user.UserSource != "" {
+		if user.UserSource != "$external" {
+			return fmt.Errorf("MongoDB 2.6+ does not support the UserSource setting")
+		}
+		rundb = db.session.DB(user.UserSource)
+	}
+	err := rundb.Run(userCmd("updateUser", user), nil)
+	// retry with createUser when unauthorized in order to enable the "localhost exception"
+	if isNotFound(err) || isAuthError(err) {
+		return rundb.Run(userCmd("createUser", user), nil)
+	}
+	return err
+}
+
+// AddUser creates or updates the authentication credentials of user within
+// the db database, with read or read-write roles depending on readOnly
+// (mgo API compatible).
+//
+// WARNING: This method is obsolete. Use UpsertUser instead.
+func (db *ModernDB) AddUser(username, password string, readOnly bool) error {
+	user := &User{Username: username, Password: password}
+	if db.name == "admin" {
+		if readOnly {
+			user.Roles = []Role{RoleReadAny}
+		} else {
+			user.Roles = []Role{RoleReadWriteAny}
+		}
+	} else {
+		if readOnly {
+			user.Roles = []Role{RoleRead}
+		} else {
+			user.Roles = []Role{RoleReadWrite}
+		}
+	}
+	err := db.Run(userCmd("updateUser", user), nil)
+	if isNotFound(err) {
+		return db.Run(userCmd("createUser", user), nil)
+	}
+	return err
+}
+
+// RemoveUser removes the authentication credentials of user from the
+// database, returning ErrNotFound if there is no such user (mgo API
+// compatible).
+func (db *ModernDB) RemoveUser(user string) error {
+	err := db.Run(bson.D{{Name: "dropUser", Value: user}}, nil)
+	if isNotFound(err) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// DatabaseNames returns the names of non-empty databases present in the
+// cluster (mgo API compatible).
+func (m *ModernMGO) DatabaseNames() ([]string, error) {
+	ctx, cancel := m.opContext(30 * time.Second)
+	defer cancel()
+
+	result, err := m.client.ListDatabases(ctx, officialBson.D{})
+	if err != nil {
+		return nil, modernError(err)
+	}
+	var names []string
+	for _, db := range result.Databases {
+		if !db.Empty {
+			names = append(names, db.Name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
@@ -0,0 +1,61 @@
+package mgo
+
+import (
+	"github.com/globalsign/mgo/bson"
+	. "gopkg.in/check.v1"
+)
+
+func (s *S) TestUserCmd(c *C) {
+	user := &User{
+		Username:     "myuser",
+		Password:     "mypass",
+		Roles:        []Role{RoleRead},
+		OtherDBRoles: map[string][]Role{"other": {RoleReadWrite}},
+	}
+	c.Assert(userCmd("createUser", user), DeepEquals, bson.D{
+		{Name: "createUser", Value: "myuser"},
+		{Name: "pwd", Value: "mypass"},
+		{Name: "roles", Value: []interface{}{RoleRead, bson.D{{Name: "role", Value: RoleReadWrite}, {Name: "db", Value: "other"}}}},
+	})
+
+	// Updates leave the roles alone unless some are given.
+	c.Assert(userCmd("updateUser", &User{Username: "myuser"}), DeepEquals, bson.D{{Name: "updateUser", Value: "myuser"}})
+	c.Assert(userCmd("createUser", &User{Username: "myuser"}), DeepEquals, bson.D{
+		{Name: "createUser", Value: "myuser"},
+		{Name: "roles", Value: []interface{}(nil)},
+	})
+}
+
+func (s *S) TestCollectionInfoCreateCmd(c *C) {
+	info := &CollectionInfo{
+		Capped:    true,
+		MaxBytes:  1024,
+		MaxDocs:   10,
+		Validator: bson.M{"n": bson.M{"$gte": 0}},
+		Collation: &Collation{Locale: "en"},
+	}
+	cmd, err := info.createCmd("mycoll")
+	c.Assert(err, IsNil)
+	c.Assert(cmd, DeepEquals, bson.D{
+		{Name: "create", Value: "mycoll"},
+		{Name: "capped", Value: true},
+		{Name: "size", Value: 1024},
+		{Name: "max", Value: 10},
+		{Name: "validator", Value: bson.M{"n": bson.M{"$gte": 0}}},
+		{Name: "collation", Value: &Collation{Locale: "en"}},
+	})
+
+	_, err = (&CollectionInfo{Capped: true}).createCmd("mycoll")
+	c.Assert(err, ErrorMatches, "Collection.Create: with Capped, MaxBytes must also be set")
+}
+
+func (s *S) TestModernUpsertUserValidation(c *C) {
+	db := &ModernDB{name: "mydb"}
+	c.Assert(db.UpsertUser(&User{}), ErrorMatches, "user has no Username")
+	c.Assert(db.UpsertUser(&User{Username: "u", Password: "p", UserSource: "other"}), ErrorMatches,
+		"user has both Password/PasswordHash and UserSource set")
+	c.Assert(db.UpsertUser(&User{Username: "u", OtherDBRoles: map[string][]Role{"other": {RoleRead}}}), ErrorMatches,
+		"user with OtherDBRoles is only supported in the admin or \\$external databases")
+	c.Assert(db.UpsertUser(&User{Username: "u", UserSource: "other"}), ErrorMatches,
+		"MongoDB 2.6\\+ does not support the UserSource setting")
+}
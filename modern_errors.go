@@ -0,0 +1,130 @@
+// modern_errors.go - Error translation for modern MongoDB driver compatibility wrapper
+
+package mgo
+
+import (
+	"sort"
+
+	mongodrv "go.mongodb.org/mongo-driver/mongo"
+)
+
+// modernError translates an error returned by the official driver into the
+// error types of the original driver, so that IsDup, ErrNotFound and the
+// *QueryError, *LastError and *BulkError checks behave the same with both
+// backends:
+//
+//   - ErrNoDocuments becomes ErrNotFound;
+//   - command errors become a *QueryError, keeping their error labels;
+//   - write errors and write concern errors become a *LastError, holding
+//     the code and message of the first write error, or of the write
+//     concern error when there are no write errors.
+//
+// Any other error, such as a network or context error, is returned as is.
+func modernError(err error) error {
+	switch e := err.(type) {
+	case nil:
+		return nil
+	case mongodrv.CommandError:
+		return &QueryError{Code: int(e.Code), Message: e.Message, Labels: e.Labels}
+	case mongodrv.WriteException:
+		ecases := make([]BulkErrorCase, len(e.WriteErrors))
+		for i, werr := range e.WriteErrors {
+			ecases[i] = modernErrorCase(werr)
+		}
+		if lerr := modernLastError(ecases, e.WriteConcernError); lerr != nil {
+			return lerr
+		}
+	case mongodrv.BulkWriteException:
+		ecases := make([]BulkErrorCase, len(e.WriteErrors))
+		for i, werr := range e.WriteErrors {
+			ecases[i] = modernErrorCase(werr.WriteError)
+		}
+		if lerr := modernLastError(ecases, e.WriteConcernError); lerr != nil {
+			return lerr
+		}
+	}
+	if err == mongodrv.ErrNoDocuments {
+		return ErrNotFound
+	}
+	return err
+}
+
+// modernErrorCase returns the bulk error case reporting werr.
+func modernErrorCase(werr mongodrv.WriteError) BulkErrorCase {
+	return BulkErrorCase{
+		Index: werr.Index,
+		Err:   &QueryError{Code: werr.Code, Message: werr.Message},
+	}
+}
+
+// modernLastError returns the *LastError for a write that failed with the
+// ecases write errors and the wcerr write concern error, as writeOpCommand
+// builds it, or nil if there is neither.
+func modernLastError(ecases []BulkErrorCase, wcerr *mongodrv.WriteConcernError) *LastError {
+	lerr := &LastError{ecases: ecases}
+	if len(ecases) > 0 {
+		qerr := ecases[0].Err.(*QueryError)
+		lerr.Code = qerr.Code
+		lerr.Err = qerr.Message
+	} else if wcerr != nil {
+		lerr.Code = wcerr.Code
+		lerr.Err = wcerr.Message
+	} else {
+		return nil
+	}
+	return lerr
+}
+
+// modernUpdateError returns the error of an update made through the driver,
+// translated by modernWriteError. When the translated error is a *LastError,
+// it also reports the documents matched and upserted by the update.
+func modernUpdateError(result *mongodrv.UpdateResult, err error) error {
+	err = modernWriteError(err)
+	if lerr, ok := err.(*LastError); ok && result != nil {
+		lerr.N = int(result.MatchedCount + result.UpsertedCount)
+		lerr.UpdatedExisting = result.MatchedCount > 0 && result.UpsertedCount == 0
+		lerr.modified = int(result.ModifiedCount)
+		if result.UpsertedID != nil {
+			lerr.UpsertedId = convertOfficialToMGO(result.UpsertedID)
+		}
+	}
+	return err
+}
+
+// modernDeleteError returns the error of a delete made through the driver,
+// translated by modernWriteError. When the translated error is a *LastError,
+// it also reports the documents removed.
+func modernDeleteError(result *mongodrv.DeleteResult, err error) error {
+	err = modernWriteError(err)
+	if lerr, ok := err.(*LastError); ok && result != nil {
+		lerr.N = int(result.DeletedCount)
+	}
+	return err
+}
+
+// modernBulkError translates the error of a bulk write made through the
+// driver into a *BulkError with one case per failed operation, as Bulk.Run
+// reports it. A write concern error with no write errors is reported for
+// every one of the n operations, as Bulk.Run does. Other errors are
+// translated by modernWriteError.
+func modernBulkError(err error, n int) error {
+	bwerr, ok := err.(mongodrv.BulkWriteException)
+	if !ok {
+		return modernWriteError(err)
+	}
+	berr := &BulkError{}
+	for _, werr := range bwerr.WriteErrors {
+		berr.ecases = append(berr.ecases, modernErrorCase(werr.WriteError))
+	}
+	if len(berr.ecases) == 0 {
+		lerr := modernLastError(nil, bwerr.WriteConcernError)
+		if lerr == nil {
+			return err
+		}
+		for i := 0; i < n; i++ {
+			berr.ecases = append(berr.ecases, BulkErrorCase{i, lerr})
+		}
+	}
+	sort.Sort(bulkErrorCases(berr.ecases))
+	return berr
+}
@@ -0,0 +1,135 @@
+package mgo
+
+import (
+	"errors"
+
+	"github.com/globalsign/mgo/bson"
+	officialBson "go.mongodb.org/mongo-driver/bson"
+	mongodrv "go.mongodb.org/mongo-driver/mongo"
+	. "gopkg.in/check.v1"
+)
+
+func (s *S) TestModernErrorCommand(c *C) {
+	c.Assert(modernError(nil), IsNil)
+	c.Assert(modernError(mongodrv.ErrNoDocuments) == ErrNotFound, Equals, true)
+
+	other := errors.New("other")
+	c.Assert(modernError(other) == other, Equals, true)
+
+	err := modernError(mongodrv.CommandError{
+		Code:    112,
+		Message: "WriteConflict",
+		Labels:  []string{TransientTransactionError},
+	})
+	qerr, ok := err.(*QueryError)
+	c.Assert(ok, Equals, true)
+	c.Assert(qerr.Code, Equals, 112)
+	c.Assert(qerr.Message, Equals, "WriteConflict")
+	c.Assert(hasErrorLabel(err, TransientTransactionError), Equals, true)
+
+	c.Assert(isNotFound(modernError(mongodrv.CommandError{Code: 11})), Equals, true)
+	c.Assert(IsDup(modernError(mongodrv.CommandError{Code: 11000})), Equals, true)
+}
+
+func (s *S) TestModernErrorWrite(c *C) {
+	err := modernWriteError(mongodrv.WriteException{
+		WriteErrors: mongodrv.WriteErrors{{Index: 0, Code: 11000, Message: "E11000 duplicate key error"}},
+	})
+	lerr, ok := err.(*LastError)
+	c.Assert(ok, Equals, true)
+	c.Assert(lerr.Code, Equals, 11000)
+	c.Assert(lerr.Err, Equals, "E11000 duplicate key error")
+	c.Assert(IsDup(err), Equals, true)
+
+	// Write concern errors are reported when there are no write errors.
+	err = modernWriteError(mongodrv.WriteException{
+		WriteConcernError: &mongodrv.WriteConcernError{Code: 64, Message: "waiting for replication timed out"},
+	})
+	lerr, ok = err.(*LastError)
+	c.Assert(ok, Equals, true)
+	c.Assert(lerr.Code, Equals, 64)
+	c.Assert(lerr.Err, Equals, "waiting for replication timed out")
+
+	// Inserts of several documents fail with a BulkWriteException.
+	err = modernWriteError(mongodrv.BulkWriteException{
+		WriteErrors: []mongodrv.BulkWriteError{
+			{WriteError: mongodrv.WriteError{Index: 1, Code: 11000, Message: "dup"}},
+		},
+	})
+	lerr, ok = err.(*LastError)
+	c.Assert(ok, Equals, true)
+	c.Assert(lerr.Code, Equals, 11000)
+	c.Assert(IsDup(err), Equals, true)
+
+	c.Assert(modernWriteError(mongodrv.ErrUnacknowledgedWrite), IsNil)
+}
+
+func (s *S) TestModernUpdateError(c *C) {
+	werr := mongodrv.WriteException{
+		WriteErrors: mongodrv.WriteErrors{{Code: 11000, Message: "dup"}},
+	}
+	err := modernUpdateError(&mongodrv.UpdateResult{MatchedCount: 2, ModifiedCount: 1}, werr)
+	lerr, ok := err.(*LastError)
+	c.Assert(ok, Equals, true)
+	c.Assert(lerr.N, Equals, 2)
+	c.Assert(lerr.UpdatedExisting, Equals, true)
+	c.Assert(lerr.modified, Equals, 1)
+	c.Assert(lerr.UpsertedId, IsNil)
+
+	err = modernUpdateError(&mongodrv.UpdateResult{UpsertedCount: 1, UpsertedID: "myid"}, werr)
+	lerr = err.(*LastError)
+	c.Assert(lerr.N, Equals, 1)
+	c.Assert(lerr.UpdatedExisting, Equals, false)
+	c.Assert(lerr.UpsertedId, Equals, "myid")
+
+	c.Assert(modernUpdateError(&mongodrv.UpdateResult{}, nil), IsNil)
+	c.Assert(modernUpdateError(nil, mongodrv.CommandError{Code: 2}).(*QueryError).Code, Equals, 2)
+
+	err = modernDeleteError(&mongodrv.DeleteResult{DeletedCount: 3}, werr)
+	c.Assert(err.(*LastError).N, Equals, 3)
+}
+
+func (s *S) TestModernBulkError(c *C) {
+	err := modernBulkError(mongodrv.BulkWriteException{
+		WriteErrors: []mongodrv.BulkWriteError{
+			{WriteError: mongodrv.WriteError{Index: 3, Code: 11000, Message: "dup3"}},
+			{WriteError: mongodrv.WriteError{Index: 1, Code: 11000, Message: "dup1"}},
+		},
+		WriteConcernError: &mongodrv.WriteConcernError{Code: 64, Message: "wtimeout"},
+	}, 4)
+	berr, ok := err.(*BulkError)
+	c.Assert(ok, Equals, true)
+	c.Assert(IsDup(err), Equals, true)
+	cases := berr.Cases()
+	c.Assert(cases, HasLen, 2)
+	c.Assert(cases[0].Index, Equals, 1)
+	c.Assert(cases[0].Err, ErrorMatches, "dup1")
+	c.Assert(cases[1].Index, Equals, 3)
+	c.Assert(cases[1].Err, ErrorMatches, "dup3")
+
+	// A write concern error alone is reported for every operation.
+	err = modernBulkError(mongodrv.BulkWriteException{
+		WriteConcernError: &mongodrv.WriteConcernError{Code: 64, Message: "wtimeout"},
+	}, 2)
+	cases = err.(*BulkError).Cases()
+	c.Assert(cases, HasLen, 2)
+	for i, ecase := range cases {
+		c.Assert(ecase.Index, Equals, i)
+		c.Assert(ecase.Err.(*LastError).Code, Equals, 64)
+	}
+	c.Assert(IsDup(err), Equals, false)
+
+	c.Assert(modernBulkError(nil, 2), IsNil)
+	c.Assert(modernBulkError(mongodrv.CommandError{Code: 13}, 2).(*QueryError).Code, Equals, 13)
+}
+
+func (s *S) TestModernErrorUpsertedId(c *C) {
+	// Upserted ids are converted back to the mgo types.
+	id := officialBson.M{"a": 1}
+	err := modernUpdateError(&mongodrv.UpdateResult{UpsertedCount: 1, UpsertedID: id}, mongodrv.WriteException{
+		WriteConcernError: &mongodrv.WriteConcernError{Code: 64, Message: "wtimeout"},
+	})
+	upserted, ok := err.(*LastError).UpsertedId.(bson.M)
+	c.Assert(ok, Equals, true)
+	c.Assert(upserted["a"], Equals, 1)
+}
@@ -0,0 +1,610 @@
+// modern_gridfs.go - GridFS operations for modern MongoDB driver compatibility wrapper
+
+package mgo
+
+import (
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	officialBson "go.mongodb.org/mongo-driver/bson"
+)
+
+// Create creates a new GridFS file for writing, as GridFS.Create does (mgo
+// API compatible). Chunks are inserted as soon as they fill up, so that at
+// most one chunk of data is held in memory, and the file document is
+// inserted on Close, which makes the file visible.
+func (gfs *ModernGridFS) Create(filename string) (*ModernGridFile, error) {
+	file := &ModernGridFile{gfs: gfs, mode: gfsWriting, checksum: gfs.checksum, wsum: gfs.checksum.newHash()}
+	file.doc = gfsFile{Id: bson.NewObjectId(), ChunkSize: gfsChunkSize(gfs.chunkSize), Filename: filename}
+	return file, nil
+}
+
+// Open opens the most recent GridFS file with the given filename for
+// reading (mgo API compatible). Chunks are only fetched as they are read.
+func (gfs *ModernGridFS) Open(filename string) (*ModernGridFile, error) {
+	return gfs.OpenRevision(filename, -1)
+}
+
+// OpenRevision opens the given revision of the GridFS file with the given
+// filename for reading, as GridFS.OpenRevision does (mgo API compatible).
+// Revision 0 is the first upload, and -1 the most recent one.
+func (gfs *ModernGridFS) OpenRevision(filename string, revision int) (*ModernGridFile, error) {
+	doc, err := gfsFindRevision(modernCollection{gfs.Files}, filename, revision)
+	if err != nil {
+		return nil, err
+	}
+	return gfs.newReader(doc), nil
+}
+
+// OpenId opens a GridFS file by its ID for reading (mgo API compatible)
+func (gfs *ModernGridFS) OpenId(id interface{}) (*ModernGridFile, error) {
+	return gfs.openFile(gfs.Files.FindId(id))
+}
+
+// openFile opens the first file found by query for reading.
+func (gfs *ModernGridFS) openFile(query *ModernQ) (*ModernGridFile, error) {
+	var doc gfsFile
+	if err := query.One(&doc); err != nil {
+		return nil, err
+	}
+	return gfs.newReader(doc), nil
+}
+
+// newReader returns the file described by doc, open for reading.
+func (gfs *ModernGridFS) newReader(doc gfsFile) *ModernGridFile {
+	file := &ModernGridFile{gfs: gfs, mode: gfsReading, doc: doc}
+	if gfs.verify {
+		checksum, _ := doc.storedChecksum()
+		file.rsum = checksum.newHash()
+	}
+	return file
+}
+
+// Remove removes all GridFS files with the given filename (mgo API compatible)
+func (gfs *ModernGridFS) Remove(filename string) error {
+	ctx, cancel := gfs.Files.opContext(10 * time.Second)
+	defer cancel()
+
+	// Find all files with this filename to get their IDs
+	filter := convertMGOToOfficial(bson.M{"filename": filename})
+	cursor, err := gfs.Files.collection().Find(ctx, filter)
+	if err != nil {
+		return modernError(err)
+	}
+	defer cursor.Close(ctx)
+
+	var fileIds []interface{}
+	for cursor.Next(ctx) {
+		var doc gfsDocId
+		if err := decodeModern(cursor.Current, &doc); err != nil {
+			continue
+		}
+		fileIds = append(fileIds, doc.Id)
+	}
+
+	// Remove the files and chunks
+	for _, id := range fileIds {
+		if err := gfs.RemoveId(id); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RemoveId removes a GridFS file by its ID (mgo API compatible)
+func (gfs *ModernGridFS) RemoveId(id interface{}) error {
+	ctx, cancel := gfs.Files.opContext(10 * time.Second)
+	defer cancel()
+
+	// Remove the file document
+	fileFilter := convertMGOToOfficial(bson.M{"_id": id})
+	_, err := gfs.Files.collection().DeleteOne(ctx, fileFilter)
+	err = modernWriteError(err)
+	if err != nil {
+		return err
+	}
+
+	// Remove the chunks
+	return gfs.removeChunks(id)
+}
+
+// removeChunks removes the chunks of the file with the given ID.
+func (gfs *ModernGridFS) removeChunks(id interface{}) error {
+	ctx, cancel := gfs.Chunks.opContext(30 * time.Second)
+	defer cancel()
+
+	chunkFilter := convertMGOToOfficial(bson.M{"files_id": id})
+	_, err := gfs.Chunks.collection().DeleteMany(ctx, chunkFilter)
+	return modernWriteError(err)
+}
+
+// Find returns a query for finding GridFS files (mgo API compatible)
+func (gfs *ModernGridFS) Find(selector interface{}) *ModernQ {
+	return gfs.Files.Find(selector)
+}
+
+// OpenNext opens the next file from an iterator on the files collection
+// for reading, closing *file first if it's not nil, as GridFS.OpenNext does
+// (mgo API compatible).
+func (gfs *ModernGridFS) OpenNext(iter *ModernIt, file **ModernGridFile) bool {
+	if *file != nil {
+		(*file).Close()
+	}
+
+	var doc gfsFile
+	if !iter.Next(&doc) {
+		*file = nil
+		return false
+	}
+	*file = gfs.newReader(doc)
+	return true
+}
+
+// Rename changes the filename of the GridFS file with the given ID, as
+// GridFS.Rename does (mgo API compatible)
+func (gfs *ModernGridFS) Rename(id interface{}, filename string) error {
+	return gfsRename(modernCollection{gfs.Files}, id, filename)
+}
+
+// Drop removes the files and chunks collections of the GridFS (mgo API
+// compatible)
+func (gfs *ModernGridFS) Drop() error {
+	return gfsDrop(modernCollection{gfs.Files}, modernCollection{gfs.Chunks})
+}
+
+// UploadFromStream creates a GridFS file with the data read from source
+// until EOF, and returns its ID, as GridFS.UploadFromStream does (mgo API
+// compatible)
+func (gfs *ModernGridFS) UploadFromStream(filename string, source io.Reader, opts GridFSUploadOptions) (interface{}, error) {
+	file, err := gfs.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	return gfsUploadFromStream(file, source, opts)
+}
+
+// DownloadToStream writes the data of the GridFS file with the given ID to
+// destination, as GridFS.DownloadToStream does (mgo API compatible)
+func (gfs *ModernGridFS) DownloadToStream(id interface{}, destination io.Writer) (int64, error) {
+	file, err := gfs.OpenId(id)
+	if err != nil {
+		return 0, err
+	}
+	return gfsDownloadToStream(file, destination)
+}
+
+// Fsck checks the consistency of the files and chunks collections of the
+// GridFS, repairing or deleting the damage found as requested in opts, as
+// GridFS.Fsck does (mgo API compatible)
+func (gfs *ModernGridFS) Fsck(opts GridFSFsckOptions) (*GridFSFsckResult, error) {
+	return gfsFsck(modernCollection{gfs.Files}, modernCollection{gfs.Chunks}, gfs.RemoveId, opts)
+}
+
+func (f *ModernGridFile) assertMode(mode gfsFileMode) {
+	switch f.mode {
+	case mode:
+		return
+	case gfsWriting:
+		panic("GridFile is open for writing")
+	case gfsReading:
+		panic("GridFile is open for reading")
+	case gfsClosed:
+		panic("GridFile is closed")
+	default:
+		panic("internal error: missing GridFile mode")
+	}
+}
+
+// Write writes data to the GridFS file, as GridFile.Write does (mgo API
+// compatible). Every chunk filled up is inserted before Write returns, and
+// only the data of the last incomplete one is kept for a future call.
+func (f *ModernGridFile) Write(data []byte) (n int, err error) {
+	f.assertMode(gfsWriting)
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	if f.err != nil {
+		return 0, f.err
+	}
+
+	n = len(data)
+	f.doc.Length += int64(n)
+	chunkSize := f.doc.ChunkSize
+
+	if len(f.wbuf)+len(data) < chunkSize {
+		f.wbuf = append(f.wbuf, data...)
+		return n, nil
+	}
+
+	// First, flush f.wbuf complementing with data.
+	if len(f.wbuf) > 0 {
+		missing := chunkSize - len(f.wbuf)
+		f.wbuf = append(f.wbuf, data[:missing]...)
+		data = data[missing:]
+		f.insertChunk(f.wbuf)
+		f.wbuf = f.wbuf[0:0]
+	}
+
+	// Then, flush all chunks from data without copying.
+	for len(data) > chunkSize && f.err == nil {
+		f.insertChunk(data[:chunkSize])
+		data = data[chunkSize:]
+	}
+
+	// And append the rest for a future call.
+	f.wbuf = append(f.wbuf, data...)
+
+	return n, f.err
+}
+
+// insertChunk inserts data as the next chunk of the file.
+func (f *ModernGridFile) insertChunk(data []byte) {
+	if f.err != nil {
+		return
+	}
+	n := f.chunk
+	f.chunk++
+	if f.wsum != nil {
+		f.wsum.Write(data)
+	}
+
+	doc, err := bson.Marshal(gfsChunk{bson.NewObjectId(), f.doc.Id, n, data})
+	if err != nil {
+		f.err = err
+		return
+	}
+
+	ctx, cancel := f.gfs.Chunks.opContext(30 * time.Second)
+	defer cancel()
+	_, err = f.gfs.Chunks.collection().InsertOne(ctx, officialBson.Raw(doc))
+	f.err = modernWriteError(err)
+}
+
+// Abort cancels an in-progress write, preventing the file from being
+// created and removing the chunks written so far when the file is closed,
+// as GridFile.Abort does (mgo API compatible).
+//
+// It is a runtime error to call Abort when the file was not opened
+// for writing.
+func (f *ModernGridFile) Abort() {
+	if f.mode != gfsWriting {
+		panic("file.Abort must be called on file opened for writing")
+	}
+	f.m.Lock()
+	f.err = errors.New("write aborted")
+	f.m.Unlock()
+}
+
+// Seek sets the offset for the next Read on the file, interpreted
+// according to whence, as GridFile.Seek does (mgo API compatible). The
+// chunk holding the new offset is fetched right away, unless it's the one
+// being read already.
+func (f *ModernGridFile) Seek(offset int64, whence int) (pos int64, err error) {
+	f.assertMode(gfsReading)
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.doc.Length
+	default:
+		panic("unsupported whence value")
+	}
+	if offset < 0 {
+		return f.offset, errors.New("seek before start of file")
+	}
+	if offset > f.doc.Length {
+		return f.offset, errors.New("seek past end of file")
+	}
+	if offset == 0 && f.rsum != nil {
+		// Verify the data read again from the start.
+		f.rsum.Reset()
+		f.rsummed = 0
+	}
+	if offset == f.doc.Length {
+		// Nothing needs to be read at the end, which makes finding the
+		// size of the file with Seek cheap.
+		f.offset = offset
+		return f.offset, nil
+	}
+	chunk := int(offset / int64(f.doc.ChunkSize))
+	if chunk+1 == f.chunk && offset >= f.offset {
+		f.rbuf = f.rbuf[int(offset-f.offset):]
+		f.offset = offset
+		return f.offset, nil
+	}
+	f.offset = offset
+	f.chunk = chunk
+	f.rbuf, err = f.getChunk()
+	if err == nil {
+		f.rbuf = f.rbuf[int(f.offset-int64(chunk)*int64(f.doc.ChunkSize)):]
+	}
+	return f.offset, err
+}
+
+// Read reads the next data of the file into b, as GridFile.Read does (mgo
+// API compatible). Chunks are fetched one at a time as they are reached.
+// At the end of the file, n is zero and err is io.EOF.
+func (f *ModernGridFile) Read(b []byte) (n int, err error) {
+	f.assertMode(gfsReading)
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	if f.offset == f.doc.Length {
+		return 0, f.eof()
+	}
+	for err == nil {
+		i := copy(b, f.rbuf)
+		if f.rsum != nil && f.rsummed == f.offset {
+			f.rsum.Write(b[:i])
+			f.rsummed += int64(i)
+		}
+		n += i
+		f.offset += int64(i)
+		f.rbuf = f.rbuf[i:]
+		if i == len(b) || f.offset == f.doc.Length {
+			break
+		}
+		b = b[i:]
+		f.rbuf, err = f.getChunk()
+	}
+	return n, err
+}
+
+// eof returns io.EOF, or ErrChecksumMismatch if the file is verified and
+// the data read from its start doesn't match its stored checksum.
+func (f *ModernGridFile) eof() error {
+	if f.rsum != nil && f.rsummed == f.doc.Length {
+		if _, sum := f.doc.storedChecksum(); hex.EncodeToString(f.rsum.Sum(nil)) != sum {
+			return ErrChecksumMismatch
+		}
+	}
+	return io.EOF
+}
+
+// getChunk fetches the data of the chunk at f.chunk and moves on to the
+// next one.
+func (f *ModernGridFile) getChunk() ([]byte, error) {
+	data, err := f.readChunk(f.chunk)
+	f.chunk++
+	return data, err
+}
+
+// readChunk fetches the data of chunk n of the file.
+func (f *ModernGridFile) readChunk(n int) ([]byte, error) {
+	ctx, cancel := f.gfs.Chunks.opContext(30 * time.Second)
+	defer cancel()
+
+	filter := convertMGOToOfficial(bson.D{{Name: "files_id", Value: f.doc.Id}, {Name: "n", Value: n}})
+	raw, err := f.gfs.Chunks.collection().FindOne(ctx, filter).Raw()
+	if err != nil {
+		return nil, modernError(err)
+	}
+	var doc gfsChunk
+	if err := decodeModern(raw, &doc); err != nil {
+		return nil, err
+	}
+	return doc.Data, nil
+}
+
+// ReadAt reads len(b) bytes of the file starting at offset off, making
+// ModernGridFile an io.ReaderAt. It doesn't change the offset of Read and
+// Seek, and may be called concurrently with other ReadAt calls. Only the
+// chunks holding the requested data are fetched.
+func (f *ModernGridFile) ReadAt(b []byte, off int64) (n int, err error) {
+	f.assertMode(gfsReading)
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	chunkSize := int64(f.doc.ChunkSize)
+	for n < len(b) && off < f.doc.Length {
+		data, err := f.readChunk(int(off / chunkSize))
+		if err != nil {
+			return n, err
+		}
+		start := off % chunkSize
+		if start >= int64(len(data)) {
+			return n, io.ErrUnexpectedEOF
+		}
+		i := copy(b[n:], data[start:])
+		n += i
+		off += int64(i)
+	}
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Close flushes the data pending to be written and inserts the file
+// document, or removes the chunks written if the write failed or was
+// aborted, as GridFile.Close does (mgo API compatible).
+func (f *ModernGridFile) Close() error {
+	f.m.Lock()
+	defer f.m.Unlock()
+	if f.mode == gfsWriting {
+		if len(f.wbuf) > 0 && f.err == nil {
+			f.insertChunk(f.wbuf)
+			f.wbuf = nil
+		}
+		f.completeWrite()
+	}
+	f.rbuf = nil
+	f.mode = gfsClosed
+	return f.err
+}
+
+func (f *ModernGridFile) completeWrite() {
+	if f.err == nil {
+		f.doc.setChecksum(f.checksum, f.wsum)
+		if f.doc.UploadDate.IsZero() {
+			f.doc.UploadDate = bson.Now()
+		}
+		var doc []byte
+		doc, f.err = bson.Marshal(f.doc)
+		if f.err == nil {
+			ctx, cancel := f.gfs.Files.opContext(30 * time.Second)
+			_, err := f.gfs.Files.collection().InsertOne(ctx, officialBson.Raw(doc))
+			cancel()
+			f.err = modernWriteError(err)
+		}
+	}
+	if f.err != nil {
+		f.gfs.removeChunks(f.doc.Id)
+	}
+	if f.err == nil {
+		f.err = f.gfs.Chunks.EnsureIndex(Index{
+			Key:    []string{"files_id", "n"},
+			Unique: true,
+		})
+	}
+}
+
+// GridFile property getters and setters (mgo API compatible)
+
+// Id returns the file ID
+func (f *ModernGridFile) Id() interface{} {
+	return f.doc.Id
+}
+
+// SetId sets the file ID. It is a runtime error to call this function once
+// the file has started being written to, or when the file is not open for
+// writing.
+func (f *ModernGridFile) SetId(id interface{}) {
+	f.assertMode(gfsWriting)
+	f.m.Lock()
+	f.doc.Id = id
+	f.m.Unlock()
+}
+
+// Name returns the filename
+func (f *ModernGridFile) Name() string {
+	return f.doc.Filename
+}
+
+// SetName sets the filename
+func (f *ModernGridFile) SetName(filename string) {
+	f.assertMode(gfsWriting)
+	f.m.Lock()
+	f.doc.Filename = filename
+	f.m.Unlock()
+}
+
+// ContentType returns the content type
+func (f *ModernGridFile) ContentType() string {
+	return f.doc.ContentType
+}
+
+// SetContentType sets the content type
+func (f *ModernGridFile) SetContentType(contentType string) {
+	f.assertMode(gfsWriting)
+	f.m.Lock()
+	f.doc.ContentType = contentType
+	f.m.Unlock()
+}
+
+// Size returns the file size
+func (f *ModernGridFile) Size() int64 {
+	f.m.Lock()
+	defer f.m.Unlock()
+	return f.doc.Length
+}
+
+// MD5 returns the MD5 hash, empty if the file has another checksum
+func (f *ModernGridFile) MD5() string {
+	return f.doc.MD5
+}
+
+// SHA256 returns the SHA-256 hash, empty if the file has another checksum
+func (f *ModernGridFile) SHA256() string {
+	return f.doc.SHA256
+}
+
+// UploadDate returns the upload date
+func (f *ModernGridFile) UploadDate() time.Time {
+	return f.doc.UploadDate
+}
+
+// SetUploadDate sets the upload date
+func (f *ModernGridFile) SetUploadDate(t time.Time) {
+	f.assertMode(gfsWriting)
+	f.m.Lock()
+	f.doc.UploadDate = t
+	f.m.Unlock()
+}
+
+// GetMeta unmarshals the metadata of the file into result
+func (f *ModernGridFile) GetMeta(result interface{}) (err error) {
+	f.m.Lock()
+	if f.doc.Metadata != nil {
+		err = bson.Unmarshal(f.doc.Metadata.Data, result)
+	}
+	f.m.Unlock()
+	return err
+}
+
+// SetMeta sets the metadata
+func (f *ModernGridFile) SetMeta(metadata interface{}) {
+	f.assertMode(gfsWriting)
+	data, err := bson.Marshal(metadata)
+	f.m.Lock()
+	if err != nil && f.err == nil {
+		f.err = err
+	} else {
+		f.doc.Metadata = &bson.Raw{Data: data}
+	}
+	f.m.Unlock()
+}
+
+// SetChunkSize sets the chunk size. It is a runtime error to call this
+// function once the file has started being written to.
+func (f *ModernGridFile) SetChunkSize(size int) {
+	f.assertMode(gfsWriting)
+	f.m.Lock()
+	f.doc.ChunkSize = size
+	f.m.Unlock()
+}
+
+// HTTPHandler returns an http.Handler serving the files of the GridFS, as
+// GridFS.HTTPHandler does (mgo API compatible)
+func (gfs *ModernGridFS) HTTPHandler(opts GridFSHTTPOptions) http.Handler {
+	return &gfsHandler{
+		opts: opts,
+		openName: func(name string) (gfsReadFile, error) {
+			file, err := gfs.Open(name)
+			if err != nil {
+				return nil, err
+			}
+			return file, nil
+		},
+		openId: func(id interface{}) (gfsReadFile, error) {
+			file, err := gfs.OpenId(id)
+			if err != nil {
+				return nil, err
+			}
+			return file, nil
+		},
+	}
+}
+
+// FS returns the files of the GridFS as an fs.FS, as GridFS.FS does (mgo
+// API compatible)
+func (gfs *ModernGridFS) FS() *GridFSFS {
+	return &GridFSFS{gfs}
+}
+
+func (gfs *ModernGridFS) openFS(doc *gfsFile) gfsReadFile {
+	return gfs.newReader(*doc)
+}
+
+func (gfs *ModernGridFS) listFS(prefix, from string, fn func(doc *gfsFile) bool) error {
+	return gfsListFS(modernCollection{gfs.Files}, prefix, from, fn)
+}
@@ -0,0 +1,120 @@
+package mgo
+
+import (
+	"io"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *S) TestModernGridFileModes(c *C) {
+	gfs := &ModernGridFS{}
+	file, err := gfs.Create("name")
+	c.Assert(err, IsNil)
+	c.Assert(func() { file.Seek(0, io.SeekStart) }, PanicMatches, "GridFile is open for writing")
+	c.Assert(func() { file.ReadAt(nil, 0) }, PanicMatches, "GridFile is open for writing")
+
+	file = &ModernGridFile{gfs: gfs, mode: gfsReading}
+	c.Assert(func() { file.Write(nil) }, PanicMatches, "GridFile is open for reading")
+	c.Assert(func() { file.SetChunkSize(1) }, PanicMatches, "GridFile is open for reading")
+	c.Assert(func() { file.Abort() }, PanicMatches, "file.Abort must be called on file opened for writing")
+	c.Assert(file.Close(), IsNil)
+	c.Assert(func() { file.Read(nil) }, PanicMatches, "GridFile is closed")
+}
+
+func (s *S) TestModernGridFileWriteBuffer(c *C) {
+	file, err := (&ModernGridFS{}).Create("name")
+	c.Assert(err, IsNil)
+	file.SetChunkSize(8)
+
+	// Data is held until a whole chunk is available.
+	n, err := file.Write([]byte("1234567"))
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 7)
+	c.Assert(string(file.wbuf), Equals, "1234567")
+	c.Assert(file.chunk, Equals, 0)
+	c.Assert(file.Size(), Equals, int64(7))
+
+	file.Abort()
+	_, err = file.Write([]byte("8"))
+	c.Assert(err, ErrorMatches, "write aborted")
+}
+
+func (s *S) TestModernGridFileSeekEnd(c *C) {
+	// Seeking to the end or reading past it doesn't need any chunk.
+	file := &ModernGridFile{gfs: &ModernGridFS{}, mode: gfsReading}
+	file.doc.Length = 20
+	file.doc.ChunkSize = 8
+
+	pos, err := file.Seek(0, io.SeekEnd)
+	c.Assert(err, IsNil)
+	c.Assert(pos, Equals, int64(20))
+	n, err := file.Read(make([]byte, 4))
+	c.Assert(n, Equals, 0)
+	c.Assert(err, Equals, io.EOF)
+
+	_, err = file.Seek(1, io.SeekCurrent)
+	c.Assert(err, ErrorMatches, "seek past end of file")
+	_, err = file.Seek(-21, io.SeekEnd)
+	c.Assert(err, ErrorMatches, "seek before start of file")
+
+	n, err = file.ReadAt(make([]byte, 4), 20)
+	c.Assert(n, Equals, 0)
+	c.Assert(err, Equals, io.EOF)
+	_, err = file.ReadAt(make([]byte, 4), -1)
+	c.Assert(err, ErrorMatches, "negative offset")
+}
+
+func (s *S) TestModernGridFSOptions(c *C) {
+	coll := &ModernColl{name: "c"}
+	c.Assert(coll.withSafe(nil) == coll, Equals, true)
+	c.Assert(coll.withSafe(&Safe{W: 2}).safe.W, Equals, 2)
+
+	file, err := (&ModernGridFS{chunkSize: 16}).Create("name")
+	c.Assert(err, IsNil)
+	c.Assert(file.doc.ChunkSize, Equals, 16)
+	file, err = (&ModernGridFS{}).Create("name")
+	c.Assert(err, IsNil)
+	c.Assert(file.doc.ChunkSize, Equals, 255*1024)
+}
+
+func (s *S) TestGridFSRevisionOrder(c *C) {
+	for _, t := range []struct {
+		revision int
+		sort     string
+		skip     int
+	}{
+		{0, "uploadDate", 0},
+		{2, "uploadDate", 2},
+		{-1, "-uploadDate", 0},
+		{-3, "-uploadDate", 2},
+	} {
+		sort, skip := gfsRevisionOrder(t.revision)
+		c.Assert(sort, Equals, t.sort)
+		c.Assert(skip, Equals, t.skip)
+	}
+}
+
+func (s *S) TestModernGridFSVerifyChecksum(c *C) {
+	gfs := &ModernGridFS{verify: true}
+	sum := ChecksumSHA256.newHash()
+	sum.Write([]byte("some data"))
+	var doc gfsFile
+	doc.Length = 9
+	doc.setChecksum(ChecksumSHA256, sum)
+
+	file := gfs.newReader(doc)
+	file.rbuf = []byte("some dat!")
+	data, err := io.ReadAll(file)
+	c.Assert(err, Equals, ErrChecksumMismatch)
+	c.Assert(string(data), Equals, "some dat!")
+
+	file = gfs.newReader(doc)
+	file.rbuf = []byte("some data")
+	data, err = io.ReadAll(file)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "some data")
+
+	file, err = (&ModernGridFS{checksum: ChecksumNone}).Create("name")
+	c.Assert(err, IsNil)
+	c.Assert(file.wsum, IsNil)
+}
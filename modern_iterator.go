@@ -3,8 +3,8 @@
 package mgo
 
 import (
-	"github.com/globalsign/mgo/bson"
-	officialBson "go.mongodb.org/mongo-driver/bson"
+	"reflect"
+	"time"
 )
 
 // Next gets next document from iterator
@@ -15,26 +15,66 @@ func (it *ModernIt) Next(result interface{}) bool {
 
 	if it.cursor == nil {
 		it.err = ErrNotFound
+		it.endSession()
 		return false
 	}
 
+	if it.tail {
+		return it.tailNext(result)
+	}
+
 	if !it.cursor.Next(it.ctx) {
 		// Check if there was an actual error, or just end of cursor
-		it.err = it.cursor.Err()
+		it.err = modernError(it.cursor.Err())
 		// Don't set ErrNotFound here - end of iteration is normal
+		if it.cursor.ID() == 0 {
+			it.endSession()
+		}
 		return false
 	}
+	it.sess.observe()
 
-	var doc officialBson.M
-	err := it.cursor.Decode(&doc)
-	if err != nil {
-		it.err = err
-		return false
+	it.err = decodeModern(it.cursor.Current, result)
+	return it.err == nil
+}
+
+// tailNext is Next for tailable cursors, which waits for a document for
+// the timeout given to ModernQ.Tail.
+func (it *ModernIt) tailNext(result interface{}) bool {
+	it.timedout = false
+	deadline := time.Now().Add(it.timeout)
+	for {
+		if it.cursor.TryNext(it.ctx) {
+			it.sess.observe()
+			it.err = decodeModern(it.cursor.Current, result)
+			return it.err == nil
+		}
+		if it.err = modernError(it.cursor.Err()); it.err != nil {
+			return false
+		}
+		if it.cursor.ID() == 0 {
+			// The cursor is no longer valid, and the query must be restarted.
+			it.endSession()
+			return false
+		}
+		if it.timeout >= 0 && !time.Now().Before(deadline) {
+			it.timedout = true
+			return false
+		}
 	}
+}
 
-	converted := convertOfficialToMGO(doc)
-	it.err = mapStructToInterface(converted, result)
-	return it.err == nil
+// Err returns nil if no errors happened during iteration, or the actual
+// error otherwise (mgo API compatible).
+func (it *ModernIt) Err() error {
+	return it.err
+}
+
+// Timeout returns true if Next returned false due to a timeout of a
+// tailable cursor, in which case Next may be called again to continue the
+// iteration at the previous cursor position (mgo API compatible).
+func (it *ModernIt) Timeout() bool {
+	return it.timedout
 }
 
 // Close closes the iterator
@@ -42,13 +82,22 @@ func (it *ModernIt) Close() error {
 	if it.cursor != nil {
 		err := it.cursor.Close(it.ctx)
 		if err != nil && it.err == nil {
-			it.err = err
+			it.err = modernError(err)
 		}
 	}
+	it.endSession()
 	return it.err
 }
 
-// All gets all documents from iterator
+// endSession ends the driver session the cursor runs in, if it has one of
+// its own, once the cursor is gone.
+func (it *ModernIt) endSession() {
+	it.sess.end()
+	it.sess = nil
+}
+
+// All gets all documents from iterator, decoding them straight into the
+// elements of the slice result points to (mgo API compatible)
 func (it *ModernIt) All(result interface{}) error {
 	if it.err != nil {
 		return it.err
@@ -58,27 +107,67 @@ func (it *ModernIt) All(result interface{}) error {
 		return ErrNotFound
 	}
 
-	// Use Next() in a loop to avoid BSON slice unmarshalling issues
-	var docs []interface{}
+	resultv := reflect.ValueOf(result)
+	if resultv.Kind() != reflect.Ptr {
+		panic("result argument must be a slice address")
+	}
+	slicev := resultv.Elem()
+	if slicev.Kind() == reflect.Interface {
+		slicev = slicev.Elem()
+	}
+	if slicev.Kind() != reflect.Slice {
+		panic("result argument must be a slice address")
+	}
 
-	for {
-		var doc bson.M
-		if !it.Next(&doc) {
-			break
+	slicev = slicev.Slice(0, slicev.Cap())
+	elemt := slicev.Type().Elem()
+	i := 0
+	for ; ; i++ {
+		if slicev.Len() == i {
+			elemp := reflect.New(elemt)
+			if !it.Next(elemp.Interface()) {
+				break
+			}
+			slicev = reflect.Append(slicev, elemp.Elem())
+			slicev = slicev.Slice(0, slicev.Cap())
+		} else {
+			if !it.Next(slicev.Index(i).Addr().Interface()) {
+				break
+			}
 		}
-		if it.err != nil {
-			return it.err
-		}
-		docs = append(docs, doc)
 	}
-
-	// Check for iteration errors (not end-of-cursor)
-	if it.err != nil && it.err != ErrNotFound {
+	if it.err != nil {
 		return it.err
 	}
+	resultv.Elem().Set(slicev.Slice(0, i))
+	return nil
+}
 
-	// Reset error since reaching end of cursor is expected
-	it.err = nil
-
-	return mapStructToInterface(docs, result)
+// For method is obsolete and will be removed in a future release.
+// See Next as an elegant replacement (mgo API compatible).
+func (it *ModernIt) For(result interface{}, f func() error) (err error) {
+	valid := false
+	v := reflect.ValueOf(result)
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+		switch v.Kind() {
+		case reflect.Map, reflect.Ptr, reflect.Interface, reflect.Slice:
+			valid = v.IsNil()
+		}
+	}
+	if !valid {
+		panic("For needs a pointer to nil reference value.  See the documentation.")
+	}
+	zero := reflect.Zero(v.Type())
+	for {
+		v.Set(zero)
+		if !it.Next(result) {
+			break
+		}
+		err = f()
+		if err != nil {
+			return err
+		}
+	}
+	return it.Err()
 }
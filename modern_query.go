@@ -7,6 +7,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/globalsign/mgo/bson"
 	officialBson "go.mongodb.org/mongo-driver/bson"
 	mongodrv "go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -14,36 +15,35 @@ import (
 
 // One finds one document (mgo API compatible)
 func (q *ModernQ) One(result interface{}) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := q.opContext(10 * time.Second)
 	defer cancel()
 
-	findOpts := &options.FindOneOptions{}
-	if q.projection != nil {
-		findOpts.Projection = q.projection
-	}
-	if q.sort != nil {
-		findOpts.Sort = q.sort
+	findOpts := &options.FindOneOptions{
+		Projection: q.projection,
+		Sort:       q.sort,
+		Hint:       q.hint,
+		Collation:  q.collation,
 	}
 	if q.skip > 0 {
 		findOpts.Skip = &q.skip
 	}
+	if q.maxTime > 0 {
+		findOpts.SetMaxTime(q.maxTime)
+	}
+	if q.comment != "" {
+		findOpts.SetComment(q.comment)
+	}
 
-	singleResult := q.coll.mgoColl.FindOne(ctx, q.filter, findOpts)
-	if singleResult.Err() != nil {
-		if singleResult.Err() == mongodrv.ErrNoDocuments {
-			return ErrNotFound
-		}
-		return singleResult.Err()
+	singleResult := q.coll.collection().FindOne(ctx, q.filter, findOpts)
+	if err := singleResult.Err(); err != nil {
+		return modernError(err)
 	}
 
-	var doc officialBson.M
-	err := singleResult.Decode(&doc)
+	raw, err := singleResult.Raw()
 	if err != nil {
 		return err
 	}
-
-	converted := convertOfficialToMGO(doc)
-	return mapStructToInterface(converted, result)
+	return decodeModern(raw, result)
 }
 
 // All finds all documents
@@ -53,33 +53,93 @@ func (q *ModernQ) All(result interface{}) error {
 	return iter.All(result)
 }
 
+// For method is obsolete and will be removed in a future release.
+// See Iter as an elegant replacement (mgo API compatible).
+func (q *ModernQ) For(result interface{}, f func() error) error {
+	iter := q.Iter()
+	defer iter.Close()
+	return iter.For(result, f)
+}
+
 // Count counts query results
 func (q *ModernQ) Count() (int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := q.opContext(10 * time.Second)
 	defer cancel()
 
-	opts := &options.CountOptions{}
+	opts := &options.CountOptions{
+		Hint:      q.hint,
+		Collation: q.collation,
+	}
 	if q.skip > 0 {
 		opts.Skip = &q.skip
 	}
 	if q.limit > 0 {
 		opts.Limit = &q.limit
 	}
+	if q.maxTime > 0 {
+		opts.SetMaxTime(q.maxTime)
+	}
+	if q.comment != "" {
+		opts.SetComment(q.comment)
+	}
 
-	count, err := q.coll.mgoColl.CountDocuments(ctx, q.filter, opts)
-	return int(count), err
+	count, err := q.coll.collection().CountDocuments(ctx, q.filter, opts)
+	return int(count), modernError(err)
 }
 
 // Iter returns an iterator
 func (q *ModernQ) Iter() *ModernIt {
-	ctx := context.Background()
+	ctx, sess := q.coll.cursorContext()
+	cursor, err := q.coll.collection().Find(ctx, q.filter, q.findOptions())
 
-	findOpts := &options.FindOptions{}
-	if q.projection != nil {
-		findOpts.Projection = q.projection
+	return &ModernIt{
+		cursor: cursor,
+		ctx:    ctx,
+		sess:   sess,
+		err:    modernError(err),
 	}
-	if q.sort != nil {
-		findOpts.Sort = q.sort
+}
+
+// Tail returns a tailable iterator, which may wait for new documents to be
+// inserted in the capped collection queried once the end of the current
+// result set is reached (mgo API compatible).
+//
+// As with Query.Tail, Next blocks for up to timeout waiting for a
+// document, or for as long as the cursor is valid if timeout is negative,
+// and returns false on timeouts, with Timeout returning true. Next may be
+// called again then. If the cursor becomes invalid, both Next and Timeout
+// return false and the query must be restarted.
+func (q *ModernQ) Tail(timeout time.Duration) *ModernIt {
+	ctx, sess := q.coll.cursorContext()
+
+	findOpts := q.findOptions()
+	if timeout == 0 {
+		findOpts.SetCursorType(options.Tailable)
+	} else {
+		findOpts.SetCursorType(options.TailableAwait)
+		if timeout > 0 {
+			findOpts.SetMaxAwaitTime(timeout)
+		}
+	}
+	cursor, err := q.coll.collection().Find(ctx, q.filter, findOpts)
+
+	return &ModernIt{
+		cursor:  cursor,
+		ctx:     ctx,
+		sess:    sess,
+		err:     modernError(err),
+		tail:    true,
+		timeout: timeout,
+	}
+}
+
+// findOptions returns the options of the find command run for q.
+func (q *ModernQ) findOptions() *options.FindOptions {
+	findOpts := &options.FindOptions{
+		Projection: q.projection,
+		Sort:       q.sort,
+		Hint:       q.hint,
+		Collation:  q.collation,
 	}
 	if q.skip > 0 {
 		findOpts.Skip = &q.skip
@@ -87,26 +147,60 @@ func (q *ModernQ) Iter() *ModernIt {
 	if q.limit > 0 {
 		findOpts.Limit = &q.limit
 	}
+	if q.batchSize > 0 {
+		findOpts.SetBatchSize(q.batchSize)
+	}
+	if q.maxTime > 0 {
+		findOpts.SetMaxTime(q.maxTime)
+	}
+	if q.comment != "" {
+		findOpts.SetComment(q.comment)
+	}
+	return findOpts
+}
 
-	cursor, err := q.coll.mgoColl.Find(ctx, q.filter, findOpts)
-
-	return &ModernIt{
-		cursor: cursor,
-		ctx:    ctx,
-		err:    err,
+// opContext returns the context to run an operation of q with, which times
+// out after timeout, or a little after the time set with SetMaxTime if
+// that's longer, so that the server reports the query as interrupted.
+func (q *ModernQ) opContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	if q.maxTime >= timeout {
+		timeout = q.maxTime + time.Second
 	}
+	return q.coll.opContext(timeout)
 }
 
-// Sort sets sort order
+// Sort asks the database to order returned documents according to the
+// provided field names, as with Query.Sort (mgo API compatible). A field
+// name may be prefixed by - (minus) for it to be sorted in reverse order,
+// and "$textScore:field" sorts by the text score projected onto field.
 func (q *ModernQ) Sort(fields ...string) *ModernQ {
 	var sort officialBson.D
 	for _, field := range fields {
 		order := 1
-		if strings.HasPrefix(field, "-") {
-			order = -1
-			field = field[1:]
+		var kind string
+		if field != "" {
+			if field[0] == '$' {
+				if c := strings.Index(field, ":"); c > 1 && c < len(field)-1 {
+					kind = field[1:c]
+					field = field[c+1:]
+				}
+			}
+			switch field[0] {
+			case '+':
+				field = field[1:]
+			case '-':
+				order = -1
+				field = field[1:]
+			}
+		}
+		if field == "" {
+			panic("Sort: empty field name")
+		}
+		if kind == "textScore" {
+			sort = append(sort, officialBson.E{Key: field, Value: officialBson.D{{Key: "$meta", Value: kind}}})
+		} else {
+			sort = append(sort, officialBson.E{Key: field, Value: order})
 		}
-		sort = append(sort, officialBson.E{Key: field, Value: order})
 	}
 	q.sort = sort
 	return q
@@ -130,9 +224,188 @@ func (q *ModernQ) Select(selector interface{}) *ModernQ {
 	return q
 }
 
-// Apply applies a change to a single document and returns the old or new document (mgo API compatible)
+// Batch sets the batch size used when fetching documents from the database
+// (mgo API compatible). The default batch size is defined by the database
+// itself.
+func (q *ModernQ) Batch(n int) *ModernQ {
+	if n == 1 {
+		// As with Query.Batch, since servers interpret 1 as a limit.
+		n = 2
+	}
+	q.batchSize = int32(n)
+	return q
+}
+
+// Prefetch is accepted for compatibility with Query.Prefetch, but has no
+// effect: the official driver requests the next batch of results once the
+// current one is exhausted (mgo API compatible).
+func (q *ModernQ) Prefetch(p float64) *ModernQ {
+	return q
+}
+
+// Hint forces the server to use the index with the given key, built as
+// for EnsureIndex (mgo API compatible). As with Query.Hint, it panics if
+// the key is invalid.
+func (q *ModernQ) Hint(indexKey ...string) *ModernQ {
+	keyInfo, err := parseIndexKey(indexKey)
+	if err != nil {
+		panic(err)
+	}
+	q.hint = convertMGOToOfficial(keyInfo.key)
+	return q
+}
+
+// Collation sets language-specific rules for string comparison, such as
+// rules for lettercase and accent marks (mgo API compatible).
+func (q *ModernQ) Collation(collation *Collation) *ModernQ {
+	q.collation = modernCollation(collation)
+	return q
+}
+
+// SetMaxTime constrains the query to stop after running for the specified
+// time (mgo API compatible).
+func (q *ModernQ) SetMaxTime(d time.Duration) *ModernQ {
+	q.maxTime = d
+	return q
+}
+
+// Comment adds a comment to the query to identify it in the database
+// profiler output (mgo API compatible).
+func (q *ModernQ) Comment(comment string) *ModernQ {
+	q.comment = comment
+	return q
+}
+
+// Explain returns a number of details about how the server would execute
+// the query, as reported by the explain command (mgo API compatible).
+func (q *ModernQ) Explain(result interface{}) error {
+	ctx, cancel := q.opContext(10 * time.Second)
+	defer cancel()
+
+	find := officialBson.D{{Key: "find", Value: q.coll.name}, {Key: "filter", Value: q.filter}}
+	if q.projection != nil {
+		find = append(find, officialBson.E{Key: "projection", Value: q.projection})
+	}
+	if q.sort != nil {
+		find = append(find, officialBson.E{Key: "sort", Value: q.sort})
+	}
+	if q.skip > 0 {
+		find = append(find, officialBson.E{Key: "skip", Value: q.skip})
+	}
+	if q.limit > 0 {
+		find = append(find, officialBson.E{Key: "limit", Value: q.limit})
+	}
+	if q.hint != nil {
+		find = append(find, officialBson.E{Key: "hint", Value: q.hint})
+	}
+	if q.collation != nil {
+		find = append(find, officialBson.E{Key: "collation", Value: q.collation.ToDocument()})
+	}
+	if q.maxTime > 0 {
+		find = append(find, officialBson.E{Key: "maxTimeMS", Value: int64(q.maxTime / time.Millisecond)})
+	}
+	if q.comment != "" {
+		find = append(find, officialBson.E{Key: "comment", Value: q.comment})
+	}
+	return q.coll.runRead(ctx, officialBson.D{{Key: "explain", Value: find}}, result)
+}
+
+// Distinct unmarshals into result the list of distinct values for the
+// given key among the documents matched by the query (mgo API compatible).
+//
+// For example:
+//
+//	var result []int
+//	err := collection.Find(bson.M{"gender": "F"}).Distinct("age", &result)
+func (q *ModernQ) Distinct(key string, result interface{}) error {
+	ctx, cancel := q.opContext(10 * time.Second)
+	defer cancel()
+
+	cmd := officialBson.D{{Key: "distinct", Value: q.coll.name}, {Key: "key", Value: key}, {Key: "query", Value: q.filter}}
+	var doc struct{ Values bson.Raw }
+	if err := q.coll.runRead(ctx, cmd, &doc); err != nil {
+		return err
+	}
+	return doc.Values.Unmarshal(result)
+}
+
+// MapReduce executes a map/reduce job for the documents matched by the
+// query, as with Query.MapReduce (mgo API compatible). Results are inlined
+// into result if job.Out is nil, and stored as job.Out states otherwise,
+// in which case result should be nil.
+func (q *ModernQ) MapReduce(job *MapReduce, result interface{}) (*MapReduceInfo, error) {
+	ctx, cancel := q.opContext(30 * time.Second)
+	defer cancel()
+
+	cmd := officialBson.D{{Key: "mapreduce", Value: q.coll.name}}
+	if job.Map != "" {
+		cmd = append(cmd, officialBson.E{Key: "map", Value: job.Map})
+	}
+	if job.Reduce != "" {
+		cmd = append(cmd, officialBson.E{Key: "reduce", Value: job.Reduce})
+	}
+	if job.Finalize != "" {
+		cmd = append(cmd, officialBson.E{Key: "finalize", Value: job.Finalize})
+	}
+	out := fixMROut(job.Out)
+	if out == nil {
+		out = bson.D{{Name: "inline", Value: 1}}
+	}
+	cmd = append(cmd, officialBson.E{Key: "out", Value: convertMGOToOfficial(out)})
+	cmd = append(cmd, officialBson.E{Key: "query", Value: q.filter})
+	if q.sort != nil {
+		cmd = append(cmd, officialBson.E{Key: "sort", Value: q.sort})
+	}
+	if job.Scope != nil {
+		cmd = append(cmd, officialBson.E{Key: "scope", Value: convertMGOToOfficial(job.Scope)})
+	}
+	if q.limit > 0 {
+		cmd = append(cmd, officialBson.E{Key: "limit", Value: q.limit})
+	}
+	if job.Verbose {
+		cmd = append(cmd, officialBson.E{Key: "verbose", Value: true})
+	}
+
+	var doc mapReduceResult
+	var err error
+	if job.Out == nil {
+		err = q.coll.runRead(ctx, cmd, &doc)
+	} else {
+		// Jobs storing their output write, and so run on the primary.
+		var raw officialBson.Raw
+		raw, err = q.coll.ackCollection().Database().RunCommand(ctx, cmd).Raw()
+		if err == nil {
+			err = decodeModern(raw, &doc)
+		}
+	}
+	if err != nil {
+		return nil, modernError(err)
+	}
+	return doc.info(q.coll.mgoColl.Database().Name(), result)
+}
+
+// runRead runs cmd on the database of c with the read preference of its
+// session, decoding the reply into result.
+func (c *ModernColl) runRead(ctx context.Context, cmd officialBson.D, result interface{}) error {
+	cache := c.collections()
+	opts := options.RunCmd()
+	if cache.settings != nil {
+		opts.SetReadPreference(cache.settings.coll.ReadPreference)
+	}
+	raw, err := cache.coll.Database().RunCommand(ctx, cmd, opts).Raw()
+	if err != nil {
+		return modernError(err)
+	}
+	return decodeModern(raw, result)
+}
+
+// Apply applies a change to a single document and returns the old or new
+// document (mgo API compatible). As with Query.Apply, Sort selects which
+// document to act upon when several match, and Select the fields of the
+// document returned. With change.Replace, the document is replaced with
+// change.Update rather than updated.
 func (q *ModernQ) Apply(change Change, result interface{}) (*ChangeInfo, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := q.opContext(10 * time.Second)
 	defer cancel()
 
 	var updateDoc interface{}
@@ -140,24 +413,27 @@ func (q *ModernQ) Apply(change Change, result interface{}) (*ChangeInfo, error)
 	if change.Remove {
 		// For remove operations, use FindOneAndDelete
 		deleteOpts := options.FindOneAndDelete()
+		if q.sort != nil {
+			deleteOpts.SetSort(q.sort)
+		}
+		if q.projection != nil {
+			deleteOpts.SetProjection(q.projection)
+		}
 
-		singleResult := q.coll.mgoColl.FindOneAndDelete(ctx, q.filter, deleteOpts)
+		singleResult := q.coll.ackCollection().FindOneAndDelete(ctx, q.filter, deleteOpts)
 		if singleResult.Err() != nil {
 			if singleResult.Err() == mongodrv.ErrNoDocuments {
 				return &ChangeInfo{}, ErrNotFound
 			}
-			return nil, singleResult.Err()
+			return nil, modernError(singleResult.Err())
 		}
 
 		if result != nil {
-			var doc officialBson.M
-			err := singleResult.Decode(&doc)
+			raw, err := singleResult.Raw()
 			if err != nil {
 				return nil, err
 			}
-			converted := convertOfficialToMGO(doc)
-			err = mapStructToInterface(converted, result)
-			if err != nil {
+			if err := decodeModern(raw, result); err != nil {
 				return nil, err
 			}
 		}
@@ -166,19 +442,45 @@ func (q *ModernQ) Apply(change Change, result interface{}) (*ChangeInfo, error)
 	}
 
 	// For update/upsert operations
-	// Wrap plain documents in $set operator for MongoDB compatibility
-	wrappedUpdate := wrapInSetOperator(change.Update)
-	updateDoc = convertMGOToOfficial(wrappedUpdate)
-	updateOpts := options.FindOneAndUpdate()
-	updateOpts.SetUpsert(change.Upsert)
-
-	if change.ReturnNew {
-		updateOpts.SetReturnDocument(options.After)
+	// Wrap plain documents in $set operator for MongoDB compatibility,
+	// unless they are replacements
+	updateDoc = change.Update
+	if change.Replace {
+		if err := checkReplacement(updateDoc); err != nil {
+			return nil, err
+		}
 	} else {
-		updateOpts.SetReturnDocument(options.Before)
+		updateDoc = q.coll.updateDocument(updateDoc)
+	}
+	returnDoc := options.Before
+	if change.ReturnNew {
+		returnDoc = options.After
 	}
 
-	singleResult := q.coll.mgoColl.FindOneAndUpdate(ctx, q.filter, updateDoc, updateOpts)
+	var singleResult *mongodrv.SingleResult
+	if !hasUpdateOperators(updateDoc) {
+		replaceOpts := options.FindOneAndReplace()
+		replaceOpts.SetUpsert(change.Upsert)
+		replaceOpts.SetReturnDocument(returnDoc)
+		if q.sort != nil {
+			replaceOpts.SetSort(q.sort)
+		}
+		if q.projection != nil {
+			replaceOpts.SetProjection(q.projection)
+		}
+		singleResult = q.coll.ackCollection().FindOneAndReplace(ctx, q.filter, convertMGOToOfficial(updateDoc), replaceOpts)
+	} else {
+		updateOpts := options.FindOneAndUpdate()
+		updateOpts.SetUpsert(change.Upsert)
+		updateOpts.SetReturnDocument(returnDoc)
+		if q.sort != nil {
+			updateOpts.SetSort(q.sort)
+		}
+		if q.projection != nil {
+			updateOpts.SetProjection(q.projection)
+		}
+		singleResult = q.coll.ackCollection().FindOneAndUpdate(ctx, q.filter, convertMGOToOfficial(updateDoc), updateOpts)
+	}
 	if singleResult.Err() != nil {
 		if singleResult.Err() == mongodrv.ErrNoDocuments {
 			if change.Upsert {
@@ -187,18 +489,15 @@ func (q *ModernQ) Apply(change Change, result interface{}) (*ChangeInfo, error)
 			}
 			return &ChangeInfo{}, ErrNotFound
 		}
-		return nil, singleResult.Err()
+		return nil, modernError(singleResult.Err())
 	}
 
 	if result != nil {
-		var doc officialBson.M
-		err := singleResult.Decode(&doc)
+		raw, err := singleResult.Raw()
 		if err != nil {
 			return nil, err
 		}
-		converted := convertOfficialToMGO(doc)
-		err = mapStructToInterface(converted, result)
-		if err != nil {
+		if err := decodeModern(raw, result); err != nil {
 			return nil, err
 		}
 	}
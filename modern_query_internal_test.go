@@ -0,0 +1,55 @@
+package mgo
+
+import (
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	officialBson "go.mongodb.org/mongo-driver/bson"
+	. "gopkg.in/check.v1"
+)
+
+func (s *S) TestModernQueryOptions(c *C) {
+	q := &ModernQ{coll: &ModernColl{name: "c"}}
+	q.Sort("a", "+b", "-c", "$textScore:score").Hint("-x", "y").Batch(1).Prefetch(0.5)
+	q.Skip(2).Limit(3).Select(bson.M{"a": 1}).Comment("hi").SetMaxTime(time.Minute)
+	q.Collation(&Collation{Locale: "en", Strength: 2})
+
+	c.Assert(q.sort, DeepEquals, officialBson.D{
+		{Key: "a", Value: 1},
+		{Key: "b", Value: 1},
+		{Key: "c", Value: -1},
+		{Key: "score", Value: officialBson.D{{Key: "$meta", Value: "textScore"}}},
+	})
+	c.Assert(func() { q.Sort("-") }, PanicMatches, "Sort: empty field name")
+	c.Assert(func() { q.Hint() }, PanicMatches, "invalid index key: no fields provided")
+
+	opts := q.findOptions()
+	c.Assert(opts.Sort, DeepEquals, q.sort)
+	c.Assert(opts.Hint, DeepEquals, officialBson.D{{Key: "x", Value: -1}, {Key: "y", Value: 1}})
+	c.Assert(*opts.BatchSize, Equals, int32(2))
+	c.Assert(*opts.Skip, Equals, int64(2))
+	c.Assert(*opts.Limit, Equals, int64(3))
+	c.Assert(opts.Projection, DeepEquals, officialBson.M{"a": 1})
+	c.Assert(*opts.Comment, Equals, "hi")
+	c.Assert(*opts.MaxTime, Equals, time.Minute)
+	c.Assert(opts.Collation.Locale, Equals, "en")
+	c.Assert(opts.Collation.Strength, Equals, 2)
+	c.Assert(opts.CursorType, IsNil)
+
+	// Operations outlive the time the server has to run them.
+	ctx, cancel := q.opContext(10 * time.Second)
+	defer cancel()
+	deadline, ok := ctx.Deadline()
+	c.Assert(ok, Equals, true)
+	c.Assert(time.Until(deadline) > time.Minute, Equals, true)
+}
+
+func (s *S) TestModernIterFor(c *C) {
+	it := &ModernIt{}
+	var m bson.M
+	c.Assert(func() { it.For(m, func() error { return nil }) }, PanicMatches, "For needs a pointer to nil reference value.  See the documentation.")
+	// Without a cursor, there's nothing to iterate over.
+	c.Assert(it.For(&m, func() error { return nil }), Equals, ErrNotFound)
+	c.Assert(it.Err(), Equals, ErrNotFound)
+	c.Assert(it.Timeout(), Equals, false)
+}
@@ -4,17 +4,27 @@ package mgo
 
 import (
 	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
 	"net/url"
 	"strings"
 	"time"
 
+	"github.com/globalsign/mgo/bson"
 	officialBson "go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	mongodrv "go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
 	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+	"go.mongodb.org/mongo-driver/tag"
 )
 
 // DialModernMGO connects to MongoDB using the official driver but provides mgo API (mgo API compatible)
+// The tls, tlsCAFile, tlsCertificateKeyFile, tlsCertificateKeyFilePassword, tlsInsecure and
+// tlsAllowInvalidHostnames options of mongoURL are handled as in ParseURL.
 func DialModernMGO(mongoURL string) (*ModernMGO, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -22,6 +32,16 @@ func DialModernMGO(mongoURL string) (*ModernMGO, error) {
 	// Disable retryable writes to avoid "Retryable writes are not supported" error
 	clientOptions := options.Client().ApplyURI(mongoURL).SetRetryWrites(false)
 
+	// Build the TLS configuration as ParseURL does, so that the tls* options
+	// mean the same for both drivers.
+	tlsConfig, err := modernTLSConfig(mongoURL)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		clientOptions.SetTLSConfig(tlsConfig)
+	}
+
 	client, err := mongodrv.Connect(ctx, clientOptions)
 	if err != nil {
 		return nil, err
@@ -36,22 +56,253 @@ func DialModernMGO(mongoURL string) (*ModernMGO, error) {
 		}
 	}
 
+	return newModernMGO(client, dbName, newModernSettings(Primary, &Safe{W: 1}, "", nil)), nil
+}
+
+// DialModernWithInfo connects to MongoDB using the official driver as
+// DialWithInfo does with the original one, so that the same DialInfo
+// configures either (mgo API compatible).
+//
+// Every DialInfo field is carried over to the official driver, except for
+// the following, which have no counterpart there:
+//
+//   - PoolTimeout: waiting for a pooled connection is bounded by the
+//     timeout of each operation instead.
+//   - FailFast: server selection fails once Timeout elapses already.
+//
+// As with DialWithInfo, the servers are pinged before returning, so that
+// unreachable servers are reported right away.
+func DialModernWithInfo(info *DialInfo) (*ModernMGO, error) {
+	info = info.Copy()
+	if info.SRVName != "" {
+		if err := info.resolveSRV(); err != nil {
+			return nil, err
+		}
+	}
+
+	clientOptions, err := modernClientOptions(info)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	if info.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, info.Timeout)
+		defer cancel()
+	}
+
+	client, err := mongodrv.Connect(ctx, clientOptions)
+	if err != nil {
+		return nil, err
+	}
+	if err := client.Ping(ctx, clientOptions.ReadPreference); err != nil {
+		client.Disconnect(context.Background())
+		return nil, err
+	}
+
+	dbName := info.Database
+	if dbName == "" {
+		dbName = "test"
+	}
+	settings := newModernSettings(Strong, nil, "", nil).ensureSafe(&info.Safe)
+	if info.ReadPreference != nil {
+		settings = newModernSettings(info.ReadPreference.Mode, settings.safe, settings.rmode, info.ReadPreference.TagSets)
+	}
+	return newModernMGO(client, dbName, settings), nil
+}
+
+// newModernMGO returns an original session on client, which disconnects
+// it when closed.
+func newModernMGO(client *mongodrv.Client, dbName string, settings *modernSettings) *ModernMGO {
 	return &ModernMGO{
-		client: client,
-		dbName: dbName,
-		mode:   Primary,
-		safe: &Safe{
-			W:        1,
-			WTimeout: 0,
-			FSync:    false,
-			J:        false,
-		},
-		isOriginal: true, // Mark as original session
-	}, nil
+		client:      client,
+		dbName:      dbName,
+		settings:    settings,
+		consistency: &modernConsistency{},
+		isOriginal:  true,
+	}
+}
+
+// modernClientOptions maps info onto the official driver client options.
+func modernClientOptions(info *DialInfo) (*options.ClientOptions, error) {
+	opts := options.Client().
+		SetHosts(info.Addrs).
+		SetMaxPoolSize(uint64(info.poolLimit())).
+		SetMinPoolSize(uint64(info.MinPoolSize)).
+		SetRetryWrites(!info.DisableRetryWrites).
+		SetRetryReads(!info.DisableRetryReads)
+
+	if info.Timeout > 0 {
+		opts.SetConnectTimeout(info.Timeout)
+		opts.SetServerSelectionTimeout(info.Timeout)
+	}
+	if timeout := info.readTimeout(); timeout > 0 || info.writeTimeout() > 0 {
+		// The official driver has a single timeout for reads and writes.
+		if info.writeTimeout() > timeout {
+			timeout = info.writeTimeout()
+		}
+		opts.SetSocketTimeout(timeout)
+	}
+	if info.MaxIdleTimeMS > 0 {
+		opts.SetMaxConnIdleTime(time.Duration(info.MaxIdleTimeMS) * time.Millisecond)
+	}
+	if info.ReplicaSetName != "" {
+		opts.SetReplicaSet(info.ReplicaSetName)
+	}
+	if info.Direct {
+		opts.SetDirect(true)
+	}
+	if info.AppName != "" {
+		opts.SetAppName(info.AppName)
+	}
+	if len(info.Compressors) > 0 {
+		opts.SetCompressors(info.Compressors)
+	}
+	if info.ZlibCompressionLevel != nil {
+		opts.SetZlibLevel(*info.ZlibCompressionLevel)
+	}
+
+	// Safe and ReadPreference are applied by the collections, as they
+	// may change later on with SetSafe, SetMode and SelectServers. The
+	// client only needs the read preference for the initial ping.
+	if info.ReadPreference != nil {
+		opts.SetReadPreference(modernReadPref(info.ReadPreference.Mode, info.ReadPreference.TagSets))
+	}
+
+	if cred := modernCredential(info); cred != nil {
+		opts.SetAuth(*cred)
+	}
+
+	dial := dialer{old: info.Dial, new: info.DialServer}
+	if dial.isSet() {
+		// The dial functions establish TLS themselves, if at all.
+		opts.SetDialer(dial)
+	} else if info.TLSConfig != nil {
+		opts.SetTLSConfig(info.TLSConfig)
+	}
+	return opts, nil
+}
+
+// modernCredential returns the credential to authenticate with as set in
+// info, picking its source database as DialWithInfo does, or nil if
+// there's none.
+func modernCredential(info *DialInfo) *options.Credential {
+	if info.Username == "" && info.Mechanism != "MONGODB-X509" {
+		return nil
+	}
+	source := info.Source
+	if source == "" {
+		switch info.Mechanism {
+		case "GSSAPI", "PLAIN", "MONGODB-X509":
+			source = "$external"
+		default:
+			source = info.Database
+			if source == "" {
+				source = "admin"
+			}
+		}
+	}
+	cred := &options.Credential{
+		AuthMechanism: info.Mechanism,
+		AuthSource:    source,
+		Username:      info.Username,
+		Password:      info.Password,
+		PasswordSet:   info.Password != "",
+	}
+	if info.Service != "" || info.ServiceHost != "" {
+		cred.AuthMechanismProperties = map[string]string{}
+		if info.Service != "" {
+			cred.AuthMechanismProperties["SERVICE_NAME"] = info.Service
+		}
+		if info.ServiceHost != "" {
+			cred.AuthMechanismProperties["SERVICE_HOST"] = info.ServiceHost
+		}
+	}
+	return cred
+}
+
+// modernReadPref returns the official driver read preference for mode,
+// restricted to the servers matching one of tagSets, if any. As with the
+// original driver, tags don't apply to the primary, and the session modes
+// read as their closest read preference: Monotonic sessions read from
+// secondaries until their first write (see SetMode), and Eventual ones
+// from any server.
+func modernReadPref(mode Mode, tagSets []bson.D) *readpref.ReadPref {
+	var rpMode readpref.Mode
+	switch mode {
+	case PrimaryPreferred:
+		rpMode = readpref.PrimaryPreferredMode
+	case Secondary:
+		rpMode = readpref.SecondaryMode
+	case SecondaryPreferred, Monotonic:
+		rpMode = readpref.SecondaryPreferredMode
+	case Nearest, Eventual:
+		rpMode = readpref.NearestMode
+	default:
+		return readpref.Primary()
+	}
+	sets := make([]tag.Set, len(tagSets))
+	for i, tags := range tagSets {
+		sets[i] = make(tag.Set, len(tags))
+		for j, t := range tags {
+			// Servers hold tag values as strings.
+			value, ok := t.Value.(string)
+			if !ok {
+				value = fmt.Sprint(t.Value)
+			}
+			sets[i][j] = tag.Tag{Name: t.Name, Value: value}
+		}
+	}
+	rp, err := readpref.New(rpMode, readpref.WithTagSets(sets...))
+	if err != nil {
+		// Only the primary mode rejects tag sets.
+		panic(err)
+	}
+	return rp
+}
+
+// DialContext adapts DialInfo.DialServer and DialInfo.Dial to the dialer
+// interface of the official driver.
+func (dial dialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	tcpaddr, err := resolveAddr(address)
+	if err != nil {
+		return nil, err
+	}
+	if dial.new != nil {
+		return dial.new(&ServerAddr{address, tcpaddr})
+	}
+	return dial.old(tcpaddr)
+}
+
+// modernTLSConfig returns the TLS configuration requested by the options
+// of mongoURL, or nil if there's none.
+func modernTLSConfig(mongoURL string) (*tls.Config, error) {
+	i := strings.Index(mongoURL, "?")
+	if i < 0 {
+		return nil, nil
+	}
+	query, err := url.ParseQuery(mongoURL[i+1:])
+	if err != nil {
+		return nil, err
+	}
+	var opts tlsOptions
+	for key, values := range query {
+		for _, value := range values {
+			if _, err := opts.set(key, value); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return opts.config()
 }
 
 // Close closes the modern MGO session
 func (m *ModernMGO) Close() {
+	m.mu.Lock()
+	m.consistency = nil
+	m.mu.Unlock()
+
 	// Only close the client if this is the original session
 	if m.isOriginal && m.client != nil {
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -60,61 +311,367 @@ func (m *ModernMGO) Close() {
 	}
 }
 
-// Copy creates a copy of the session (mgo API compatible)
+// Copy creates a copy of the session (mgo API compatible). The copy has
+// the same settings, but its own consistency guarantees, as with
+// Session.Copy.
 func (m *ModernMGO) Copy() *ModernMGO {
 	return &ModernMGO{
-		client:     m.client, // Reuse the same client connection
-		dbName:     m.dbName,
-		mode:       m.mode,
-		safe:       m.safe,
-		isOriginal: false, // Mark as copy
+		client:      m.client, // Reuse the same client connection
+		dbName:      m.dbName,
+		settings:    m.currentSettings(),
+		consistency: &modernConsistency{},
+		isOriginal:  false, // Mark as copy
+		strict:      m.strictReplace(),
 	}
 }
 
-// Clone creates a clone of the session (mgo API compatible)
+// Clone creates a clone of the session (mgo API compatible). Unlike with
+// Copy, the clone keeps the consistency guarantees of m, as with
+// Session.Clone.
 func (m *ModernMGO) Clone() *ModernMGO {
-	return m.Copy() // In our implementation, Clone behaves like Copy
+	clone := m.Copy()
+	m.mu.Lock()
+	clone.consistency = m.consistency
+	m.mu.Unlock()
+	return clone
 }
 
-// SetMode sets the session mode for read preference (mgo API compatible)
+// SetMode changes the consistency mode of the session (mgo API compatible).
+//
+// Strong sessions read from the primary. Monotonic sessions read from
+// secondaries while available, and run their operations in causally
+// consistent driver sessions sharing the times observed, so that they see
+// their own writes and never see data going back in time. Eventual sessions read from any
+// server, without guarantees on ordering. Other modes are read
+// preferences, with no further guarantees.
+//
+// If refresh is true, the consistency guarantees are reset as well, so
+// that a Monotonic session no longer waits for what it observed before.
 func (m *ModernMGO) SetMode(mode Mode, refresh bool) {
-	m.mode = mode
-	// Note: refresh parameter is for mgo compatibility but not used in modern driver
+	m.mu.Lock()
+	s := m.settings
+	m.settings = newModernSettings(mode, s.safe, s.rmode, s.tagSets)
+	if refresh {
+		m.consistency = &modernConsistency{}
+	}
+	m.mu.Unlock()
+}
+
+// Refresh resets the consistency guarantees of the session, as with
+// SetMode(m.Mode(), true) (mgo API compatible).
+func (m *ModernMGO) Refresh() {
+	m.SetMode(m.Mode(), true)
 }
 
 // Mode returns the current session mode
 func (m *ModernMGO) Mode() Mode {
-	return m.mode
+	return m.currentSettings().mode
+}
+
+// SelectServers restricts reads to the servers configured with the given
+// tags, as with Session.SelectServers (mgo API compatible). Tags don't
+// apply to the primary, and so neither to Strong sessions.
+func (m *ModernMGO) SelectServers(tags ...bson.D) {
+	m.mu.Lock()
+	s := m.settings
+	m.settings = newModernSettings(s.mode, s.safe, s.rmode, tags)
+	m.mu.Unlock()
+}
+
+// Safe returns the current safety mode of the session, or nil if writes
+// are unacknowledged (mgo API compatible).
+func (m *ModernMGO) Safe() *Safe {
+	s := m.currentSettings()
+	if s.safe == nil {
+		return nil
+	}
+	safe := *s.safe
+	safe.RMode = s.rmode
+	return &safe
+}
+
+// SetSafe changes the safety mode of the session, as with Session.SetSafe
+// (mgo API compatible). A nil safe makes writes unacknowledged. Otherwise
+// writes are made with the write concern described by safe: W or WMode,
+// WTimeout, and J or FSync, which both wait for the journal. RMode sets
+// the read concern of reads.
+func (m *ModernMGO) SetSafe(safe *Safe) {
+	m.mu.Lock()
+	s := m.settings
+	m.settings = newModernSettings(s.mode, nil, s.rmode, s.tagSets).ensureSafe(safe)
+	m.mu.Unlock()
+}
+
+// EnsureSafe compares safe with the safety mode of the session and picks
+// the most conservative choice for each setting, as with
+// Session.EnsureSafe (mgo API compatible).
+func (m *ModernMGO) EnsureSafe(safe *Safe) {
+	m.mu.Lock()
+	m.settings = m.settings.ensureSafe(safe)
+	m.mu.Unlock()
+}
+
+// SetStrictReplace sets whether update documents holding no update
+// operators replace the documents they apply to, as in the original mgo,
+// rather than being wrapped in a $set operator, as with
+// Session.SetStrictReplace (mgo API compatible). It applies to Update,
+// UpdateAll, Upsert, Apply and the updates of bulks.
+func (m *ModernMGO) SetStrictReplace(strict bool) {
+	m.mu.Lock()
+	m.strict = strict
+	m.mu.Unlock()
+}
+
+func (m *ModernMGO) strictReplace() bool {
+	m.mu.Lock()
+	strict := m.strict
+	m.mu.Unlock()
+	return strict
+}
+
+func (m *ModernMGO) currentSettings() *modernSettings {
+	m.mu.Lock()
+	s := m.settings
+	m.mu.Unlock()
+	return s
 }
 
 // getReadPreference converts mgo Mode to official driver ReadPreference
 func (m *ModernMGO) getReadPreference() *readpref.ReadPref {
-	switch m.mode {
-	case Primary:
-		return readpref.Primary()
-	case PrimaryPreferred:
-		return readpref.PrimaryPreferred()
-	case Secondary:
-		return readpref.Secondary()
-	case SecondaryPreferred:
-		return readpref.SecondaryPreferred()
-	case Nearest:
-		return readpref.Nearest()
-	default:
-		return readpref.Primary()
+	return m.currentSettings().coll.ReadPreference
+}
+
+// newModernSettings returns the settings for the given mode, safety mode,
+// read concern level and server tags.
+func newModernSettings(mode Mode, safe *Safe, rmode string, tagSets []bson.D) *modernSettings {
+	s := &modernSettings{mode: mode, safe: safe, rmode: rmode, tagSets: tagSets}
+	s.coll = options.Collection().SetReadPreference(modernReadPref(mode, tagSets))
+	if rmode != "" {
+		s.coll.SetReadConcern(&readconcern.ReadConcern{Level: rmode})
+	}
+	s.ackColl = options.MergeCollectionOptions(s.coll)
+	if safe != nil {
+		s.coll.SetWriteConcern(modernWriteConcern(safe))
+		s.ackColl.SetWriteConcern(modernWriteConcern(safe))
+	} else {
+		s.coll.SetWriteConcern(writeconcern.Unacknowledged())
+	}
+	return s
+}
+
+// ensureSafe returns the settings with safe merged into the safety mode as
+// Session.EnsureSafe does.
+func (s *modernSettings) ensureSafe(safe *Safe) *modernSettings {
+	if safe == nil {
+		return s
+	}
+	rmode := s.rmode
+	switch safe.RMode {
+	case "majority", "local", "linearizable":
+		rmode = safe.RMode
+	}
+
+	var merged Safe
+	if s.safe == nil {
+		merged = Safe{WMode: safe.WMode, WTimeout: safe.WTimeout, FSync: safe.FSync, J: safe.J}
+		if safe.WMode == "" && safe.W > 0 {
+			merged.W = safe.W
+		}
+	} else {
+		merged = *s.safe
+		if merged.W == 0 && merged.WMode == "" {
+			if safe.WMode != "" {
+				merged.WMode = safe.WMode
+			} else if safe.W > 0 {
+				merged.W = safe.W
+			}
+		} else if safe.WMode != "" {
+			merged.W, merged.WMode = 0, safe.WMode
+		} else if merged.WMode == "" && safe.W > merged.W {
+			merged.W = safe.W
+		}
+		if safe.WTimeout > 0 && safe.WTimeout < merged.WTimeout {
+			merged.WTimeout = safe.WTimeout
+		}
+		if safe.FSync {
+			merged.FSync, merged.J = true, false
+		} else if safe.J && !merged.FSync {
+			merged.J = true
+		}
+	}
+	merged.RMode = ""
+	return newModernSettings(s.mode, &merged, rmode, s.tagSets)
+}
+
+// start starts a causally consistent driver session on client for an
+// operation, advanced to the times observed so far.
+func (consistency *modernConsistency) start(client *mongodrv.Client) (*modernOpSession, error) {
+	sess, err := client.StartSession(options.Session().SetCausalConsistency(true))
+	if err != nil {
+		return nil, err
+	}
+	consistency.mu.Lock()
+	defer consistency.mu.Unlock()
+	if consistency.clusterTime != nil {
+		sess.AdvanceClusterTime(consistency.clusterTime)
+	}
+	if consistency.operationTime != nil {
+		sess.AdvanceOperationTime(consistency.operationTime)
+	}
+	return &modernOpSession{consistency: consistency, sess: sess}, nil
+}
+
+// observe advances the times of the consistency of s to those observed
+// through s, if later.
+func (s *modernOpSession) observe() {
+	if s == nil {
+		return
+	}
+	clusterTime, operationTime := s.sess.ClusterTime(), s.sess.OperationTime()
+	consistency := s.consistency
+	consistency.mu.Lock()
+	defer consistency.mu.Unlock()
+	if clusterTime != nil && (consistency.clusterTime == nil || clusterTimeOf(clusterTime).After(clusterTimeOf(consistency.clusterTime))) {
+		consistency.clusterTime = clusterTime
+	}
+	if operationTime != nil && (consistency.operationTime == nil || operationTime.After(*consistency.operationTime)) {
+		consistency.operationTime = operationTime
+	}
+}
+
+// end observes the times of s and ends its driver session.
+func (s *modernOpSession) end() {
+	if s == nil {
+		return
 	}
+	s.observe()
+	s.sess.EndSession(context.Background())
+}
+
+// clusterTimeOf returns the time in the $clusterTime document doc.
+func clusterTimeOf(doc officialBson.Raw) primitive.Timestamp {
+	t, i, _ := doc.Lookup("$clusterTime", "clusterTime").TimestampOK()
+	return primitive.Timestamp{T: t, I: i}
+}
+
+// sessionContext returns the context the operations of m run with, based
+// on ctx, and the driver session they run in, to be ended once they're
+// done, if they have one of their own. Those of Monotonic sessions run in
+// a causally consistent one, unless ctx is bound to a session already, as
+// in RunTransaction.
+func (m *ModernMGO) sessionContext(ctx context.Context) (context.Context, *modernOpSession) {
+	ctx = baseContext(ctx)
+	if m == nil || mongodrv.SessionFromContext(ctx) != nil {
+		return ctx, nil
+	}
+	m.mu.Lock()
+	mode, consistency := m.settings.mode, m.consistency
+	m.mu.Unlock()
+	if mode != Monotonic || consistency == nil {
+		return ctx, nil
+	}
+	sess, err := consistency.start(m.client)
+	if err != nil {
+		// The operation will run in an implicit session instead.
+		return ctx, nil
+	}
+	return mongodrv.NewSessionContext(ctx, sess.sess), sess
+}
+
+// timeoutContext returns ctx timing out after timeout, with a cancel
+// function ending sess as well.
+func timeoutContext(ctx context.Context, sess *modernOpSession, timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	return ctx, func() {
+		cancel()
+		sess.end()
+	}
+}
+
+// opContext returns the context to run an operation of m with, which
+// times out after timeout.
+func (m *ModernMGO) opContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, sess := m.sessionContext(m.ctx)
+	return timeoutContext(ctx, sess, timeout)
+}
+
+// opContext returns the context to run an operation on db with, which
+// times out after timeout.
+func (db *ModernDB) opContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, sess := db.session.sessionContext(db.ctx)
+	return timeoutContext(ctx, sess, timeout)
+}
+
+// opContext returns the context to run an operation on c with, which
+// times out after timeout.
+func (c *ModernColl) opContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, sess := c.session.sessionContext(c.ctx)
+	return timeoutContext(ctx, sess, timeout)
+}
+
+// cursorContext returns the context the cursors of c run with, and the
+// driver session to end along with them, if any.
+func (c *ModernColl) cursorContext() (context.Context, *modernOpSession) {
+	return c.session.sessionContext(c.ctx)
+}
+
+// collection returns the driver collection behind c, with the current
+// settings of the session applied.
+func (c *ModernColl) collection() *mongodrv.Collection {
+	return c.collections().coll
+}
+
+// ackCollection is like collection, but writes are acknowledged even if
+// the session is unsafe. It's used for findAndModify, which always
+// returns a result.
+func (c *ModernColl) ackCollection() *mongodrv.Collection {
+	return c.collections().ackColl
+}
+
+func (c *ModernColl) collections() *modernCollCache {
+	if c.session == nil {
+		return &modernCollCache{coll: c.mgoColl, ackColl: c.mgoColl}
+	}
+	settings := c.session.currentSettings()
+	if cache := c.cache.Load(); cache != nil && cache.settings == settings {
+		return cache
+	}
+	collOpts, ackOpts := settings.coll, settings.ackColl
+	if c.safe != nil {
+		wc := modernWriteConcern(c.safe)
+		collOpts = options.MergeCollectionOptions(collOpts).SetWriteConcern(wc)
+		ackOpts = options.MergeCollectionOptions(ackOpts).SetWriteConcern(wc)
+	}
+	db := c.mgoColl.Database()
+	cache := &modernCollCache{
+		settings: settings,
+		coll:     db.Collection(c.name, collOpts),
+		ackColl:  db.Collection(c.name, ackOpts),
+	}
+	c.cache.Store(cache)
+	return cache
+}
+
+// modernWriteError returns the error of a write made through the driver,
+// translated by modernError, dropping the one reporting that an
+// unacknowledged write wasn't checked, as the original driver does.
+func modernWriteError(err error) error {
+	if err == mongodrv.ErrUnacknowledgedWrite {
+		return nil
+	}
+	return modernError(err)
 }
 
 // Ping tests the connection
 func (m *ModernMGO) Ping() error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := m.opContext(10 * time.Second)
 	defer cancel()
-	return m.client.Ping(ctx, readpref.Primary())
+	return modernError(m.client.Ping(ctx, readpref.Primary()))
 }
 
 // BuildInfo gets server build information (mgo API compatible)
 func (m *ModernMGO) BuildInfo() (BuildInfo, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := m.opContext(10 * time.Second)
 	defer cancel()
 
 	db := m.client.Database("admin")
@@ -132,7 +689,7 @@ func (m *ModernMGO) BuildInfo() (BuildInfo, error) {
 
 	err := db.RunCommand(ctx, officialBson.M{"buildInfo": 1}).Decode(&result)
 	if err != nil {
-		return BuildInfo{}, err
+		return BuildInfo{}, modernError(err)
 	}
 
 	return BuildInfo{
@@ -153,8 +710,10 @@ func (m *ModernMGO) DB(name string) *ModernDB {
 		name = m.dbName
 	}
 	return &ModernDB{
-		mgoDB: m.client.Database(name),
-		name:  name,
+		mgoDB:   m.client.Database(name),
+		name:    name,
+		ctx:     m.ctx,
+		session: m,
 	}
 }
 
@@ -163,25 +722,55 @@ func (db *ModernDB) C(name string) *ModernColl {
 	return &ModernColl{
 		mgoColl: db.mgoDB.Collection(name),
 		name:    name,
+		ctx:     db.ctx,
+		session: db.session,
+	}
+}
+
+// withSafe returns a copy of c whose writes use the safety mode safe
+// rather than the one of the session, or c itself if safe is nil.
+func (c *ModernColl) withSafe(safe *Safe) *ModernColl {
+	if safe == nil {
+		return c
+	}
+	return &ModernColl{
+		mgoColl: c.mgoColl,
+		name:    c.name,
+		ctx:     c.ctx,
+		session: c.session,
+		safe:    safe,
 	}
 }
 
 // GridFS returns a GridFS handle (mgo API compatible)
 func (db *ModernDB) GridFS(prefix string) *ModernGridFS {
+	return db.GridFSWithOptions(prefix, GridFSOptions{})
+}
+
+// GridFSWithOptions is like GridFS, but the GridFS uses the chunk size and
+// safety mode in opts (mgo API compatible)
+func (db *ModernDB) GridFSWithOptions(prefix string, opts GridFSOptions) *ModernGridFS {
 	return &ModernGridFS{
-		Files:  db.C(prefix + ".files"),
-		Chunks: db.C(prefix + ".chunks"),
-		prefix: prefix,
+		Files:     db.C(prefix + ".files").withSafe(opts.Safe),
+		Chunks:    db.C(prefix + ".chunks").withSafe(opts.Safe),
+		prefix:    prefix,
+		chunkSize: gfsChunkSize(opts.ChunkSize),
+		checksum:  opts.Checksum,
+		verify:    opts.VerifyChecksum,
 	}
 }
 
 // Run executes a database command (mgo API compatible)
 func (db *ModernDB) Run(cmd interface{}, result interface{}) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := db.opContext(30 * time.Second)
 	defer cancel()
 
 	command := convertMGOToOfficial(cmd)
-	return db.mgoDB.RunCommand(ctx, command).Decode(result)
+	raw, err := db.mgoDB.RunCommand(ctx, command).Raw()
+	if err != nil {
+		return modernError(err)
+	}
+	return decodeModern(raw, result)
 }
 
 // Run executes a database command (mgo API compatible with 3-parameter interface)
@@ -0,0 +1,343 @@
+package mgo
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	officialBson "go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	mongodrv "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/tag"
+	. "gopkg.in/check.v1"
+)
+
+func (s *S) TestModernClientOptions(c *C) {
+	zlibLevel := 0
+	info := &DialInfo{
+		Addrs:          []string{"a:27017", "b:27018"},
+		Timeout:        5 * time.Second,
+		ReadTimeout:    time.Second,
+		WriteTimeout:   2 * time.Second,
+		ReplicaSetName: "rs0",
+		AppName:        "app",
+		PoolLimit:      10,
+		MinPoolSize:    2,
+		MaxIdleTimeMS:  1500,
+		Compressors:    []string{"zlib"},
+		Safe:           Safe{WMode: "majority", RMode: "majority"},
+
+		ZlibCompressionLevel: &zlibLevel,
+		DisableRetryWrites:   true,
+		TLSConfig:            &tls.Config{},
+	}
+	opts, err := modernClientOptions(info)
+	c.Assert(err, IsNil)
+	c.Assert(opts.Hosts, DeepEquals, []string{"a:27017", "b:27018"})
+	c.Assert(*opts.ConnectTimeout, Equals, 5*time.Second)
+	c.Assert(*opts.ServerSelectionTimeout, Equals, 5*time.Second)
+	c.Assert(*opts.SocketTimeout, Equals, 2*time.Second)
+	c.Assert(*opts.ReplicaSet, Equals, "rs0")
+	c.Assert(*opts.AppName, Equals, "app")
+	c.Assert(*opts.MaxPoolSize, Equals, uint64(10))
+	c.Assert(*opts.MinPoolSize, Equals, uint64(2))
+	c.Assert(*opts.MaxConnIdleTime, Equals, 1500*time.Millisecond)
+	c.Assert(opts.Compressors, DeepEquals, []string{"zlib"})
+	c.Assert(*opts.ZlibLevel, Equals, 0)
+	c.Assert(*opts.RetryWrites, Equals, false)
+	c.Assert(*opts.RetryReads, Equals, true)
+	// Safe and ReadPreference are left to the collections.
+	c.Assert(opts.WriteConcern, IsNil)
+	c.Assert(opts.ReadConcern, IsNil)
+	c.Assert(opts.ReadPreference, IsNil)
+	c.Assert(opts.TLSConfig, Equals, info.TLSConfig)
+	c.Assert(opts.Direct, IsNil)
+	c.Assert(opts.Auth, IsNil)
+	c.Assert(opts.Dialer, IsNil)
+
+	// Defaults follow DialWithInfo.
+	opts, err = modernClientOptions(&DialInfo{Addrs: []string{"a"}, Direct: true})
+	c.Assert(err, IsNil)
+	c.Assert(*opts.MaxPoolSize, Equals, uint64(DefaultConnectionPoolLimit))
+	c.Assert(*opts.Direct, Equals, true)
+	c.Assert(opts.ConnectTimeout, IsNil)
+	c.Assert(opts.SocketTimeout, IsNil)
+	c.Assert(opts.ZlibLevel, IsNil)
+}
+
+func (s *S) TestModernClientOptionsDialServer(c *C) {
+	var dialed *ServerAddr
+	info := &DialInfo{
+		Addrs:     []string{"127.0.0.1:27017"},
+		TLSConfig: &tls.Config{},
+		DialServer: func(addr *ServerAddr) (net.Conn, error) {
+			dialed = addr
+			return nil, errors.New("dial failed")
+		},
+	}
+	opts, err := modernClientOptions(info)
+	c.Assert(err, IsNil)
+	c.Assert(opts.TLSConfig, IsNil)
+	_, err = opts.Dialer.DialContext(context.Background(), "tcp", "127.0.0.1:27017")
+	c.Assert(err, ErrorMatches, "dial failed")
+	c.Assert(dialed.String(), Equals, "127.0.0.1:27017")
+	c.Assert(dialed.TCPAddr().Port, Equals, 27017)
+
+	var dialedOld net.Addr
+	info = &DialInfo{Dial: func(addr net.Addr) (net.Conn, error) {
+		dialedOld = addr
+		return nil, errors.New("old dial failed")
+	}}
+	opts, err = modernClientOptions(info)
+	c.Assert(err, IsNil)
+	_, err = opts.Dialer.DialContext(context.Background(), "tcp", "127.0.0.1:27018")
+	c.Assert(err, ErrorMatches, "old dial failed")
+	c.Assert(dialedOld.String(), Equals, "127.0.0.1:27018")
+}
+
+func (s *S) TestModernCredential(c *C) {
+	c.Assert(modernCredential(&DialInfo{}), IsNil)
+
+	cred := modernCredential(&DialInfo{Username: "u", Password: "p", Database: "db"})
+	c.Assert(cred.Username, Equals, "u")
+	c.Assert(cred.Password, Equals, "p")
+	c.Assert(cred.PasswordSet, Equals, true)
+	c.Assert(cred.AuthSource, Equals, "db")
+	c.Assert(cred.AuthMechanismProperties, IsNil)
+
+	cred = modernCredential(&DialInfo{Username: "u", Mechanism: "SCRAM-SHA-256"})
+	c.Assert(cred.AuthSource, Equals, "admin")
+	c.Assert(cred.AuthMechanism, Equals, "SCRAM-SHA-256")
+	c.Assert(cred.PasswordSet, Equals, false)
+
+	cred = modernCredential(&DialInfo{Mechanism: "MONGODB-X509", Database: "db"})
+	c.Assert(cred.AuthSource, Equals, "$external")
+
+	cred = modernCredential(&DialInfo{Username: "u", Mechanism: "GSSAPI", Service: "svc", ServiceHost: "host"})
+	c.Assert(cred.AuthSource, Equals, "$external")
+	c.Assert(cred.AuthMechanismProperties, DeepEquals, map[string]string{"SERVICE_NAME": "svc", "SERVICE_HOST": "host"})
+
+	cred = modernCredential(&DialInfo{Username: "u", Mechanism: "PLAIN", Source: "ldap"})
+	c.Assert(cred.AuthSource, Equals, "ldap")
+}
+
+func (s *S) TestModernReadPref(c *C) {
+	modes := map[Mode]readpref.Mode{
+		Primary:            readpref.PrimaryMode,
+		PrimaryPreferred:   readpref.PrimaryPreferredMode,
+		Secondary:          readpref.SecondaryMode,
+		SecondaryPreferred: readpref.SecondaryPreferredMode,
+		Nearest:            readpref.NearestMode,
+		Monotonic:          readpref.SecondaryPreferredMode,
+		Eventual:           readpref.NearestMode,
+	}
+	for mode, want := range modes {
+		rp := modernReadPref(mode, nil)
+		c.Assert(rp.Mode(), Equals, want, Commentf("mode: %d", mode))
+	}
+	c.Assert(modernReadPref(Strong, nil).Mode(), Equals, readpref.PrimaryMode)
+	c.Assert(modernReadPref(Mode(42), nil).Mode(), Equals, readpref.PrimaryMode)
+
+	rp := modernReadPref(Secondary, []bson.D{{{Name: "dc", Value: "ny"}, {Name: "rack", Value: 1}}, {}})
+	c.Assert(rp.TagSets(), DeepEquals, []tag.Set{{{Name: "dc", Value: "ny"}, {Name: "rack", Value: "1"}}, {}})
+
+	// Tags don't apply to the primary.
+	rp = modernReadPref(Primary, []bson.D{{{Name: "dc", Value: "ny"}}})
+	c.Assert(rp.Mode(), Equals, readpref.PrimaryMode)
+	c.Assert(rp.TagSets(), HasLen, 0)
+
+	opts, err := modernClientOptions(&DialInfo{ReadPreference: &ReadPreference{Mode: Nearest, TagSets: []bson.D{{{Name: "dc", Value: "ny"}}}}})
+	c.Assert(err, IsNil)
+	c.Assert(opts.ReadPreference.Mode(), Equals, readpref.NearestMode)
+	c.Assert(opts.ReadPreference.TagSets(), HasLen, 1)
+}
+
+func (s *S) TestModernEnsureSafe(c *C) {
+	tests := []struct {
+		safe, ensure, want *Safe
+	}{
+		{nil, nil, nil},
+		{nil, &Safe{W: 2, WTimeout: 100, J: true}, &Safe{W: 2, WTimeout: 100, J: true}},
+		{&Safe{}, &Safe{W: 2}, &Safe{W: 2}},
+		{&Safe{W: 2}, &Safe{W: 1}, &Safe{W: 2}},
+		{&Safe{W: 2}, &Safe{W: 3}, &Safe{W: 3}},
+		{&Safe{W: 2}, &Safe{WMode: "majority"}, &Safe{WMode: "majority"}},
+		{&Safe{WMode: "majority"}, &Safe{W: 5}, &Safe{WMode: "majority"}},
+		{&Safe{WTimeout: 200}, &Safe{WTimeout: 100}, &Safe{WTimeout: 100}},
+		{&Safe{WTimeout: 100}, &Safe{WTimeout: 200}, &Safe{WTimeout: 100}},
+		{&Safe{J: true}, &Safe{FSync: true}, &Safe{FSync: true}},
+		{&Safe{FSync: true}, &Safe{J: true}, &Safe{FSync: true}},
+		{&Safe{}, &Safe{RMode: "majority"}, &Safe{RMode: "majority"}},
+		{&Safe{RMode: "majority"}, &Safe{RMode: "bogus"}, &Safe{RMode: "majority"}},
+	}
+	for i, test := range tests {
+		m := &ModernMGO{settings: newModernSettings(Strong, nil, "", nil)}
+		m.SetSafe(test.safe)
+		m.EnsureSafe(test.ensure)
+		c.Assert(m.Safe(), DeepEquals, test.want, Commentf("test %d", i))
+	}
+}
+
+func (s *S) TestModernSetSafe(c *C) {
+	client, err := mongodrv.Connect(context.Background(), options.Client().ApplyURI("mongodb://localhost:1"))
+	c.Assert(err, IsNil)
+	defer client.Disconnect(context.Background())
+
+	m := newModernMGO(client, "test", newModernSettings(Strong, &Safe{}, "", nil))
+	coll := m.DB("").C("c")
+	cache := coll.collections()
+	c.Assert(coll.collections() == cache, Equals, true)
+	c.Assert(cache.settings.coll.WriteConcern.Acknowledged(), Equals, true)
+
+	m.SetSafe(&Safe{WMode: "majority", WTimeout: 500, J: true, RMode: "majority"})
+	c.Assert(coll.collections() == cache, Equals, false)
+	wc := coll.collections().settings.coll.WriteConcern
+	c.Assert(wc.W, Equals, "majority")
+	c.Assert(wc.WTimeout, Equals, 500*time.Millisecond)
+	c.Assert(*wc.Journal, Equals, true)
+	c.Assert(coll.collections().settings.coll.ReadConcern.Level, Equals, "majority")
+
+	// Unsafe sessions don't wait for writes, except for findAndModify.
+	m.SetSafe(nil)
+	c.Assert(m.Safe(), IsNil)
+	c.Assert(coll.collections().settings.coll.WriteConcern.Acknowledged(), Equals, false)
+	c.Assert(coll.collections().settings.ackColl.WriteConcern, IsNil)
+	c.Assert(coll.collections().settings.coll.ReadConcern.Level, Equals, "majority")
+
+	c.Assert(modernWriteError(mongodrv.ErrUnacknowledgedWrite), IsNil)
+	c.Assert(modernWriteError(errors.New("fail")), ErrorMatches, "fail")
+}
+
+func (s *S) TestModernSetMode(c *C) {
+	client, err := mongodrv.Connect(context.Background(), options.Client().ApplyURI("mongodb://localhost:1"))
+	c.Assert(err, IsNil)
+	defer client.Disconnect(context.Background())
+
+	m := newModernMGO(client, "test", newModernSettings(Strong, &Safe{}, "", nil))
+	coll := m.DB("").C("c")
+	c.Assert(coll.collections().settings.coll.ReadPreference.Mode(), Equals, readpref.PrimaryMode)
+	ctx, cancel := coll.opContext(time.Second)
+	c.Assert(mongodrv.SessionFromContext(ctx), IsNil)
+	cancel()
+
+	m.SetMode(Eventual, false)
+	m.SelectServers(bson.D{{Name: "dc", Value: "ny"}})
+	c.Assert(m.Mode(), Equals, Eventual)
+	rp := coll.collections().settings.coll.ReadPreference
+	c.Assert(rp.Mode(), Equals, readpref.NearestMode)
+	c.Assert(rp.TagSets(), DeepEquals, []tag.Set{{{Name: "dc", Value: "ny"}}})
+
+	// Monotonic sessions run each operation in a causally consistent
+	// session of its own, advanced to the times observed by the session
+	// and its clones, but not by its copies.
+	m.SetMode(Monotonic, false)
+	c.Assert(coll.collections().settings.coll.ReadPreference.Mode(), Equals, readpref.SecondaryPreferredMode)
+	ctx, cancel = coll.opContext(time.Second)
+	sess := mongodrv.SessionFromContext(ctx)
+	c.Assert(sess == nil, Equals, false)
+	opTime := &primitive.Timestamp{T: 42, I: 1}
+	c.Assert(sess.AdvanceOperationTime(opTime), IsNil)
+	c.Assert(sess.AdvanceClusterTime(modernClusterTime(42)), IsNil)
+	cancel()
+
+	clone, copied := m.Clone(), m.Copy()
+	ctx, opSess := clone.sessionContext(nil)
+	c.Assert(mongodrv.SessionFromContext(ctx) == sess, Equals, false)
+	c.Assert(opSess.sess.OperationTime(), DeepEquals, opTime)
+	c.Assert(clusterTimeOf(opSess.sess.ClusterTime()), Equals, primitive.Timestamp{T: 42})
+	opSess.end()
+	_, opSess = copied.sessionContext(nil)
+	c.Assert(opSess.sess.OperationTime(), IsNil)
+	opSess.end()
+	clone.Close()
+	copied.Close()
+
+	m.Refresh()
+	c.Assert(m.Mode(), Equals, Monotonic)
+	_, opSess = m.sessionContext(nil)
+	c.Assert(opSess.sess.OperationTime(), IsNil)
+	opSess.end()
+}
+
+// modernClusterTime returns a $clusterTime document holding the time t.
+func modernClusterTime(t uint32) officialBson.Raw {
+	doc, err := officialBson.Marshal(officialBson.D{{Key: "$clusterTime", Value: officialBson.D{
+		{Key: "clusterTime", Value: primitive.Timestamp{T: t}},
+	}}})
+	if err != nil {
+		panic(err)
+	}
+	return doc
+}
+
+func (s *S) TestModernMonotonicConcurrent(c *C) {
+	client, err := mongodrv.Connect(context.Background(), options.Client().ApplyURI("mongodb://localhost:1"))
+	c.Assert(err, IsNil)
+	defer client.Disconnect(context.Background())
+
+	m := newModernMGO(client, "test", newModernSettings(Monotonic, &Safe{}, "", nil))
+
+	// Clones run operations concurrently, each in a driver session of its
+	// own, and the latest times observed by any of them are kept.
+	var wg sync.WaitGroup
+	for i := 1; i <= 20; i++ {
+		wg.Add(1)
+		go func(t uint32) {
+			defer wg.Done()
+			clone := m.Clone()
+			defer clone.Close()
+			for j := uint32(0); j < 10; j++ {
+				ctx, cancel := clone.DB("").C("c").opContext(time.Second)
+				sess := mongodrv.SessionFromContext(ctx)
+				sess.AdvanceOperationTime(&primitive.Timestamp{T: t, I: j})
+				sess.AdvanceClusterTime(modernClusterTime(t))
+				cancel()
+			}
+		}(uint32(i))
+	}
+	wg.Wait()
+
+	_, opSess := m.sessionContext(nil)
+	defer opSess.end()
+	c.Assert(opSess.sess.OperationTime(), DeepEquals, &primitive.Timestamp{T: 20, I: 9})
+	c.Assert(clusterTimeOf(opSess.sess.ClusterTime()), Equals, primitive.Timestamp{T: 20})
+}
+
+func (s *S) TestModernStrictReplace(c *C) {
+	client, err := mongodrv.Connect(context.Background(), options.Client().ApplyURI("mongodb://localhost:1"))
+	c.Assert(err, IsNil)
+	defer client.Disconnect(context.Background())
+
+	m := newModernMGO(client, "test", newModernSettings(Strong, &Safe{}, "", nil))
+	coll := m.DB("").C("c")
+	c.Assert(coll.updateDocument(bson.M{"n": 1}), DeepEquals, bson.M{"$set": bson.M{"n": 1}})
+
+	bulk := coll.Bulk()
+	bulk.Update(bson.M{"_id": 1}, bson.M{"n": 1})
+	bulk.Upsert(bson.M{"_id": 2}, bson.M{"n": 2})
+	_, ok := bulk.operations[0].(*mongodrv.UpdateOneModel)
+	c.Assert(ok, Equals, true)
+	_, ok = bulk.operations[1].(*mongodrv.UpdateOneModel)
+	c.Assert(ok, Equals, true)
+
+	m.SetStrictReplace(true)
+	c.Assert(coll.updateDocument(bson.M{"n": 1}), DeepEquals, bson.M{"n": 1})
+	c.Assert(m.Copy().strictReplace(), Equals, true)
+
+	bulk = coll.Bulk()
+	bulk.Update(bson.M{"_id": 1}, bson.M{"n": 1})
+	bulk.Upsert(bson.M{"_id": 2}, bson.M{"n": 2})
+	bulk.Update(bson.M{"_id": 3}, bson.M{"$inc": bson.M{"n": 1}})
+	_, ok = bulk.operations[0].(*mongodrv.ReplaceOneModel)
+	c.Assert(ok, Equals, true)
+	_, ok = bulk.operations[1].(*mongodrv.ReplaceOneModel)
+	c.Assert(ok, Equals, true)
+	_, ok = bulk.operations[2].(*mongodrv.UpdateOneModel)
+	c.Assert(ok, Equals, true)
+}
@@ -1,7 +1,17 @@
 package mgo_test
 
 import (
+	"errors"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"testing/fstest"
 	"time"
 
 	"github.com/globalsign/mgo"
@@ -1022,3 +1032,954 @@ func TestModernOrQueryDetailedReproduction(t *testing.T) {
 		t.Log("✓ Cleanup successful")
 	}
 }
+
+// TestModernRunTransaction tests RunTransaction against MongoDB 6.0 (localhost:27018)
+func TestModernRunTransaction(t *testing.T) {
+	session, err := mgo.DialModernMGO("mongodb://localhost:27018/test")
+	if err != nil {
+		t.Skipf("Skipping transaction tests due to connection failure: %v", err)
+	}
+	defer session.Close()
+	if err := session.Ping(); err != nil {
+		t.Skipf("Skipping transaction tests due to connection failure: %v", err)
+	}
+	var isMaster struct {
+		SetName string `bson:"setName"`
+	}
+	if err := session.Run(true, bson.M{"isMaster": 1}, &isMaster); err != nil {
+		t.Fatalf("isMaster failed: %v", err)
+	}
+	if isMaster.SetName == "" {
+		t.Skip("Skipping transaction tests: transactions need a replica set")
+	}
+
+	coll := session.DB("test").C("modern_txn_test")
+	coll.DropCollection()
+	defer coll.DropCollection()
+	if err := coll.Insert(bson.M{"_id": "from", "balance": 100}, bson.M{"_id": "to", "balance": 0}); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+
+	opts := mgo.TxnOptions{ReadConcern: "snapshot", WriteConcern: &mgo.Safe{WMode: "majority"}}
+	err = session.RunTransaction(func(tx *mgo.ModernMGO) error {
+		c := tx.DB("test").C("modern_txn_test")
+		if err := c.UpdateId("from", bson.M{"$inc": bson.M{"balance": -30}}); err != nil {
+			return err
+		}
+		if err := c.UpdateId("to", bson.M{"$inc": bson.M{"balance": 30}}); err != nil {
+			return err
+		}
+		// Not visible outside of the transaction before the commit.
+		var outside struct{ Balance int }
+		if err := coll.FindId("to").One(&outside); err != nil {
+			return err
+		}
+		if outside.Balance != 0 {
+			t.Errorf("Uncommitted balance visible outside of the transaction: %d", outside.Balance)
+		}
+		var inside struct{ Balance int }
+		if err := c.FindId("to").One(&inside); err != nil {
+			return err
+		}
+		if inside.Balance != 30 {
+			t.Errorf("Expected balance 30 inside the transaction, got %d", inside.Balance)
+		}
+		return nil
+	}, opts)
+	if err != nil {
+		t.Fatalf("RunTransaction failed: %v", err)
+	}
+
+	var result struct{ Balance int }
+	if err := coll.FindId("to").One(&result); err != nil {
+		t.Fatalf("FindId failed: %v", err)
+	}
+	if result.Balance != 30 {
+		t.Errorf("Expected balance 30 after commit, got %d", result.Balance)
+	}
+
+	// Errors abort the transaction and are returned as they are.
+	err = session.RunTransaction(func(tx *mgo.ModernMGO) error {
+		if err := tx.DB("test").C("modern_txn_test").UpdateId("to", bson.M{"$inc": bson.M{"balance": 1}}); err != nil {
+			return err
+		}
+		return mgo.ErrNotFound
+	}, mgo.TxnOptions{})
+	if err != mgo.ErrNotFound {
+		t.Fatalf("Expected ErrNotFound, got %v", err)
+	}
+	if err := coll.FindId("to").One(&result); err != nil {
+		t.Fatalf("FindId failed: %v", err)
+	}
+	if result.Balance != 30 {
+		t.Errorf("Expected balance 30 after abort, got %d", result.Balance)
+	}
+}
+
+// TestModernMonotonicConcurrent tests clones of a Monotonic session used
+// from several goroutines at once, each reading its own writes
+func TestModernMonotonicConcurrent(t *testing.T) {
+	session, err := mgo.DialModernMGO("mongodb://localhost:27018/test")
+	if err != nil {
+		t.Skipf("Skipping Monotonic tests due to connection failure: %v", err)
+	}
+	defer session.Close()
+	if err := session.Ping(); err != nil {
+		t.Skipf("Skipping Monotonic tests due to connection failure: %v", err)
+	}
+	session.SetMode(mgo.Monotonic, true)
+
+	coll := session.DB("test").C("modern_monotonic_test")
+	coll.DropCollection()
+	defer coll.DropCollection()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			clone := session.Clone()
+			defer clone.Close()
+			c := clone.DB("test").C("modern_monotonic_test")
+			for j := 0; j < 10; j++ {
+				id := i*100 + j
+				if err := c.Insert(bson.M{"_id": id}); err != nil {
+					t.Errorf("Insert failed: %v", err)
+					return
+				}
+				var doc bson.M
+				if err := c.FindId(id).One(&doc); err != nil {
+					t.Errorf("FindId(%d) after Insert failed: %v", id, err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if n, err := coll.Count(); err != nil || n != 80 {
+		t.Errorf("Expected 80 documents, got %d (%v)", n, err)
+	}
+}
+
+// TestModernDialWithInfo tests connecting with a DialInfo (localhost:27017)
+func TestModernDialWithInfo(t *testing.T) {
+	var dialed atomic.Int32
+	info := &mgo.DialInfo{
+		Addrs:    []string{"localhost:27017"},
+		Database: "test",
+		Timeout:  5 * time.Second,
+		AppName:  "modern_dial_test",
+		DialServer: func(addr *mgo.ServerAddr) (net.Conn, error) {
+			dialed.Add(1)
+			return net.DialTCP("tcp", nil, addr.TCPAddr())
+		},
+	}
+	session, err := mgo.DialModernWithInfo(info)
+	if err != nil {
+		t.Skipf("Skipping DialInfo tests due to connection failure: %v", err)
+	}
+	defer session.Close()
+
+	if dialed.Load() == 0 {
+		t.Error("DialServer was not used to connect")
+	}
+	if session.Mode() != mgo.Strong {
+		t.Errorf("Expected Strong mode, got %v", session.Mode())
+	}
+	testModernOperations(t, session, "test", "modern_dial_info_test")
+}
+
+// TestModernQueryModifiers tests the ModernQ methods matching those of Query
+func TestModernQueryModifiers(t *testing.T) {
+	session, err := mgo.DialModernMGO("mongodb://localhost:27018/test")
+	if err != nil {
+		t.Skipf("Skipping query modifier tests due to connection failure: %v", err)
+	}
+	defer session.Close()
+	if err := session.Ping(); err != nil {
+		t.Skipf("Skipping query modifier tests due to connection failure: %v", err)
+	}
+
+	coll := session.DB("test").C("modern_query_test")
+	coll.DropCollection()
+	defer coll.DropCollection()
+	for i := 0; i < 6; i++ {
+		if err := coll.Insert(bson.M{"_id": i, "n": i % 3, "name": "Doc"}); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+	}
+
+	var values []int
+	if err := coll.Find(bson.M{"_id": bson.M{"$gt": 0}}).Distinct("n", &values); err != nil {
+		t.Fatalf("Distinct failed: %v", err)
+	}
+	if len(values) != 3 {
+		t.Errorf("Expected 3 distinct values, got %v", values)
+	}
+
+	var docs []bson.M
+	query := coll.Find(nil).Hint("_id").Sort("-_id").Batch(2).Prefetch(0.5).
+		SetMaxTime(5 * time.Second).Comment("modifiers").
+		Collation(&mgo.Collation{Locale: "en", Strength: 2})
+	if err := query.All(&docs); err != nil {
+		t.Fatalf("All failed: %v", err)
+	}
+	if len(docs) != 6 || docs[0]["_id"] != 5 {
+		t.Errorf("Expected 6 documents starting with _id 5, got %v", docs)
+	}
+
+	var explain bson.M
+	if err := coll.Find(bson.M{"n": 1}).Hint("_id").Explain(&explain); err != nil {
+		t.Fatalf("Explain failed: %v", err)
+	}
+	if explain["queryPlanner"] == nil {
+		t.Errorf("Expected a query plan, got %v", explain)
+	}
+
+	count := 0
+	var doc bson.M
+	err = coll.Find(bson.M{"n": 2}).For(&doc, func() error {
+		count++
+		return nil
+	})
+	if err != nil || count != 2 {
+		t.Errorf("For visited %d documents: %v", count, err)
+	}
+
+	// Apply acts upon the first document in sort order, returning the
+	// selected fields only.
+	var removed bson.M
+	_, err = coll.Find(bson.M{"n": 0}).Sort("-_id").Select(bson.M{"n": 1}).Apply(mgo.Change{Remove: true}, &removed)
+	if err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	if removed["_id"] != 3 || removed["name"] != nil {
+		t.Errorf("Expected _id 3 without name, got %v", removed)
+	}
+
+	var result []struct {
+		Id    int `bson:"_id"`
+		Value int
+	}
+	job := &mgo.MapReduce{
+		Map:    "function() { emit(this.n, 1) }",
+		Reduce: "function(key, values) { return Array.sum(values) }",
+	}
+	info, err := coll.Find(nil).MapReduce(job, &result)
+	if err != nil {
+		t.Fatalf("MapReduce failed: %v", err)
+	}
+	if len(result) != 3 || info.InputCount != 5 {
+		t.Errorf("Unexpected map/reduce results %v with %+v", result, info)
+	}
+}
+
+// TestModernTail tests tailable iterators on capped collections
+func TestModernTail(t *testing.T) {
+	session, err := mgo.DialModernMGO("mongodb://localhost:27018/test")
+	if err != nil {
+		t.Skipf("Skipping tail tests due to connection failure: %v", err)
+	}
+	defer session.Close()
+	if err := session.Ping(); err != nil {
+		t.Skipf("Skipping tail tests due to connection failure: %v", err)
+	}
+
+	db := session.DB("test")
+	coll := db.C("modern_tail_test")
+	coll.DropCollection()
+	defer coll.DropCollection()
+	if err := db.Run(bson.D{{Name: "create", Value: "modern_tail_test"}, {Name: "capped", Value: true}, {Name: "size", Value: 4096}}, nil); err != nil {
+		t.Fatalf("create failed: %v", err)
+	}
+	if err := coll.Insert(bson.M{"n": 1}); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+
+	iter := coll.Find(nil).Sort("$natural").Tail(200 * time.Millisecond)
+	defer iter.Close()
+	var doc struct{ N int }
+	if !iter.Next(&doc) || doc.N != 1 {
+		t.Fatalf("Expected the first document, got %v: %v", doc, iter.Err())
+	}
+	if iter.Next(&doc) || !iter.Timeout() {
+		t.Fatalf("Expected a timeout: %v", iter.Err())
+	}
+	if err := coll.Insert(bson.M{"n": 2}); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+	if !iter.Next(&doc) || doc.N != 2 || iter.Timeout() {
+		t.Fatalf("Expected the second document, got %v: %v", doc, iter.Err())
+	}
+}
+
+// TestModernWatch tests change streams on collections, databases and deployments
+func TestModernWatch(t *testing.T) {
+	session, err := mgo.DialModernMGO("mongodb://localhost:27018/test")
+	if err != nil {
+		t.Skipf("Skipping change stream tests due to connection failure: %v", err)
+	}
+	defer session.Close()
+	if err := session.Ping(); err != nil {
+		t.Skipf("Skipping change stream tests due to connection failure: %v", err)
+	}
+	var isMaster struct {
+		SetName string `bson:"setName"`
+	}
+	if err := session.Run(true, bson.M{"isMaster": 1}, &isMaster); err != nil {
+		t.Fatalf("isMaster failed: %v", err)
+	}
+	if isMaster.SetName == "" {
+		t.Skip("Skipping change stream tests: change streams need a replica set")
+	}
+
+	coll := session.DB("test").C("modern_watch_test")
+	coll.DropCollection()
+	defer coll.DropCollection()
+	if err := coll.Insert(bson.M{"_id": 0}); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+
+	opts := mgo.ChangeStreamOptions{MaxAwaitTimeMS: 200 * time.Millisecond, FullDocument: mgo.UpdateLookup}
+	pipeline := []bson.M{{"$match": bson.M{"operationType": "insert"}}}
+	collStream, err := coll.Watch(pipeline, opts)
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	defer collStream.Close()
+	dbStream, err := session.DB("test").Watch(pipeline, opts)
+	if err != nil {
+		t.Fatalf("DB Watch failed: %v", err)
+	}
+	defer dbStream.Close()
+	clusterStream, err := session.Watch(pipeline, opts)
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	defer clusterStream.Close()
+
+	var event struct {
+		FullDocument struct {
+			Id int `bson:"_id"`
+		} `bson:"fullDocument"`
+	}
+	if collStream.Next(&event) || !collStream.Timeout() || collStream.Err() != nil {
+		t.Fatalf("Expected a timeout: %v", collStream.Err())
+	}
+	if err := coll.Insert(bson.M{"_id": 1}); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+	for name, stream := range map[string]*mgo.ModernChangeStream{"collection": collStream, "database": dbStream, "deployment": clusterStream} {
+		for !stream.Next(&event) && stream.Timeout() {
+		}
+		if stream.Err() != nil || event.FullDocument.Id != 1 {
+			t.Fatalf("Expected the insert on the %s stream, got %+v: %v", name, event, stream.Err())
+		}
+	}
+
+	// Streams pick up where others left.
+	token := collStream.ResumeToken()
+	if token == nil {
+		t.Fatal("Expected a resume token")
+	}
+	if err := coll.Insert(bson.M{"_id": 2}); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+	resumed, err := coll.Watch(pipeline, mgo.ChangeStreamOptions{ResumeAfter: token})
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	defer resumed.Close()
+	for !resumed.Next(&event) && resumed.Timeout() {
+	}
+	if resumed.Err() != nil || event.FullDocument.Id != 2 {
+		t.Fatalf("Expected the second insert, got %+v: %v", event, resumed.Err())
+	}
+
+	collStream.Close()
+	if collStream.Next(&event) || collStream.Err() == nil {
+		t.Fatal("Expected an error on a closed change stream")
+	}
+}
+
+func TestModernAdmin(t *testing.T) {
+	session, err := mgo.DialModernMGO("mongodb://localhost:27018/test")
+	if err != nil {
+		t.Skipf("Skipping admin tests due to connection failure: %v", err)
+	}
+	defer session.Close()
+	if err := session.Ping(); err != nil {
+		t.Skipf("Skipping admin tests due to connection failure: %v", err)
+	}
+
+	db := session.DB("modern_admin_test")
+	db.DropDatabase()
+	defer db.DropDatabase()
+
+	coll := db.C("capped")
+	err = coll.Create(&mgo.CollectionInfo{
+		Capped:    true,
+		MaxBytes:  4096,
+		Validator: bson.M{"n": bson.M{"$gte": 0}},
+		Collation: &mgo.Collation{Locale: "en", Strength: 2},
+	})
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if err := coll.Insert(bson.M{"n": -1}); err == nil {
+		t.Errorf("Insert should have failed validation")
+	}
+	if _, err := coll.UpsertId(1, bson.M{"n": 1}); err != nil {
+		t.Fatalf("UpsertId failed: %v", err)
+	}
+	if err := db.CreateView("view", "capped", []bson.M{{"$match": bson.M{"n": 1}}}, nil); err != nil {
+		t.Fatalf("CreateView failed: %v", err)
+	}
+
+	names, err := db.CollectionNames()
+	if err != nil {
+		t.Fatalf("CollectionNames failed: %v", err)
+	}
+	if len(names) < 2 || names[0] != "capped" || names[1] != "view" {
+		t.Errorf("unexpected collection names: %v", names)
+	}
+	dbNames, err := session.DatabaseNames()
+	if err != nil {
+		t.Fatalf("DatabaseNames failed: %v", err)
+	}
+	found := false
+	for _, name := range dbNames {
+		found = found || name == "modern_admin_test"
+	}
+	if !found {
+		t.Errorf("database missing from %v", dbNames)
+	}
+
+	if err := coll.EnsureIndexKey("a", "-b"); err != nil {
+		t.Fatalf("EnsureIndexKey failed: %v", err)
+	}
+	if err := coll.EnsureIndexKey("c"); err != nil {
+		t.Fatalf("EnsureIndexKey failed: %v", err)
+	}
+	if err := coll.DropIndex("a", "-b"); err != nil {
+		t.Fatalf("DropIndex failed: %v", err)
+	}
+	if err := coll.DropIndexName("c_1"); err != nil {
+		t.Fatalf("DropIndexName failed: %v", err)
+	}
+	if err := coll.EnsureIndexKey("d"); err != nil {
+		t.Fatalf("EnsureIndexKey failed: %v", err)
+	}
+	if err := coll.DropAllIndexes(); err != nil {
+		t.Fatalf("DropAllIndexes failed: %v", err)
+	}
+	indexes, err := coll.Indexes()
+	if err != nil {
+		t.Fatalf("Indexes failed: %v", err)
+	}
+	if len(indexes) != 1 || indexes[0].Name != "_id_" {
+		t.Errorf("unexpected indexes left: %v", indexes)
+	}
+
+	if err := db.UpsertUser(&mgo.User{Username: "modern_admin", Password: "secret", Roles: []mgo.Role{mgo.RoleRead}}); err != nil {
+		t.Fatalf("UpsertUser failed: %v", err)
+	}
+	if err := db.AddUser("modern_admin", "secret2", false); err != nil {
+		t.Fatalf("AddUser failed: %v", err)
+	}
+	if err := db.RemoveUser("modern_admin"); err != nil {
+		t.Fatalf("RemoveUser failed: %v", err)
+	}
+	if err := db.RemoveUser("modern_admin"); err != mgo.ErrNotFound {
+		t.Errorf("RemoveUser of a missing user returned %v", err)
+	}
+}
+
+func TestModernReplace(t *testing.T) {
+	session, err := mgo.DialModernMGO("mongodb://localhost:27018/test")
+	if err != nil {
+		t.Skipf("Skipping replace tests due to connection failure: %v", err)
+	}
+	defer session.Close()
+	if err := session.Ping(); err != nil {
+		t.Skipf("Skipping replace tests due to connection failure: %v", err)
+	}
+
+	c := session.DB("test").C("modern_replace_test")
+	c.DropCollection()
+	defer c.DropCollection()
+
+	find := func(id interface{}) bson.M {
+		var doc bson.M
+		if err := c.FindId(id).One(&doc); err != nil {
+			t.Fatalf("FindId(%v) failed: %v", id, err)
+		}
+		return doc
+	}
+	if err := c.Insert(bson.M{"_id": 1, "n": 1, "gone": true}, bson.M{"_id": 2, "n": 2, "gone": true}); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+
+	// Replacements drop the fields they don't hold.
+	if err := c.ReplaceId(1, bson.M{"n": 10}); err != nil {
+		t.Fatalf("ReplaceId failed: %v", err)
+	}
+	if doc := find(1); doc["n"] != 10 || doc["gone"] != nil {
+		t.Errorf("ReplaceId left %v", doc)
+	}
+	if err := c.Replace(bson.M{"_id": 42}, bson.M{"n": 42}); err != mgo.ErrNotFound {
+		t.Errorf("Replace of a missing document returned %v", err)
+	}
+	info, err := c.ReplaceOne(bson.M{"_id": 42}, bson.M{"n": 42})
+	if err != nil || info.Matched != 0 {
+		t.Errorf("ReplaceOne of a missing document returned %+v, %v", info, err)
+	}
+	if err := c.Replace(bson.M{"_id": 1}, bson.M{"$set": bson.M{"n": 1}}); err == nil {
+		t.Errorf("Replace with update operators should have failed")
+	}
+
+	// Plain updates are wrapped in $set by default, keeping other fields.
+	if err := c.UpdateId(2, bson.M{"n": 20}); err != nil {
+		t.Fatalf("UpdateId failed: %v", err)
+	}
+	if doc := find(2); doc["n"] != 20 || doc["gone"] != true {
+		t.Errorf("UpdateId left %v", doc)
+	}
+
+	// Strict mode replaces them, as the original mgo does.
+	session.SetStrictReplace(true)
+	if err := c.UpdateId(2, bson.M{"n": 21}); err != nil {
+		t.Fatalf("strict UpdateId failed: %v", err)
+	}
+	if doc := find(2); doc["n"] != 21 || doc["gone"] != nil {
+		t.Errorf("strict UpdateId left %v", doc)
+	}
+	if err := c.Insert(bson.M{"_id": 3, "n": 3, "gone": true}); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+	if _, err := c.UpsertId(3, bson.M{"n": 30}); err != nil {
+		t.Fatalf("strict UpsertId failed: %v", err)
+	}
+	if doc := find(3); doc["n"] != 30 || doc["gone"] != nil {
+		t.Errorf("strict UpsertId left %v", doc)
+	}
+
+	var result bson.M
+	if _, err := c.FindId(3).Apply(mgo.Change{Update: bson.M{"n": 31}, ReturnNew: true}, &result); err != nil {
+		t.Fatalf("strict Apply failed: %v", err)
+	}
+	if result["n"] != 31 {
+		t.Errorf("strict Apply returned %v", result)
+	}
+
+	if err := c.Insert(bson.M{"_id": 4, "n": 4, "gone": true}); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+	// The mode is kept by copies.
+	scopy := session.Copy()
+	defer scopy.Close()
+	bulk := scopy.DB("test").C("modern_replace_test").Bulk()
+	bulk.Update(bson.M{"_id": 4}, bson.M{"n": 40})
+	if _, err := bulk.Run(); err != nil {
+		t.Fatalf("strict bulk update failed: %v", err)
+	}
+	if doc := find(4); doc["n"] != 40 || doc["gone"] != nil {
+		t.Errorf("strict bulk update left %v", doc)
+	}
+}
+
+func TestModernGridFSStreaming(t *testing.T) {
+	session, err := mgo.DialModernMGO("mongodb://localhost:27018/test")
+	if err != nil {
+		t.Skipf("Skipping GridFS tests due to connection failure: %v", err)
+	}
+	defer session.Close()
+	if err := session.Ping(); err != nil {
+		t.Skipf("Skipping GridFS tests due to connection failure: %v", err)
+	}
+
+	gfs := session.DB("test").GridFS("modern_streaming")
+	gfs.Files.DropCollection()
+	gfs.Chunks.DropCollection()
+	defer gfs.Files.DropCollection()
+	defer gfs.Chunks.DropCollection()
+
+	chunks := func(id interface{}) int {
+		n, err := gfs.Chunks.Find(bson.M{"files_id": id}).Count()
+		if err != nil {
+			t.Fatalf("Count failed: %v", err)
+		}
+		return n
+	}
+
+	file, err := gfs.Create("stream.txt")
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	file.SetChunkSize(5)
+
+	// Chunks are inserted as soon as they fill up.
+	if _, err := file.Write([]byte("abcdefghij")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if _, err := file.Write([]byte("klmnopq")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if n := chunks(file.Id()); n != 3 {
+		t.Errorf("got %d chunks before Close, want 3", n)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if n := chunks(file.Id()); n != 4 {
+		t.Errorf("got %d chunks after Close, want 4", n)
+	}
+
+	file, err = gfs.Open("stream.txt")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer file.Close()
+	if file.Size() != 17 {
+		t.Errorf("got size %d, want 17", file.Size())
+	}
+	data, err := io.ReadAll(file)
+	if err != nil || string(data) != "abcdefghijklmnopq" {
+		t.Errorf("ReadAll returned %q, %v", data, err)
+	}
+
+	pos, err := file.Seek(7, io.SeekStart)
+	if err != nil || pos != 7 {
+		t.Fatalf("Seek returned %d, %v", pos, err)
+	}
+	buf := make([]byte, 4)
+	if n, err := file.Read(buf); err != nil || string(buf[:n]) != "hijk" {
+		t.Errorf("Read after Seek returned %q, %v", buf[:n], err)
+	}
+	if pos, err := file.Seek(-2, io.SeekEnd); err != nil || pos != 15 {
+		t.Errorf("Seek from end returned %d, %v", pos, err)
+	}
+	if n, err := file.Read(buf); err != nil || string(buf[:n]) != "pq" {
+		t.Errorf("Read at end returned %q, %v", buf[:n], err)
+	}
+
+	// ReadAt doesn't move the offset of Read.
+	buf = make([]byte, 8)
+	if n, err := file.ReadAt(buf, 3); err != nil || string(buf[:n]) != "defghijk" {
+		t.Errorf("ReadAt returned %q, %v", buf[:n], err)
+	}
+	if n, err := file.ReadAt(buf, 12); err != io.EOF || string(buf[:n]) != "mnopq" {
+		t.Errorf("ReadAt past end returned %q, %v", buf[:n], err)
+	}
+	if _, err := file.Read(buf); err != io.EOF {
+		t.Errorf("Read at end returned %v, want io.EOF", err)
+	}
+
+	// Aborted writes remove the chunks written so far.
+	file, err = gfs.Create("aborted.txt")
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	file.SetChunkSize(5)
+	if _, err := file.Write([]byte("abcdefghijkl")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if n := chunks(file.Id()); n != 2 {
+		t.Errorf("got %d chunks before Abort, want 2", n)
+	}
+	file.Abort()
+	if err := file.Close(); err == nil || err.Error() != "write aborted" {
+		t.Errorf("Close after Abort returned %v", err)
+	}
+	if n := chunks(file.Id()); n != 0 {
+		t.Errorf("got %d chunks after Abort, want 0", n)
+	}
+	if _, err := gfs.Open("aborted.txt"); err != mgo.ErrNotFound {
+		t.Errorf("Open of aborted file returned %v, want ErrNotFound", err)
+	}
+}
+
+func TestModernGridFSBucket(t *testing.T) {
+	session, err := mgo.DialModernMGO("mongodb://localhost:27018/test")
+	if err != nil {
+		t.Skipf("Skipping GridFS tests due to connection failure: %v", err)
+	}
+	defer session.Close()
+	if err := session.Ping(); err != nil {
+		t.Skipf("Skipping GridFS tests due to connection failure: %v", err)
+	}
+
+	gfs := session.DB("test").GridFSWithOptions("modern_bucket", mgo.GridFSOptions{ChunkSize: 16, Safe: &mgo.Safe{WMode: "majority"}})
+	if err := gfs.Drop(); err != nil {
+		t.Fatalf("Drop failed: %v", err)
+	}
+	defer gfs.Drop()
+
+	uploaded := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		file, err := gfs.Create("myfile.txt")
+		if err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+		file.SetUploadDate(uploaded.Add(time.Duration(i) * time.Hour))
+		file.Write([]byte{byte('0' + i)})
+		if err := file.Close(); err != nil {
+			t.Fatalf("Close failed: %v", err)
+		}
+	}
+	for revision, want := range map[int]string{0: "0", 2: "2", -1: "2", -3: "0"} {
+		file, err := gfs.OpenRevision("myfile.txt", revision)
+		if err != nil {
+			t.Fatalf("OpenRevision(%d) failed: %v", revision, err)
+		}
+		data, err := io.ReadAll(file)
+		file.Close()
+		if err != nil || string(data) != want {
+			t.Errorf("revision %d holds %q, %v; want %q", revision, data, err, want)
+		}
+	}
+	if _, err := gfs.OpenRevision("myfile.txt", -4); err != mgo.ErrNotFound {
+		t.Errorf("OpenRevision(-4) returned %v, want ErrNotFound", err)
+	}
+
+	data := strings.Repeat("abcdefghij", 10)
+	id, err := gfs.UploadFromStream("upload.txt", strings.NewReader(data), mgo.GridFSUploadOptions{Id: "myid", ContentType: "text/plain"})
+	if err != nil || id != "myid" {
+		t.Fatalf("UploadFromStream returned %v, %v", id, err)
+	}
+	if n, err := gfs.Chunks.Find(bson.M{"files_id": "myid"}).Count(); err != nil || n != 7 {
+		t.Errorf("got %d chunks, %v; want 7", n, err)
+	}
+
+	if err := gfs.Rename("myid", "renamed.txt"); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+	if err := gfs.Rename("missing", "renamed.txt"); err != mgo.ErrNotFound {
+		t.Errorf("Rename of missing file returned %v, want ErrNotFound", err)
+	}
+	file, err := gfs.Open("renamed.txt")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if file.ContentType() != "text/plain" || file.Id() != "myid" {
+		t.Errorf("renamed file has content type %q and id %v", file.ContentType(), file.Id())
+	}
+	file.Close()
+
+	var buf strings.Builder
+	if n, err := gfs.DownloadToStream("myid", &buf); err != nil || n != int64(len(data)) || buf.String() != data {
+		t.Errorf("DownloadToStream returned %d, %v", n, err)
+	}
+	if _, err := gfs.DownloadToStream("missing", &buf); err != mgo.ErrNotFound {
+		t.Errorf("DownloadToStream of missing file returned %v, want ErrNotFound", err)
+	}
+
+	if err := gfs.Drop(); err != nil {
+		t.Fatalf("Drop failed: %v", err)
+	}
+	if n, err := gfs.Files.Count(); err != nil || n != 0 {
+		t.Errorf("got %d files after Drop, %v", n, err)
+	}
+}
+
+func TestModernGridFSIntegrity(t *testing.T) {
+	session, err := mgo.DialModernMGO("mongodb://localhost:27018/test")
+	if err != nil {
+		t.Skipf("Skipping GridFS tests due to connection failure: %v", err)
+	}
+	defer session.Close()
+	if err := session.Ping(); err != nil {
+		t.Skipf("Skipping GridFS tests due to connection failure: %v", err)
+	}
+
+	gfs := session.DB("test").GridFSWithOptions("modern_integrity", mgo.GridFSOptions{
+		ChunkSize:      4,
+		Checksum:       mgo.ChecksumSHA256,
+		VerifyChecksum: true,
+	})
+	gfs.Drop()
+	defer gfs.Drop()
+
+	for _, id := range []string{"healthy", "corrupt", "missing", "orphan"} {
+		if _, err := gfs.UploadFromStream(id+".txt", strings.NewReader("0123456789"), mgo.GridFSUploadOptions{Id: id}); err != nil {
+			t.Fatalf("UploadFromStream failed: %v", err)
+		}
+	}
+
+	file, err := gfs.OpenId("healthy")
+	if err != nil {
+		t.Fatalf("OpenId failed: %v", err)
+	}
+	if file.MD5() != "" || file.SHA256() != "84d89877f0d4041efb6bf91a16f0248f2fd573e6af05c19f96bedb9f882f7882" {
+		t.Errorf("got checksums %q and %q", file.MD5(), file.SHA256())
+	}
+	if _, err := io.ReadAll(file); err != nil {
+		t.Errorf("reading a healthy file failed: %v", err)
+	}
+	file.Close()
+
+	if err := gfs.Chunks.Update(bson.M{"files_id": "corrupt", "n": 1}, bson.M{"$set": bson.M{"data": []byte("4567!")}}); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	file, err = gfs.OpenId("corrupt")
+	if err != nil {
+		t.Fatalf("OpenId failed: %v", err)
+	}
+	if _, err := io.ReadAll(file); err != mgo.ErrChecksumMismatch {
+		t.Errorf("reading a corrupt file returned %v, want ErrChecksumMismatch", err)
+	}
+	file.Close()
+
+	if err := gfs.Chunks.Remove(bson.M{"files_id": "missing", "n": 1}); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	if err := gfs.Files.RemoveId("orphan"); err != nil {
+		t.Fatalf("RemoveId failed: %v", err)
+	}
+
+	// The chunks of files just written may belong to uploads in progress.
+	result, err := gfs.Fsck(mgo.GridFSFsckOptions{})
+	if err != nil {
+		t.Fatalf("Fsck failed: %v", err)
+	}
+	if len(result.Orphans) != 0 {
+		t.Errorf("Fsck found recent orphans %v", result.Orphans)
+	}
+
+	result, err = gfs.Fsck(mgo.GridFSFsckOptions{Repair: true, Delete: true, MinAge: -1})
+	if err != nil {
+		t.Fatalf("Fsck failed: %v", err)
+	}
+	if result.Files != 3 || len(result.Orphans) != 1 || result.Orphans[0] != "orphan" {
+		t.Errorf("Fsck checked %d files and found orphans %v", result.Files, result.Orphans)
+	}
+	for _, file := range result.Damaged {
+		switch file.Id {
+		case "corrupt":
+			// A chunk too long can only be detected by the checksum.
+			if !file.Repaired || file.ChunksLength != 11 {
+				t.Errorf("corrupt file reported as %+v", file)
+			}
+		case "missing":
+			if !file.Deleted || len(file.MissingChunks) != 1 || file.MissingChunks[0] != 1 {
+				t.Errorf("file with a missing chunk reported as %+v", file)
+			}
+		default:
+			t.Errorf("unexpected damaged file %+v", file)
+		}
+	}
+
+	result, err = gfs.Fsck(mgo.GridFSFsckOptions{MinAge: -1})
+	if err != nil {
+		t.Fatalf("Fsck failed: %v", err)
+	}
+	if result.Files != 2 || len(result.Damaged) != 0 || len(result.Orphans) != 0 {
+		t.Errorf("second Fsck returned %+v", result)
+	}
+}
+
+func TestModernGridFSHTTPHandler(t *testing.T) {
+	session, err := mgo.DialModernMGO("mongodb://localhost:27018/test")
+	if err != nil {
+		t.Skipf("Skipping GridFS tests due to connection failure: %v", err)
+	}
+	defer session.Close()
+	if err := session.Ping(); err != nil {
+		t.Skipf("Skipping GridFS tests due to connection failure: %v", err)
+	}
+
+	gfs := session.DB("test").GridFSWithOptions("modern_http", mgo.GridFSOptions{ChunkSize: 4, Checksum: mgo.ChecksumSHA256})
+	gfs.Drop()
+	defer gfs.Drop()
+
+	if _, err := gfs.UploadFromStream("img/logo.txt", strings.NewReader("0123456789"), mgo.GridFSUploadOptions{Id: "logo"}); err != nil {
+		t.Fatalf("UploadFromStream failed: %v", err)
+	}
+
+	server := httptest.NewServer(gfs.HTTPHandler(mgo.GridFSHTTPOptions{Prefix: "/assets/"}))
+	defer server.Close()
+
+	req, err := http.NewRequest("GET", server.URL+"/assets/img/logo.txt", nil)
+	if err != nil {
+		t.Fatalf("NewRequest failed: %v", err)
+	}
+	req.Header.Set("Range", "bytes=3-8")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || resp.StatusCode != http.StatusPartialContent || string(body) != "345678" {
+		t.Errorf("range request returned %d %q, %v", resp.StatusCode, body, err)
+	}
+	etag := resp.Header.Get("ETag")
+	if etag != `"84d89877f0d4041efb6bf91a16f0248f2fd573e6af05c19f96bedb9f882f7882"` {
+		t.Errorf("got ETag %s", etag)
+	}
+	if ctype := resp.Header.Get("Content-Type"); ctype != "text/plain; charset=utf-8" {
+		t.Errorf("got Content-Type %s", ctype)
+	}
+
+	req.Header.Del("Range")
+	req.Header.Set("If-None-Match", etag)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotModified {
+		t.Errorf("conditional request returned %d", resp.StatusCode)
+	}
+
+	server = httptest.NewServer(gfs.HTTPHandler(mgo.GridFSHTTPOptions{ById: true}))
+	defer server.Close()
+	resp, err = http.Head(server.URL + "/logo")
+	if err != nil {
+		t.Fatalf("HEAD failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.ContentLength != 10 {
+		t.Errorf("HEAD by id returned %d with length %d", resp.StatusCode, resp.ContentLength)
+	}
+}
+
+func TestModernGridFSFS(t *testing.T) {
+	session, err := mgo.DialModernMGO("mongodb://localhost:27018/test")
+	if err != nil {
+		t.Skipf("Skipping GridFS tests due to connection failure: %v", err)
+	}
+	defer session.Close()
+	if err := session.Ping(); err != nil {
+		t.Skipf("Skipping GridFS tests due to connection failure: %v", err)
+	}
+
+	gfs := session.DB("test").GridFSWithOptions("modern_fs", mgo.GridFSOptions{ChunkSize: 4})
+	gfs.Drop()
+	defer gfs.Drop()
+
+	for _, name := range []string{"a.txt", "dir/b.txt", "dir/b.txt", "dir/sub/c.txt", "dir.txt"} {
+		opts := mgo.GridFSUploadOptions{Metadata: bson.M{"name": name}}
+		if _, err := gfs.UploadFromStream(name, strings.NewReader("data of "+name), opts); err != nil {
+			t.Fatalf("UploadFromStream failed: %v", err)
+		}
+	}
+
+	fsys := gfs.FS()
+	if err := fstest.TestFS(fsys, "a.txt", "dir/b.txt", "dir/sub/c.txt", "dir.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := fs.Stat(fsys, "dir/b.txt")
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	var meta bson.M
+	if raw, ok := info.Sys().(*bson.Raw); !ok || raw.Unmarshal(&meta) != nil || meta["name"] != "dir/b.txt" {
+		t.Errorf("got metadata %#v", info.Sys())
+	}
+	if _, err := fs.Stat(fsys, "dir/missing.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Stat of a missing file returned %v", err)
+	}
+}
@@ -0,0 +1,85 @@
+// modern_transaction.go - Transactions for modern MongoDB driver compatibility wrapper
+
+package mgo
+
+import (
+	"time"
+
+	mongodrv "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+// TxnOptions holds the options of transactions run with RunTransaction.
+// They mean the same as for Session.StartTransaction.
+type TxnOptions = TransactionOptions
+
+// RunTransaction runs fn in a transaction, committing it if fn returns nil
+// and aborting it otherwise (mgo API compatible with Session.WithTransaction).
+//
+// The session handed to fn is bound to the transaction: all the operations
+// made through the databases, collections, queries, pipes, bulks and GridFS
+// obtained from it take part in the transaction. Operations made through
+// other handles, including m itself, don't.
+//
+// As with Session.WithTransaction, fn is called again if the transaction
+// fails with a TransientTransactionError, and the commit is retried on an
+// UnknownTransactionCommitResult error, for up to 120 seconds in total.
+// fn must thus be safe to run more than once.
+//
+// Transactions require MongoDB 4.0+ on a replica set or 4.2+ on a sharded
+// cluster.
+func (m *ModernMGO) RunTransaction(fn func(*ModernMGO) error, opts TxnOptions) error {
+	ctx := baseContext(m.ctx)
+	sess, err := m.client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer sess.EndSession(ctx)
+
+	_, err = sess.WithTransaction(ctx, func(sc mongodrv.SessionContext) (interface{}, error) {
+		txm := m.Copy()
+		txm.ctx = sc
+		return nil, fn(txm)
+	}, m.transactionOptions(opts))
+	return modernError(err)
+}
+
+// transactionOptions converts opts to the official driver options.
+func (m *ModernMGO) transactionOptions(opts TxnOptions) *options.TransactionOptions {
+	// Reads in a transaction must be done on the primary.
+	txnOpts := options.Transaction().SetReadPreference(readpref.Primary())
+	if opts.ReadConcern != "" {
+		txnOpts.SetReadConcern(&readconcern.ReadConcern{Level: opts.ReadConcern})
+	}
+	safe := opts.WriteConcern
+	if safe == nil {
+		safe = m.Safe()
+	}
+	if safe != nil {
+		txnOpts.SetWriteConcern(modernWriteConcern(safe))
+	}
+	if opts.MaxCommitTime > 0 {
+		txnOpts.SetMaxCommitTime(&opts.MaxCommitTime)
+	}
+	return txnOpts
+}
+
+// modernWriteConcern converts safe to the official driver write concern.
+func modernWriteConcern(safe *Safe) *writeconcern.WriteConcern {
+	wc := &writeconcern.WriteConcern{WTimeout: time.Duration(safe.WTimeout) * time.Millisecond}
+	if safe.WMode != "" {
+		wc.W = safe.WMode
+	} else if safe.W > 0 {
+		wc.W = safe.W
+	}
+	if safe.J || safe.FSync {
+		// The official driver has no fsync option; the journal provides
+		// the same durability where present.
+		journal := true
+		wc.Journal = &journal
+	}
+	return wc
+}
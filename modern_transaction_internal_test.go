@@ -0,0 +1,57 @@
+package mgo
+
+import (
+	"context"
+	"time"
+
+	mongodrv "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	. "gopkg.in/check.v1"
+)
+
+func (s *S) TestModernWriteConcern(c *C) {
+	wc := modernWriteConcern(&Safe{WMode: "majority", W: 2, WTimeout: 500, J: true})
+	c.Assert(wc.W, Equals, "majority")
+	c.Assert(wc.WTimeout, Equals, 500*time.Millisecond)
+	c.Assert(*wc.Journal, Equals, true)
+
+	wc = modernWriteConcern(&Safe{W: 2})
+	c.Assert(wc.W, Equals, 2)
+	c.Assert(wc.Journal, IsNil)
+
+	wc = modernWriteConcern(&Safe{})
+	c.Assert(wc.W, IsNil)
+}
+
+func (s *S) TestModernTransactionOptions(c *C) {
+	m := &ModernMGO{settings: newModernSettings(Primary, &Safe{W: 1}, "", nil)}
+	opts := m.transactionOptions(TxnOptions{ReadConcern: "snapshot", MaxCommitTime: time.Second})
+	c.Assert(opts.ReadConcern.Level, Equals, "snapshot")
+	c.Assert(opts.WriteConcern.W, Equals, 1)
+	c.Assert(*opts.MaxCommitTime, Equals, time.Second)
+	c.Assert(opts.ReadPreference.Mode().String(), Equals, "primary")
+
+	opts = m.transactionOptions(TxnOptions{WriteConcern: &Safe{WMode: "majority"}})
+	c.Assert(opts.ReadConcern, IsNil)
+	c.Assert(opts.WriteConcern.W, Equals, "majority")
+	c.Assert(opts.MaxCommitTime, IsNil)
+}
+
+func (s *S) TestModernContextPropagation(c *C) {
+	type key struct{}
+	ctx := context.WithValue(context.Background(), key{}, "txn")
+	// Connecting doesn't talk to the server until an operation is run.
+	client, err := mongodrv.Connect(context.Background(), options.Client().ApplyURI("mongodb://localhost:1"))
+	c.Assert(err, IsNil)
+	defer client.Disconnect(context.Background())
+
+	m := newModernMGO(client, "test", newModernSettings(Primary, &Safe{}, "", nil))
+	m.ctx = ctx
+	gfs := m.DB("").GridFS("fs")
+	c.Assert(gfs.Chunks.ctx, Equals, ctx)
+	opctx, cancel := gfs.Files.opContext(time.Second)
+	defer cancel()
+	c.Assert(opctx.Value(key{}), Equals, "txn")
+	c.Assert(baseContext(nil), Equals, context.Background())
+	c.Assert(m.Copy().ctx, IsNil)
+}
@@ -4,31 +4,86 @@ package mgo
 
 import (
 	"context"
+	"hash"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/globalsign/mgo/bson"
+	officialBson "go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	mongodrv "go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // ModernMGO provides the mgo API using the official MongoDB driver
 type ModernMGO struct {
-	client     *mongodrv.Client
-	dbName     string
-	mode       Mode
-	safe       *Safe
-	isOriginal bool // Track if this is the original session or a copy
+	client      *mongodrv.Client
+	dbName      string
+	mu          sync.Mutex
+	settings    *modernSettings    // Safety and consistency settings; see SetSafe and SetMode
+	consistency *modernConsistency // Times observed in the Monotonic mode
+	isOriginal  bool               // Track if this is the original session or a copy
+	strict      bool               // Strict replace mode; see SetStrictReplace
+	ctx         context.Context    // Base context of operations; the transaction's one in RunTransaction
+}
+
+// modernSettings holds the safety and consistency settings of a ModernMGO,
+// along with the driver collection options implementing them. It's never
+// modified: a new one replaces it on changes, so that handles may cache
+// the driver collections built out of it.
+type modernSettings struct {
+	mode    Mode
+	safe    *Safe  // nil for unacknowledged writes
+	rmode   string // Read concern level
+	tagSets []bson.D
+	coll    *options.CollectionOptions
+	ackColl *options.CollectionOptions // As coll, but acknowledging writes
+}
+
+// modernConsistency holds the cluster and operation times observed by the
+// operations of Monotonic sessions. Each operation runs in a causally
+// consistent driver session of its own, advanced to those times first, so
+// that sessions and their clones, which share it, may run operations
+// concurrently as with Session.
+type modernConsistency struct {
+	mu            sync.Mutex
+	clusterTime   officialBson.Raw
+	operationTime *primitive.Timestamp
+}
+
+// modernOpSession is the causally consistent driver session an operation
+// of a Monotonic session runs in, which reports the times it observes to
+// the consistency of the session.
+type modernOpSession struct {
+	consistency *modernConsistency
+	sess        mongodrv.Session
 }
 
 // ModernDB wraps the modern database
 type ModernDB struct {
-	mgoDB *mongodrv.Database
-	name  string
+	mgoDB   *mongodrv.Database
+	name    string
+	ctx     context.Context
+	session *ModernMGO
 }
 
 // ModernColl wraps the modern collection
 type ModernColl struct {
 	mgoColl *mongodrv.Collection
 	name    string
+	ctx     context.Context
+	session *ModernMGO
+	safe    *Safe // Safety mode of writes in place of the session's one; see withSafe
+	cache   atomic.Pointer[modernCollCache]
+}
+
+// modernCollCache holds the driver collections of a ModernColl with the
+// settings of its session applied.
+type modernCollCache struct {
+	settings *modernSettings
+	coll     *mongodrv.Collection
+	ackColl  *mongodrv.Collection
 }
 
 // ModernQ wraps query state
@@ -39,13 +94,33 @@ type ModernQ struct {
 	skip       int64
 	limit      int64
 	projection interface{}
+	hint       interface{}
+	collation  *options.Collation
+	maxTime    time.Duration
+	comment    string
+	batchSize  int32
 }
 
 // ModernIt wraps cursor iteration
 type ModernIt struct {
-	cursor *mongodrv.Cursor
-	ctx    context.Context
-	err    error
+	cursor   *mongodrv.Cursor
+	ctx      context.Context
+	sess     *modernOpSession // Driver session of Monotonic sessions, ended with the cursor
+	err      error
+	tail     bool          // Whether the cursor is tailable; see ModernQ.Tail
+	timeout  time.Duration // Time Next waits for documents of tailable cursors
+	timedout bool
+}
+
+// ModernChangeStream wraps a change stream of the official driver
+type ModernChangeStream struct {
+	stream   *mongodrv.ChangeStream
+	ctx      context.Context
+	sess     *modernOpSession // Driver session of Monotonic sessions, ended with the stream
+	m        sync.Mutex
+	err      error
+	isClosed bool
+	timedout bool
 }
 
 // ModernPipe wraps aggregation pipeline state
@@ -68,22 +143,32 @@ type ModernBulk struct {
 
 // ModernGridFS provides GridFS operations using the official MongoDB driver
 type ModernGridFS struct {
-	Files  *ModernColl
-	Chunks *ModernColl
-	prefix string
+	Files     *ModernColl
+	Chunks    *ModernColl
+	prefix    string
+	chunkSize int            // Chunk size of new files; see GridFSOptions
+	checksum  GridFSChecksum // Checksum of new files; see GridFSOptions
+	verify    bool           // Whether reads verify checksums; see GridFSOptions
 }
 
-// ModernGridFile wraps GridFS file operations
+// ModernGridFile wraps GridFS file operations, streaming chunks to and from
+// the server as GridFile does rather than holding whole files in memory
 type ModernGridFile struct {
-	id          interface{}
-	filename    string
-	contentType string
-	chunkSize   int
-	length      int64
-	md5         string
-	uploadDate  time.Time
-	metadata    interface{}
-	gfs         *ModernGridFS
-	chunks      [][]byte
-	closed      bool
+	m    sync.Mutex
+	gfs  *ModernGridFS
+	mode gfsFileMode
+	err  error
+
+	chunk  int
+	offset int64
+
+	wbuf     []byte
+	wsum     hash.Hash
+	checksum GridFSChecksum
+
+	rbuf    []byte
+	rsum    hash.Hash // Checksum of the data read from the start, if verifying
+	rsummed int64     // Length of the data in rsum
+
+	doc gfsFile
 }
@@ -3,6 +3,7 @@
 package mgo
 
 import (
+	"context"
 	stdlog "log"
 	"reflect"
 	"time"
@@ -10,8 +11,53 @@ import (
 	"github.com/globalsign/mgo/bson"
 	officialBson "go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// baseContext returns the context operations of a handle bound to ctx
+// derive from, which is the background context for unbound handles.
+func baseContext(ctx context.Context) context.Context {
+	if ctx == nil {
+		return context.Background()
+	}
+	return ctx
+}
+
+// modernPipeline converts the stages of pipeline, which must be a slice,
+// for the official driver. A nil pipeline has no stages.
+func modernPipeline(pipeline interface{}) []interface{} {
+	if pipeline == nil {
+		return []interface{}{}
+	}
+	pipelinev := reflect.ValueOf(pipeline)
+	if pipelinev.Kind() != reflect.Slice {
+		panic("pipeline argument must be a slice")
+	}
+	stages := make([]interface{}, pipelinev.Len())
+	for i := range stages {
+		stages[i] = convertMGOToOfficial(pipelinev.Index(i).Interface())
+	}
+	return stages
+}
+
+// modernCollation converts collation to the official driver Collation.
+func modernCollation(collation *Collation) *options.Collation {
+	if collation == nil {
+		return nil
+	}
+	return &options.Collation{
+		Locale:          collation.Locale,
+		CaseFirst:       collation.CaseFirst,
+		Strength:        collation.Strength,
+		Alternate:       collation.Alternate,
+		MaxVariable:     collation.MaxVariable,
+		Normalization:   collation.Normalization,
+		CaseLevel:       collation.CaseLevel,
+		NumericOrdering: collation.NumericOrdering,
+		Backwards:       collation.Backwards,
+	}
+}
+
 // Debug flag to enable conversion debugging
 var DebugConversion = false
 
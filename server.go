@@ -86,6 +86,47 @@ type mongoServerInfo struct {
 	Tags           bson.D
 	MaxWireVersion int
 	SetName        string
+	Compressors    []string // Agreed upon during the handshake, in order of preference.
+	MaxMessageSize int      // As reported by the server, or zero if it didn't.
+
+	// SaslSupportedMechs holds the mechanisms the server supports for
+	// the user named by SaslUser ("<source>.<username>"), which is the
+	// one provided in the DialInfo.
+	SaslUser           string
+	SaslSupportedMechs []string
+
+	// LogicalSessionTimeout is how long the server keeps idle logical
+	// sessions around, or zero if it doesn't support them.
+	LogicalSessionTimeout time.Duration
+}
+
+// retryableWrites returns whether the server accepts retryable writes,
+// which need logical sessions and a replica set or sharded cluster.
+func (info *mongoServerInfo) retryableWrites() bool {
+	return info.MaxWireVersion >= opMsgMinWireVersion && info.LogicalSessionTimeout > 0 && (info.SetName != "" || info.Mongos)
+}
+
+// maxMessageSize returns the maximum size of the messages of the server.
+func (info *mongoServerInfo) maxMessageSize() int {
+	if info.MaxMessageSize > 0 {
+		return info.MaxMessageSize
+	}
+	return defaultMaxMessageSize
+}
+
+// scramMechanism returns the SCRAM mechanism to authenticate cred with when
+// none was explicitly requested. SCRAM-SHA-256 is only picked when the
+// handshake reported that it's supported for that very user, since users
+// created before MongoDB 4.0 only have SCRAM-SHA-1 credentials.
+func (info *mongoServerInfo) scramMechanism(cred Credential) string {
+	if info.SaslUser == cred.Source+"."+cred.Username {
+		for _, mech := range info.SaslSupportedMechs {
+			if mech == "SCRAM-SHA-256" {
+				return mech
+			}
+		}
+	}
+	return "SCRAM-SHA-1"
 }
 
 var defaultServerInfo mongoServerInfo
@@ -249,6 +290,9 @@ func (server *mongoServer) Connect(info *DialInfo) (*mongoSocket, error) {
 	default:
 		panic("dialer is set, but both dial.old and dial.new are nil")
 	}
+	if err == nil && info.TLSConfig != nil && !dial.isSet() {
+		conn, err = dialTLS(conn, server.Addr, info.TLSConfig, info.Timeout)
+	}
 	if err != nil {
 		logf("Connection to %s failed: %v", server.Addr, err.Error())
 		return nil, err
@@ -0,0 +1,247 @@
+package mgo
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+// serverSession is a logical session on the server side, identified by the
+// lsid sent along with commands. Retryable writes use it together with an
+// increasing txnNumber, so the server recognizes a retried write it has
+// applied already.
+//
+// Relevant documentation:
+//
+//	https://github.com/mongodb/specifications/blob/master/source/sessions/driver-sessions.rst
+type serverSession struct {
+	id        bson.Binary
+	lastUse   time.Time
+	txnNumber int64
+	timeout   time.Duration // The logical session timeout of the server.
+}
+
+func newServerSession() (*serverSession, error) {
+	var uuid [16]byte
+	if _, err := io.ReadFull(rand.Reader, uuid[:]); err != nil {
+		return nil, errors.New("cannot generate session id: " + err.Error())
+	}
+	// Version 4 (random) UUID, RFC 4122 variant.
+	uuid[6] = uuid[6]&0x0f | 0x40
+	uuid[8] = uuid[8]&0x3f | 0x80
+	return &serverSession{id: bson.Binary{Kind: 0x04, Data: uuid[:]}}, nil
+}
+
+func (ss *serverSession) lsid() bson.D {
+	return bson.D{{Name: "id", Value: ss.id}}
+}
+
+// stale returns whether the server may have expired the session by now.
+// A minute of slack is left so that a session isn't reused right as it
+// expires.
+func (ss *serverSession) stale() bool {
+	return !ss.lastUse.IsZero() && time.Since(ss.lastUse) > ss.timeout-time.Minute
+}
+
+// serverSessionPool holds the server sessions that are not in use by any
+// Session, so that they're reused instead of piling up on the servers.
+type serverSessionPool struct {
+	sync.Mutex
+	sessions []*serverSession
+}
+
+// get returns the most recently used session that isn't stale, or a new
+// one if there's none.
+func (pool *serverSessionPool) get() (*serverSession, error) {
+	pool.Lock()
+	for len(pool.sessions) > 0 {
+		n := len(pool.sessions) - 1
+		ss := pool.sessions[n]
+		pool.sessions[n] = nil
+		pool.sessions = pool.sessions[:n]
+		if !ss.stale() {
+			pool.Unlock()
+			return ss, nil
+		}
+	}
+	pool.Unlock()
+	return newServerSession()
+}
+
+// put returns ss to the pool, unless it's stale already.
+func (pool *serverSessionPool) put(ss *serverSession) {
+	if ss.stale() {
+		return
+	}
+	pool.Lock()
+	pool.sessions = append(pool.sessions, ss)
+	pool.Unlock()
+}
+
+// startServerSession returns the server session to run a retryable write
+// with on socket. Each Session keeps one for itself while alive, and takes
+// extra ones from the pool for writes issued concurrently, as a session's
+// txnNumber may not go backwards.
+func (s *Session) startServerSession(socket *mongoSocket) (ss *serverSession, err error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.serverSessionBusy {
+		ss, err = s.cluster().sessionPool.get()
+	} else {
+		if s.serverSession == nil || s.serverSession.stale() {
+			s.serverSession, err = s.cluster().sessionPool.get()
+		}
+		ss = s.serverSession
+		s.serverSessionBusy = err == nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	ss.timeout = socket.ServerInfo().LogicalSessionTimeout
+	return ss, nil
+}
+
+// endServerSession releases a server session obtained from startServerSession.
+func (s *Session) endServerSession(ss *serverSession) {
+	s.m.Lock()
+	ss.lastUse = time.Now()
+	if ss == s.serverSession {
+		s.serverSessionBusy = false
+	} else if s.mgoCluster != nil {
+		s.mgoCluster.sessionPool.put(ss)
+	}
+	s.m.Unlock()
+}
+
+// Server error codes after which an operation may be retried, as the
+// server was unreachable, shutting down or no longer the primary.
+var retryableCodes = map[int]bool{
+	6:     true, // HostUnreachable
+	7:     true, // HostNotFound
+	89:    true, // NetworkTimeout
+	91:    true, // ShutdownInProgress
+	189:   true, // PrimarySteppedDown
+	262:   true, // ExceededTimeLimit
+	9001:  true, // SocketException
+	10107: true, // NotMaster
+	11600: true, // InterruptedAtShutdown
+	11602: true, // InterruptedDueToReplStateChange
+	13435: true, // NotMasterNoSlaveOk
+	13436: true, // NotMasterOrSecondary
+}
+
+// Server error codes meaning that the server is no longer the primary.
+var notMasterCodes = map[int]bool{
+	189:   true,
+	10107: true,
+	11602: true,
+	13435: true,
+	13436: true,
+}
+
+// isRetryableError returns whether err may go away by running the same
+// operation again, possibly on another server.
+func isRetryableError(err error) bool {
+	switch err {
+	case nil, context.Canceled, context.DeadlineExceeded:
+		return false
+	case io.EOF, io.ErrUnexpectedEOF:
+		return true
+	}
+	switch e := err.(type) {
+	case *QueryError:
+		return retryableCodes[e.Code] || strings.Contains(e.Message, "not master")
+	case *LastError:
+		return retryableCodes[e.Code] || strings.Contains(e.Err, "not master")
+	case net.Error:
+		return true
+	}
+	return false
+}
+
+// prepareRetry drops the sockets reserved by the session after err happened
+// talking to server, so that retrying picks a server anew.
+func (s *Session) prepareRetry(server *mongoServer, err error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.mgoCluster == nil {
+		return
+	}
+	if server != nil && isNotMasterError(err) {
+		s.mgoCluster.demoteServer(server)
+	}
+	s.unsetSocket()
+}
+
+// runWrite calls run to perform a write on socket, or on a socket acquired
+// from the primary if it's nil. Retryable writes are run with txn holding
+// the lsid and txnNumber to add to the command, and are retried once on a
+// retryable error. Others are run once with a nil txn.
+func (s *Session) runWrite(socket *mongoSocket, retryable bool, run func(socket *mongoSocket, txn bson.D) error) error {
+	if socket == nil {
+		var err error
+		socket, err = s.acquireSocket(false)
+		if err != nil {
+			return err
+		}
+		defer socket.Release()
+	}
+	if !retryable || s.dialInfo.DisableRetryWrites || !socket.ServerInfo().retryableWrites() || s.transaction() != nil {
+		// Writes in a transaction are retried with the whole transaction.
+		return run(socket, nil)
+	}
+
+	ss, err := s.startServerSession(socket)
+	if err != nil {
+		return err
+	}
+	defer s.endServerSession(ss)
+	ss.txnNumber++
+	txn := bson.D{{Name: "lsid", Value: ss.lsid()}, {Name: "txnNumber", Value: ss.txnNumber}}
+
+	err = run(socket, txn)
+	if !isRetryableError(err) {
+		return err
+	}
+	debugf("Retrying write after error: %v", err)
+	s.prepareRetry(socket.Server(), err)
+	retrySocket, rerr := s.acquireSocket(false)
+	if rerr != nil {
+		return err
+	}
+	defer retrySocket.Release()
+	if !retrySocket.ServerInfo().retryableWrites() {
+		return err
+	}
+	return run(retrySocket, txn)
+}
+
+// runRead acquires a socket and calls run to perform a read with it. If
+// that fails with a retryable error on a server that supports retryable
+// reads, it's done once more on a newly acquired socket.
+func (s *Session) runRead(run func(socket *mongoSocket) error) error {
+	for retried := false; ; retried = true {
+		socket, err := s.acquireSocket(true)
+		if err != nil {
+			return err
+		}
+		err = run(socket)
+		socket.Release()
+		if retried || s.dialInfo.DisableRetryReads || socket.ServerInfo().MaxWireVersion < opMsgMinWireVersion || !isRetryableError(err) {
+			return err
+		}
+		if s.transaction() != nil {
+			// Reads in a transaction are retried with the whole transaction.
+			return err
+		}
+		debugf("Retrying read after error: %v", err)
+		s.prepareRetry(socket.Server(), err)
+	}
+}
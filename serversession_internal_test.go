@@ -0,0 +1,261 @@
+package mgo
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	. "gopkg.in/check.v1"
+)
+
+func (s *S) TestServerSessionPool(c *C) {
+	var pool serverSessionPool
+
+	first, err := pool.get()
+	c.Assert(err, IsNil)
+	c.Assert(first.id.Kind, Equals, byte(0x04))
+	c.Assert(first.id.Data, HasLen, 16)
+	c.Assert(first.id.Data[6]>>4, Equals, byte(4))
+
+	second, err := pool.get()
+	c.Assert(err, IsNil)
+	c.Assert(second.id.Data, Not(DeepEquals), first.id.Data)
+
+	// The most recently used session is reused first.
+	first.timeout, first.lastUse = 30*time.Minute, time.Now().Add(-time.Minute)
+	second.timeout, second.lastUse = 30*time.Minute, time.Now()
+	pool.put(first)
+	pool.put(second)
+	ss, err := pool.get()
+	c.Assert(err, IsNil)
+	c.Assert(ss, Equals, second)
+
+	// Sessions about to expire on the server are dropped.
+	first.lastUse = time.Now().Add(-29*time.Minute - time.Second)
+	ss, err = pool.get()
+	c.Assert(err, IsNil)
+	c.Assert(ss, Not(Equals), first)
+	c.Assert(pool.sessions, HasLen, 0)
+
+	pool.put(first)
+	c.Assert(pool.sessions, HasLen, 0)
+}
+
+func (s *S) TestIsRetryableError(c *C) {
+	retryable := []error{
+		io.EOF,
+		io.ErrUnexpectedEOF,
+		&net.OpError{Op: "read", Err: errors.New("connection reset")},
+		&QueryError{Code: 10107, Message: "not master"},
+		&QueryError{Code: 189, Message: "primary stepped down"},
+		&QueryError{Message: "not master and slaveOk=false"},
+		&LastError{Code: 11600, Err: "interrupted at shutdown"},
+	}
+	for _, err := range retryable {
+		c.Assert(isRetryableError(err), Equals, true, Commentf("error: %#v", err))
+	}
+	notRetryable := []error{
+		nil,
+		context.Canceled,
+		context.DeadlineExceeded,
+		ErrNotFound,
+		&QueryError{Code: 11000, Message: "E11000 duplicate key error"},
+		&LastError{Code: 2, Err: "bad value"},
+	}
+	for _, err := range notRetryable {
+		c.Assert(isRetryableError(err), Equals, false, Commentf("error: %#v", err))
+	}
+}
+
+func (s *S) TestIsRetryableWrite(c *C) {
+	c.Assert(isRetryableWrite(&insertOp{}), Equals, true)
+	c.Assert(isRetryableWrite(&updateOp{}), Equals, true)
+	c.Assert(isRetryableWrite(&updateOp{Multi: true}), Equals, false)
+	c.Assert(isRetryableWrite(&deleteOp{Limit: 1}), Equals, true)
+	c.Assert(isRetryableWrite(&deleteOp{Limit: 0}), Equals, false)
+	c.Assert(isRetryableWrite(bulkUpdateOp{&updateOp{}, &updateOp{Upsert: true}}), Equals, true)
+	c.Assert(isRetryableWrite(bulkUpdateOp{&updateOp{}, &updateOp{Multi: true}}), Equals, false)
+	c.Assert(isRetryableWrite(bulkDeleteOp{&deleteOp{Limit: 1}, &deleteOp{Limit: 0}}), Equals, false)
+}
+
+func (s *S) TestWriteConcernOf(c *C) {
+	c.Assert(writeConcernOf(nil), DeepEquals, bson.D{{Name: "w", Value: 0}})
+
+	safeOp := &queryOp{query: &getLastError{CmdName: 1, W: "majority", WTimeout: 100}}
+	data, err := bson.Marshal(writeConcernOf(safeOp))
+	c.Assert(err, IsNil)
+	var wc bson.D
+	c.Assert(bson.Unmarshal(data, &wc), IsNil)
+	c.Assert(wc, DeepEquals, bson.D{{Name: "w", Value: "majority"}, {Name: "wtimeout", Value: 100}})
+	c.Assert(safeOp.query.(*getLastError).CmdName, Equals, 1)
+}
+
+func (s *S) TestMsgSessionFields(c *C) {
+	lsid := bson.D{{Name: "id", Value: bson.Binary{Kind: 0x04, Data: make([]byte, 16)}}}
+	op := &queryOp{
+		collection:    "db.$cmd",
+		query:         bson.D{{Name: "findAndModify", Value: "coll"}},
+		sessionFields: bson.D{{Name: "lsid", Value: lsid}, {Name: "txnNumber", Value: int64(1)}},
+	}
+	b, err := addMsg(nil, op.finalMsg())
+	c.Assert(err, IsNil)
+	setInt32(b, 0, int32(len(b)))
+	_, doc, err := parseMsg(b[:16], b[16:])
+	c.Assert(err, IsNil)
+
+	var body bson.D
+	c.Assert(bson.Unmarshal(doc, &body), IsNil)
+	c.Assert(body, DeepEquals, bson.D{
+		{Name: "findAndModify", Value: "coll"},
+		{Name: "$db", Value: "db"},
+		{Name: "lsid", Value: lsid},
+		{Name: "txnNumber", Value: int64(1)},
+	})
+}
+
+func (s *S) TestRetryableWritesSupport(c *C) {
+	info := &mongoServerInfo{MaxWireVersion: 6, LogicalSessionTimeout: 30 * time.Minute, SetName: "rs0"}
+	c.Assert(info.retryableWrites(), Equals, true)
+	info = &mongoServerInfo{MaxWireVersion: 6, LogicalSessionTimeout: 30 * time.Minute, Mongos: true}
+	c.Assert(info.retryableWrites(), Equals, true)
+	info = &mongoServerInfo{MaxWireVersion: 6, LogicalSessionTimeout: 30 * time.Minute}
+	c.Assert(info.retryableWrites(), Equals, false)
+	info = &mongoServerInfo{MaxWireVersion: 6, SetName: "rs0"}
+	c.Assert(info.retryableWrites(), Equals, false)
+	info = &mongoServerInfo{MaxWireVersion: 5, LogicalSessionTimeout: 30 * time.Minute, SetName: "rs0"}
+	c.Assert(info.retryableWrites(), Equals, false)
+}
+
+func (s *S) TestParseURLRetryOptions(c *C) {
+	info, err := ParseURL("localhost:27017")
+	c.Assert(err, IsNil)
+	c.Assert(info.DisableRetryWrites, Equals, false)
+	c.Assert(info.DisableRetryReads, Equals, false)
+
+	info, err = ParseURL("localhost:27017?retryWrites=false&retryReads=false")
+	c.Assert(err, IsNil)
+	c.Assert(info.DisableRetryWrites, Equals, true)
+	c.Assert(info.DisableRetryReads, Equals, true)
+	c.Assert(info.Copy().DisableRetryWrites, Equals, true)
+	c.Assert(info.Copy().DisableRetryReads, Equals, true)
+
+	_, err = ParseURL("localhost:27017?retryWrites=maybe")
+	c.Assert(err, ErrorMatches, "bad value for retryWrites: maybe")
+}
+
+func (s *S) TestPipelineWrites(c *C) {
+	c.Assert(pipelineWrites([]bson.M{{"$match": bson.M{}}}), Equals, false)
+	c.Assert(pipelineWrites([]bson.M{{"$match": bson.M{}}, {"$out": "other"}}), Equals, true)
+	c.Assert(pipelineWrites([]bson.D{{{Name: "$merge", Value: bson.M{"into": "other"}}}}), Equals, true)
+	c.Assert(pipelineWrites([]interface{}{}), Equals, false)
+	c.Assert(pipelineWrites(nil), Equals, false)
+}
+
+// fakeCommandServer answers every command sent through conn, over OP_QUERY
+// or OP_MSG, with the document reply returns for its body, until conn is
+// closed. isMaster is answered as by the primary of a replica set that
+// supports retryable writes.
+func fakeCommandServer(conn net.Conn, reply func(body bson.D) interface{}) {
+	defer conn.Close()
+	for {
+		h := make([]byte, 16)
+		if err := fill(conn, h); err != nil {
+			return
+		}
+		b := make([]byte, getInt32(h, 0)-16)
+		if err := fill(conn, b); err != nil {
+			return
+		}
+		var doc []byte
+		if getInt32(h, 12) == 2013 {
+			_, msgDoc, err := parseMsg(h, b)
+			if err != nil {
+				return
+			}
+			doc = msgDoc
+		} else {
+			// Skip the flags, the collection name, and the number of
+			// documents to skip and to return.
+			i := 4
+			for b[i] != 0 {
+				i++
+			}
+			doc = b[i+9:]
+		}
+		var body bson.D
+		if bson.Unmarshal(doc, &body) != nil || len(body) == 0 {
+			return
+		}
+		var r interface{}
+		switch body[0].Name {
+		case "isMaster", "ismaster":
+			r = bson.M{
+				"ok": 1, "ismaster": true, "maxWireVersion": 7, "setName": "rs0",
+				"hosts": []string{"127.0.0.1:27017"}, "me": "127.0.0.1:27017",
+				"logicalSessionTimeoutMinutes": 30,
+			}
+		default:
+			r = reply(body)
+		}
+		if getInt32(h, 12) == 2013 {
+			if fakeMsgReply(conn, h, r) != nil {
+				return
+			}
+			continue
+		}
+		out := addHeader(nil, 1)
+		out = addInt32(out, 0) // Response flags
+		out = addInt64(out, 0) // Cursor id
+		out = addInt32(out, 0) // Starting from
+		out = addInt32(out, 1) // Number returned
+		out, _ = addBSON(out, r)
+		setInt32(out, 0, int32(len(out)))
+		setInt32(out, 8, getInt32(h, 4))
+		if _, err := conn.Write(out); err != nil {
+			return
+		}
+	}
+}
+
+func (s *S) TestApplyUnsafeNotRetryable(c *C) {
+	cmds := make(chan bson.D, 10)
+	info := &DialInfo{
+		Addrs:   []string{"127.0.0.1:27017"},
+		Direct:  true,
+		Timeout: 5 * time.Second,
+		DialServer: func(addr *ServerAddr) (net.Conn, error) {
+			client, server := net.Pipe()
+			go fakeCommandServer(server, func(body bson.D) interface{} {
+				if body[0].Name == "findAndModify" {
+					cmds <- body
+					return bson.M{"ok": 1, "value": bson.M{"n": 1}, "lastErrorObject": bson.M{"n": 1, "updatedExisting": true}}
+				}
+				return bson.M{"ok": 1}
+			})
+			return client, nil
+		},
+	}
+	session, err := DialWithInfo(info)
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("db").C("coll")
+	for _, safe := range []*Safe{{}, nil} {
+		session.SetSafe(safe)
+		_, err = coll.Find(bson.M{"n": 1}).Apply(Change{Update: bson.M{"$inc": bson.M{"n": 1}}}, nil)
+		c.Assert(err, IsNil)
+		body := <-cmds
+		var retried bool
+		for _, elem := range body {
+			if elem.Name == "txnNumber" {
+				retried = true
+			}
+		}
+		// Unacknowledged writes are sent without a transaction number, as
+		// they can't be retried.
+		c.Assert(retried, Equals, safe != nil, Commentf("safe: %#v", safe))
+	}
+}
@@ -27,6 +27,8 @@
 package mgo
 
 import (
+	"compress/zlib"
+	"context"
 	"crypto/md5"
 	"crypto/tls"
 	"crypto/x509"
@@ -108,9 +110,18 @@ type Session struct {
 	m                sync.RWMutex
 	queryConfig      query
 	bypassValidation bool
+	strictReplace    bool
 	slaveOk          bool
 
 	dialInfo *DialInfo
+
+	// serverSession is the logical session that retryable writes are run
+	// with, taken from the cluster pool on first use and returned on Close.
+	// It's busy while a write runs with it.
+	serverSession     *serverSession
+	serverSessionBusy bool
+
+	txn *transaction // See StartTransaction.
 }
 
 // Database holds collections of documents
@@ -121,6 +132,9 @@ type Session struct {
 type Database struct {
 	Session *Session
 	Name    string
+
+	ctx    context.Context // See WithContext.
+	safeOp *queryOp        // Safety mode of writes in place of the session's one. See withSafe.
 }
 
 // Collection stores documents
@@ -177,6 +191,10 @@ type Iter struct {
 	isFindCmd      bool
 	isChangeStream bool
 	maxTimeMS      int64
+	canceled       bool        // The context was done before the iteration ended.
+	stopCtx        func() bool // Stops watching the context.
+	retry          func(error) // Retries the query if its first reply fails.
+	sessionFields  bson.D      // Sent with getMore in transactions.
 }
 
 var (
@@ -224,6 +242,15 @@ const (
 //
 // If the port number is not provided for a server, it defaults to 27017.
 //
+// Alternatively, the seed servers may be looked up in DNS, as listed in the
+// SRV records for _mongodb._tcp.<host>:
+//
+//	mongodb+srv://[user:pass@]host[/database][?options]
+//
+// In that case TLS is enabled unless tls=false is given, and the authSource
+// and replicaSet options may also be provided by a TXT record for the host.
+// See DialInfo.SRVName for details.
+//
 // The username and password provided in the URL will be used to authenticate
 // into the database named after the slash at the end of the host names, or into
 // the "admin" database if none is provided.  The authentication information
@@ -262,7 +289,10 @@ const (
 //	   authMechanism=<mechanism>
 //
 //	      Defines the protocol for credential negotiation. Defaults to "MONGODB-CR",
-//	      which is the default username/password challenge-response mechanism.
+//	      which is the default username/password challenge-response mechanism,
+//	      on servers older than 3.0. Newer servers are asked which of
+//	      "SCRAM-SHA-256" and "SCRAM-SHA-1" the user supports, and the former
+//	      is preferred.
 //
 //
 //	   gssapiServiceName=<name>
@@ -291,11 +321,64 @@ const (
 //	      The identifier of this client application. This parameter is used to
 //	      annotate logs / profiler output and cannot exceed 128 bytes.
 //
-//	   ssl=<true|false>
+//	   tls=<true|false>, ssl=<true|false>
 //
 //	      true: Initiate the connection with TLS/SSL.
 //	      false: Initiate the connection without TLS/SSL.
-//	      The default value is false.
+//	      The default value is false, unless any of the options below is set.
+//
+//	   tlsCAFile=<path>
+//
+//	      The PEM file with the certificate authorities to trust, instead of
+//	      those of the system.
+//
+//	   tlsCertificateKeyFile=<path>
+//
+//	      The PEM file with the client certificate and its private key.
+//
+//	   tlsCertificateKeyFilePassword=<password>
+//
+//	      The password to decrypt the private key in tlsCertificateKeyFile.
+//
+//	   tlsInsecure=<true|false>
+//
+//	      Skips verifying the server certificate altogether. Never use this
+//	      in production.
+//
+//	   tlsAllowInvalidHostnames=<true|false>
+//
+//	      Verifies the server certificate, but not that it matches the
+//	      server hostname.
+//
+//	   compressors=<compressor1>[,<compressor2>,...]
+//
+//	      The wire protocol compressors to use with servers that support them,
+//	      in order of preference. The supported compressors are snappy, zlib
+//	      and zstd. By default, messages are not compressed.
+//
+//	   zlibCompressionLevel=<level>
+//
+//	      The compression level of the zlib compressor, from 0 (no
+//	      compression) and 1 (best speed) to 9 (best compression), or -1
+//	      for zlib's default level, which is used if unset.
+//
+//	   retryWrites=<true|false>
+//
+//	      Whether single-document writes are retried once after a network
+//	      error or a primary stepdown, on replica sets and sharded clusters
+//	      with MongoDB 3.6+. Defaults to true.
+//
+//	   retryReads=<true|false>
+//
+//	      Whether find, aggregate, count and distinct are retried once after
+//	      a network error or a primary stepdown, with MongoDB 3.6+. Defaults
+//	      to true.
+//
+//	   backend=<auto|legacy|modern>
+//
+//	      The implementation behind the sessions of DialAPI, which by
+//	      default is picked for the server version. Dial itself always uses
+//	      the legacy one. See Backend.
 //
 // Relevant documentation:
 //
@@ -333,7 +416,7 @@ func ParseURL(url string) (*DialInfo, error) {
 	if err != nil {
 		return nil, err
 	}
-	ssl := false
+	var tlsOpts tlsOptions
 	direct := false
 	mechanism := ""
 	service := ""
@@ -345,13 +428,20 @@ func ParseURL(url string) (*DialInfo, error) {
 	var readPreferenceTagSets []bson.D
 	minPoolSize := 0
 	maxIdleTimeMS := 0
+	var compressors []string
+	var zlibCompressionLevel *int
+	retryWrites := true
+	retryReads := true
+	var backend Backend
 	safe := Safe{}
 	for _, opt := range uinfo.options {
-		switch opt.key {
-		case "ssl":
-			if v, err := strconv.ParseBool(opt.value); err == nil && v {
-				ssl = true
+		if ok, err := tlsOpts.set(opt.key, opt.value); ok {
+			if err != nil {
+				return nil, err
 			}
+			continue
+		}
+		switch opt.key {
 		case "authSource":
 			source = opt.value
 		case "authMechanism":
@@ -434,6 +524,36 @@ func ParseURL(url string) (*DialInfo, error) {
 			if maxIdleTimeMS < 0 {
 				return nil, errors.New("bad value (negative) for maxIdleTimeMS: " + opt.value)
 			}
+		case "compressors":
+			for _, name := range strings.Split(opt.value, ",") {
+				if _, ok := compressorIds[name]; !ok {
+					return nil, errors.New("unsupported compressor: " + name)
+				}
+				compressors = append(compressors, name)
+			}
+		case "zlibCompressionLevel":
+			level, err := strconv.Atoi(opt.value)
+			if err != nil || level < -1 || level > 9 {
+				return nil, errors.New("bad value for zlibCompressionLevel: " + opt.value)
+			}
+			zlibCompressionLevel = &level
+		case "retryWrites":
+			retryWrites, err = strconv.ParseBool(opt.value)
+			if err != nil {
+				return nil, errors.New("bad value for retryWrites: " + opt.value)
+			}
+		case "retryReads":
+			retryReads, err = strconv.ParseBool(opt.value)
+			if err != nil {
+				return nil, errors.New("bad value for retryReads: " + opt.value)
+			}
+		case "backend":
+			switch Backend(opt.value) {
+			case AutoBackend, LegacyBackend, ModernBackend:
+				backend = Backend(opt.value)
+			default:
+				return nil, errors.New("bad value for backend: " + opt.value)
+			}
 		case "connect":
 			if opt.value == "direct" {
 				direct = true
@@ -452,6 +572,27 @@ func ParseURL(url string) (*DialInfo, error) {
 		return nil, errors.New("readPreferenceTagSet may not be specified when readPreference is primary")
 	}
 
+	srvName := ""
+	if uinfo.srv {
+		if direct {
+			return nil, errors.New("mongodb+srv URLs can't be used with connect=direct")
+		}
+		srvName = uinfo.addrs[0]
+		if err := validateSRVName(strings.Join(uinfo.addrs, ",")); err != nil {
+			return nil, err
+		}
+		uinfo.addrs = nil
+		if !tlsOpts.disabled {
+			// TLS is on by default for SRV seed lists.
+			tlsOpts.enabled = true
+		}
+	}
+
+	tlsConfig, err := tlsOpts.config()
+	if err != nil {
+		return nil, err
+	}
+
 	info := DialInfo{
 		Addrs:     uinfo.addrs,
 		Direct:    direct,
@@ -471,13 +612,14 @@ func ParseURL(url string) (*DialInfo, error) {
 		ReplicaSetName: setName,
 		MinPoolSize:    minPoolSize,
 		MaxIdleTimeMS:  maxIdleTimeMS,
-	}
-	if ssl && info.DialServer == nil {
-		// Set DialServer only if nil, we don't want to override user's settings.
-		info.DialServer = func(addr *ServerAddr) (net.Conn, error) {
-			conn, err := tls.Dial("tcp", addr.String(), &tls.Config{})
-			return conn, err
-		}
+
+		Compressors:          compressors,
+		ZlibCompressionLevel: zlibCompressionLevel,
+		TLSConfig:            tlsConfig,
+		SRVName:              srvName,
+		DisableRetryWrites:   !retryWrites,
+		DisableRetryReads:    !retryReads,
+		Backend:              backend,
 	}
 	return &info, nil
 }
@@ -520,7 +662,8 @@ type DialInfo struct {
 	ServiceHost string
 
 	// Mechanism defines the protocol for credential negotiation.
-	// Defaults to "MONGODB-CR".
+	// Defaults to "MONGODB-CR" on servers older than 3.0, and to the
+	// strongest SCRAM mechanism supported for Username otherwise.
 	Mechanism string
 
 	// Username and Password inform the credentials for the initial authentication
@@ -590,6 +733,54 @@ type DialInfo struct {
 	// before being removed and closed.
 	MaxIdleTimeMS int
 
+	// Compressors lists the wire protocol compressors that may be used
+	// to talk to the servers, in order of preference. The supported ones
+	// are "snappy", "zlib" and "zstd", and a server only uses those that
+	// it supports as well. Compression is disabled if none is agreed on.
+	Compressors []string
+
+	// ZlibCompressionLevel defines the level used by the zlib compressor,
+	// from 0 (no compression) and 1 (best speed) to 9 (best compression),
+	// or -1 for zlib's own default level. Defaults to zlib's default level
+	// if nil.
+	ZlibCompressionLevel *int
+
+	// DisableRetryWrites turns off retrying single-document writes once
+	// after a network error or a primary stepdown. Retryable writes need
+	// MongoDB 3.6+ on a replica set or sharded cluster.
+	DisableRetryWrites bool
+
+	// DisableRetryReads turns off retrying find, aggregate, count and
+	// distinct once after a network error or a primary stepdown.
+	DisableRetryReads bool
+
+	// SRVName, if set, is the name behind a mongodb+srv URL. The seed
+	// servers in Addrs are then replaced on dial by those listed in the
+	// SRV records for "_mongodb._tcp.<SRVName>", and are looked up again
+	// periodically afterwards. The TXT record for SRVName may provide
+	// Source and ReplicaSetName, unless they are set already.
+	SRVName string
+
+	// Resolver looks up the SRV and TXT records for SRVName. Defaults to
+	// net.DefaultResolver.
+	Resolver Resolver
+
+	// TLSConfig, if set, has connections with the MongoDB servers secured
+	// with TLS using the given configuration. The server name is taken
+	// from the server address unless set in the configuration. TLSConfig
+	// is ignored when DialServer or Dial are set, as these are expected to
+	// establish TLS themselves.
+	//
+	// When the configuration holds a client certificate, authenticating
+	// with the MONGODB-X509 mechanism needs no Username, as the subject of
+	// the certificate is used.
+	TLSConfig *tls.Config
+
+	// Backend selects the implementation behind the sessions obtained
+	// with DialAPIWithInfo, and defaults to AutoBackend. It's ignored by
+	// DialWithInfo and DialModernWithInfo, which have one of their own.
+	Backend Backend
+
 	// DialServer optionally specifies the dial function for establishing
 	// connections with the MongoDB servers.
 	DialServer func(addr *ServerAddr) (net.Conn, error)
@@ -631,11 +822,24 @@ func (i *DialInfo) Copy() *DialInfo {
 		MaxIdleTimeMS:  i.MaxIdleTimeMS,
 		DialServer:     i.DialServer,
 		Dial:           i.Dial,
+
+		ZlibCompressionLevel: i.ZlibCompressionLevel,
+		TLSConfig:            i.TLSConfig,
+		SRVName:              i.SRVName,
+		Resolver:             i.Resolver,
+		DisableRetryWrites:   i.DisableRetryWrites,
+		DisableRetryReads:    i.DisableRetryReads,
+		Backend:              i.Backend,
 	}
 
 	info.Addrs = make([]string, len(i.Addrs))
 	copy(info.Addrs, i.Addrs)
 
+	if i.Compressors != nil {
+		info.Compressors = make([]string, len(i.Compressors))
+		copy(info.Compressors, i.Compressors)
+	}
+
 	return info
 }
 
@@ -671,6 +875,15 @@ func (i *DialInfo) poolLimit() int {
 	return i.PoolLimit
 }
 
+// zlibCompressionLevel returns the configured zlib compression level, or
+// zlib.DefaultCompression.
+func (i *DialInfo) zlibCompressionLevel() int {
+	if i.ZlibCompressionLevel == nil {
+		return zlib.DefaultCompression
+	}
+	return *i.ZlibCompressionLevel
+}
+
 // ReadPreference defines the manner in which servers are chosen.
 type ReadPreference struct {
 	// Mode determines the consistency of results. See Session.SetMode.
@@ -706,16 +919,13 @@ func DialWithInfo(dialInfo *DialInfo) (*Session, error) {
 	info.ReadTimeout = info.readTimeout()
 	info.WriteTimeout = info.writeTimeout()
 
-	addrs := make([]string, len(info.Addrs))
-	for i, addr := range info.Addrs {
-		p := strings.LastIndexAny(addr, "]:")
-		if p == -1 || addr[p] != ':' {
-			// XXX This is untested. The test suite doesn't use the standard port.
-			addr += ":27017"
+	if info.SRVName != "" {
+		if err := info.resolveSRV(); err != nil {
+			return nil, err
 		}
-		addrs[i] = addr
 	}
-	cluster := newCluster(addrs, info)
+
+	cluster := newCluster(seedAddrs(info.Addrs), info)
 	session := newSession(Eventual, cluster, info)
 	session.defaultdb = info.Database
 	if session.defaultdb == "" {
@@ -728,7 +938,7 @@ func DialWithInfo(dialInfo *DialInfo) (*Session, error) {
 			session.sourcedb = "admin"
 		}
 	}
-	if info.Username != "" {
+	if info.Username != "" || info.Mechanism == "MONGODB-X509" {
 		source := session.sourcedb
 		if info.Source == "" &&
 			(info.Mechanism == "GSSAPI" || info.Mechanism == "PLAIN" || info.Mechanism == "MONGODB-X509") {
@@ -770,11 +980,26 @@ func DialWithInfo(dialInfo *DialInfo) (*Session, error) {
 	return session, nil
 }
 
+// seedAddrs returns addrs with the default port added where missing.
+func seedAddrs(addrs []string) []string {
+	seeds := make([]string, len(addrs))
+	for i, addr := range addrs {
+		p := strings.LastIndexAny(addr, "]:")
+		if p == -1 || addr[p] != ':' {
+			// XXX This is untested. The test suite doesn't use the standard port.
+			addr += ":27017"
+		}
+		seeds[i] = addr
+	}
+	return seeds
+}
+
 func isOptSep(c rune) bool {
 	return c == ';' || c == '&'
 }
 
 type urlInfo struct {
+	srv     bool
 	addrs   []string
 	user    string
 	pass    string
@@ -788,8 +1013,12 @@ type urlInfoOption struct {
 }
 
 func extractURL(s string) (*urlInfo, error) {
-	s = strings.TrimPrefix(s, "mongodb://")
 	info := &urlInfo{options: []urlInfoOption{}}
+	if strings.HasPrefix(s, "mongodb+srv://") {
+		info.srv = true
+		s = strings.TrimPrefix(s, "mongodb+srv://")
+	}
+	s = strings.TrimPrefix(s, "mongodb://")
 
 	if c := strings.Index(s, "?"); c != -1 {
 		for _, pair := range strings.FieldsFunc(s[c+1:], isOptSep) {
@@ -871,6 +1100,7 @@ func copySession(session *Session, keepCreds bool) (s *Session) {
 		m:                sync.RWMutex{},
 		queryConfig:      session.queryConfig,
 		bypassValidation: session.bypassValidation,
+		strictReplace:    session.strictReplace,
 		slaveOk:          session.slaveOk,
 		dialInfo:         session.dialInfo,
 	}
@@ -899,7 +1129,7 @@ func (s *Session) DB(name string) *Database {
 	if name == "" {
 		name = s.defaultdb
 	}
-	return &Database{s, name}
+	return &Database{Session: s, Name: name}
 }
 
 // C returns a value representing the named collection.
@@ -949,6 +1179,49 @@ func (c *Collection) With(s *Session) *Collection {
 	return &newc
 }
 
+// WithContext returns a copy of db whose operations are bound to ctx, as are
+// those of the collections, queries, pipes and iterators obtained from it.
+//
+// Sending a request fails with ctx.Err() if ctx is done already, and may
+// take no longer than the ctx deadline. Operations waiting for a reply
+// return ctx.Err() as soon as ctx is done, while the reply is dropped
+// once it arrives, so the socket remains usable. Iterators also kill
+// their server cursor at that point.
+//
+// Waiting for a server to become available in the cluster is still bound
+// by the session sync timeout, rather than by ctx.
+func (db *Database) WithContext(ctx context.Context) *Database {
+	if ctx == nil {
+		panic("nil context")
+	}
+	newdb := *db
+	newdb.ctx = ctx
+	return &newdb
+}
+
+// withSafe returns a copy of c whose writes use the safety mode safe
+// rather than the one of the session, or c itself if safe is nil.
+func (c *Collection) withSafe(safe *Safe) *Collection {
+	if safe == nil {
+		return c
+	}
+	var s Session
+	s.ensureSafe(safe)
+	newdb := *c.Database
+	newdb.safeOp = s.safeOp
+	newc := *c
+	newc.Database = &newdb
+	return &newc
+}
+
+// WithContext returns a copy of c whose operations are bound to ctx.
+// See Database.WithContext for details.
+func (c *Collection) WithContext(ctx context.Context) *Collection {
+	newc := *c
+	newc.Database = c.Database.WithContext(ctx)
+	return &newc
+}
+
 // GridFS returns a GridFS value representing collections in db that
 // follow the standard GridFS specification.
 // The provided prefix (sometimes known as root) will determine which
@@ -963,7 +1236,13 @@ func (c *Collection) With(s *Session) *Collection {
 //	http://www.mongodb.org/display/DOCS/GridFS+Tools
 //	http://www.mongodb.org/display/DOCS/GridFS+Specification
 func (db *Database) GridFS(prefix string) *GridFS {
-	return newGridFS(db, prefix)
+	return newGridFS(db, prefix, GridFSOptions{})
+}
+
+// GridFSWithOptions is like GridFS, but the GridFS uses the chunk size and
+// safety mode in opts.
+func (db *Database) GridFSWithOptions(prefix string, opts GridFSOptions) *GridFS {
+	return newGridFS(db, prefix, opts)
 }
 
 // Run issues the provided command on the db database and unmarshals
@@ -996,6 +1275,22 @@ func (db *Database) Run(cmd interface{}, result interface{}) error {
 	return db.run(socket, cmd, result)
 }
 
+// runRead does the same as Run, but runs cmd as a retryable read, which
+// is retried once after a network error or a primary stepdown.
+func (db *Database) runRead(cmd interface{}, result interface{}) error {
+	return db.Session.runRead(func(socket *mongoSocket) error {
+		return db.run(socket, cmd, result)
+	})
+}
+
+// contextDB returns the named database of session, bound to ctx if it's
+// not nil. It's used by queries that run commands on their behalf.
+func contextDB(session *Session, name string, ctx context.Context) *Database {
+	db := session.DB(name)
+	db.ctx = ctx
+	return db
+}
+
 // runOnSocket does the same as Run, but guarantees that your command will be run
 // on the provided socket instance; if it's unhealthy, you will receive the error
 // from it.
@@ -1027,7 +1322,9 @@ type Credential struct {
 	ServiceHost string
 
 	// Mechanism defines the protocol for credential negotiation.
-	// Defaults to "MONGODB-CR".
+	// Defaults to "MONGODB-CR" on servers older than 3.0, and to
+	// "SCRAM-SHA-1" otherwise, unless this is the credential provided
+	// on dial and the server reported that it supports "SCRAM-SHA-256".
 	Mechanism string
 
 	// Certificate sets the x509 certificate for authentication, see:
@@ -1310,17 +1607,36 @@ func isNotFound(err error) bool {
 	return ok && e.Code == 11
 }
 
+func isNsNotFound(err error) bool {
+	e, ok := err.(*QueryError)
+	return ok && (e.Code == 26 || e.Message == "ns not found")
+}
+
 func isAuthError(err error) bool {
 	e, ok := err.(*QueryError)
 	return ok && e.Code == 13
 }
 
 func isNotMasterError(err error) bool {
-	e, ok := err.(*QueryError)
-	return ok && strings.Contains(e.Message, "not master")
+	switch e := err.(type) {
+	case *QueryError:
+		return notMasterCodes[e.Code] || strings.Contains(e.Message, "not master")
+	case *LastError:
+		return notMasterCodes[e.Code] || strings.Contains(e.Err, "not master")
+	}
+	return false
 }
 
 func (db *Database) runUserCmd(cmdName string, user *User) error {
+	err := db.Run(userCmd(cmdName, user), nil)
+	if !isNoCmd(err) && user.UserSource != "" && (user.UserSource != "$external" || db.Name != "$external") {
+		return fmt.Errorf("MongoDB 2.6+ does not support the UserSource setting")
+	}
+	return err
+}
+
+// userCmd returns the cmdName command, createUser or updateUser, for user.
+func userCmd(cmdName string, user *User) bson.D {
 	cmd := make(bson.D, 0, 16)
 	cmd = append(cmd, bson.DocElem{Name: cmdName, Value: user.Username})
 	if user.Password != "" {
@@ -1338,11 +1654,7 @@ func (db *Database) runUserCmd(cmdName string, user *User) error {
 	if roles != nil || user.Roles != nil || cmdName == "createUser" {
 		cmd = append(cmd, bson.DocElem{Name: "roles", Value: roles})
 	}
-	err := db.Run(cmd, nil)
-	if !isNoCmd(err) && user.UserSource != "" && (user.UserSource != "$external" || db.Name != "$external") {
-		return fmt.Errorf("MongoDB 2.6+ does not support the UserSource setting")
-	}
-	return err
+	return cmd
 }
 
 // AddUser creates or updates the authentication credentials of user within
@@ -1872,6 +2184,7 @@ func (s *Session) nonEventual() *Session {
 	if cloned.consistency == Eventual {
 		cloned.SetMode(Monotonic, false)
 	}
+	cloned.txn = s.transaction()
 	return cloned
 }
 
@@ -2046,10 +2359,17 @@ func (s *Session) Clone() *Session {
 // Close terminates the session.  It's a runtime error to use a session
 // after it has been closed.
 func (s *Session) Close() {
+	if txn := s.transaction(); txn != nil && txn.session == s {
+		s.AbortTransaction()
+	}
 	s.m.Lock()
 	if s.mgoCluster != nil {
 		debugf("Closing session %p", s)
 		s.unsetSocket()
+		if s.serverSession != nil && !s.serverSessionBusy {
+			s.mgoCluster.sessionPool.put(s.serverSession)
+		}
+		s.serverSession = nil
 		s.mgoCluster.Release()
 		s.mgoCluster = nil
 	}
@@ -2226,6 +2546,33 @@ func (s *Session) SetBypassValidation(bypass bool) {
 	s.m.Unlock()
 }
 
+// SetStrictReplace sets whether update documents holding no update operators
+// replace the documents they apply to, as in the original mgo, rather than
+// being wrapped in a $set operator by Upsert, by Apply on upserts and by
+// Bulk.Upsert. With the $set wrapping, which is the default, fields missing
+// from the given document are kept in the stored one.
+//
+// Replace, ReplaceId, ReplaceOne and the Replace field of Change always
+// replace documents, whatever the mode.
+func (s *Session) SetStrictReplace(strict bool) {
+	s.m.Lock()
+	s.strictReplace = strict
+	s.m.Unlock()
+}
+
+// updateDocument returns update as sent to the server for Upsert and the
+// like, wrapped in a $set operator unless the session is in strict replace
+// mode. See SetStrictReplace.
+func (s *Session) updateDocument(update interface{}) interface{} {
+	s.m.RLock()
+	strict := s.strictReplace
+	s.m.RUnlock()
+	if strict {
+		return update
+	}
+	return wrapInSetOperator(update)
+}
+
 // SetBatch sets the default batch size used when fetching documents from the
 // database. It's possible to change this setting on a per-query basis as
 // well, using the Query.Batch method.
@@ -2582,6 +2929,7 @@ func (c *Collection) Find(query interface{}) *Query {
 	session.m.RUnlock()
 	q.op.query = query
 	q.op.collection = c.FullName
+	q.op.ctx = c.Database.ctx
 	return q
 }
 
@@ -2710,11 +3058,15 @@ func (p *Pipe) Iter() *Iter {
 	if p.maxTimeMS > 0 {
 		cmd.MaxTimeMS = p.maxTimeMS
 	}
-	err := c.Database.Run(cmd, &result)
+	run := c.Database.runRead
+	if pipelineWrites(p.pipeline) {
+		run = c.Database.Run
+	}
+	err := run(cmd, &result)
 	if e, ok := err.(*QueryError); ok && e.Message == `unrecognized field "cursor` {
 		cmd.Cursor = nil
 		cmd.AllowDisk = false
-		err = c.Database.Run(cmd, &result)
+		err = run(cmd, &result)
 	}
 	firstBatch := result.Result
 	if firstBatch == nil {
@@ -2727,6 +3079,26 @@ func (p *Pipe) Iter() *Iter {
 	return it
 }
 
+// pipelineWrites returns whether pipeline ends with an $out or $merge
+// stage, which makes it unsafe to retry.
+func pipelineWrites(pipeline interface{}) bool {
+	v := reflect.ValueOf(pipeline)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array || v.Len() == 0 {
+		return false
+	}
+	data, err := bson.Marshal(v.Index(v.Len() - 1).Interface())
+	if err != nil {
+		return false
+	}
+	var stage bson.M
+	if bson.Unmarshal(data, &stage) != nil {
+		return false
+	}
+	_, out := stage["$out"]
+	_, merge := stage["$merge"]
+	return out || merge
+}
+
 // NewIter returns a newly created iterator with the provided parameters. Using
 // this method is not recommended unless the desired functionality is not yet
 // exposed via a more convenient interface (Find, Pipe, etc).
@@ -2788,6 +3160,9 @@ func (c *Collection) NewIter(session *Session, firstBatch []bson.Raw, cursorId i
 	if socket.ServerInfo().MaxWireVersion >= 4 && c.FullName != "admin.$cmd" {
 		iter.isFindCmd = true
 	}
+	if txn := session.transaction(); txn != nil {
+		iter.sessionFields = txn.cursorFields()
+	}
 
 	iter.gotReply.L = &iter.m
 	for _, doc := range firstBatch {
@@ -2801,6 +3176,7 @@ func (c *Collection) NewIter(session *Session, firstBatch []bson.Raw, cursorId i
 		iter.op.collection = c.FullName
 		iter.op.replyFunc = iter.replyFunc()
 	}
+	iter.watchContext(c.Database.ctx)
 	return iter
 }
 
@@ -2887,6 +3263,13 @@ func (p *Pipe) Batch(n int) *Pipe {
 	return p
 }
 
+// WithContext binds the pipeline and its iterator to ctx.
+// See Database.WithContext for details.
+func (p *Pipe) WithContext(ctx context.Context) *Pipe {
+	p.collection = p.collection.WithContext(ctx)
+	return p
+}
+
 // SetMaxTime sets the maximum amount of time to allow the query to run.
 func (p *Pipe) SetMaxTime(d time.Duration) *Pipe {
 	p.maxTimeMS = int64(d / time.Millisecond)
@@ -2936,7 +3319,8 @@ type queryError struct {
 	ErrMsg        string
 	Assertion     string
 	Code          int
-	AssertionCode int `bson:"assertionCode"`
+	AssertionCode int      `bson:"assertionCode"`
+	ErrorLabels   []string `bson:"errorLabels"`
 }
 
 // QueryError is returned when a query fails
@@ -2944,12 +3328,33 @@ type QueryError struct {
 	Code      int
 	Message   string
 	Assertion bool
+
+	// Labels holds the error labels the server attached to the error,
+	// such as TransientTransactionError.
+	Labels []string
 }
 
 func (err *QueryError) Error() string {
 	return err.Message
 }
 
+// HasErrorLabel returns whether the error was labelled with label, such as
+// TransientTransactionError or UnknownTransactionCommitResult.
+func (err *QueryError) HasErrorLabel(label string) bool {
+	for _, l := range err.Labels {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
+
+func (err *QueryError) addErrorLabel(label string) {
+	if !err.HasErrorLabel(label) {
+		err.Labels = append(err.Labels, label)
+	}
+}
+
 // IsDup returns whether err informs of a duplicate key error because
 // a primary key index or a secondary unique index already has an entry
 // with the given value.
@@ -3056,6 +3461,67 @@ func (c *Collection) UpdateAll(selector interface{}, update interface{}) (info *
 	return info, err
 }
 
+// Replace finds a single document matching the provided selector document
+// and replaces it with the replacement document, dropping the fields the
+// replacement doesn't hold. The _id of the document is kept.
+// If the session is in safe mode (see SetSafe) a ErrNotFound error is
+// returned if a document isn't found, or a value of type *LastError
+// when some other error is detected.
+//
+// Relevant documentation:
+//
+//	https://docs.mongodb.com/manual/reference/method/db.collection.replaceOne/
+func (c *Collection) Replace(selector interface{}, replacement interface{}) error {
+	info, err := c.ReplaceOne(selector, replacement)
+	if err == nil && info != nil && info.Matched == 0 {
+		return ErrNotFound
+	}
+	return err
+}
+
+// ReplaceId is a convenience helper equivalent to:
+//
+//	err := collection.Replace(bson.M{"_id": id}, replacement)
+//
+// See the Replace method for more details.
+func (c *Collection) ReplaceId(id interface{}, replacement interface{}) error {
+	return c.Replace(bson.D{{Name: "_id", Value: id}}, replacement)
+}
+
+// ReplaceOne finds a single document matching the provided selector
+// document and replaces it with the replacement document, as Replace does.
+// If the session is in safe mode (see SetSafe) details of the executed
+// operation are returned in info or an error of type *LastError when
+// some problem is detected. It is not an error for no document to be
+// replaced because the selector doesn't match.
+func (c *Collection) ReplaceOne(selector interface{}, replacement interface{}) (info *ChangeInfo, err error) {
+	if err := checkReplacement(replacement); err != nil {
+		return nil, err
+	}
+	if selector == nil {
+		selector = bson.D{}
+	}
+	op := updateOp{
+		Collection: c.FullName,
+		Selector:   selector,
+		Update:     replacement,
+	}
+	lerr, err := c.writeOp(&op, true)
+	if err == nil && lerr != nil {
+		info = &ChangeInfo{Updated: lerr.modified, Matched: lerr.N}
+	}
+	return info, err
+}
+
+// checkReplacement returns an error if doc holds update operators, which
+// replacement documents can't.
+func checkReplacement(doc interface{}) error {
+	if hasUpdateOperators(doc) {
+		return errors.New("replacement document cannot contain update operators")
+	}
+	return nil
+}
+
 func hasUpdateOperators(doc interface{}) bool {
 	if doc == nil {
 		return false
@@ -3109,7 +3575,7 @@ func (c *Collection) Upsert(selector interface{}, update interface{}) (info *Cha
 	}
 
 	// Wrap plain documents in $set operator for MongoDB compatibility
-	wrappedUpdate := wrapInSetOperator(update)
+	wrappedUpdate := c.Database.Session.updateDocument(update)
 
 	op := updateOp{
 		Collection: c.FullName,
@@ -3268,11 +3734,21 @@ type CollectionInfo struct {
 //	http://www.mongodb.org/display/DOCS/createCollection+Command
 //	http://www.mongodb.org/display/DOCS/Capped+Collections
 func (c *Collection) Create(info *CollectionInfo) error {
+	cmd, err := info.createCmd(c.Name)
+	if err != nil {
+		return err
+	}
+	return c.Database.Run(cmd, nil)
+}
+
+// createCmd returns the command creating the named collection with the
+// details of info.
+func (info *CollectionInfo) createCmd(name string) (bson.D, error) {
 	cmd := make(bson.D, 0, 4)
-	cmd = append(cmd, bson.DocElem{Name: "create", Value: c.Name})
+	cmd = append(cmd, bson.DocElem{Name: "create", Value: name})
 	if info.Capped {
 		if info.MaxBytes < 1 {
-			return fmt.Errorf("Collection.Create: with Capped, MaxBytes must also be set")
+			return nil, fmt.Errorf("Collection.Create: with Capped, MaxBytes must also be set")
 		}
 		cmd = append(cmd, bson.DocElem{Name: "capped", Value: true})
 		cmd = append(cmd, bson.DocElem{Name: "size", Value: info.MaxBytes})
@@ -3301,8 +3777,7 @@ func (c *Collection) Create(info *CollectionInfo) error {
 	if info.Collation != nil {
 		cmd = append(cmd, bson.DocElem{Name: "collation", Value: info.Collation})
 	}
-
-	return c.Database.Run(cmd, nil)
+	return cmd, nil
 }
 
 // Batch sets the batch size used when fetching documents from the database.
@@ -3323,6 +3798,19 @@ func (q *Query) Batch(n int) *Query {
 	return q
 }
 
+// WithContext binds the query and its iterator to ctx. Queries obtained
+// from a collection returned by Collection.WithContext are bound to that
+// context already. See Database.WithContext for details.
+func (q *Query) WithContext(ctx context.Context) *Query {
+	if ctx == nil {
+		panic("nil context")
+	}
+	q.m.Lock()
+	q.op.ctx = ctx
+	q.m.Unlock()
+	return q
+}
+
 // Prefetch sets the point at which the next batch of results will be requested.
 // When there are p*batch_size remaining documents cached in an Iter, the next
 // batch will be requested in background. For instance, when using this:
@@ -3648,12 +4136,12 @@ Error:
 		return nil
 	}
 	if result.AssertionCode != 0 && result.Assertion != "" {
-		return &QueryError{Code: result.AssertionCode, Message: result.Assertion, Assertion: true}
+		return &QueryError{Code: result.AssertionCode, Message: result.Assertion, Assertion: true, Labels: result.ErrorLabels}
 	}
 	if result.Err != "" {
-		return &QueryError{Code: result.Code, Message: result.Err}
+		return &QueryError{Code: result.Code, Message: result.Err, Labels: result.ErrorLabels}
 	}
-	return &QueryError{Code: result.Code, Message: result.ErrMsg}
+	return &QueryError{Code: result.Code, Message: result.ErrMsg, Labels: result.ErrorLabels}
 }
 
 // One executes the query and unmarshals the first obtained document into the
@@ -3675,54 +4163,52 @@ func (q *Query) One(result interface{}) (err error) {
 	op := q.op // Copy.
 	q.m.Unlock()
 
-	socket, err := session.acquireSocket(true)
-	if err != nil {
-		return err
-	}
-	defer socket.Release()
-
 	op.limit = -1
 
 	session.prepareQuery(&op)
 
-	expectFindReply := prepareFindOp(socket, &op, 1)
+	return session.runRead(func(socket *mongoSocket) error {
+		op := op // Copy, as it's changed to match the server.
+		expectFindReply := prepareFindOp(socket, &op, 1)
 
-	data, err := socket.SimpleQuery(&op)
-	if err != nil {
-		return err
-	}
-	if data == nil {
-		return ErrNotFound
-	}
-	if expectFindReply {
-		var findReply struct {
-			Ok     bool
-			Code   int
-			Errmsg string
-			Cursor cursorData
-		}
-		err = bson.Unmarshal(data, &findReply)
+		data, err := socket.SimpleQuery(&op)
 		if err != nil {
 			return err
 		}
-		if !findReply.Ok && findReply.Errmsg != "" {
-			return &QueryError{Code: findReply.Code, Message: findReply.Errmsg}
-		}
-		if len(findReply.Cursor.FirstBatch) == 0 {
+		if data == nil {
 			return ErrNotFound
 		}
-		data = findReply.Cursor.FirstBatch[0].Data
-	}
-	if result != nil {
-		err = bson.Unmarshal(data, result)
-		if err == nil {
-			debugf("Query %p document unmarshaled: %#v", q, result)
-		} else {
-			debugf("Query %p document unmarshaling failed: %#v", q, err)
-			return err
+		if expectFindReply {
+			var findReply struct {
+				Ok     bool
+				Code   int
+				Errmsg string
+				Cursor cursorData
+				Labels []string `bson:"errorLabels"`
+			}
+			err = bson.Unmarshal(data, &findReply)
+			if err != nil {
+				return err
+			}
+			if !findReply.Ok && findReply.Errmsg != "" {
+				return &QueryError{Code: findReply.Code, Message: findReply.Errmsg, Labels: findReply.Labels}
+			}
+			if len(findReply.Cursor.FirstBatch) == 0 {
+				return ErrNotFound
+			}
+			data = findReply.Cursor.FirstBatch[0].Data
 		}
-	}
-	return checkQueryError(op.collection, data)
+		if result != nil {
+			err = bson.Unmarshal(data, result)
+			if err == nil {
+				debugf("Query %p document unmarshaled: %#v", q, result)
+			} else {
+				debugf("Query %p document unmarshaling failed: %#v", q, err)
+				return err
+			}
+		}
+		return checkQueryError(op.collection, data)
+	})
 }
 
 // prepareFindOp translates op from being an old-style wire protocol query into
@@ -3843,6 +4329,12 @@ type getMoreCmd struct {
 // as performed by Database.Run, specializing the logic for running
 // database commands on a given socket.
 func (db *Database) run(socket *mongoSocket, cmd, result interface{}) (err error) {
+	return db.runSession(socket, cmd, result, nil)
+}
+
+// runSession runs cmd like run, with sessionFields such as lsid and
+// txnNumber added to it when sent as OP_MSG.
+func (db *Database) runSession(socket *mongoSocket, cmd, result interface{}, sessionFields bson.D) (err error) {
 	// Database.Run:
 	if name, ok := cmd.(string); ok {
 		cmd = bson.M{name: 1}
@@ -3865,6 +4357,8 @@ func (db *Database) run(socket *mongoSocket, cmd, result interface{}) (err error
 	session.m.RUnlock()
 	op.query = cmd
 	op.collection = db.Name + ".$cmd"
+	op.ctx = db.ctx
+	op.sessionFields = sessionFields
 
 	// Query.One:
 	session.prepareQuery(&op)
@@ -3892,6 +4386,28 @@ func (db *Database) run(socket *mongoSocket, cmd, result interface{}) (err error
 	return checkQueryError(op.collection, data)
 }
 
+// runMsg sends op to the database and unmarshals its reply into result,
+// if one is expected. Unlike run, the command is sent verbatim, so it is up
+// to the caller to set the read preference and any session-level options.
+func (db *Database) runMsg(socket *mongoSocket, op *msgOp, result interface{}) error {
+	op.ctx = db.ctx
+	data, err := socket.SimpleMsg(op)
+	if err != nil || op.flags&msgFlagMoreToCome != 0 {
+		return err
+	}
+	if data == nil {
+		return ErrNotFound
+	}
+	if result != nil {
+		err = bson.Unmarshal(data, result)
+		if err != nil {
+			debugf("Run message unmarshaling failed: %#v, err: %#v", op, err)
+			return err
+		}
+	}
+	return checkQueryError(db.Name+".$cmd", data)
+}
+
 // The DBRef type implements support for the database reference MongoDB
 // convention as supported by multiple drivers.  This convention enables
 // cross-referencing documents between collections and databases using
@@ -4051,6 +4567,7 @@ func (q *Query) Iter() *Iter {
 	iter.op.limit = op.limit
 	iter.op.replyFunc = iter.replyFunc()
 	iter.docsToReceive++
+	iter.watchContext(op.ctx)
 
 	socket, err := session.acquireSocket(true)
 	if err != nil {
@@ -4062,22 +4579,56 @@ func (q *Query) Iter() *Iter {
 	session.prepareQuery(&op)
 	op.replyFunc = iter.op.replyFunc
 
+	if txn := session.transaction(); txn != nil {
+		iter.sessionFields = txn.cursorFields()
+	} else if socket.ServerInfo().MaxWireVersion >= opMsgMinWireVersion && !session.dialInfo.DisableRetryReads {
+		retryOp := op // Copy, before it's turned into a find command.
+		iter.retry = func(err error) { iter.retryQuery(retryOp, err) }
+	}
+
 	if prepareFindOp(socket, &op, limit) {
 		iter.isFindCmd = true
 	}
 
 	iter.server = socket.Server()
+	retrying := iter.retry != nil
 	err = socket.Query(&op)
 	if err != nil {
 		// Must lock as the query is already out and it may call replyFunc.
 		iter.m.Lock()
-		iter.err = err
+		if !retrying || iter.retry != nil {
+			// Otherwise replyFunc got the error too, and is retrying.
+			iter.err = err
+			iter.retry = nil
+		}
 		iter.m.Unlock()
 	}
 
 	return iter
 }
 
+// retryQuery sends op once more on a newly acquired socket, as a retryable
+// read, after its first reply failed with err.
+func (iter *Iter) retryQuery(op queryOp, err error) {
+	debugf("Iter %p retrying query after error: %v", iter, err)
+	iter.session.prepareRetry(iter.server, err)
+	socket, err := iter.session.acquireSocket(true)
+	if err == nil {
+		defer socket.Release()
+		iter.m.Lock()
+		iter.isFindCmd = prepareFindOp(socket, &op, iter.limit)
+		iter.server = socket.Server()
+		iter.m.Unlock()
+		err = socket.Query(&op)
+	}
+	if err != nil {
+		iter.m.Lock()
+		iter.err = err
+		iter.gotReply.Broadcast()
+		iter.m.Unlock()
+	}
+}
+
 // Tail returns a tailable iterator. Unlike a normal iterator, a
 // tailable iterator may wait for new values to be inserted in the
 // collection once the end of the current result set is reached,
@@ -4138,6 +4689,7 @@ func (q *Query) Tail(timeout time.Duration) *Iter {
 	iter.op.limit = op.limit
 	iter.op.replyFunc = iter.replyFunc()
 	iter.docsToReceive++
+	iter.watchContext(op.ctx)
 	session.prepareQuery(&op)
 	op.replyFunc = iter.op.replyFunc
 	op.flags |= flagTailable | flagAwaitData
@@ -4146,6 +4698,11 @@ func (q *Query) Tail(timeout time.Duration) *Iter {
 	if err != nil {
 		iter.err = err
 	} else {
+		// Servers that only speak OP_MSG need a tailable find command,
+		// while older ones keep the historical OP_QUERY behavior.
+		if socket.ServerInfo().MaxWireVersion >= opMsgMinWireVersion && prepareFindOp(socket, &op, 0) {
+			iter.isFindCmd = true
+		}
 		iter.server = socket.Server()
 		err = socket.Query(&op)
 		if err != nil {
@@ -4165,7 +4722,14 @@ func (s *Session) prepareQuery(op *queryOp) {
 	if s.slaveOk {
 		op.flags |= flagSlaveOk
 	}
+	txn := s.txn
 	s.m.RUnlock()
+	if fields := txn.fields(); fields != nil {
+		// Transactions only read from the primary.
+		op.mode = Strong
+		op.flags &^= flagSlaveOk
+		op.sessionFields = fields
+	}
 	return
 }
 
@@ -4201,32 +4765,57 @@ func (iter *Iter) Err() error {
 // standard ways for MongoDB to report an improper query, the returned value has
 // a *QueryError type.
 func (iter *Iter) Close() error {
+	if iter.stopCtx != nil {
+		iter.stopCtx()
+	}
+	err := iter.killCursor()
+
+	iter.m.Lock()
+	if err != nil && (iter.err == nil || iter.err == ErrNotFound) {
+		iter.err = err
+	} else if iter.err != ErrNotFound {
+		err = iter.err
+	}
+	iter.m.Unlock()
+	return err
+}
+
+// killCursor kills the server cursor used by the iterator, if any.
+func (iter *Iter) killCursor() error {
 	iter.m.Lock()
 	cursorId := iter.op.cursorId
 	iter.op.cursorId = 0
-	err := iter.err
 	iter.m.Unlock()
 	if cursorId == 0 {
-		if err == ErrNotFound {
-			return nil
-		}
-		return err
+		return nil
 	}
 	socket, err := iter.acquireSocket()
 	if err == nil {
 		// TODO Batch kills.
-		err = socket.Query(&killCursorsOp{[]int64{cursorId}})
+		err = socket.Query(&killCursorsOp{iter.op.collection, []int64{cursorId}})
 		socket.Release()
 	}
+	return err
+}
 
-	iter.m.Lock()
-	if err != nil && (iter.err == nil || iter.err == ErrNotFound) {
-		iter.err = err
-	} else if iter.err != ErrNotFound {
-		err = iter.err
+// watchContext binds the iterator to ctx, if not nil. Once ctx is done,
+// Next returns false, Err reports ctx.Err(), and the server cursor is
+// killed, including one only known from a reply arriving afterwards.
+func (iter *Iter) watchContext(ctx context.Context) {
+	if ctx == nil {
+		return
 	}
-	iter.m.Unlock()
-	return err
+	iter.op.ctx = ctx
+	iter.stopCtx = context.AfterFunc(ctx, func() {
+		iter.m.Lock()
+		if iter.err == nil {
+			iter.err = ctx.Err()
+			iter.canceled = true
+		}
+		iter.gotReply.Broadcast()
+		iter.m.Unlock()
+		iter.killCursor()
+	})
 }
 
 // Done returns true only if a follow up Next call is guaranteed
@@ -4243,6 +4832,9 @@ func (iter *Iter) Done() bool {
 	defer iter.m.Unlock()
 
 	for {
+		if iter.canceled {
+			return true
+		}
 		if iter.docData.Len() > 0 {
 			return false
 		}
@@ -4332,6 +4924,10 @@ func (iter *Iter) Next(result interface{}) bool {
 		}
 		iter.gotReply.Wait()
 	}
+	if iter.canceled {
+		iter.m.Unlock()
+		return false
+	}
 	// We have data from the getMore.
 	// Exhaust available data before reporting any errors.
 	if docData, ok := iter.docData.Pop().([]byte); ok {
@@ -4580,6 +5176,8 @@ func (iter *Iter) getMoreCmd() *queryOp {
 	op.query = &getMore
 	op.limit = -1
 	op.replyFunc = iter.op.replyFunc
+	op.ctx = iter.op.ctx
+	op.sessionFields = iter.sessionFields
 	return &op
 }
 
@@ -4616,7 +5214,7 @@ func (q *Query) Count() (n int, err error) {
 	// simply want a Zero bson.D
 	hint, _ := q.op.options.Hint.(bson.D)
 	result := struct{ N int }{}
-	err = session.DB(dbname).Run(countCmd{cname, query, limit, op.skip, hint, op.options.MaxTimeMS, op.options.Collation}, &result)
+	err = contextDB(session, dbname, op.ctx).runRead(countCmd{cname, query, limit, op.skip, hint, op.options.MaxTimeMS, op.options.Collation}, &result)
 
 	return result.N, err
 }
@@ -4657,7 +5255,7 @@ func (q *Query) Distinct(key string, result interface{}) error {
 	cname := op.collection[c+1:]
 
 	var doc struct{ Values bson.Raw }
-	err := session.DB(dbname).Run(distinctCmd{cname, key, op.query}, &doc)
+	err := contextDB(session, dbname, op.ctx).runRead(distinctCmd{cname, key, op.query}, &doc)
 	if err != nil {
 		return err
 	}
@@ -4810,10 +5408,16 @@ func (q *Query) MapReduce(job *MapReduce, result interface{}) (info *MapReduceIn
 	}
 
 	var doc mapReduceResult
-	err = session.DB(dbname).Run(&cmd, &doc)
+	err = contextDB(session, dbname, op.ctx).Run(&cmd, &doc)
 	if err != nil {
 		return nil, err
 	}
+	return doc.info(dbname, result)
+}
+
+// info returns the details of the map/reduce job reported in doc, which
+// ran on the database dbname, unmarshalling inlined results into result.
+func (doc *mapReduceResult) info(dbname string, result interface{}) (info *MapReduceInfo, err error) {
 	if doc.Err != "" {
 		return nil, errors.New(doc.Err)
 	}
@@ -4886,6 +5490,7 @@ type Change struct {
 	Update    interface{} // The update document
 	Upsert    bool        // Whether to insert in case the document isn't found
 	Remove    bool        // Whether to remove the document found rather than updating
+	Replace   bool        // Whether Update is a replacement document rather than an update one
 	ReturnNew bool        // Should the modified document be returned rather than the old one
 }
 
@@ -4893,7 +5498,7 @@ type findModifyCmd struct {
 	Collection                  string      `bson:"findAndModify"`
 	Query, Update, Sort, Fields interface{} `bson:",omitempty"`
 	Upsert, Remove, New         bool        `bson:",omitempty"`
-	WriteConcern                interface{} `bson:"writeConcern"`
+	WriteConcern                interface{} `bson:"writeConcern,omitempty"`
 }
 
 type valueResult struct {
@@ -4948,30 +5553,16 @@ func (q *Query) Apply(change Change, result interface{}) (info *ChangeInfo, err
 	session.m.RLock()
 	safeOp := session.safeOp
 	session.m.RUnlock()
-	var writeConcern interface{}
-	if safeOp == nil {
-		// Explicitly disable retryable writes to avoid "Retryable writes are not supported" error
-		writeConcern = bson.D{{Name: "w", Value: 0}, {Name: "retryWrites", Value: false}}
-	} else {
-		// Use existing safe operation but ensure retryable writes are disabled
-		if gleConcern, ok := safeOp.query.(*getLastError); ok {
-			// Create a modified write concern that disables retryable writes
-			writeConcern = bson.D{
-				{Name: "w", Value: gleConcern.W},
-				{Name: "wtimeout", Value: gleConcern.WTimeout},
-				{Name: "fsync", Value: gleConcern.FSync},
-				{Name: "j", Value: gleConcern.J},
-				{Name: "retryWrites", Value: false},
-			}
-		} else {
-			writeConcern = bson.D{{Name: "w", Value: 1}, {Name: "retryWrites", Value: false}}
-		}
-	}
+	writeConcern := writeConcernOf(safeOp)
 
 	// Wrap plain documents in $set operator for MongoDB compatibility when doing upserts
 	updateDoc := change.Update
-	if change.Upsert && !change.Remove {
-		updateDoc = wrapInSetOperator(change.Update)
+	if change.Replace && !change.Remove {
+		if err := checkReplacement(change.Update); err != nil {
+			return nil, err
+		}
+	} else if change.Upsert && !change.Remove {
+		updateDoc = session.updateDocument(change.Update)
 	}
 
 	cmd := findModifyCmd{
@@ -4986,25 +5577,28 @@ func (q *Query) Apply(change Change, result interface{}) (info *ChangeInfo, err
 		WriteConcern: writeConcern,
 	}
 
+	txn := session.transaction()
+	if txn != nil {
+		// Write concerns can't be set on operations in a transaction.
+		cmd.WriteConcern = nil
+	}
 	session = session.Clone()
 	defer session.Close()
 	session.SetMode(Strong, false)
+	session.txn = txn
 
 	var doc valueResult
+	db := contextDB(session, dbname, op.ctx)
+	// Unacknowledged writes can't be retried.
+	retryable := safeOp != nil && dbname != "local"
 	for i := 0; i < maxUpsertRetries; i++ {
-		err = session.DB(dbname).Run(&cmd, &doc)
+		err = session.runWrite(nil, retryable, func(socket *mongoSocket, txn bson.D) error {
+			doc = valueResult{}
+			return db.runSession(socket, &cmd, &doc, txn)
+		})
 		if err == nil {
 			break
 		}
-		// Handle retryable writes error specifically
-		if qerr, ok := err.(*QueryError); ok && strings.Contains(qerr.Message, "Retryable writes are not supported") {
-			// Retry with a more basic write concern
-			cmd.WriteConcern = bson.D{{Name: "w", Value: 1}}
-			err = session.DB(dbname).Run(&cmd, &doc)
-			if err == nil {
-				break
-			}
-		}
 		if change.Upsert && IsDup(err) && i+1 < maxUpsertRetries {
 			// Retry duplicate key errors on upserts.
 			// https://docs.mongodb.com/v3.2/reference/method/db.collection.update/#use-unique-indexes
@@ -5108,6 +5702,9 @@ func (s *Session) BuildInfo() (info BuildInfo, err error) {
 // Internal session handling helpers.
 
 func (s *Session) acquireSocket(slaveOk bool) (*mongoSocket, error) {
+	if slaveOk && s.transaction() != nil {
+		slaveOk = false
+	}
 
 	// Read-only lock to check for previously reserved socket.
 	s.m.RLock()
@@ -5218,6 +5815,8 @@ func (iter *Iter) replyFunc() replyFunc {
 	return func(err error, op *replyOp, docNum int, docData []byte) {
 		iter.m.Lock()
 		iter.docsToReceive--
+		retry := iter.retry
+		iter.retry = nil // Only the first reply is retried.
 		if err != nil {
 			iter.err = err
 			debugf("Iter %p received an error: %s", iter, err.Error())
@@ -5239,12 +5838,13 @@ func (iter *Iter) replyFunc() replyFunc {
 				Code   int
 				Errmsg string
 				Cursor cursorData
+				Labels []string `bson:"errorLabels"`
 			}
 			if err := bson.Unmarshal(docData, &findReply); err != nil {
 				iter.err = err
 			} else if !findReply.Ok && findReply.Errmsg != "" {
-				iter.err = &QueryError{Code: findReply.Code, Message: findReply.Errmsg}
-			} else if !iter.isChangeStream && len(findReply.Cursor.FirstBatch) == 0 && len(findReply.Cursor.NextBatch) == 0 {
+				iter.err = &QueryError{Code: findReply.Code, Message: findReply.Errmsg, Labels: findReply.Labels}
+			} else if !iter.isChangeStream && len(findReply.Cursor.FirstBatch) == 0 && len(findReply.Cursor.NextBatch) == 0 && findReply.Cursor.Id == 0 {
 				iter.err = ErrNotFound
 			} else {
 				batch := findReply.Cursor.FirstBatch
@@ -5279,6 +5879,20 @@ func (iter *Iter) replyFunc() replyFunc {
 			debugf("Iter %p received reply document %d/%d (cursor=%d)", iter, docNum+1, rdocs, op.cursorId)
 			iter.docData.Push(docData)
 		}
+		if retry != nil && !iter.canceled && isRetryableError(iter.err) {
+			go retry(iter.err)
+			iter.err = nil
+			iter.docsToReceive++
+			iter.m.Unlock()
+			return
+		}
+		if iter.canceled {
+			// The reply arrived after the context was done.
+			iter.err = iter.op.ctx.Err()
+			if iter.op.cursorId != 0 {
+				go iter.killCursor()
+			}
+		}
 		iter.gotReply.Broadcast()
 		iter.m.Unlock()
 	}
@@ -5331,6 +5945,9 @@ func (c *Collection) writeOp(op interface{}, ordered bool) (lerr *LastError, err
 	safeOp := s.safeOp
 	bypassValidation := s.bypassValidation
 	s.m.RUnlock()
+	if c.Database.safeOp != nil {
+		safeOp = c.Database.safeOp
+	}
 
 	if socket.ServerInfo().MaxWireVersion >= 2 {
 		// Servers with a more recent write protocol benefit from write commands.
@@ -5500,76 +6117,105 @@ func (c *Collection) writeOpQuery(socket *mongoSocket, safeOp *queryOp, op inter
 	return result, nil
 }
 
-func (c *Collection) writeOpCommand(socket *mongoSocket, safeOp *queryOp, op interface{}, ordered, bypassValidation bool) (lerr *LastError, err error) {
-	var writeConcern interface{}
+// writeConcernOf returns the write concern for write commands matching the
+// safety settings in safeOp, or an unacknowledged one if it's nil.
+func writeConcernOf(safeOp *queryOp) interface{} {
 	if safeOp == nil {
-		// Explicitly disable retryable writes to avoid "Retryable writes are not supported" error
-		writeConcern = bson.D{{Name: "w", Value: 0}, {Name: "retryWrites", Value: false}}
-	} else {
-		// Use existing safe operation but ensure retryable writes are disabled
-		if gleConcern, ok := safeOp.query.(*getLastError); ok {
-			// Create a modified write concern that disables retryable writes
-			writeConcern = bson.D{
-				{Name: "w", Value: gleConcern.W},
-				{Name: "wtimeout", Value: gleConcern.WTimeout},
-				{Name: "fsync", Value: gleConcern.FSync},
-				{Name: "j", Value: gleConcern.J},
-				{Name: "retryWrites", Value: false},
-			}
-		} else {
-			writeConcern = bson.D{{Name: "w", Value: 1}, {Name: "retryWrites", Value: false}}
-		}
+		return bson.D{{Name: "w", Value: 0}}
 	}
+	wc := *safeOp.query.(*getLastError) // Copy.
+	wc.CmdName = 0
+	return &wc
+}
+
+func (c *Collection) writeOpCommand(socket *mongoSocket, safeOp *queryOp, op interface{}, ordered, bypassValidation bool) (lerr *LastError, err error) {
+	writeConcern := writeConcernOf(safeOp)
 
+	// The documents, updates or deletes are sent as an OP_MSG document
+	// sequence where supported, and as an array in the command otherwise.
 	var cmd bson.D
+	var seq msgSection
 	switch op := op.(type) {
 	case *insertOp:
 		// http://docs.mongodb.org/manual/reference/command/insert
 		cmd = bson.D{
 			{Name: "insert", Value: c.Name},
-			{Name: "documents", Value: op.documents},
 			{Name: "writeConcern", Value: writeConcern},
 			{Name: "ordered", Value: op.flags&1 == 0},
 		}
+		seq = msgSection{"documents", op.documents}
 	case *updateOp:
 		// http://docs.mongodb.org/manual/reference/command/update
 		cmd = bson.D{
 			{Name: "update", Value: c.Name},
-			{Name: "updates", Value: []interface{}{op}},
 			{Name: "writeConcern", Value: writeConcern},
 			{Name: "ordered", Value: ordered},
 		}
+		seq = msgSection{"updates", []interface{}{op}}
 	case bulkUpdateOp:
 		// http://docs.mongodb.org/manual/reference/command/update
 		cmd = bson.D{
 			{Name: "update", Value: c.Name},
-			{Name: "updates", Value: op},
 			{Name: "writeConcern", Value: writeConcern},
 			{Name: "ordered", Value: ordered},
 		}
+		seq = msgSection{"updates", op}
 	case *deleteOp:
 		// http://docs.mongodb.org/manual/reference/command/delete
 		cmd = bson.D{
 			{Name: "delete", Value: c.Name},
-			{Name: "deletes", Value: []interface{}{op}},
 			{Name: "writeConcern", Value: writeConcern},
 			{Name: "ordered", Value: ordered},
 		}
+		seq = msgSection{"deletes", []interface{}{op}}
 	case bulkDeleteOp:
 		// http://docs.mongodb.org/manual/reference/command/delete
 		cmd = bson.D{
 			{Name: "delete", Value: c.Name},
-			{Name: "deletes", Value: op},
 			{Name: "writeConcern", Value: writeConcern},
 			{Name: "ordered", Value: ordered},
 		}
+		seq = msgSection{"deletes", op}
 	}
 	if bypassValidation {
 		cmd = append(cmd, bson.DocElem{Name: "bypassDocumentValidation", Value: true})
 	}
+	txnFields := c.Database.Session.transaction().fields()
+	if txnFields != nil {
+		// Writes in a transaction take the write concern of its commit.
+		for i, elem := range cmd {
+			if elem.Name == "writeConcern" {
+				cmd = append(cmd[:i], cmd[i+1:]...)
+				break
+			}
+		}
+	}
 
 	var result writeCmdResult
-	err = c.Database.run(socket, cmd, &result)
+	if socket.ServerInfo().MaxWireVersion >= opMsgMinWireVersion {
+		msg := &msgOp{
+			body:     cmd,
+			database: c.Database.Name,
+			sections: []msgSection{seq},
+		}
+		if txnFields != nil {
+			msg.sessionFields = txnFields
+			err = c.Database.runMsg(socket, msg, &result)
+		} else if safeOp == nil {
+			// Unacknowledged writes need no reply at all.
+			msg.flags = msgFlagMoreToCome
+			return nil, c.Database.runMsg(socket, msg, nil)
+		} else {
+			err = c.Database.Session.runWrite(socket, isRetryableWrite(op) && c.Database.Name != "local", func(socket *mongoSocket, txn bson.D) error {
+				result = writeCmdResult{}
+				msg.sessionFields = txn
+				return c.Database.runMsg(socket, msg, &result)
+			})
+		}
+	} else {
+		cmd = append(cmd[:1], append(bson.D{{Name: seq.identifier, Value: seq.documents}}, cmd[1:]...)...)
+		err = c.Database.run(socket, cmd, &result)
+	}
 	debugf("Write command result: %#v (err=%v)", result, err)
 	ecases := result.BulkErrorCases()
 	lerr = &LastError{
@@ -5600,6 +6246,34 @@ func (c *Collection) writeOpCommand(socket *mongoSocket, safeOp *queryOp, op int
 	return lerr, err
 }
 
+// isRetryableWrite returns whether op may be retried as a retryable write,
+// which excludes multi-document updates and deletes.
+func isRetryableWrite(op interface{}) bool {
+	switch op := op.(type) {
+	case *insertOp:
+		return true
+	case *updateOp:
+		return !op.Multi
+	case bulkUpdateOp:
+		for _, update := range op {
+			if update.(*updateOp).Multi {
+				return false
+			}
+		}
+		return true
+	case *deleteOp:
+		return op.Limit == 1
+	case bulkDeleteOp:
+		for _, del := range op {
+			if del.(*deleteOp).Limit != 1 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
 func hasErrMsg(d []byte) bool {
 	l := len(d)
 	for i := 0; i+8 < l; i++ {
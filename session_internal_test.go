@@ -83,3 +83,66 @@ func (s *S) TestDialTimeouts(c *C) {
 	info.WriteTimeout = time.Second
 	c.Assert(info.writeTimeout(), Equals, time.Second)
 }
+
+func (s *S) TestScramMechanism(c *C) {
+	cred := Credential{Username: "root", Source: "admin"}
+	info := &mongoServerInfo{}
+	c.Assert(info.scramMechanism(cred), Equals, "SCRAM-SHA-1")
+
+	info.SaslUser = "admin.root"
+	info.SaslSupportedMechs = []string{"SCRAM-SHA-1"}
+	c.Assert(info.scramMechanism(cred), Equals, "SCRAM-SHA-1")
+
+	info.SaslSupportedMechs = []string{"SCRAM-SHA-1", "SCRAM-SHA-256"}
+	c.Assert(info.scramMechanism(cred), Equals, "SCRAM-SHA-256")
+
+	// Mechanisms reported for someone else don't apply.
+	cred.Source = "mydb"
+	c.Assert(info.scramMechanism(cred), Equals, "SCRAM-SHA-1")
+}
+
+func (s *S) TestSaslNewScramSha256(c *C) {
+	// SASLprep maps the non-ASCII space to a plain one.
+	sasl, err := saslNewScram(Credential{Username: "user", Password: "pen\u00A0cil", Mechanism: "SCRAM-SHA-256"})
+	c.Assert(err, IsNil)
+	c.Assert(sasl, NotNil)
+
+	// And rejects prohibited characters outright.
+	_, err = saslNewScram(Credential{Username: "user", Password: "pen\u0007cil", Mechanism: "SCRAM-SHA-256"})
+	c.Assert(err, ErrorMatches, "cannot prepare SCRAM-SHA-256 password: .*")
+}
+
+func (s *S) TestUpdateDocument(c *C) {
+	session := &Session{}
+	c.Assert(session.updateDocument(bson.M{"n": 1}), DeepEquals, bson.M{"$set": bson.M{"n": 1}})
+	c.Assert(session.updateDocument(bson.M{"$inc": bson.M{"n": 1}}), DeepEquals, bson.M{"$inc": bson.M{"n": 1}})
+
+	session.SetStrictReplace(true)
+	c.Assert(session.updateDocument(bson.M{"n": 1}), DeepEquals, bson.M{"n": 1})
+
+	c.Assert(checkReplacement(bson.D{{Name: "n", Value: 1}}), IsNil)
+	c.Assert(checkReplacement(bson.D{{Name: "$set", Value: 1}}), ErrorMatches, "replacement document cannot contain update operators")
+}
+
+func (s *S) TestCollectionWithSafe(c *C) {
+	db := &Database{Session: &Session{}, Name: "db"}
+	coll := db.C("c")
+	c.Assert(coll.withSafe(nil) == coll, Equals, true)
+
+	safe := coll.withSafe(&Safe{WMode: "majority", J: true})
+	c.Assert(safe.Database.safeOp, NotNil)
+	c.Assert(safe.Database.safeOp.query, DeepEquals, &getLastError{1, "majority", 0, false, true})
+	c.Assert(safe.FullName, Equals, "db.c")
+	c.Assert(db.safeOp, IsNil)
+
+	gfs := db.GridFSWithOptions("fs", GridFSOptions{ChunkSize: 16, Safe: &Safe{W: 2}})
+	c.Assert(gfs.Files.Database.safeOp.query, DeepEquals, &getLastError{1, 2, 0, false, false})
+	c.Assert(gfs.Chunks.FullName, Equals, "db.fs.chunks")
+	file, err := gfs.Create("name")
+	c.Assert(err, IsNil)
+	c.Assert(file.doc.ChunkSize, Equals, 16)
+
+	file, err = db.GridFS("fs").Create("name")
+	c.Assert(err, IsNil)
+	c.Assert(file.doc.ChunkSize, Equals, 255*1024)
+}
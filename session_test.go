@@ -27,6 +27,7 @@
 package mgo_test
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"math"
@@ -277,6 +278,62 @@ func (s *S) TestMaxIdleTimeMS(c *C) {
 	}
 }
 
+func (s *S) TestCompressorsURL(c *C) {
+	level := func(n int) *int { return &n }
+	tests := []struct {
+		url         string
+		compressors []string
+		level       *int
+		fail        bool
+	}{
+		{"localhost:40001", nil, nil, false},
+		{"localhost:40001?compressors=snappy", []string{"snappy"}, nil, false},
+		{"localhost:40001?compressors=zstd,zlib&zlibCompressionLevel=9", []string{"zstd", "zlib"}, level(9), false},
+		{"localhost:40001?compressors=zlib&zlibCompressionLevel=-1", []string{"zlib"}, level(-1), false},
+		{"localhost:40001?compressors=zlib&zlibCompressionLevel=0", []string{"zlib"}, level(0), false},
+		{"localhost:40001?compressors=lz4", nil, nil, true},
+		{"localhost:40001?zlibCompressionLevel=10", nil, nil, true},
+		{"localhost:40001?zlibCompressionLevel=-2", nil, nil, true},
+		{"localhost:40001?zlibCompressionLevel=x", nil, nil, true},
+	}
+	for _, test := range tests {
+		info, err := mgo.ParseURL(test.url)
+		if test.fail {
+			c.Assert(err, NotNil)
+		} else {
+			c.Assert(err, IsNil)
+			c.Assert(info.Compressors, DeepEquals, test.compressors)
+			c.Assert(info.ZlibCompressionLevel, DeepEquals, test.level)
+		}
+	}
+}
+
+func (s *S) TestCompressedInsertIter(c *C) {
+	if !s.versionAtLeast(3, 6) {
+		c.Skip("wire protocol compression is only supported by 3.6+")
+	}
+	for _, compressor := range []string{"snappy", "zlib", "zstd"} {
+		if compressor == "zstd" && !s.versionAtLeast(4, 2) {
+			continue
+		}
+		session, err := mgo.Dial("localhost:40001?compressors=" + compressor)
+		c.Assert(err, IsNil)
+
+		coll := session.DB("mydb").C("compressed_" + compressor)
+		docs := make([]interface{}, 100)
+		for i := range docs {
+			docs[i] = M{"n": i, "pad": strings.Repeat("x", 1000)}
+		}
+		c.Assert(coll.Insert(docs...), IsNil)
+
+		var result []struct{ N int }
+		c.Assert(coll.Find(nil).Sort("n").All(&result), IsNil)
+		c.Assert(result, HasLen, 100)
+		c.Assert(result[99].N, Equals, 99)
+		session.Close()
+	}
+}
+
 func (s *S) TestPoolShrink(c *C) {
 	if *fast {
 		c.Skip("-fast")
@@ -419,6 +476,44 @@ func (s *S) TestInsertFindOne(c *C) {
 	c.Assert(result.B, Equals, 3)
 }
 
+func (s *S) TestOpMsgInsertIterPipe(c *C) {
+	if !s.versionAtLeast(3, 6) {
+		c.Skip("OP_MSG is only supported by 3.6+")
+	}
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+	docs := make([]interface{}, 10)
+	for i := range docs {
+		docs[i] = M{"n": i}
+	}
+	err = coll.Insert(docs...)
+	c.Assert(err, IsNil)
+
+	// Unacknowledged writes are sent without expecting a reply.
+	session.SetSafe(nil)
+	err = coll.Insert(M{"n": 10})
+	c.Assert(err, IsNil)
+	session.SetSafe(&mgo.Safe{})
+
+	iter := coll.Find(nil).Sort("n").Batch(3).Iter()
+	var result struct{ N int }
+	n := 0
+	for iter.Next(&result) {
+		c.Assert(result.N, Equals, n)
+		n++
+	}
+	c.Assert(iter.Close(), IsNil)
+	c.Assert(n, Equals, 11)
+
+	var sum struct{ Total int }
+	err = coll.Pipe([]M{{"$group": M{"_id": nil, "total": M{"$sum": "$n"}}}}).One(&sum)
+	c.Assert(err, IsNil)
+	c.Assert(sum.Total, Equals, 55)
+}
+
 func (s *S) TestInsertFindOneNil(c *C) {
 	session, err := mgo.Dial("localhost:40002")
 	c.Assert(err, IsNil)
@@ -869,6 +964,87 @@ func (s *S) TestUpdateAll(c *C) {
 	}
 }
 
+func (s *S) TestReplace(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+
+	err = coll.Insert(M{"_id": 1, "k": 1, "n": 1, "gone": true})
+	c.Assert(err, IsNil)
+
+	// Fields missing from the replacement are dropped.
+	err = coll.Replace(M{"k": 1}, M{"k": 1, "n": 2})
+	c.Assert(err, IsNil)
+
+	result := M{}
+	err = coll.FindId(1).One(result)
+	c.Assert(err, IsNil)
+	c.Assert(result, DeepEquals, M{"_id": 1, "k": 1, "n": 2})
+
+	err = coll.ReplaceId(1, M{"n": 3})
+	c.Assert(err, IsNil)
+	result = M{}
+	err = coll.FindId(1).One(result)
+	c.Assert(err, IsNil)
+	c.Assert(result, DeepEquals, M{"_id": 1, "n": 3})
+
+	info, err := coll.ReplaceOne(M{"n": 3}, M{"n": 4})
+	c.Assert(err, IsNil)
+	c.Assert(info.Matched, Equals, 1)
+	c.Assert(info.Updated, Equals, 1)
+
+	err = coll.Replace(M{"k": 42}, M{"n": 5})
+	c.Assert(err, Equals, mgo.ErrNotFound)
+	info, err = coll.ReplaceOne(M{"k": 42}, M{"n": 5})
+	c.Assert(err, IsNil)
+	c.Assert(info.Matched, Equals, 0)
+
+	err = coll.Replace(M{"n": 4}, M{"$set": M{"n": 5}})
+	c.Assert(err, ErrorMatches, "replacement document cannot contain update operators")
+}
+
+func (s *S) TestStrictReplace(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+
+	err = coll.Insert(M{"_id": 1, "n": 1, "gone": true}, M{"_id": 2, "n": 2, "gone": true})
+	c.Assert(err, IsNil)
+
+	// Plain documents are wrapped in $set by default, keeping other fields.
+	_, err = coll.Upsert(M{"_id": 1}, M{"n": 10})
+	c.Assert(err, IsNil)
+	result := M{}
+	err = coll.FindId(1).One(result)
+	c.Assert(err, IsNil)
+	c.Assert(result, DeepEquals, M{"_id": 1, "n": 10, "gone": true})
+
+	// In strict mode they replace the document, as in the original mgo.
+	session.SetStrictReplace(true)
+	_, err = coll.Upsert(M{"_id": 1}, M{"n": 11})
+	c.Assert(err, IsNil)
+	result = M{}
+	err = coll.FindId(1).One(result)
+	c.Assert(err, IsNil)
+	c.Assert(result, DeepEquals, M{"_id": 1, "n": 11})
+
+	// The mode is kept by copies.
+	scopy := session.Copy()
+	defer scopy.Close()
+	bulk := scopy.DB("mydb").C("mycoll").Bulk()
+	bulk.Upsert(M{"_id": 2}, M{"n": 20})
+	_, err = bulk.Run()
+	c.Assert(err, IsNil)
+	result = M{}
+	err = coll.FindId(2).One(result)
+	c.Assert(err, IsNil)
+	c.Assert(result, DeepEquals, M{"_id": 2, "n": 20})
+}
+
 func (s *S) TestRemove(c *C) {
 	session, err := mgo.Dial("localhost:40001")
 	c.Assert(err, IsNil)
@@ -1398,6 +1574,32 @@ func (s *S) TestFindAndModify(c *C) {
 	c.Assert(info, IsNil)
 }
 
+func (s *S) TestFindAndModifyReplace(c *C) {
+	session, err := mgo.Dial("localhost:40011")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+
+	err = coll.Insert(M{"_id": 1, "n": 1, "gone": true})
+	c.Assert(err, IsNil)
+
+	result := M{}
+	info, err := coll.FindId(1).Apply(mgo.Change{Update: M{"n": 2}, Replace: true, ReturnNew: true}, result)
+	c.Assert(err, IsNil)
+	c.Assert(info.Updated, Equals, 1)
+	c.Assert(result, DeepEquals, M{"_id": 1, "n": 2})
+
+	// Upserted plain documents are wrapped in $set unless replacing.
+	result = M{}
+	_, err = coll.Find(M{"_id": 2}).Apply(mgo.Change{Update: M{"n": 3}, Upsert: true, Replace: true, ReturnNew: true}, result)
+	c.Assert(err, IsNil)
+	c.Assert(result, DeepEquals, M{"_id": 2, "n": 3})
+
+	_, err = coll.FindId(1).Apply(mgo.Change{Update: M{"$inc": M{"n": 1}}, Replace: true}, nil)
+	c.Assert(err, ErrorMatches, "replacement document cannot contain update operators")
+}
+
 func (s *S) TestFindAndModifyWriteConcern(c *C) {
 	session, err := mgo.Dial("localhost:40011")
 	c.Assert(err, IsNil)
@@ -2315,6 +2517,65 @@ func (s *S) TestBatchSizeZeroGetMore(c *C) {
 	c.Assert(iter.Close(), IsNil)
 }
 
+func (s *S) TestFindIterContextCancel(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+	for n := 0; n < 10; n++ {
+		c.Assert(coll.Insert(M{"n": n}), IsNil)
+	}
+	cursorsOpen := serverCursorsOpen(session)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	iter := coll.WithContext(ctx).Find(nil).Sort("n").Batch(2).Iter()
+	result := struct{ N int }{}
+	c.Assert(iter.Next(&result), Equals, true)
+	c.Assert(result.N, Equals, 0)
+	c.Assert(serverCursorsOpen(session), Equals, cursorsOpen+1)
+
+	cancel()
+	c.Assert(iter.Next(&result), Equals, false)
+	c.Assert(iter.Err(), Equals, context.Canceled)
+	c.Assert(iter.Close(), Equals, context.Canceled)
+
+	// The cursor is killed in background once the context is done.
+	for i := 0; i < 10 && serverCursorsOpen(session) != cursorsOpen; i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	c.Assert(serverCursorsOpen(session), Equals, cursorsOpen)
+}
+
+func (s *S) TestContextDone(c *C) {
+	session, err := mgo.Dial("localhost:40001")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+	c.Assert(coll.Insert(M{"n": 1}), IsNil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	db := session.DB("mydb").WithContext(ctx)
+	cctx := db.C("mycoll")
+
+	c.Assert(cctx.Insert(M{"n": 2}), Equals, context.Canceled)
+	c.Assert(cctx.Find(nil).One(nil), Equals, context.Canceled)
+	c.Assert(coll.Find(nil).WithContext(ctx).One(nil), Equals, context.Canceled)
+	_, err = cctx.Find(nil).Count()
+	c.Assert(err, Equals, context.Canceled)
+	c.Assert(cctx.Find(nil).All(&[]M{}), Equals, context.Canceled)
+	c.Assert(cctx.Pipe([]M{}).All(&[]M{}), Equals, context.Canceled)
+	c.Assert(db.Run("ping", nil), Equals, context.Canceled)
+
+	// The session is unaffected.
+	n, err := coll.Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 1)
+}
+
 func serverCursorsOpen(session *mgo.Session) int {
 	var result struct {
 		Cursors struct {
@@ -0,0 +1,510 @@
+package mgo
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+// ShadowOptions configures the reads shadowed by the sessions of
+// NewShadowSession.
+type ShadowOptions struct {
+	// SampleRate is the fraction of the reads, from 0 to 1, which are also
+	// run on the shadow session.
+	SampleRate float64
+
+	// Collections lists the full names, as "db.coll", of the collections
+	// whose reads are shadowed. All of them are if it's empty.
+	Collections []string
+
+	// OnMismatch is called, from its own goroutine, for every shadowed read
+	// whose results differ between the sessions.
+	OnMismatch func(mismatch *ShadowMismatch)
+
+	// MaxPending is the maximum number of shadow reads running at once,
+	// shared by the copies and clones of the session. Reads sampled while
+	// that many are pending aren't shadowed, so that a slow shadow session
+	// doesn't pile them up. It's 100 if zero.
+	MaxPending int
+}
+
+// shadowMaxPending is the default of ShadowOptions.MaxPending.
+const shadowMaxPending = 100
+
+// ShadowMismatch describes a read whose results differ between the primary
+// and the shadow sessions of NewShadowSession.
+type ShadowMismatch struct {
+	Collection string      // Full name of the collection read, as "db.coll"
+	Op         string      // One of "One", "All", "Count", "Distinct", "PipeOne" or "PipeAll"
+	Query      interface{} // Query document, or pipeline for the Pipe reads
+
+	// The results of both sessions, normalized so that documents are
+	// bson.M values and numbers are float64 values.
+	Primary, Shadow       interface{}
+	PrimaryErr, ShadowErr error
+
+	// Diff lists the differences between the results, one per line, as
+	// "<path>: <primary value> != <shadow value>".
+	Diff []string
+}
+
+// NewShadowSession returns a session running everything on primary, such
+// as a legacy one, and also running a sample of the reads on shadow, such
+// as a modern one, so that their results may be compared before switching
+// from one to the other. Shadow reads run asynchronously once the primary
+// read is done, and mismatches are reported through opts.OnMismatch.
+//
+// The reads shadowed are One, All, Count and Distinct on queries, Count on
+// collections and One and All on pipes. Everything else, writes included,
+// only runs on primary. Closing the returned session waits for its pending
+// shadow reads.
+//
+// The shadow reads run the query or pipeline as it was when the primary
+// read was made, so the query may be changed and run again right away.
+func NewShadowSession(primary, shadow SessionAPI, opts ShadowOptions) SessionAPI {
+	maxPending := opts.MaxPending
+	if maxPending <= 0 {
+		maxPending = shadowMaxPending
+	}
+	return newShadowSession(primary, shadow, opts, make(chan struct{}, maxPending))
+}
+
+// newShadowSession returns a shadow session whose shadow reads take a slot
+// of slots while running.
+func newShadowSession(primary, shadow SessionAPI, opts ShadowOptions, slots chan struct{}) *shadowSession {
+	s := &shadowSession{SessionAPI: primary, shadow: shadow, opts: opts, slots: slots}
+	if len(opts.Collections) > 0 {
+		s.collections = make(map[string]bool, len(opts.Collections))
+		for _, name := range opts.Collections {
+			s.collections[name] = true
+		}
+	}
+	return s
+}
+
+type shadowSession struct {
+	SessionAPI
+	shadow      SessionAPI
+	opts        ShadowOptions
+	collections map[string]bool
+	slots       chan struct{} // Held by the pending shadow reads
+	pending     sync.WaitGroup
+}
+
+func (s *shadowSession) DB(name string) DatabaseAPI {
+	return &shadowDatabase{DatabaseAPI: s.SessionAPI.DB(name), shadow: s.shadow.DB(name), session: s, name: name}
+}
+
+func (s *shadowSession) Copy() SessionAPI {
+	return newShadowSession(s.SessionAPI.Copy(), s.shadow.Copy(), s.opts, s.slots)
+}
+
+func (s *shadowSession) Clone() SessionAPI {
+	return newShadowSession(s.SessionAPI.Clone(), s.shadow.Clone(), s.opts, s.slots)
+}
+
+func (s *shadowSession) Close() {
+	s.pending.Wait()
+	s.SessionAPI.Close()
+	s.shadow.Close()
+}
+
+// SetMode, SelectServers, SetSafe, EnsureSafe and Refresh are applied to
+// both sessions, so that shadow reads go to the same servers.
+
+func (s *shadowSession) SetMode(consistency Mode, refresh bool) {
+	s.SessionAPI.SetMode(consistency, refresh)
+	s.shadow.SetMode(consistency, refresh)
+}
+
+func (s *shadowSession) SelectServers(tags ...bson.D) {
+	s.SessionAPI.SelectServers(tags...)
+	s.shadow.SelectServers(tags...)
+}
+
+func (s *shadowSession) SetSafe(safe *Safe) {
+	s.SessionAPI.SetSafe(safe)
+	s.shadow.SetSafe(safe)
+}
+
+func (s *shadowSession) EnsureSafe(safe *Safe) {
+	s.SessionAPI.EnsureSafe(safe)
+	s.shadow.EnsureSafe(safe)
+}
+
+func (s *shadowSession) SetStrictReplace(strict bool) {
+	s.SessionAPI.SetStrictReplace(strict)
+	s.shadow.SetStrictReplace(strict)
+}
+
+func (s *shadowSession) Refresh() {
+	s.SessionAPI.Refresh()
+	s.shadow.Refresh()
+}
+
+// sampled reports whether a read on the named collection is to be
+// shadowed, taking a slot for it if so, which shadowRead releases.
+func (s *shadowSession) sampled(fullName string) bool {
+	if s.opts.OnMismatch == nil || s.collections != nil && !s.collections[fullName] {
+		return false
+	}
+	if s.opts.SampleRate < 1 && rand.Float64() >= s.opts.SampleRate {
+		return false
+	}
+	select {
+	case s.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// shadowRead runs read on the shadow session in the background, with a new
+// value of the type of result, and reports whether its outcome differs
+// from the primary one of result and err. The read must have been sampled.
+func (s *shadowSession) shadowRead(mismatch ShadowMismatch, result interface{}, err error, read func(result interface{}) error) {
+	// The primary result is normalized now, as it's the caller's once
+	// this returns.
+	mismatch.Primary = shadowNormalize(result)
+	mismatch.PrimaryErr = err
+	resultv := reflect.ValueOf(result)
+	s.pending.Add(1)
+	go func() {
+		defer func() {
+			<-s.slots
+			s.pending.Done()
+		}()
+		var shadowResult interface{}
+		switch resultv.Kind() {
+		case reflect.Ptr:
+			shadowResult = reflect.New(resultv.Elem().Type()).Interface()
+		case reflect.Map:
+			// Maps are results in their own right, as with Query.One.
+			shadowResult = reflect.MakeMap(resultv.Type()).Interface()
+		}
+		mismatch.ShadowErr = read(shadowResult)
+		mismatch.Shadow = shadowNormalize(shadowResult)
+		if (mismatch.PrimaryErr == nil) != (mismatch.ShadowErr == nil) ||
+			(mismatch.PrimaryErr == ErrNotFound) != (mismatch.ShadowErr == ErrNotFound) {
+			mismatch.Diff = []string{fmt.Sprintf("error: %v != %v", mismatch.PrimaryErr, mismatch.ShadowErr)}
+		} else if mismatch.PrimaryErr == nil {
+			mismatch.Diff = shadowDiff("", mismatch.Primary, mismatch.Shadow, nil)
+		}
+		if len(mismatch.Diff) > 0 {
+			s.opts.OnMismatch(&mismatch)
+		}
+	}()
+}
+
+type shadowDatabase struct {
+	DatabaseAPI
+	shadow  DatabaseAPI
+	session *shadowSession
+	name    string
+}
+
+func (db *shadowDatabase) C(name string) CollectionAPI {
+	return &shadowCollection{
+		CollectionAPI: db.DatabaseAPI.C(name),
+		shadow:        db.shadow.C(name),
+		session:       db.session,
+		fullName:      db.name + "." + name,
+	}
+}
+
+type shadowCollection struct {
+	CollectionAPI
+	shadow   CollectionAPI
+	session  *shadowSession
+	fullName string
+}
+
+func (c *shadowCollection) Find(query interface{}) QueryAPI {
+	return &shadowQuery{QueryAPI: c.CollectionAPI.Find(query), coll: c, query: query,
+		shadow: func() QueryAPI { return c.shadow.Find(query) }}
+}
+
+func (c *shadowCollection) FindId(id interface{}) QueryAPI {
+	return &shadowQuery{QueryAPI: c.CollectionAPI.FindId(id), coll: c, query: bson.M{"_id": id},
+		shadow: func() QueryAPI { return c.shadow.FindId(id) }}
+}
+
+func (c *shadowCollection) Count() (int, error) {
+	n, err := c.CollectionAPI.Count()
+	if c.session.sampled(c.fullName) {
+		mismatch := ShadowMismatch{Collection: c.fullName, Op: "Count"}
+		c.session.shadowRead(mismatch, &n, err, func(result interface{}) (err error) {
+			*result.(*int), err = c.shadow.Count()
+			return err
+		})
+	}
+	return n, err
+}
+
+func (c *shadowCollection) Pipe(pipeline interface{}) PipeAPI {
+	return &shadowPipe{PipeAPI: c.CollectionAPI.Pipe(pipeline), coll: c, pipeline: pipeline,
+		shadow: func() PipeAPI { return c.shadow.Pipe(pipeline) }}
+}
+
+// shadowQuery runs its reads on the primary query, and builds a new shadow
+// query for each shadow read out of the modifiers applied so far, so that
+// shadow reads run in the background don't share the query the caller
+// may change meanwhile.
+type shadowQuery struct {
+	QueryAPI
+	shadow func() QueryAPI
+	mods   []func(q QueryAPI)
+	coll   *shadowCollection
+	query  interface{}
+}
+
+// modify applies mod to the primary query and records it for the shadow
+// ones.
+func (q *shadowQuery) modify(mod func(q QueryAPI)) QueryAPI {
+	mod(q.QueryAPI)
+	// The slice is never appended to in place, as shadow reads hold it.
+	q.mods = append(q.mods[:len(q.mods):len(q.mods)], mod)
+	return q
+}
+
+// read runs op on the primary query, and on a shadow one if sampled.
+func (q *shadowQuery) read(op string, result interface{}, read func(q QueryAPI, result interface{}) error) error {
+	err := read(q.QueryAPI, result)
+	session := q.coll.session
+	if session.sampled(q.coll.fullName) {
+		shadow := q.shadow()
+		for _, mod := range q.mods {
+			mod(shadow)
+		}
+		mismatch := ShadowMismatch{Collection: q.coll.fullName, Op: op, Query: q.query}
+		session.shadowRead(mismatch, result, err, func(result interface{}) error {
+			return read(shadow, result)
+		})
+	}
+	return err
+}
+
+func (q *shadowQuery) One(result interface{}) error {
+	return q.read("One", result, func(q QueryAPI, result interface{}) error { return q.One(result) })
+}
+
+func (q *shadowQuery) All(result interface{}) error {
+	return q.read("All", result, func(q QueryAPI, result interface{}) error { return q.All(result) })
+}
+
+func (q *shadowQuery) Count() (n int, err error) {
+	err = q.read("Count", &n, func(q QueryAPI, result interface{}) (err error) {
+		*result.(*int), err = q.Count()
+		return err
+	})
+	return n, err
+}
+
+func (q *shadowQuery) Distinct(key string, result interface{}) error {
+	return q.read("Distinct", result, func(q QueryAPI, result interface{}) error { return q.Distinct(key, result) })
+}
+
+// The query modifiers are applied to the primary query and recorded for
+// the shadow ones.
+
+func (q *shadowQuery) Sort(fields ...string) QueryAPI {
+	return q.modify(func(q QueryAPI) { q.Sort(fields...) })
+}
+
+func (q *shadowQuery) Limit(n int) QueryAPI {
+	return q.modify(func(q QueryAPI) { q.Limit(n) })
+}
+
+func (q *shadowQuery) Skip(n int) QueryAPI {
+	return q.modify(func(q QueryAPI) { q.Skip(n) })
+}
+
+func (q *shadowQuery) Select(selector interface{}) QueryAPI {
+	return q.modify(func(q QueryAPI) { q.Select(selector) })
+}
+
+func (q *shadowQuery) Batch(n int) QueryAPI {
+	return q.modify(func(q QueryAPI) { q.Batch(n) })
+}
+
+func (q *shadowQuery) Prefetch(p float64) QueryAPI {
+	return q.modify(func(q QueryAPI) { q.Prefetch(p) })
+}
+
+func (q *shadowQuery) Hint(indexKey ...string) QueryAPI {
+	return q.modify(func(q QueryAPI) { q.Hint(indexKey...) })
+}
+
+func (q *shadowQuery) Collation(collation *Collation) QueryAPI {
+	return q.modify(func(q QueryAPI) { q.Collation(collation) })
+}
+
+func (q *shadowQuery) SetMaxTime(d time.Duration) QueryAPI {
+	return q.modify(func(q QueryAPI) { q.SetMaxTime(d) })
+}
+
+func (q *shadowQuery) Comment(comment string) QueryAPI {
+	return q.modify(func(q QueryAPI) { q.Comment(comment) })
+}
+
+// shadowPipe is to pipes what shadowQuery is to queries.
+type shadowPipe struct {
+	PipeAPI
+	shadow   func() PipeAPI
+	mods     []func(p PipeAPI)
+	coll     *shadowCollection
+	pipeline interface{}
+}
+
+// modify applies mod to the primary pipe and records it for the shadow
+// ones.
+func (p *shadowPipe) modify(mod func(p PipeAPI)) PipeAPI {
+	mod(p.PipeAPI)
+	p.mods = append(p.mods[:len(p.mods):len(p.mods)], mod)
+	return p
+}
+
+// read runs op on the primary pipe, and on a shadow one if sampled.
+func (p *shadowPipe) read(op string, result interface{}, read func(p PipeAPI, result interface{}) error) error {
+	err := read(p.PipeAPI, result)
+	session := p.coll.session
+	if session.sampled(p.coll.fullName) {
+		shadow := p.shadow()
+		for _, mod := range p.mods {
+			mod(shadow)
+		}
+		mismatch := ShadowMismatch{Collection: p.coll.fullName, Op: op, Query: p.pipeline}
+		session.shadowRead(mismatch, result, err, func(result interface{}) error {
+			return read(shadow, result)
+		})
+	}
+	return err
+}
+
+func (p *shadowPipe) One(result interface{}) error {
+	return p.read("PipeOne", result, func(p PipeAPI, result interface{}) error { return p.One(result) })
+}
+
+func (p *shadowPipe) All(result interface{}) error {
+	return p.read("PipeAll", result, func(p PipeAPI, result interface{}) error { return p.All(result) })
+}
+
+// The pipe modifiers are applied to the primary pipe and recorded for the
+// shadow ones.
+
+func (p *shadowPipe) AllowDiskUse() PipeAPI {
+	return p.modify(func(p PipeAPI) { p.AllowDiskUse() })
+}
+
+func (p *shadowPipe) Batch(n int) PipeAPI {
+	return p.modify(func(p PipeAPI) { p.Batch(n) })
+}
+
+func (p *shadowPipe) SetMaxTime(d time.Duration) PipeAPI {
+	return p.modify(func(p PipeAPI) { p.SetMaxTime(d) })
+}
+
+func (p *shadowPipe) Collation(collation *Collation) PipeAPI {
+	return p.modify(func(p PipeAPI) { p.Collation(collation) })
+}
+
+// shadowNormalize returns the value result points to as plain BSON data,
+// with documents as bson.M values and numbers as float64 values, so that
+// results decoded differently by the backends compare equal.
+func shadowNormalize(result interface{}) interface{} {
+	if result == nil {
+		return nil
+	}
+	data, err := bson.Marshal(bson.M{"v": result})
+	if err != nil {
+		return fmt.Sprintf("<%v>", err)
+	}
+	var doc bson.M
+	if err := bson.Unmarshal(data, &doc); err != nil {
+		return fmt.Sprintf("<%v>", err)
+	}
+	return shadowNormalizeValue(doc["v"])
+}
+
+func shadowNormalizeValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case bson.M:
+		for key, elem := range v {
+			v[key] = shadowNormalizeValue(elem)
+		}
+		return v
+	case []interface{}:
+		for i, elem := range v {
+			v[i] = shadowNormalizeValue(elem)
+		}
+		return v
+	case int:
+		return float64(v)
+	case int64:
+		return float64(v)
+	}
+	return value
+}
+
+// shadowDiff appends the differences between the normalized values a and b
+// at path to diff.
+func shadowDiff(path string, a, b interface{}, diff []string) []string {
+	switch av := a.(type) {
+	case bson.M:
+		bv, ok := b.(bson.M)
+		if !ok {
+			break
+		}
+		keys := make([]string, 0, len(av)+len(bv))
+		for key := range av {
+			keys = append(keys, key)
+		}
+		for key := range bv {
+			if _, ok := av[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			aelem, aok := av[key]
+			belem, bok := bv[key]
+			switch {
+			case !aok:
+				diff = append(diff, fmt.Sprintf("%s: <missing> != %#v", shadowPath(path, key), belem))
+			case !bok:
+				diff = append(diff, fmt.Sprintf("%s: %#v != <missing>", shadowPath(path, key), aelem))
+			default:
+				diff = shadowDiff(shadowPath(path, key), aelem, belem, diff)
+			}
+		}
+		return diff
+	case []interface{}:
+		bv, ok := b.([]interface{})
+		if !ok || len(av) != len(bv) {
+			break
+		}
+		for i := range av {
+			diff = shadowDiff(shadowPath(path, fmt.Sprint(i)), av[i], bv[i], diff)
+		}
+		return diff
+	}
+	if !reflect.DeepEqual(a, b) {
+		if path == "" {
+			path = "."
+		}
+		diff = append(diff, fmt.Sprintf("%s: %#v != %#v", path, a, b))
+	}
+	return diff
+}
+
+func shadowPath(path, elem string) string {
+	if path == "" {
+		return elem
+	}
+	return path + "." + elem
+}
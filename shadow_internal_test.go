@@ -0,0 +1,253 @@
+package mgo
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/globalsign/mgo/bson"
+	. "gopkg.in/check.v1"
+)
+
+// The fakeAPI types implement the few API methods the shadow tests need
+// out of a fixed list of documents.
+
+type fakeAPISession struct {
+	SessionAPI
+	docs   []interface{}
+	gate   chan struct{} // If not nil, reads wait for it to be closed
+	reads  int32         // Number of reads, updated atomically
+	closed bool
+}
+
+func (s *fakeAPISession) DB(name string) DatabaseAPI { return fakeAPIDatabase{session: s} }
+func (s *fakeAPISession) Close()                     { s.closed = true }
+func (s *fakeAPISession) Copy() SessionAPI           { return s }
+
+type fakeAPIDatabase struct {
+	DatabaseAPI
+	session *fakeAPISession
+}
+
+func (db fakeAPIDatabase) C(name string) CollectionAPI { return fakeAPICollection{session: db.session} }
+
+type fakeAPICollection struct {
+	CollectionAPI
+	session *fakeAPISession
+}
+
+func (c fakeAPICollection) Find(query interface{}) QueryAPI {
+	return &fakeAPIQuery{session: c.session, docs: c.session.docs}
+}
+func (c fakeAPICollection) Count() (int, error) { return len(c.session.docs), nil }
+
+type fakeAPIQuery struct {
+	QueryAPI
+	session *fakeAPISession
+	docs    []interface{}
+}
+
+func (q *fakeAPIQuery) Skip(n int) QueryAPI {
+	if n > len(q.docs) {
+		n = len(q.docs)
+	}
+	q.docs = q.docs[n:]
+	return q
+}
+
+func (q *fakeAPIQuery) Limit(n int) QueryAPI {
+	if n < len(q.docs) {
+		q.docs = q.docs[:n]
+	}
+	return q
+}
+
+func (q *fakeAPIQuery) One(result interface{}) error {
+	atomic.AddInt32(&q.session.reads, 1)
+	if q.session.gate != nil {
+		<-q.session.gate
+	}
+	if len(q.docs) == 0 {
+		return ErrNotFound
+	}
+	return fakeAPIDecode(q.docs[0], result)
+}
+
+func (q *fakeAPIQuery) All(result interface{}) error {
+	return fakeAPIDecode(q.docs, result)
+}
+
+func fakeAPIDecode(value interface{}, result interface{}) error {
+	data, err := bson.Marshal(bson.M{"v": value})
+	if err != nil {
+		return err
+	}
+	var doc struct{ V bson.Raw }
+	if err := bson.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+	return doc.V.Unmarshal(result)
+}
+
+type shadowRecorder struct {
+	m          sync.Mutex
+	mismatches []*ShadowMismatch
+}
+
+func (r *shadowRecorder) record(mismatch *ShadowMismatch) {
+	r.m.Lock()
+	r.mismatches = append(r.mismatches, mismatch)
+	r.m.Unlock()
+}
+
+func (s *S) TestShadowSessionMatch(c *C) {
+	primary := &fakeAPISession{docs: []interface{}{bson.M{"_id": 1, "n": 1, "f": 1.5}}}
+	shadow := &fakeAPISession{docs: []interface{}{bson.M{"_id": int64(1), "n": 1.0, "f": 1.5}}}
+	recorder := &shadowRecorder{}
+	session := NewShadowSession(primary, shadow, ShadowOptions{SampleRate: 1, OnMismatch: recorder.record})
+
+	coll := session.DB("db").C("coll")
+	var doc bson.M
+	c.Assert(coll.Find(nil).One(&doc), IsNil)
+	c.Assert(doc["n"], Equals, 1)
+	var docs []bson.M
+	c.Assert(coll.Find(nil).All(&docs), IsNil)
+	// Maps are results of their own, without a pointer to them.
+	m := bson.M{}
+	c.Assert(coll.Find(nil).One(m), IsNil)
+	c.Assert(m["n"], Equals, 1)
+	n, err := coll.Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 1)
+
+	session.Close()
+	c.Assert(primary.closed, Equals, true)
+	c.Assert(shadow.closed, Equals, true)
+	c.Assert(recorder.mismatches, HasLen, 0)
+}
+
+func (s *S) TestShadowSessionMismatch(c *C) {
+	primary := &fakeAPISession{docs: []interface{}{bson.M{"_id": 1, "name": "a", "gone": true}, bson.M{"_id": 2}}}
+	shadow := &fakeAPISession{docs: []interface{}{bson.M{"_id": 1, "name": "b", "extra": 1}}}
+	recorder := &shadowRecorder{}
+	session := NewShadowSession(primary, shadow, ShadowOptions{SampleRate: 1, OnMismatch: recorder.record})
+
+	coll := session.DB("db").C("coll")
+	var docs []bson.M
+	c.Assert(coll.Find(bson.M{"x": 1}).All(&docs), IsNil)
+	c.Assert(docs, HasLen, 2)
+	var doc struct {
+		Id   int `bson:"_id"`
+		Name string
+	}
+	c.Assert(coll.Find(nil).Limit(1).One(&doc), IsNil)
+	c.Assert(doc.Name, Equals, "a")
+	session.Close()
+
+	c.Assert(recorder.mismatches, HasLen, 2)
+	for _, mismatch := range recorder.mismatches {
+		switch mismatch.Op {
+		case "All":
+			c.Assert(mismatch.Collection, Equals, "db.coll")
+			c.Assert(mismatch.Query, DeepEquals, bson.M{"x": 1})
+			c.Assert(mismatch.Diff, DeepEquals, []string{`.: []interface {}{bson.M{"_id":1, "gone":true, "name":"a"}, bson.M{"_id":2}} != []interface {}{bson.M{"_id":1, "extra":1, "name":"b"}}`})
+		case "One":
+			// The shadow query got the limit too, and the result of the
+			// same type as the primary one.
+			c.Assert(mismatch.Primary, DeepEquals, bson.M{"_id": 1.0, "name": "a"})
+			c.Assert(mismatch.Diff, DeepEquals, []string{`name: "a" != "b"`})
+		default:
+			c.Fatalf("unexpected mismatch of %s", mismatch.Op)
+		}
+	}
+}
+
+func (s *S) TestShadowSessionErrors(c *C) {
+	primary := &fakeAPISession{}
+	shadow := &fakeAPISession{docs: []interface{}{bson.M{"_id": 1}}}
+	recorder := &shadowRecorder{}
+	session := NewShadowSession(primary, shadow, ShadowOptions{SampleRate: 1, OnMismatch: recorder.record})
+
+	var doc bson.M
+	c.Assert(session.DB("db").C("coll").Find(nil).One(&doc), Equals, ErrNotFound)
+	session.Close()
+
+	c.Assert(recorder.mismatches, HasLen, 1)
+	c.Assert(recorder.mismatches[0].PrimaryErr, Equals, ErrNotFound)
+	c.Assert(recorder.mismatches[0].ShadowErr, IsNil)
+	c.Assert(recorder.mismatches[0].Diff, DeepEquals, []string{"error: not found != <nil>"})
+}
+
+func (s *S) TestShadowSessionSampling(c *C) {
+	primary := &fakeAPISession{docs: []interface{}{bson.M{"n": 1}}}
+	shadow := &fakeAPISession{docs: []interface{}{bson.M{"n": 2}}}
+	recorder := &shadowRecorder{}
+
+	session := NewShadowSession(primary, shadow, ShadowOptions{SampleRate: 0, OnMismatch: recorder.record})
+	var doc bson.M
+	c.Assert(session.DB("db").C("coll").Find(nil).One(&doc), IsNil)
+	session.Close()
+	c.Assert(recorder.mismatches, HasLen, 0)
+
+	session = NewShadowSession(primary, shadow, ShadowOptions{SampleRate: 1, Collections: []string{"db.other"}, OnMismatch: recorder.record})
+	c.Assert(session.DB("db").C("coll").Find(nil).One(&doc), IsNil)
+	c.Assert(session.DB("db").C("other").Find(nil).One(&doc), IsNil)
+	session.Close()
+	c.Assert(recorder.mismatches, HasLen, 1)
+	c.Assert(recorder.mismatches[0].Collection, Equals, "db.other")
+}
+
+func (s *S) TestShadowSessionQueryReused(c *C) {
+	docs := []interface{}{bson.M{"n": 1}, bson.M{"n": 2}}
+	primary := &fakeAPISession{docs: docs}
+	shadow := &fakeAPISession{docs: docs, gate: make(chan struct{})}
+	recorder := &shadowRecorder{}
+	session := NewShadowSession(primary, shadow, ShadowOptions{SampleRate: 1, OnMismatch: recorder.record})
+
+	// Shadow reads run the query as it was when the primary read was made,
+	// even if they only run once it was changed.
+	q := session.DB("db").C("coll").Find(nil)
+	var a, b bson.M
+	c.Assert(q.One(&a), IsNil)
+	c.Assert(q.Skip(1).One(&b), IsNil)
+	c.Assert(a["n"], Equals, 1)
+	c.Assert(b["n"], Equals, 2)
+	close(shadow.gate)
+	session.Close()
+	c.Assert(recorder.mismatches, HasLen, 0)
+}
+
+func (s *S) TestShadowSessionMaxPending(c *C) {
+	primary := &fakeAPISession{docs: []interface{}{bson.M{"n": 1}}}
+	shadow := &fakeAPISession{docs: []interface{}{bson.M{"n": 2}}, gate: make(chan struct{})}
+	recorder := &shadowRecorder{}
+	session := NewShadowSession(primary, shadow, ShadowOptions{SampleRate: 1, MaxPending: 2, OnMismatch: recorder.record})
+
+	// Reads sampled while MaxPending shadow reads are pending, in the
+	// session or its copies, aren't shadowed.
+	copied := session.Copy()
+	var doc bson.M
+	for i := 0; i < 3; i++ {
+		c.Assert(session.DB("db").C("coll").Find(nil).One(&doc), IsNil)
+		c.Assert(copied.DB("db").C("coll").Find(nil).One(&doc), IsNil)
+	}
+	close(shadow.gate)
+	session.Close()
+	copied.Close()
+	c.Assert(recorder.mismatches, HasLen, 2)
+	c.Assert(atomic.LoadInt32(&shadow.reads), Equals, int32(2))
+
+	// Slots are released once shadow reads are done.
+	c.Assert(session.DB("db").C("coll").Find(nil).One(&doc), IsNil)
+	session.Close()
+	c.Assert(recorder.mismatches, HasLen, 3)
+}
+
+func (s *S) TestShadowDiff(c *C) {
+	a := shadowNormalize(&bson.M{"a": bson.M{"b": []interface{}{1, "x"}}, "c": 1})
+	b := shadowNormalize(&bson.M{"a": bson.M{"b": []interface{}{int64(1), "y"}}, "d": 1.0})
+	c.Assert(shadowDiff("", a, b, nil), DeepEquals, []string{
+		`a.b.1: "x" != "y"`,
+		`c: 1 != <missing>`,
+		`d: <missing> != 1`,
+	})
+}
@@ -27,9 +27,14 @@
 package mgo
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	"hash/crc32"
+	"io"
 	"net"
+	"strings"
 	"sync"
 	"time"
 
@@ -49,12 +54,14 @@ type mongoSocket struct {
 	creds          []Credential
 	logout         []Credential
 	cachedNonce    string
+	nonceRequested bool
 	gotNonce       sync.Cond
 	dead           error
 	serverInfo     *mongoServerInfo
 	closeAfterIdle bool
 	lastTimeUsed   time.Time // for time based idle socket release
 	sendMeta       sync.Once
+	wm             sync.Mutex // Keeps write deadlines from applying to other writes.
 
 	dialInfo *DialInfo
 }
@@ -83,6 +90,11 @@ type queryOp struct {
 	hasOptions  bool
 	flags       queryOpFlags
 	readConcern string
+	ctx         context.Context // Bounds the request, if set.
+
+	// sessionFields holds the lsid and txnNumber of commands sent as
+	// OP_MSG, which are added to the command body.
+	sessionFields bson.D
 }
 
 type queryWrapper struct {
@@ -98,28 +110,31 @@ type queryWrapper struct {
 	Collation      *Collation  `bson:"$collation,omitempty"`
 }
 
+// readPreferenceModeName returns the server name for the read preference
+// implied by the mgo consistency mode.
+func readPreferenceModeName(mode Mode) string {
+	switch mode {
+	case Strong:
+		return "primary"
+	case Monotonic, Eventual:
+		return "secondaryPreferred"
+	case PrimaryPreferred:
+		return "primaryPreferred"
+	case Secondary:
+		return "secondary"
+	case SecondaryPreferred:
+		return "secondaryPreferred"
+	case Nearest:
+		return "nearest"
+	}
+	panic(fmt.Sprintf("unsupported read mode: %d", mode))
+}
+
 func (op *queryOp) finalQuery(socket *mongoSocket) interface{} {
 	if op.flags&flagSlaveOk != 0 && socket.ServerInfo().Mongos {
-		var modeName string
-		switch op.mode {
-		case Strong:
-			modeName = "primary"
-		case Monotonic, Eventual:
-			modeName = "secondaryPreferred"
-		case PrimaryPreferred:
-			modeName = "primaryPreferred"
-		case Secondary:
-			modeName = "secondary"
-		case SecondaryPreferred:
-			modeName = "secondaryPreferred"
-		case Nearest:
-			modeName = "nearest"
-		default:
-			panic(fmt.Sprintf("unsupported read mode: %d", op.mode))
-		}
 		op.hasOptions = true
 		op.options.ReadPreference = make(bson.D, 0, 2)
-		op.options.ReadPreference = append(op.options.ReadPreference, bson.DocElem{Name: "mode", Value: modeName})
+		op.options.ReadPreference = append(op.options.ReadPreference, bson.DocElem{Name: "mode", Value: readPreferenceModeName(op.mode)})
 		if len(op.serverTags) > 0 {
 			op.options.ReadPreference = append(op.options.ReadPreference, bson.DocElem{Name: "tags", Value: op.serverTags})
 		}
@@ -137,11 +152,95 @@ func (op *queryOp) finalQuery(socket *mongoSocket) interface{} {
 	return op.query
 }
 
+// isCommand returns whether op is a command, that is, a query against the
+// special $cmd collection of a database.
+func (op *queryOp) isCommand() bool {
+	return strings.HasSuffix(op.collection, ".$cmd")
+}
+
+// finalMsg translates a command op into the equivalent OP_MSG. The read
+// preference that OP_QUERY carries in the slaveOk flag is sent as an explicit
+// $readPreference field instead, since OP_MSG has no such flag.
+func (op *queryOp) finalMsg() *msgOp {
+	msg := &msgOp{
+		body:      op.query,
+		database:  op.collection[:len(op.collection)-len(".$cmd")],
+		replyFunc: op.replyFunc,
+
+		sessionFields: op.sessionFields,
+	}
+	if op.flags&flagSlaveOk != 0 {
+		mode := op.mode
+		if mode == Strong {
+			// The slaveOk bit allows a secondary to answer even in
+			// Strong mode, e.g. when talking to a direct connection.
+			mode = PrimaryPreferred
+		}
+		msg.readPreference = bson.D{{Name: "mode", Value: readPreferenceModeName(mode)}}
+		if len(op.serverTags) > 0 {
+			msg.readPreference = append(msg.readPreference, bson.DocElem{Name: "tags", Value: op.serverTags})
+		}
+	}
+	return msg
+}
+
+// opMsgMinWireVersion is the wire version of MongoDB 3.6, the first release
+// to accept OP_MSG. Servers from MongoDB 5.1 onwards accept nothing else
+// beyond the initial handshake.
+const opMsgMinWireVersion = 6
+
+type msgFlags uint32
+
+const (
+	msgFlagChecksumPresent msgFlags = 1 << 0
+	msgFlagMoreToCome      msgFlags = 1 << 1
+	msgFlagExhaustAllowed  msgFlags = 1 << 16
+)
+
+// msgSection is a document sequence (kind 1) section of an OP_MSG. The
+// documents are sent as if they were an array field named after identifier
+// in the command body, without the cost of marshalling them as an array.
+type msgSection struct {
+	identifier string
+	documents  []interface{}
+}
+
+// msgOp is an OP_MSG request, the only wire protocol message supported by
+// modern servers for commands.
+//
+// Relevant documentation:
+//
+//	https://github.com/mongodb/specifications/blob/master/source/message/OP_MSG.rst
+type msgOp struct {
+	flags          msgFlags
+	body           interface{} // The command document (kind 0 section).
+	database       string      // Sent as $db in the body.
+	readPreference bson.D      // Sent as $readPreference in the body, if set.
+	sections       []msgSection
+	replyFunc      replyFunc // Unused with msgFlagMoreToCome, as no reply is sent.
+	ctx            context.Context
+	sessionFields  bson.D // Sent in the body, such as lsid and txnNumber.
+}
+
 type getMoreOp struct {
 	collection string
 	limit      int32
 	cursorId   int64
 	replyFunc  replyFunc
+	ctx        context.Context
+}
+
+// opContext returns the context op was issued with, or nil if none.
+func opContext(op interface{}) context.Context {
+	switch op := op.(type) {
+	case *queryOp:
+		return op.ctx
+	case *msgOp:
+		return op.ctx
+	case *getMoreOp:
+		return op.ctx
+	}
+	return nil
 }
 
 type replyOp struct {
@@ -174,7 +273,23 @@ type deleteOp struct {
 }
 
 type killCursorsOp struct {
-	cursorIds []int64
+	collection string // "database.collection", used by the killCursors command.
+	cursorIds  []int64
+}
+
+// finalMsg translates op into the killCursors command, which replaced
+// OP_KILL_CURSORS in servers that speak OP_MSG. As with OP_KILL_CURSORS, no
+// reply is requested.
+func (op *killCursorsOp) finalMsg() *msgOp {
+	nameDot := strings.Index(op.collection, ".")
+	return &msgOp{
+		flags: msgFlagMoreToCome,
+		body: bson.D{
+			{Name: "killCursors", Value: op.collection[nameDot+1:]},
+			{Name: "cursors", Value: op.cursorIds},
+		},
+		database: op.collection[:nameDot],
+	}
 }
 
 type requestInfo struct {
@@ -196,7 +311,6 @@ func newSocket(server *mongoServer, conn net.Conn, info *DialInfo) *mongoSocket
 	}
 	stats.socketsAlive(+1)
 	debugf("Socket %p to %s: initialized", socket, socket.addr)
-	socket.resetNonce()
 	go socket.readLoop()
 	return socket
 }
@@ -302,38 +416,46 @@ const (
 	writeDeadline deadlineType = 2
 )
 
-func (socket *mongoSocket) updateDeadline(which deadlineType) {
-	var when time.Time
+// updateDeadline sets the given deadlines on the socket connection out of
+// the dial timeouts. If limit is not zero, the deadlines are set no later
+// than limit, even when there's no timeout.
+func (socket *mongoSocket) updateDeadline(which deadlineType, limit time.Time) {
+	var timeout time.Duration
 	var whichStr string
 	switch which {
 	case readDeadline | writeDeadline:
-		if socket.dialInfo.roundTripTimeout() == 0 {
-			return
-		}
+		timeout = socket.dialInfo.roundTripTimeout()
 		whichStr = "read/write"
-		when = time.Now().Add(socket.dialInfo.roundTripTimeout())
-		socket.conn.SetDeadline(when)
-
 	case readDeadline:
-		if socket.dialInfo.ReadTimeout == zeroDuration {
-			return
-		}
+		timeout = socket.dialInfo.ReadTimeout
 		whichStr = "read"
-		when = time.Now().Add(socket.dialInfo.ReadTimeout)
-		socket.conn.SetReadDeadline(when)
-
 	case writeDeadline:
-		if socket.dialInfo.WriteTimeout == zeroDuration {
-			return
-		}
+		timeout = socket.dialInfo.WriteTimeout
 		whichStr = "write"
-		when = time.Now().Add(socket.dialInfo.WriteTimeout)
-		socket.conn.SetWriteDeadline(when)
-
 	default:
 		panic("invalid parameter to updateDeadline")
 	}
 
+	var when time.Time
+	if timeout != zeroDuration {
+		when = time.Now().Add(timeout)
+	}
+	if !limit.IsZero() && (when.IsZero() || limit.Before(when)) {
+		when = limit
+	}
+	if when.IsZero() {
+		return
+	}
+
+	switch which {
+	case readDeadline | writeDeadline:
+		socket.conn.SetDeadline(when)
+	case readDeadline:
+		socket.conn.SetReadDeadline(when)
+	case writeDeadline:
+		socket.conn.SetWriteDeadline(when)
+	}
+
 	debugf("Socket %p to %s: updated %s deadline to %s", socket, socket.addr, whichStr, when)
 }
 
@@ -383,13 +505,32 @@ func (socket *mongoSocket) kill(err error, abend bool) {
 	}
 }
 
+// SimpleQuery sends op and waits for its single reply document.
 func (socket *mongoSocket) SimpleQuery(op *queryOp) (data []byte, err error) {
-	var wait, change sync.Mutex
+	return socket.simpleRequest(op.ctx, op, &op.replyFunc)
+}
+
+// SimpleMsg sends op and waits for its reply document. Messages flagged with
+// msgFlagMoreToCome have no reply, so SimpleMsg returns as soon as they are
+// sent.
+func (socket *mongoSocket) SimpleMsg(op *msgOp) (data []byte, err error) {
+	if op.flags&msgFlagMoreToCome != 0 {
+		return nil, socket.Query(op)
+	}
+	return socket.simpleRequest(op.ctx, op, &op.replyFunc)
+}
+
+// simpleRequest sends op after pointing its replyFunc to a function that
+// records the reply, and then waits for that reply to arrive. If ctx is
+// done first, the reply is dropped whenever it arrives and ctx.Err() is
+// returned instead.
+func (socket *mongoSocket) simpleRequest(ctx context.Context, op interface{}, opReplyFunc *replyFunc) (data []byte, err error) {
+	var change sync.Mutex
 	var replyDone bool
 	var replyData []byte
 	var replyErr error
-	wait.Lock()
-	op.replyFunc = func(err error, reply *replyOp, docNum int, docData []byte) {
+	wait := make(chan struct{})
+	*opReplyFunc = func(err error, reply *replyOp, docNum int, docData []byte) {
 		change.Lock()
 		if !replyDone {
 			replyDone = true
@@ -397,15 +538,29 @@ func (socket *mongoSocket) SimpleQuery(op *queryOp) (data []byte, err error) {
 			if err == nil {
 				replyData = docData
 			}
+			close(wait)
 		}
 		change.Unlock()
-		wait.Unlock()
 	}
 	err = socket.Query(op)
 	if err != nil {
 		return nil, err
 	}
-	wait.Lock()
+	var done <-chan struct{}
+	if ctx != nil {
+		done = ctx.Done()
+	}
+	select {
+	case <-wait:
+	case <-done:
+		change.Lock()
+		if !replyDone {
+			replyDone = true
+			replyErr = ctx.Err()
+			debugf("Socket %p to %s: dropping reply: %v", socket, socket.addr, replyErr)
+		}
+		change.Unlock()
+	}
 	change.Lock()
 	data = replyData
 	err = replyErr
@@ -421,6 +576,23 @@ var bytesBufferPool = sync.Pool{
 
 func (socket *mongoSocket) Query(ops ...interface{}) (err error) {
 
+	// Find the context with the earliest deadline, which bounds the write.
+	// Nothing is sent if any of the contexts is done already.
+	var ctx context.Context
+	var deadline time.Time
+	for _, op := range ops {
+		opCtx := opContext(op)
+		if opCtx == nil {
+			continue
+		}
+		if err := opCtx.Err(); err != nil {
+			return err
+		}
+		if when, ok := opCtx.Deadline(); ok && (deadline.IsZero() || when.Before(deadline)) {
+			ctx, deadline = opCtx, when
+		}
+	}
+
 	if lops := socket.flushLogout(); len(lops) > 0 {
 		ops = append(lops, ops...)
 	}
@@ -437,6 +609,13 @@ func (socket *mongoSocket) Query(ops ...interface{}) (err error) {
 	requests := make([]requestInfo, len(ops))
 	requestCount := 0
 
+	// Messages may only be checksummed once their request id is known.
+	var checksums []int
+
+	serverInfo := socket.ServerInfo()
+	opMsg := serverInfo.MaxWireVersion >= opMsgMinWireVersion
+	compressor, compress := pickCompressor(serverInfo.Compressors)
+
 	for _, op := range ops {
 		debugf("Socket %p to %s: serializing op: %#v", socket, socket.addr, op)
 		if qop, ok := op.(*queryOp); ok {
@@ -446,6 +625,18 @@ func (socket *mongoSocket) Query(ops ...interface{}) (err error) {
 		}
 		start := len(buf)
 		var replyFunc replyFunc
+		if opMsg {
+			switch qop := op.(type) {
+			case *queryOp:
+				if qop.isCommand() {
+					op = qop.finalMsg()
+				}
+			case *killCursorsOp:
+				if qop.collection != "" {
+					op = qop.finalMsg()
+				}
+			}
+		}
 		switch op := op.(type) {
 
 		case *updateOp:
@@ -521,12 +712,33 @@ func (socket *mongoSocket) Query(ops ...interface{}) (err error) {
 				buf = addInt64(buf, cursorId)
 			}
 
+		case *msgOp:
+			debugf("Socket %p to %s: serializing message: %#v", socket, socket.addr, op.body)
+			buf, err = addMsg(buf, op)
+			if err != nil {
+				return err
+			}
+			if op.flags&msgFlagChecksumPresent != 0 {
+				checksums = append(checksums, start)
+			}
+			if op.flags&msgFlagMoreToCome == 0 {
+				replyFunc = op.replyFunc
+			}
+
 		default:
 			panic("internal error: unknown operation type")
 		}
 
 		setInt32(buf, start, int32(len(buf)-start))
 
+		if compress && compressible(buf[start:]) {
+			buf, err = compressMsg(buf, start, compressor, socket.dialInfo.zlibCompressionLevel())
+			if err != nil {
+				return err
+			}
+			setInt32(buf, start, int32(len(buf)-start))
+		}
+
 		if replyFunc != nil {
 			request := &requests[requestCount]
 			request.replyFunc = replyFunc
@@ -568,18 +780,37 @@ func (socket *mongoSocket) Query(ops ...interface{}) (err error) {
 		requestId++
 	}
 	socket.Unlock()
+	for _, start := range checksums {
+		end := start + int(getInt32(buf, start))
+		setInt32(buf, end-4, int32(crc32.Checksum(buf[start:end-4], castagnoliTable)))
+	}
 	debugf("Socket %p to %s: sending %d op(s) (%d bytes)", socket, socket.addr, len(ops), len(buf))
 
 	stats.sentOps(len(ops))
-	socket.updateDeadline(writeDeadline)
+	socket.wm.Lock()
+	socket.updateDeadline(writeDeadline, deadline)
 	_, err = socket.conn.Write(buf)
+	if !deadline.IsZero() {
+		// Don't let the context deadline linger for later writes.
+		socket.conn.SetWriteDeadline(time.Time{})
+		if err != nil && !time.Now().Before(deadline) {
+			// The write failed past the context deadline, so the
+			// context is done or about to be.
+			<-ctx.Done()
+			err = ctx.Err()
+		}
+	}
+	socket.wm.Unlock()
 	if !wasWaiting && requestCount > 0 {
-		socket.updateDeadline(readDeadline)
+		// The read deadline is not limited by the context, since other
+		// requests may be waiting on the same socket. Instead, callers
+		// stop waiting for the reply once the context is done.
+		socket.updateDeadline(readDeadline, time.Time{})
 	}
 	return err
 }
 
-func fill(r net.Conn, b []byte) error {
+func fill(r io.Reader, b []byte) error {
 	l := len(b)
 	n, err := r.Read(b)
 	for n != l && err == nil {
@@ -593,104 +824,279 @@ func fill(r net.Conn, b []byte) error {
 // Estimated minimum cost per socket: 1 goroutine + memory for the largest
 // document ever seen.
 func (socket *mongoSocket) readLoop() {
-	p := make([]byte, 36) // 16 from header + 20 from OP_REPLY fixed fields
+	h := make([]byte, 16) // Message header
+	p := make([]byte, 20) // OP_REPLY fixed fields
 	s := make([]byte, 4)
 	conn := socket.conn // No locking, conn never changes.
 	for {
-		err := fill(conn, p)
+		err := fill(conn, h)
 		if err != nil {
 			socket.kill(err, true)
 			return
 		}
 
-		totalLen := getInt32(p, 0)
-		responseTo := getInt32(p, 8)
-		opCode := getInt32(p, 12)
+		totalLen := getInt32(h, 0)
+		opCode := getInt32(h, 12)
 
 		// Don't use socket.server.Addr here.  socket is not
 		// locked and socket.server may go away.
 		debugf("Socket %p to %s: got reply (%d bytes)", socket, socket.addr, totalLen)
 
-		_ = totalLen
-
-		if opCode != 1 {
-			socket.kill(errors.New("opcode != 1, corrupted data?"), true)
+		switch opCode {
+		case 1:
+			if !socket.readReply(conn, h, p, s) {
+				return
+			}
+		case 2013:
+			if !socket.readMsg(conn, h) {
+				return
+			}
+		case 2012:
+			if !socket.readCompressed(h, p, s) {
+				return
+			}
+		default:
+			socket.kill(fmt.Errorf("unexpected opcode %d, corrupted data?", opCode), true)
 			return
 		}
 
-		reply := replyOp{
-			flags:     uint32(getInt32(p, 16)),
-			cursorId:  getInt64(p, 20),
-			firstDoc:  getInt32(p, 28),
-			replyDocs: getInt32(p, 32),
-		}
-
-		stats.receivedOps(+1)
-		stats.receivedDocs(int(reply.replyDocs))
-
 		socket.Lock()
-		replyFunc, ok := socket.replyFuncs[uint32(responseTo)]
-		if ok {
-			delete(socket.replyFuncs, uint32(responseTo))
+		if len(socket.replyFuncs) == 0 {
+			// Nothing else to read for now. Disable deadline.
+			socket.conn.SetReadDeadline(time.Time{})
+		} else {
+			socket.updateDeadline(readDeadline, time.Time{})
 		}
 		socket.Unlock()
 
-		if replyFunc != nil && reply.replyDocs == 0 {
-			replyFunc(nil, &reply, -1, nil)
-		} else {
-			for i := 0; i != int(reply.replyDocs); i++ {
-				err := fill(conn, s)
-				if err != nil {
-					if replyFunc != nil {
-						replyFunc(err, nil, -1, nil)
-					}
-					socket.kill(err, true)
-					return
-				}
+		// XXX Do bound checking against totalLen.
+	}
+}
 
-				b := make([]byte, int(getInt32(s, 0)))
+// readReply reads the remainder of an OP_REPLY message whose header is in h,
+// handing each of its documents to the respective replyFunc. It returns false
+// if the socket was killed in the process.
+func (socket *mongoSocket) readReply(conn io.Reader, h, p, s []byte) bool {
+	err := fill(conn, p)
+	if err != nil {
+		socket.kill(err, true)
+		return false
+	}
 
-				// copy(b, s) in an efficient way.
-				b[0] = s[0]
-				b[1] = s[1]
-				b[2] = s[2]
-				b[3] = s[3]
+	responseTo := getInt32(h, 8)
 
-				err = fill(conn, b[4:])
-				if err != nil {
-					if replyFunc != nil {
-						replyFunc(err, nil, -1, nil)
-					}
-					socket.kill(err, true)
-					return
-				}
+	reply := replyOp{
+		flags:     uint32(getInt32(p, 0)),
+		cursorId:  getInt64(p, 4),
+		firstDoc:  getInt32(p, 12),
+		replyDocs: getInt32(p, 16),
+	}
 
-				if globalDebug && globalLogger != nil {
-					m := bson.M{}
-					if err := bson.Unmarshal(b, m); err == nil {
-						debugf("Socket %p to %s: received document: %#v", socket, socket.addr, m)
-					}
-				}
+	stats.receivedOps(+1)
+	stats.receivedDocs(int(reply.replyDocs))
 
-				if replyFunc != nil {
-					replyFunc(nil, &reply, i, b)
-				}
+	socket.Lock()
+	replyFunc, ok := socket.replyFuncs[uint32(responseTo)]
+	if ok {
+		delete(socket.replyFuncs, uint32(responseTo))
+	}
+	socket.Unlock()
 
-				// XXX Do bound checking against totalLen.
+	if replyFunc != nil && reply.replyDocs == 0 {
+		replyFunc(nil, &reply, -1, nil)
+		return true
+	}
+	for i := 0; i != int(reply.replyDocs); i++ {
+		err := fill(conn, s)
+		if err != nil {
+			if replyFunc != nil {
+				replyFunc(err, nil, -1, nil)
 			}
+			socket.kill(err, true)
+			return false
 		}
 
-		socket.Lock()
-		if len(socket.replyFuncs) == 0 {
-			// Nothing else to read for now. Disable deadline.
-			socket.conn.SetReadDeadline(time.Time{})
-		} else {
-			socket.updateDeadline(readDeadline)
+		b := make([]byte, int(getInt32(s, 0)))
+
+		// copy(b, s) in an efficient way.
+		b[0] = s[0]
+		b[1] = s[1]
+		b[2] = s[2]
+		b[3] = s[3]
+
+		err = fill(conn, b[4:])
+		if err != nil {
+			if replyFunc != nil {
+				replyFunc(err, nil, -1, nil)
+			}
+			socket.kill(err, true)
+			return false
+		}
+
+		if globalDebug && globalLogger != nil {
+			m := bson.M{}
+			if err := bson.Unmarshal(b, m); err == nil {
+				debugf("Socket %p to %s: received document: %#v", socket, socket.addr, m)
+			}
+		}
+
+		if replyFunc != nil {
+			replyFunc(nil, &reply, i, b)
 		}
-		socket.Unlock()
 
 		// XXX Do bound checking against totalLen.
 	}
+	return true
+}
+
+// readMsg reads the remainder of an OP_MSG message whose header is in h and
+// hands its body document to the respective replyFunc. It returns false if
+// the socket was killed in the process.
+func (socket *mongoSocket) readMsg(conn io.Reader, h []byte) bool {
+	totalLen := int(getInt32(h, 0))
+	if totalLen < 16+5 {
+		socket.kill(fmt.Errorf("OP_MSG of %d bytes is too short, corrupted data?", totalLen), true)
+		return false
+	}
+	if totalLen > socket.ServerInfo().maxMessageSize() {
+		socket.kill(fmt.Errorf("OP_MSG of %d bytes is too large, corrupted data?", totalLen), true)
+		return false
+	}
+	b := make([]byte, totalLen-16)
+	err := fill(conn, b)
+	if err != nil {
+		socket.kill(err, true)
+		return false
+	}
+
+	requestId := getInt32(h, 4)
+	responseTo := getInt32(h, 8)
+	flags, doc, err := parseMsg(h, b)
+
+	socket.Lock()
+	replyFunc, ok := socket.replyFuncs[uint32(responseTo)]
+	if ok {
+		delete(socket.replyFuncs, uint32(responseTo))
+		if err == nil && flags&msgFlagMoreToCome != 0 {
+			// Further replies will follow, each one in response to
+			// the one before it.
+			socket.replyFuncs[uint32(requestId)] = replyFunc
+		}
+	}
+	socket.Unlock()
+
+	if err != nil {
+		if replyFunc != nil {
+			replyFunc(err, nil, -1, nil)
+		}
+		socket.kill(err, true)
+		return false
+	}
+
+	stats.receivedOps(+1)
+	stats.receivedDocs(1)
+
+	if globalDebug && globalLogger != nil {
+		m := bson.M{}
+		if err := bson.Unmarshal(doc, m); err == nil {
+			debugf("Socket %p to %s: received document: %#v", socket, socket.addr, m)
+		}
+	}
+
+	if replyFunc != nil {
+		replyFunc(nil, &replyOp{replyDocs: 1}, 0, doc)
+	}
+	return true
+}
+
+// readCompressed reads the remainder of an OP_COMPRESSED message whose
+// header is in h, and handles the original message within it as if it had
+// been read from the socket. It returns false if the socket was killed in
+// the process.
+func (socket *mongoSocket) readCompressed(h, p, s []byte) bool {
+	totalLen := int(getInt32(h, 0))
+	if totalLen < 16+9 {
+		socket.kill(fmt.Errorf("OP_COMPRESSED of %d bytes is too short, corrupted data?", totalLen), true)
+		return false
+	}
+	maxSize := socket.ServerInfo().maxMessageSize()
+	if totalLen > maxSize {
+		socket.kill(fmt.Errorf("OP_COMPRESSED of %d bytes is too large, corrupted data?", totalLen), true)
+		return false
+	}
+	b := make([]byte, totalLen-16)
+	err := fill(socket.conn, b)
+	if err != nil {
+		socket.kill(err, true)
+		return false
+	}
+	opCode, body, err := decompressMsg(b, maxSize)
+	if err != nil {
+		socket.kill(err, true)
+		return false
+	}
+
+	// Forge the header the original message would have had.
+	oh := make([]byte, 16)
+	copy(oh, h)
+	setInt32(oh, 0, int32(16+len(body)))
+	setInt32(oh, 12, opCode)
+
+	r := bytes.NewReader(body)
+	switch opCode {
+	case 1:
+		return socket.readReply(r, oh, p, s)
+	case 2013:
+		return socket.readMsg(r, oh)
+	}
+	socket.kill(fmt.Errorf("unexpected compressed opcode %d, corrupted data?", opCode), true)
+	return false
+}
+
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+// parseMsg parses the body b of an OP_MSG message with header h, verifying
+// its checksum if one is present, and returns the body section document.
+func parseMsg(h, b []byte) (flags msgFlags, doc []byte, err error) {
+	if len(b) < 4 {
+		return 0, nil, errors.New("OP_MSG is missing its flags, corrupted data?")
+	}
+	flags = msgFlags(getInt32(b, 0))
+	end := len(b)
+	if flags&msgFlagChecksumPresent != 0 {
+		if end < 8 {
+			return flags, nil, errors.New("OP_MSG is missing its checksum, corrupted data?")
+		}
+		end -= 4
+		sum := crc32.Update(crc32.Checksum(h, castagnoliTable), castagnoliTable, b[:end])
+		if sum != uint32(getInt32(b, end)) {
+			return flags, nil, errors.New("OP_MSG checksum mismatch, corrupted data?")
+		}
+	}
+	for pos := 4; pos < end; {
+		kind := b[pos]
+		pos++
+		if pos+4 > end {
+			return flags, nil, errors.New("OP_MSG section is truncated, corrupted data?")
+		}
+		size := int(getInt32(b, pos))
+		if size < 5 || pos+size > end {
+			return flags, nil, errors.New("OP_MSG section is truncated, corrupted data?")
+		}
+		switch kind {
+		case 0:
+			doc = b[pos : pos+size]
+		case 1:
+			// Servers never reply with document sequences.
+		default:
+			return flags, nil, fmt.Errorf("OP_MSG has unknown section kind %d, corrupted data?", kind)
+		}
+		pos += size
+	}
+	if doc == nil {
+		return flags, nil, errors.New("OP_MSG has no body section, corrupted data?")
+	}
+	return flags, doc, nil
 }
 
 var emptyHeader = []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
@@ -713,6 +1119,53 @@ func addInt64(b []byte, i int64) []byte {
 		byte(i>>32), byte(i>>40), byte(i>>48), byte(i>>56))
 }
 
+// addMsg appends op to b as a complete OP_MSG, except for its length and
+// request id which are set by the caller as usual. Room for the checksum is
+// reserved when op requests one.
+func addMsg(b []byte, op *msgOp) ([]byte, error) {
+	var err error
+	b = addHeader(b, 2013)
+	b = addInt32(b, int32(op.flags))
+
+	b = append(b, 0)
+	start := len(b)
+	b, err = addBSON(b, op.body)
+	if err != nil {
+		return b, err
+	}
+	fields := bson.D{{Name: "$db", Value: op.database}}
+	if len(op.readPreference) > 0 {
+		fields = append(fields, bson.DocElem{Name: "$readPreference", Value: op.readPreference})
+	}
+	fields = append(fields, op.sessionFields...)
+	extra, err := bson.Marshal(fields)
+	if err != nil {
+		return b, err
+	}
+	// Replace the body terminator with the extra fields and their own.
+	b = append(b[:len(b)-1], extra[4:]...)
+	setInt32(b, start, int32(len(b)-start))
+
+	for _, section := range op.sections {
+		b = append(b, 1)
+		start := len(b)
+		b = addInt32(b, 0) // Size, set below.
+		b = addCString(b, section.identifier)
+		for _, doc := range section.documents {
+			b, err = addBSON(b, doc)
+			if err != nil {
+				return b, err
+			}
+		}
+		setInt32(b, start, int32(len(b)-start))
+	}
+
+	if op.flags&msgFlagChecksumPresent != 0 {
+		b = addInt32(b, 0)
+	}
+	return b, nil
+}
+
 func addCString(b []byte, s string) []byte {
 	b = append(b, []byte(s)...)
 	b = append(b, 0)
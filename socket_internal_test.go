@@ -0,0 +1,317 @@
+package mgo
+
+import (
+	"context"
+	"errors"
+	"hash/crc32"
+	"net"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	. "gopkg.in/check.v1"
+)
+
+func (s *S) TestMsgRoundTrip(c *C) {
+	op := &msgOp{
+		body:           bson.D{{Name: "insert", Value: "coll"}, {Name: "ordered", Value: true}},
+		database:       "db",
+		readPreference: bson.D{{Name: "mode", Value: "secondary"}},
+		sections: []msgSection{
+			{"documents", []interface{}{bson.M{"n": 1}, bson.M{"n": 2}}},
+		},
+	}
+	b, err := addMsg(nil, op)
+	c.Assert(err, IsNil)
+	setInt32(b, 0, int32(len(b)))
+	c.Assert(getInt32(b, 12), Equals, int32(2013))
+
+	flags, doc, err := parseMsg(b[:16], b[16:])
+	c.Assert(err, IsNil)
+	c.Assert(flags, Equals, msgFlags(0))
+
+	var body bson.D
+	c.Assert(bson.Unmarshal(doc, &body), IsNil)
+	c.Assert(body, DeepEquals, bson.D{
+		{Name: "insert", Value: "coll"},
+		{Name: "ordered", Value: true},
+		{Name: "$db", Value: "db"},
+		{Name: "$readPreference", Value: bson.D{{Name: "mode", Value: "secondary"}}},
+	})
+
+	// The document sequence follows the body section.
+	pos := 16 + 4 + 1 + len(doc)
+	c.Assert(b[pos], Equals, byte(1))
+	size := int(getInt32(b, pos+1))
+	c.Assert(pos+1+size, Equals, len(b))
+	c.Assert(string(b[pos+5:pos+5+len("documents")]), Equals, "documents")
+
+	var first, second bson.M
+	docs := b[pos+5+len("documents")+1:]
+	c.Assert(bson.Unmarshal(docs[:getInt32(docs, 0)], &first), IsNil)
+	docs = docs[getInt32(docs, 0):]
+	c.Assert(bson.Unmarshal(docs, &second), IsNil)
+	c.Assert(first, DeepEquals, bson.M{"n": 1})
+	c.Assert(second, DeepEquals, bson.M{"n": 2})
+}
+
+func (s *S) TestMsgChecksum(c *C) {
+	op := &msgOp{
+		flags:    msgFlagChecksumPresent,
+		body:     bson.D{{Name: "ping", Value: 1}},
+		database: "admin",
+	}
+	b, err := addMsg(nil, op)
+	c.Assert(err, IsNil)
+	setInt32(b, 0, int32(len(b)))
+	setInt32(b, len(b)-4, int32(crc32.Checksum(b[:len(b)-4], castagnoliTable)))
+
+	flags, _, err := parseMsg(b[:16], b[16:])
+	c.Assert(err, IsNil)
+	c.Assert(flags, Equals, msgFlagChecksumPresent)
+
+	b[len(b)-6]++
+	_, _, err = parseMsg(b[:16], b[16:])
+	c.Assert(err, ErrorMatches, "OP_MSG checksum mismatch, corrupted data\\?")
+}
+
+func (s *S) TestParseMsgErrors(c *C) {
+	h := make([]byte, 16)
+	_, _, err := parseMsg(h, []byte{0, 0})
+	c.Assert(err, ErrorMatches, "OP_MSG is missing its flags.*")
+	_, _, err = parseMsg(h, []byte{0, 0, 0, 0})
+	c.Assert(err, ErrorMatches, "OP_MSG has no body section.*")
+	_, _, err = parseMsg(h, []byte{0, 0, 0, 0, 2, 5, 0, 0, 0, 0})
+	c.Assert(err, ErrorMatches, "OP_MSG has unknown section kind 2.*")
+	_, _, err = parseMsg(h, []byte{0, 0, 0, 0, 0, 9, 0, 0, 0, 0})
+	c.Assert(err, ErrorMatches, "OP_MSG section is truncated.*")
+}
+
+func (s *S) TestQueryOpFinalMsg(c *C) {
+	op := &queryOp{
+		collection: "db.$cmd",
+		query:      bson.D{{Name: "count", Value: "coll"}},
+		flags:      flagSlaveOk,
+		mode:       Strong,
+		serverTags: []bson.D{{{Name: "dc", Value: "ny"}}},
+	}
+	msg := op.finalMsg()
+	c.Assert(msg.database, Equals, "db")
+	c.Assert(msg.body, DeepEquals, op.query)
+	c.Assert(msg.readPreference, DeepEquals, bson.D{
+		{Name: "mode", Value: "primaryPreferred"},
+		{Name: "tags", Value: op.serverTags},
+	})
+
+	op.flags = 0
+	c.Assert(op.finalMsg().readPreference, IsNil)
+}
+
+func (s *S) TestKillCursorsOpFinalMsg(c *C) {
+	op := &killCursorsOp{"db.coll", []int64{42}}
+	msg := op.finalMsg()
+	c.Assert(msg.flags, Equals, msgFlagMoreToCome)
+	c.Assert(msg.database, Equals, "db")
+	c.Assert(msg.body, DeepEquals, bson.D{
+		{Name: "killCursors", Value: "coll"},
+		{Name: "cursors", Value: []int64{42}},
+	})
+}
+
+// fakeMsgServer reads a single message from conn and replies to it with an
+// OP_MSG holding reply, returning the body of the request.
+func fakeMsgServer(conn net.Conn, reply interface{}) (bson.D, error) {
+	h, body, err := fakeMsgRequest(conn)
+	if err != nil {
+		return nil, err
+	}
+	return body, fakeMsgReply(conn, h, reply)
+}
+
+// fakeMsgRequest reads a single OP_MSG from conn, returning its header and
+// its body.
+func fakeMsgRequest(conn net.Conn) ([]byte, bson.D, error) {
+	h := make([]byte, 16)
+	if err := fill(conn, h); err != nil {
+		return nil, nil, err
+	}
+	if getInt32(h, 12) != 2013 {
+		return nil, nil, errors.New("request is not an OP_MSG")
+	}
+	b := make([]byte, getInt32(h, 0)-16)
+	if err := fill(conn, b); err != nil {
+		return nil, nil, err
+	}
+	_, doc, err := parseMsg(h, b)
+	if err != nil {
+		return nil, nil, err
+	}
+	var body bson.D
+	if err := bson.Unmarshal(doc, &body); err != nil {
+		return nil, nil, err
+	}
+	return h, body, nil
+}
+
+// fakeMsgReply replies with an OP_MSG holding reply to the request with
+// header h.
+func fakeMsgReply(conn net.Conn, h []byte, reply interface{}) error {
+	r, err := addMsg(nil, &msgOp{body: reply})
+	if err != nil {
+		return err
+	}
+	// addMsg always appends $db, which a server would not send back.
+	r = r[:21]
+	r, _ = addBSON(r, reply)
+	setInt32(r, 0, int32(len(r)))
+	setInt32(r, 8, getInt32(h, 4))
+	_, err = conn.Write(r)
+	return err
+}
+
+func (s *S) TestSocketQueryUsesMsg(c *C) {
+	client, server := net.Pipe()
+	defer server.Close()
+
+	mserver := &mongoServer{Addr: "fake", info: &mongoServerInfo{MaxWireVersion: opMsgMinWireVersion}}
+	socket := newSocket(mserver, client, &DialInfo{})
+	defer socket.kill(errors.New("test done"), false)
+
+	done := make(chan bson.D, 1)
+	go func() {
+		body, err := fakeMsgServer(server, bson.M{"ok": 1, "n": 3})
+		c.Check(err, IsNil)
+		done <- body
+	}()
+
+	op := &queryOp{
+		collection: "db.$cmd",
+		query:      bson.D{{Name: "count", Value: "coll"}},
+		limit:      -1,
+	}
+	data, err := socket.SimpleQuery(op)
+	c.Assert(err, IsNil)
+
+	var result struct{ N int }
+	c.Assert(bson.Unmarshal(data, &result), IsNil)
+	c.Assert(result.N, Equals, 3)
+	c.Assert(<-done, DeepEquals, bson.D{{Name: "count", Value: "coll"}, {Name: "$db", Value: "db"}})
+}
+
+func (s *S) TestSocketMsgTooLarge(c *C) {
+	client, server := net.Pipe()
+	defer server.Close()
+
+	mserver := &mongoServer{Addr: "fake", info: &mongoServerInfo{
+		MaxWireVersion: opMsgMinWireVersion,
+		MaxMessageSize: 1024,
+	}}
+	socket := newSocket(mserver, client, &DialInfo{})
+	defer socket.kill(errors.New("test done"), false)
+
+	done := make(chan error, 1)
+	go func() {
+		h, _, err := fakeMsgRequest(server)
+		if err != nil {
+			done <- err
+			return
+		}
+		// Only the header is sent, as the length must be rejected before
+		// the rest of the message is read.
+		r := make([]byte, 16)
+		setInt32(r, 0, 1025)
+		setInt32(r, 8, getInt32(h, 4))
+		setInt32(r, 12, 2013)
+		_, err = server.Write(r)
+		done <- err
+	}()
+
+	op := &queryOp{
+		collection: "db.$cmd",
+		query:      bson.D{{Name: "count", Value: "coll"}},
+		limit:      -1,
+	}
+	_, err := socket.SimpleQuery(op)
+	c.Assert(err, ErrorMatches, "OP_MSG of 1025 bytes is too large.*")
+	c.Assert(<-done, IsNil)
+}
+
+func (s *S) TestSocketQueryContextCanceled(c *C) {
+	client, server := net.Pipe()
+	defer server.Close()
+
+	mserver := &mongoServer{Addr: "fake", info: &mongoServerInfo{MaxWireVersion: opMsgMinWireVersion}}
+	socket := newSocket(mserver, client, &DialInfo{})
+	defer socket.kill(errors.New("test done"), false)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	release := make(chan bool)
+	done := make(chan error, 1)
+	go func() {
+		done <- func() error {
+			h, _, err := fakeMsgRequest(server)
+			if err != nil {
+				return err
+			}
+			cancel()
+			<-release
+			if err := fakeMsgReply(server, h, bson.M{"ok": 1, "n": 1}); err != nil {
+				return err
+			}
+			_, err = fakeMsgServer(server, bson.M{"ok": 1, "n": 2})
+			return err
+		}()
+	}()
+
+	op := &queryOp{
+		collection: "db.$cmd",
+		query:      bson.D{{Name: "count", Value: "coll"}},
+		limit:      -1,
+		ctx:        ctx,
+	}
+	_, err := socket.SimpleQuery(op)
+	c.Assert(err, Equals, context.Canceled)
+	close(release)
+
+	// The late reply is dropped, and the socket is still usable.
+	op = &queryOp{
+		collection: "db.$cmd",
+		query:      bson.D{{Name: "count", Value: "coll"}},
+		limit:      -1,
+	}
+	data, err := socket.SimpleQuery(op)
+	c.Assert(err, IsNil)
+	c.Assert(<-done, IsNil)
+
+	var result struct{ N int }
+	c.Assert(bson.Unmarshal(data, &result), IsNil)
+	c.Assert(result.N, Equals, 2)
+}
+
+func (s *S) TestSocketQueryContextDeadline(c *C) {
+	client, server := net.Pipe()
+	defer server.Close()
+
+	mserver := &mongoServer{Addr: "fake", info: &mongoServerInfo{MaxWireVersion: opMsgMinWireVersion}}
+	socket := newSocket(mserver, client, &DialInfo{})
+	defer socket.kill(errors.New("test done"), false)
+
+	op := &queryOp{
+		collection: "db.$cmd",
+		query:      bson.D{{Name: "count", Value: "coll"}},
+		limit:      -1,
+	}
+
+	// Nothing is sent once the context is done.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	op.ctx = ctx
+	_, err := socket.SimpleQuery(op)
+	c.Assert(err, Equals, context.Canceled)
+
+	// Nobody reads from the pipe, so the write blocks until the deadline.
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	op.ctx = ctx
+	_, err = socket.SimpleQuery(op)
+	c.Assert(err, Equals, context.DeadlineExceeded)
+}
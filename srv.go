@@ -0,0 +1,166 @@
+package mgo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Resolver looks up the DNS records behind mongodb+srv URLs. It's
+// implemented by *net.Resolver, and may be replaced in DialInfo to use a
+// custom DNS server or to fake one in tests.
+type Resolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (cname string, addrs []*net.SRV, err error)
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+// How often the SRV records are looked up again once connected, so that
+// seed list changes are picked up.
+const srvPollInterval = 60 * time.Second
+
+// How long to wait for DNS answers when no timeout is set on dial.
+const srvDefaultTimeout = 10 * time.Second
+
+func srvContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		timeout = srvDefaultTimeout
+	}
+	return context.WithTimeout(context.Background(), timeout)
+}
+
+// validateSRVName checks that name may be used in a mongodb+srv URL, which
+// requires at least three labels so that there's a parent domain to check
+// the discovered servers against.
+func validateSRVName(name string) error {
+	if strings.Contains(name, ":") {
+		return errors.New("mongodb+srv URLs can't have a port: " + name)
+	}
+	if strings.Contains(name, ",") {
+		return errors.New("mongodb+srv URLs can't have more than one host: " + name)
+	}
+	if len(strings.Split(name, ".")) < 3 {
+		return errors.New("mongodb+srv URLs need a host with at least three labels: " + name)
+	}
+	return nil
+}
+
+// lookupSRVSeeds returns the addresses of the servers listed in the SRV
+// records for name, which must all be within the parent domain of name.
+func lookupSRVSeeds(resolver Resolver, name string, timeout time.Duration) ([]string, error) {
+	ctx, cancel := srvContext(timeout)
+	defer cancel()
+	_, records, err := resolver.LookupSRV(ctx, "mongodb", "tcp", name)
+	if err != nil {
+		return nil, fmt.Errorf("cannot look up SRV records for %s: %v", name, err)
+	}
+	if len(records) == 0 {
+		return nil, errors.New("no SRV records found for " + name)
+	}
+
+	parent := name[strings.Index(name, "."):]
+	addrs := make([]string, 0, len(records))
+	for _, record := range records {
+		target := strings.TrimSuffix(record.Target, ".")
+		if !strings.HasSuffix(target, parent) {
+			return nil, fmt.Errorf("SRV record for %s points outside of its domain: %s", name, target)
+		}
+		addrs = append(addrs, net.JoinHostPort(target, strconv.Itoa(int(record.Port))))
+	}
+	return addrs, nil
+}
+
+// lookupSRVOptions returns the connection options in the TXT record for
+// name, if any. Only authSource and replicaSet may be provided that way.
+func lookupSRVOptions(resolver Resolver, name string, timeout time.Duration) ([]urlInfoOption, error) {
+	ctx, cancel := srvContext(timeout)
+	defer cancel()
+	records, err := resolver.LookupTXT(ctx, name)
+	if err != nil {
+		if dnsErr, ok := err.(*net.DNSError); ok && dnsErr.IsNotFound {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("cannot look up TXT records for %s: %v", name, err)
+	}
+	if len(records) > 1 {
+		return nil, errors.New("more than one TXT record found for " + name)
+	}
+	var options []urlInfoOption
+	for _, record := range records {
+		for _, pair := range strings.FieldsFunc(record, isOptSep) {
+			l := strings.SplitN(pair, "=", 2)
+			if len(l) != 2 || l[0] == "" || l[1] == "" {
+				return nil, errors.New("TXT record option must be key=value: " + pair)
+			}
+			switch l[0] {
+			case "authSource", "replicaSet":
+				options = append(options, urlInfoOption{key: l[0], value: l[1]})
+			default:
+				return nil, errors.New("unsupported TXT record option: " + pair)
+			}
+		}
+	}
+	return options, nil
+}
+
+func (i *DialInfo) resolver() Resolver {
+	if i.Resolver == nil {
+		return net.DefaultResolver
+	}
+	return i.Resolver
+}
+
+// resolveSRV replaces the seed addresses with those listed in the SRV
+// records for SRVName, and sets Source and ReplicaSetName out of its TXT
+// record unless they were set already.
+func (i *DialInfo) resolveSRV() error {
+	addrs, err := lookupSRVSeeds(i.resolver(), i.SRVName, i.Timeout)
+	if err != nil {
+		return err
+	}
+	options, err := lookupSRVOptions(i.resolver(), i.SRVName, i.Timeout)
+	if err != nil {
+		return err
+	}
+	for _, opt := range options {
+		switch opt.key {
+		case "authSource":
+			if i.Source == "" {
+				i.Source = opt.value
+			}
+		case "replicaSet":
+			if i.ReplicaSetName == "" {
+				i.ReplicaSetName = opt.value
+			}
+		}
+	}
+	i.Addrs = addrs
+	return nil
+}
+
+// pollSRV looks up the SRV records behind the cluster seeds again, and
+// replaces the seeds with the current ones. Lookup failures leave the
+// seeds alone, as the servers may well still be reachable.
+func (cluster *mongoCluster) pollSRV() {
+	cluster.RLock()
+	info := cluster.dialInfo
+	due := info.SRVName != "" && time.Since(cluster.srvPolled) >= srvPollInterval
+	cluster.RUnlock()
+	if !due {
+		return
+	}
+
+	addrs, err := lookupSRVSeeds(info.resolver(), info.SRVName, info.Timeout)
+	cluster.Lock()
+	cluster.srvPolled = time.Now()
+	if err != nil {
+		logf("SYNC Keeping seeds after SRV lookup failure: %v", err)
+	} else {
+		debugf("SYNC New SRV seeds: %#v", addrs)
+		cluster.userSeeds = addrs
+	}
+	cluster.Unlock()
+}
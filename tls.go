@@ -0,0 +1,207 @@
+package mgo
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/youmark/pkcs8"
+)
+
+// tlsOptions holds the TLS related options of a connection URL.
+type tlsOptions struct {
+	enabled           bool
+	disabled          bool // Explicitly, as in tls=false.
+	caFile            string
+	certKeyFile       string
+	certKeyPassword   string
+	insecure          bool
+	allowInvalidHosts bool
+}
+
+// set records the given URL option, returning whether it's a TLS option.
+func (o *tlsOptions) set(key, value string) (bool, error) {
+	var err error
+	var flag *bool
+	switch key {
+	case "tls", "ssl":
+		v, perr := strconv.ParseBool(value)
+		if perr != nil {
+			if key == "ssl" {
+				// Historically ignored, so keep accepting it.
+				return true, nil
+			}
+			return true, errors.New("bad value for tls: " + value)
+		}
+		o.enabled = o.enabled || v
+		o.disabled = o.disabled || !v
+	case "tlsCAFile":
+		o.caFile = value
+	case "tlsCertificateKeyFile":
+		o.certKeyFile = value
+	case "tlsCertificateKeyFilePassword":
+		o.certKeyPassword = value
+	case "tlsInsecure":
+		flag = &o.insecure
+	case "tlsAllowInvalidHostnames":
+		flag = &o.allowInvalidHosts
+	default:
+		return false, nil
+	}
+	if flag != nil {
+		*flag, err = strconv.ParseBool(value)
+		if err != nil {
+			return true, errors.New("bad value for " + key + ": " + value)
+		}
+	}
+	return true, nil
+}
+
+// config returns the TLS configuration described by the options, or nil if
+// TLS was not requested.
+func (o *tlsOptions) config() (*tls.Config, error) {
+	requested := o.caFile != "" || o.certKeyFile != "" || o.certKeyPassword != "" || o.insecure || o.allowInvalidHosts
+	if o.disabled && (o.enabled || requested) {
+		return nil, errors.New("TLS options can't be used along with tls=false")
+	}
+	if !o.enabled && !requested {
+		return nil, nil
+	}
+	if o.insecure && o.allowInvalidHosts {
+		return nil, errors.New("tlsInsecure and tlsAllowInvalidHostnames can't be used together")
+	}
+
+	config := &tls.Config{}
+	if o.caFile != "" {
+		pem, err := ioutil.ReadFile(o.caFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read tlsCAFile: %v", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificates found in tlsCAFile " + o.caFile)
+		}
+	}
+	if o.certKeyFile != "" {
+		data, err := ioutil.ReadFile(o.certKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read tlsCertificateKeyFile: %v", err)
+		}
+		cert, err := loadCertificateKey(data, o.certKeyPassword)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load tlsCertificateKeyFile: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	if o.insecure {
+		config.InsecureSkipVerify = true
+	}
+	if o.allowInvalidHosts {
+		allowInvalidHostnames(config)
+	}
+	return config, nil
+}
+
+// loadCertificateKey loads a certificate and its private key from PEM data,
+// as found in a tlsCertificateKeyFile. The key is decrypted with password
+// when it's encrypted, either in PKCS#8 or in the legacy OpenSSL format.
+func loadCertificateKey(data []byte, password string) (tls.Certificate, error) {
+	var certPEM, keyPEM []byte
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		switch {
+		case block.Type == "CERTIFICATE":
+			certPEM = append(certPEM, pem.EncodeToMemory(block)...)
+		case block.Type == "ENCRYPTED PRIVATE KEY":
+			key, err := pkcs8.ParsePKCS8PrivateKey(block.Bytes, []byte(password))
+			if err != nil {
+				return tls.Certificate{}, err
+			}
+			der, err := x509.MarshalPKCS8PrivateKey(key)
+			if err != nil {
+				return tls.Certificate{}, err
+			}
+			keyPEM = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+		case x509.IsEncryptedPEMBlock(block):
+			der, err := x509.DecryptPEMBlock(block, []byte(password))
+			if err != nil {
+				return tls.Certificate{}, err
+			}
+			keyPEM = pem.EncodeToMemory(&pem.Block{Type: block.Type, Bytes: der})
+		default:
+			keyPEM = pem.EncodeToMemory(block)
+		}
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return cert, err
+	}
+	cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	return cert, err
+}
+
+// allowInvalidHostnames changes config so the server certificate is still
+// verified against the trusted authorities, but not against the hostname.
+func allowInvalidHostnames(config *tls.Config) {
+	config.InsecureSkipVerify = true
+	roots := config.RootCAs
+	config.VerifyConnection = func(state tls.ConnectionState) error {
+		if len(state.PeerCertificates) == 0 {
+			return errors.New("server presented no TLS certificate")
+		}
+		opts := x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: x509.NewCertPool(),
+		}
+		for _, cert := range state.PeerCertificates[1:] {
+			opts.Intermediates.AddCert(cert)
+		}
+		_, err := state.PeerCertificates[0].Verify(opts)
+		return err
+	}
+}
+
+// dialTLS performs the TLS handshake over conn, an established connection
+// to addr. The handshake must complete within timeout, if it is non-zero.
+func dialTLS(conn net.Conn, addr string, config *tls.Config, timeout time.Duration) (net.Conn, error) {
+	if config.ServerName == "" {
+		config = config.Clone()
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			host = addr
+		}
+		config.ServerName = host
+	}
+	tlsConn := tls.Client(conn, config)
+	if timeout > 0 {
+		tlsConn.SetDeadline(time.Now().Add(timeout))
+	}
+	if err := tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	tlsConn.SetDeadline(time.Time{})
+	return tlsConn, nil
+}
+
+// clientCertificate returns the client certificate within config, if any.
+func clientCertificate(config *tls.Config) (*x509.Certificate, error) {
+	if config == nil || len(config.Certificates) == 0 || len(config.Certificates[0].Certificate) == 0 {
+		return nil, nil
+	}
+	cert := config.Certificates[0]
+	if cert.Leaf != nil {
+		return cert.Leaf, nil
+	}
+	return x509.ParseCertificate(cert.Certificate[0])
+}
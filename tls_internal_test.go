@@ -0,0 +1,123 @@
+package mgo
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *S) TestParseURLTLS(c *C) {
+	info, err := ParseURL("localhost:40001")
+	c.Assert(err, IsNil)
+	c.Assert(info.TLSConfig, IsNil)
+
+	for _, url := range []string{"localhost:40001?ssl=true", "localhost:40001?tls=true"} {
+		info, err = ParseURL(url)
+		c.Assert(err, IsNil)
+		c.Assert(info.TLSConfig, NotNil)
+		c.Assert(info.TLSConfig.InsecureSkipVerify, Equals, false)
+		c.Assert(info.DialServer, IsNil)
+	}
+
+	// Any TLS option implies tls=true.
+	info, err = ParseURL("localhost:40001?tlsInsecure=true")
+	c.Assert(err, IsNil)
+	c.Assert(info.TLSConfig.InsecureSkipVerify, Equals, true)
+
+	info, err = ParseURL("localhost:40001?tlsCAFile=harness/certs/server.crt&tlsCertificateKeyFile=harness/certs/client.pem")
+	c.Assert(err, IsNil)
+	c.Assert(info.TLSConfig.RootCAs, NotNil)
+	c.Assert(info.TLSConfig.Certificates, HasLen, 1)
+	c.Assert(info.Copy().TLSConfig, Equals, info.TLSConfig)
+
+	bad := []string{
+		"localhost:40001?tls=maybe",
+		"localhost:40001?tls=false&tlsInsecure=true",
+		"localhost:40001?tlsInsecure=true&tlsAllowInvalidHostnames=true",
+		"localhost:40001?tlsAllowInvalidHostnames=sure",
+		"localhost:40001?tlsCAFile=harness/certs/missing.crt",
+		"localhost:40001?tlsCertificateKeyFile=harness/certs/server.crt",
+	}
+	for _, url := range bad {
+		_, err := ParseURL(url)
+		c.Assert(err, NotNil, Commentf("URL: %s", url))
+	}
+}
+
+func (s *S) TestLoadEncryptedCertificateKey(c *C) {
+	data, err := ioutil.ReadFile("harness/certs/client.pem")
+	c.Assert(err, IsNil)
+
+	var out []byte
+	for rest := data; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type == "RSA PRIVATE KEY" {
+			block, err = x509.EncryptPEMBlock(rand.Reader, block.Type, block.Bytes, []byte("secret"), x509.PEMCipherAES256)
+			c.Assert(err, IsNil)
+		}
+		out = append(out, pem.EncodeToMemory(block)...)
+	}
+	path := filepath.Join(c.MkDir(), "client.pem")
+	c.Assert(ioutil.WriteFile(path, out, 0600), IsNil)
+
+	_, err = ParseURL("localhost:40001?tlsCertificateKeyFile=" + path)
+	c.Assert(err, NotNil)
+
+	info, err := ParseURL("localhost:40001?tlsCertificateKeyFile=" + path + "&tlsCertificateKeyFilePassword=secret")
+	c.Assert(err, IsNil)
+
+	cert, err := clientCertificate(info.TLSConfig)
+	c.Assert(err, IsNil)
+	name, err := getRFC2253NameStringFromCert(cert)
+	c.Assert(err, IsNil)
+	c.Assert(name, Equals, "CN=localhost,OU=Client,O=MGO,L=MGO,ST=MGO,C=GO")
+}
+
+func (s *S) TestDialTLSAllowInvalidHostnames(c *C) {
+	cert, err := tls.LoadX509KeyPair("harness/certs/server.pem", "harness/certs/server.pem")
+	c.Assert(err, IsNil)
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	c.Assert(err, IsNil)
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+
+	dial := func(url string) error {
+		info, err := ParseURL(url)
+		c.Assert(err, IsNil)
+		conn, err := net.Dial("tcp", l.Addr().String())
+		c.Assert(err, IsNil)
+		conn, err = dialTLS(conn, l.Addr().String(), info.TLSConfig, 5*time.Second)
+		if err == nil {
+			conn.Close()
+		}
+		return err
+	}
+
+	// The certificate has no subject alternative names, so its hostname
+	// can't be verified, and it isn't signed by a well known authority.
+	c.Assert(dial("localhost:40001?tls=true"), NotNil)
+	c.Assert(dial("localhost:40001?tlsCAFile=harness/certs/server.crt"), NotNil)
+	c.Assert(dial("localhost:40001?tlsAllowInvalidHostnames=true"), NotNil)
+	c.Assert(dial("localhost:40001?tlsCAFile=harness/certs/server.crt&tlsAllowInvalidHostnames=true"), IsNil)
+	c.Assert(dial("localhost:40001?tlsInsecure=true"), IsNil)
+}
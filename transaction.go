@@ -0,0 +1,403 @@
+package mgo
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+// TransactionOptions holds the options of a multi-document transaction.
+// See Session.StartTransaction.
+type TransactionOptions struct {
+	// ReadConcern is the read concern level of the reads in the
+	// transaction, such as "snapshot" or "majority". Defaults to the
+	// server default.
+	ReadConcern string
+
+	// WriteConcern is the write concern the transaction is committed
+	// with. Defaults to the safety settings of the session. See SetSafe.
+	WriteConcern *Safe
+
+	// MaxCommitTime bounds the time the server may spend committing the
+	// transaction. Defaults to no limit.
+	MaxCommitTime time.Duration
+}
+
+// Error labels attached by the server to errors in transactions, and by the
+// driver to network errors in them. See QueryError.HasErrorLabel.
+const (
+	// TransientTransactionError means the whole transaction may be
+	// retried from the start.
+	TransientTransactionError = "TransientTransactionError"
+
+	// UnknownTransactionCommitResult means that the transaction may or
+	// may not have been committed, and that committing may be retried.
+	UnknownTransactionCommitResult = "UnknownTransactionCommitResult"
+)
+
+var (
+	errTransactionInProgress = errors.New("transaction already in progress")
+	errNoTransaction         = errors.New("no transaction started")
+)
+
+// How long WithTransaction keeps retrying a transaction.
+const withTransactionTimeout = 120 * time.Second
+
+type transactionState int
+
+const (
+	txnStarting   transactionState = iota // No command was sent yet.
+	txnInProgress                         // startTransaction was sent.
+	txnCommitted
+	txnAborted
+)
+
+// transaction holds the state of a multi-document transaction, which is
+// run with the lsid and txnNumber of a server session.
+type transaction struct {
+	m       sync.Mutex
+	session *Session // The session that started it.
+	ss      *serverSession
+	number  int64
+	opts    TransactionOptions
+	state   transactionState
+	sent    bool // Some command was sent, so there's something to end.
+}
+
+// active returns whether commands are to run in the transaction.
+func (txn *transaction) active() bool {
+	if txn == nil {
+		return false
+	}
+	txn.m.Lock()
+	defer txn.m.Unlock()
+	return txn.state == txnStarting || txn.state == txnInProgress
+}
+
+// fields returns the fields to add to a command run in the transaction,
+// or nil if it's not active. The first command starts the transaction on
+// the server.
+func (txn *transaction) fields() bson.D {
+	if txn == nil {
+		return nil
+	}
+	txn.m.Lock()
+	defer txn.m.Unlock()
+	switch txn.state {
+	case txnStarting:
+		txn.state = txnInProgress
+		txn.sent = true
+		fields := bson.D{
+			{Name: "lsid", Value: txn.ss.lsid()},
+			{Name: "txnNumber", Value: txn.number},
+			{Name: "startTransaction", Value: true},
+			{Name: "autocommit", Value: false},
+		}
+		if txn.opts.ReadConcern != "" {
+			fields = append(fields, bson.DocElem{Name: "readConcern", Value: bson.D{{Name: "level", Value: txn.opts.ReadConcern}}})
+		}
+		return fields
+	case txnInProgress:
+		return txn.cursorFields()
+	}
+	return nil
+}
+
+// cursorFields returns the fields to add to commands that don't start the
+// transaction, such as getMore on cursors opened in it.
+func (txn *transaction) cursorFields() bson.D {
+	return bson.D{
+		{Name: "lsid", Value: txn.ss.lsid()},
+		{Name: "txnNumber", Value: txn.number},
+		{Name: "autocommit", Value: false},
+	}
+}
+
+// transaction returns the transaction the session runs commands in, or nil
+// if there's none active.
+func (s *Session) transaction() *transaction {
+	s.m.RLock()
+	txn := s.txn
+	s.m.RUnlock()
+	if !txn.active() {
+		return nil
+	}
+	return txn
+}
+
+// StartTransaction starts a multi-document transaction in the session,
+// with the options in opts, if not nil. All reads and writes made through
+// the session run in the transaction from then on, on the primary, until
+// CommitTransaction or AbortTransaction is called. Their writes are only
+// visible outside of the transaction, and durable, once committed.
+//
+// Transactions need MongoDB 4.0+ on a replica set, or MongoDB 4.2+ on a
+// sharded cluster. With a sharded cluster, the session must be in the
+// Strong or Monotonic mode, so that the transaction stays with the same
+// mongos. Sessions obtained with Copy, Clone or New don't take part in the
+// transaction.
+//
+// Relevant documentation:
+//
+//	https://docs.mongodb.com/manual/core/transactions/
+func (s *Session) StartTransaction(opts *TransactionOptions) error {
+	if s.transaction() != nil {
+		return errTransactionInProgress
+	}
+	socket, err := s.acquireSocket(false)
+	if err != nil {
+		return err
+	}
+	defer socket.Release()
+	info := socket.ServerInfo()
+	if info.LogicalSessionTimeout == 0 || info.SetName == "" && !info.Mongos ||
+		info.MaxWireVersion < 7 || info.Mongos && info.MaxWireVersion < 8 {
+		return errors.New("transactions need MongoDB 4.0+ on a replica set or 4.2+ on a sharded cluster")
+	}
+
+	ss, err := s.startServerSession(socket)
+	if err != nil {
+		return err
+	}
+	ss.txnNumber++
+	txn := &transaction{session: s, ss: ss, number: ss.txnNumber}
+	if opts != nil {
+		txn.opts = *opts
+	}
+	s.m.Lock()
+	busy := s.txn.active()
+	if !busy {
+		s.txn = txn
+	}
+	s.m.Unlock()
+	if busy {
+		s.endServerSession(ss)
+		return errTransactionInProgress
+	}
+	return nil
+}
+
+// CommitTransaction commits the transaction started with StartTransaction.
+// If it fails with an error labelled UnknownTransactionCommitResult, it's
+// unknown whether the transaction was committed, and CommitTransaction may
+// be called again to retry committing it. See QueryError.HasErrorLabel.
+func (s *Session) CommitTransaction() error {
+	s.m.RLock()
+	txn := s.txn
+	s.m.RUnlock()
+	if txn == nil {
+		return errNoTransaction
+	}
+	txn.m.Lock()
+	state, sent := txn.state, txn.sent
+	if state != txnAborted {
+		txn.state = txnCommitted
+	}
+	txn.m.Unlock()
+	if state == txnAborted {
+		return errors.New("cannot commit an aborted transaction")
+	}
+	if state != txnCommitted {
+		defer s.endServerSession(txn.ss)
+	}
+	if !sent {
+		// Nothing was sent, so there's nothing to commit.
+		return nil
+	}
+
+	cmd := commitTransactionCmd{CommitTransaction: 1, MaxTimeMS: int64(txn.opts.MaxCommitTime / time.Millisecond)}
+	if txn.opts.WriteConcern != nil {
+		cmd.WriteConcern = safeWriteConcern(txn.opts.WriteConcern)
+	} else {
+		s.m.RLock()
+		if s.safeOp != nil {
+			cmd.WriteConcern = writeConcernOf(s.safeOp)
+		}
+		s.m.RUnlock()
+	}
+	retried := state == txnCommitted
+	for {
+		if retried {
+			// Retried commits must be majority acknowledged, as the first
+			// attempt may have been acknowledged by a minority only.
+			wc := &getLastError{W: "majority", WTimeout: 10000}
+			if prev, ok := cmd.WriteConcern.(*getLastError); ok && prev.WTimeout > 0 {
+				wc.WTimeout = prev.WTimeout
+			}
+			cmd.WriteConcern = wc
+		}
+		err := s.runTransactionCmd(txn, &cmd)
+		if retried || !isRetryableError(err) {
+			return unknownCommitResult(err)
+		}
+		retried = true
+	}
+}
+
+// AbortTransaction aborts the transaction started with StartTransaction,
+// discarding its writes. Errors reported by the server while aborting
+// are ignored, as the server aborts the transaction on its own eventually.
+func (s *Session) AbortTransaction() error {
+	s.m.RLock()
+	txn := s.txn
+	s.m.RUnlock()
+	if txn == nil {
+		return errNoTransaction
+	}
+	txn.m.Lock()
+	state, sent := txn.state, txn.sent
+	if state != txnCommitted {
+		txn.state = txnAborted
+	}
+	txn.m.Unlock()
+	switch state {
+	case txnCommitted:
+		return errors.New("cannot abort a committed transaction")
+	case txnAborted:
+		return errors.New("transaction already aborted")
+	}
+	defer s.endServerSession(txn.ss)
+	if sent {
+		cmd := abortTransactionCmd{AbortTransaction: 1}
+		if err := s.runTransactionCmd(txn, &cmd); isRetryableError(err) {
+			s.runTransactionCmd(txn, &cmd)
+		}
+	}
+	return nil
+}
+
+// WithTransaction runs fn in a transaction, and commits it if fn returns
+// nil, or aborts it otherwise. The whole transaction is run again if it
+// fails with an error labelled TransientTransactionError, and committing
+// is retried after UnknownTransactionCommitResult errors, for up to two
+// minutes. fn may thus be called several times, and should have no side
+// effects besides its operations on the session it's given, which is s.
+//
+// See StartTransaction for details.
+func (s *Session) WithTransaction(fn func(*Session) error) error {
+	start := time.Now()
+	for {
+		if err := s.StartTransaction(nil); err != nil {
+			return err
+		}
+		if err := fn(s); err != nil {
+			if s.transaction() != nil {
+				s.AbortTransaction()
+			}
+			if hasErrorLabel(err, TransientTransactionError) && time.Since(start) < withTransactionTimeout {
+				continue
+			}
+			return err
+		}
+		if s.transaction() == nil {
+			// fn committed or aborted the transaction itself.
+			return nil
+		}
+		for {
+			err := s.CommitTransaction()
+			if err == nil {
+				return nil
+			}
+			if time.Since(start) >= withTransactionTimeout {
+				return err
+			}
+			if hasErrorLabel(err, UnknownTransactionCommitResult) && !isMaxTimeExpired(err) {
+				continue
+			}
+			if hasErrorLabel(err, TransientTransactionError) {
+				break
+			}
+			return err
+		}
+	}
+}
+
+type commitTransactionCmd struct {
+	CommitTransaction int         `bson:"commitTransaction"`
+	WriteConcern      interface{} `bson:"writeConcern,omitempty"`
+	MaxTimeMS         int64       `bson:"maxTimeMS,omitempty"`
+}
+
+type abortTransactionCmd struct {
+	AbortTransaction int `bson:"abortTransaction"`
+}
+
+// runTransactionCmd runs cmd, a commitTransaction or abortTransaction
+// command, for txn on the primary.
+func (s *Session) runTransactionCmd(txn *transaction, cmd interface{}) error {
+	socket, err := s.acquireSocket(false)
+	if err != nil {
+		return err
+	}
+	defer socket.Release()
+	var result struct {
+		ConcernError writeConcernError `bson:"writeConcernError"`
+	}
+	err = s.DB("admin").runSession(socket, cmd, &result, txn.cursorFields())
+	if err == nil && result.ConcernError.Code != 0 {
+		err = &LastError{Code: result.ConcernError.Code, Err: result.ConcernError.ErrMsg, WTimeout: result.ConcernError.Code == 64}
+	}
+	if isRetryableError(err) {
+		s.prepareRetry(socket.Server(), err)
+	}
+	return err
+}
+
+// safeWriteConcern returns the write concern for write commands matching
+// safe.
+func safeWriteConcern(safe *Safe) *getLastError {
+	wc := &getLastError{WTimeout: safe.WTimeout, FSync: safe.FSync, J: safe.J}
+	if safe.WMode != "" {
+		wc.W = safe.WMode
+	} else if safe.W > 0 {
+		wc.W = safe.W
+	}
+	return wc
+}
+
+// unknownCommitResult labels err with UnknownTransactionCommitResult if
+// it leaves the outcome of a commit unknown.
+func unknownCommitResult(err error) error {
+	switch e := err.(type) {
+	case nil:
+		return nil
+	case *QueryError:
+		if isRetryableError(e) || isMaxTimeExpired(e) {
+			e.addErrorLabel(UnknownTransactionCommitResult)
+		}
+		return e
+	case *LastError:
+		// Write concern errors don't undo the commit.
+		return &QueryError{Code: e.Code, Message: e.Err, Labels: []string{UnknownTransactionCommitResult}}
+	}
+	if isNetworkError(err) {
+		return &QueryError{Message: err.Error(), Labels: []string{UnknownTransactionCommitResult}}
+	}
+	return err
+}
+
+// hasErrorLabel returns whether err has the given error label. Network
+// errors are considered TransientTransactionError ones.
+func hasErrorLabel(err error, label string) bool {
+	if e, ok := err.(*QueryError); ok {
+		return e.HasErrorLabel(label)
+	}
+	return label == TransientTransactionError && isNetworkError(err)
+}
+
+func isNetworkError(err error) bool {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return true
+	}
+	_, ok := err.(net.Error)
+	return ok
+}
+
+func isMaxTimeExpired(err error) bool {
+	e, ok := err.(*QueryError)
+	return ok && e.Code == 50
+}
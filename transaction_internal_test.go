@@ -0,0 +1,134 @@
+package mgo
+
+import (
+	"errors"
+	"io"
+	"net"
+
+	"github.com/globalsign/mgo/bson"
+	. "gopkg.in/check.v1"
+)
+
+func newTestTransaction(session *Session, opts TransactionOptions) *transaction {
+	ss, err := newServerSession()
+	if err != nil {
+		panic(err)
+	}
+	ss.txnNumber = 7
+	return &transaction{session: session, ss: ss, number: ss.txnNumber, opts: opts}
+}
+
+func (s *S) TestTransactionFields(c *C) {
+	txn := newTestTransaction(nil, TransactionOptions{ReadConcern: "snapshot"})
+	lsid := txn.ss.lsid()
+
+	c.Assert(txn.fields(), DeepEquals, bson.D{
+		{Name: "lsid", Value: lsid},
+		{Name: "txnNumber", Value: int64(7)},
+		{Name: "startTransaction", Value: true},
+		{Name: "autocommit", Value: false},
+		{Name: "readConcern", Value: bson.D{{Name: "level", Value: "snapshot"}}},
+	})
+	c.Assert(txn.fields(), DeepEquals, bson.D{
+		{Name: "lsid", Value: lsid},
+		{Name: "txnNumber", Value: int64(7)},
+		{Name: "autocommit", Value: false},
+	})
+
+	txn.state = txnCommitted
+	c.Assert(txn.fields(), IsNil)
+	c.Assert(txn.active(), Equals, false)
+
+	var none *transaction
+	c.Assert(none.fields(), IsNil)
+	c.Assert(none.active(), Equals, false)
+}
+
+func (s *S) TestTransactionNotSent(c *C) {
+	// Transactions that never sent a command end without talking to
+	// the server.
+	session := &Session{}
+	c.Assert(session.CommitTransaction(), Equals, errNoTransaction)
+	c.Assert(session.AbortTransaction(), Equals, errNoTransaction)
+
+	session.txn = newTestTransaction(session, TransactionOptions{})
+	c.Assert(session.transaction(), Equals, session.txn)
+	c.Assert(session.CommitTransaction(), IsNil)
+	c.Assert(session.transaction(), IsNil)
+	c.Assert(session.CommitTransaction(), IsNil)
+	c.Assert(session.AbortTransaction(), ErrorMatches, "cannot abort a committed transaction")
+
+	session.txn = newTestTransaction(session, TransactionOptions{})
+	c.Assert(session.AbortTransaction(), IsNil)
+	c.Assert(session.AbortTransaction(), ErrorMatches, "transaction already aborted")
+	c.Assert(session.CommitTransaction(), ErrorMatches, "cannot commit an aborted transaction")
+}
+
+func (s *S) TestWriteOpCommandInTransaction(c *C) {
+	client, server := net.Pipe()
+	defer server.Close()
+
+	mserver := &mongoServer{Addr: "fake", info: &mongoServerInfo{MaxWireVersion: 7}}
+	socket := newSocket(mserver, client, &DialInfo{})
+	defer socket.kill(errors.New("test done"), false)
+
+	session := &Session{}
+	session.txn = newTestTransaction(session, TransactionOptions{})
+	coll := &Collection{Database: &Database{Session: session, Name: "db"}, Name: "coll", FullName: "db.coll"}
+
+	done := make(chan bson.D, 1)
+	go func() {
+		body, err := fakeMsgServer(server, bson.M{"ok": 1, "n": 1})
+		c.Check(err, IsNil)
+		done <- body
+	}()
+
+	// Writes are acknowledged in transactions, even in unsafe sessions.
+	lerr, err := coll.writeOpCommand(socket, nil, &insertOp{coll.FullName, []interface{}{bson.M{"a": 1}}, 0}, true, false)
+	c.Assert(err, IsNil)
+	c.Assert(lerr, IsNil)
+	c.Assert(<-done, DeepEquals, bson.D{
+		{Name: "insert", Value: "coll"},
+		{Name: "ordered", Value: true},
+		{Name: "$db", Value: "db"},
+		{Name: "lsid", Value: session.txn.ss.lsid()},
+		{Name: "txnNumber", Value: int64(7)},
+		{Name: "startTransaction", Value: true},
+		{Name: "autocommit", Value: false},
+	})
+}
+
+func (s *S) TestQueryErrorLabels(c *C) {
+	data, err := bson.Marshal(bson.M{
+		"ok":          0,
+		"errmsg":      "Transaction 7 has been aborted.",
+		"code":        251,
+		"errorLabels": []string{TransientTransactionError},
+	})
+	c.Assert(err, IsNil)
+	err = checkQueryError("admin.$cmd", data)
+	qerr, ok := err.(*QueryError)
+	c.Assert(ok, Equals, true)
+	c.Assert(qerr.Code, Equals, 251)
+	c.Assert(qerr.HasErrorLabel(TransientTransactionError), Equals, true)
+	c.Assert(qerr.HasErrorLabel(UnknownTransactionCommitResult), Equals, false)
+
+	c.Assert(hasErrorLabel(qerr, TransientTransactionError), Equals, true)
+	c.Assert(hasErrorLabel(io.EOF, TransientTransactionError), Equals, true)
+	c.Assert(hasErrorLabel(io.EOF, UnknownTransactionCommitResult), Equals, false)
+	c.Assert(hasErrorLabel(ErrNotFound, TransientTransactionError), Equals, false)
+}
+
+func (s *S) TestUnknownCommitResult(c *C) {
+	c.Assert(unknownCommitResult(nil), IsNil)
+	for _, err := range []error{
+		io.EOF,
+		&QueryError{Code: 10107, Message: "not master"},
+		&QueryError{Code: 50, Message: "operation exceeded time limit"},
+		&LastError{Code: 64, Err: "waiting for replication timed out", WTimeout: true},
+	} {
+		c.Assert(hasErrorLabel(unknownCommitResult(err), UnknownTransactionCommitResult), Equals, true, Commentf("error: %#v", err))
+	}
+	err := unknownCommitResult(&QueryError{Code: 251, Message: "no such transaction"})
+	c.Assert(hasErrorLabel(err, UnknownTransactionCommitResult), Equals, false)
+}
@@ -0,0 +1,107 @@
+package mgo_test
+
+import (
+	mgo "github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	. "gopkg.in/check.v1"
+)
+
+func (s *S) TestTransactionCommit(c *C) {
+	if !s.versionAtLeast(4, 0) {
+		c.Skip("Transactions only work on 4.0+")
+	}
+	session, err := mgo.Dial("localhost:40011")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+	c.Assert(coll.Insert(M{"_id": 1, "n": 0}), IsNil)
+
+	c.Assert(session.StartTransaction(&mgo.TransactionOptions{ReadConcern: "snapshot"}), IsNil)
+	c.Assert(session.StartTransaction(nil), ErrorMatches, "transaction already in progress")
+	c.Assert(coll.Insert(M{"_id": 2, "n": 0}), IsNil)
+	c.Assert(coll.UpdateId(1, M{"$inc": M{"n": 1}}), IsNil)
+
+	// The count command can't run in transactions.
+	var docs []bson.M
+	c.Assert(coll.Find(nil).All(&docs), IsNil)
+	c.Assert(docs, HasLen, 2)
+
+	// Not visible outside of the transaction before the commit.
+	other := session.Copy()
+	defer other.Close()
+	n, err := other.DB("mydb").C("mycoll").Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 1)
+
+	c.Assert(session.CommitTransaction(), IsNil)
+
+	var result struct{ N int }
+	c.Assert(other.DB("mydb").C("mycoll").FindId(1).One(&result), IsNil)
+	c.Assert(result.N, Equals, 1)
+	n, err = other.DB("mydb").C("mycoll").Count()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 2)
+}
+
+func (s *S) TestTransactionAbort(c *C) {
+	if !s.versionAtLeast(4, 0) {
+		c.Skip("Transactions only work on 4.0+")
+	}
+	session, err := mgo.Dial("localhost:40011")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+	c.Assert(coll.Insert(M{"_id": 1}), IsNil)
+
+	c.Assert(session.StartTransaction(nil), IsNil)
+	c.Assert(coll.Insert(M{"_id": 2}), IsNil)
+	_, err = coll.FindId(1).Apply(mgo.Change{Remove: true}, nil)
+	c.Assert(err, IsNil)
+	c.Assert(session.AbortTransaction(), IsNil)
+	c.Assert(session.CommitTransaction(), ErrorMatches, "cannot commit an aborted transaction")
+
+	var ids []bson.M
+	c.Assert(coll.Find(nil).All(&ids), IsNil)
+	c.Assert(ids, DeepEquals, []bson.M{{"_id": 1}})
+}
+
+func (s *S) TestWithTransaction(c *C) {
+	if !s.versionAtLeast(4, 0) {
+		c.Skip("Transactions only work on 4.0+")
+	}
+	session, err := mgo.Dial("localhost:40011")
+	c.Assert(err, IsNil)
+	defer session.Close()
+
+	coll := session.DB("mydb").C("mycoll")
+	c.Assert(coll.Insert(M{"_id": "from", "balance": 100}, M{"_id": "to", "balance": 0}), IsNil)
+
+	calls := 0
+	err = session.WithTransaction(func(session *mgo.Session) error {
+		calls++
+		coll := session.DB("mydb").C("mycoll")
+		if err := coll.UpdateId("from", M{"$inc": M{"balance": -30}}); err != nil {
+			return err
+		}
+		return coll.UpdateId("to", M{"$inc": M{"balance": 30}})
+	})
+	c.Assert(err, IsNil)
+	c.Assert(calls, Equals, 1)
+
+	var result struct{ Balance int }
+	c.Assert(coll.FindId("to").One(&result), IsNil)
+	c.Assert(result.Balance, Equals, 30)
+
+	// Errors abort the transaction and are returned as they are.
+	err = session.WithTransaction(func(session *mgo.Session) error {
+		if err := session.DB("mydb").C("mycoll").UpdateId("to", M{"$inc": M{"balance": 1}}); err != nil {
+			return err
+		}
+		return mgo.ErrNotFound
+	})
+	c.Assert(err, Equals, mgo.ErrNotFound)
+	c.Assert(coll.FindId("to").One(&result), IsNil)
+	c.Assert(result.Balance, Equals, 30)
+}